@@ -1,17 +1,140 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
 	"servis/pkg/api"
+	"servis/pkg/config"
+	"servis/pkg/ctl"
 	"servis/pkg/ethernet"
+	"servis/pkg/grpcapi"
+	"servis/pkg/health"
+	"servis/pkg/lifecycle"
+	"servis/pkg/mqtt"
 	"servis/pkg/rtc"
+	"servis/pkg/scheduler"
 	"servis/pkg/device"
-	//"servis/pkg/update"
+	"servis/pkg/selfupdate"
+	"servis/pkg/update"
+	"servis/pkg/wifi"
 )
 
 func main() {
-	go device.Start()
-	rtc.ConfigureRTC()
-	ethernet.ConfigureEthernet()
-	
-	api.StartServer()
+	// servis ctl — клиент локального API для консоли устройства; сам сервис при этом не запускается
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		os.Exit(ctl.Run(os.Args[2:]))
+	}
+
+	if err := config.Init(os.Args[1:]); err != nil {
+		log.Fatalf("failed to load configuration: %v", err)
+	}
+	// По SIGHUP конфигурация перечитывается; подсистемы читают настройки через config.Get
+	config.WatchSignals()
+	config.OnChange(reconfigureNetwork)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	supervisor := lifecycle.New()
+	supervisor.SetShutdownTimeout(time.Duration(config.Get().ShutdownTimeout))
+	config.OnChange(func(old, new config.Config) {
+		supervisor.SetShutdownTimeout(time.Duration(new.ShutdownTimeout))
+	})
+
+	// Однократная настройка оборудования; при ошибке повторяется несколько раз с нарастающей задержкой
+	setup := []lifecycle.Worker{
+		{Name: "rtc", Run: once(rtc.ConfigureRTC), MaxRestarts: 3},
+		{Name: "ethernet-config", Run: once(ethernet.ConfigureEthernet), MaxRestarts: 5},
+		// Ключ устройства нужен для расшифровки зашифрованных пакетов прошивки
+		{Name: "device-key", Run: once(ensureDeviceKey), MaxRestarts: 3},
+	}
+	isSetup := make(map[string]bool)
+	for _, w := range setup {
+		supervisor.Add(w)
+		isSetup[w.Name] = true
+	}
+
+	// Постоянно работающие подсистемы перезапускаются после сбоя без ограничений
+	supervisor.Add(lifecycle.Worker{Name: "device", Run: device.Run})
+	// Состояние сети публикуется в шину событий для подписчиков /events
+	supervisor.Add(lifecycle.Worker{Name: "ethernet-monitor", Run: ethernet.MonitorLink})
+	supervisor.Add(lifecycle.Worker{Name: "wifi-monitor", Run: wifi.Monitor})
+	supervisor.Add(lifecycle.Worker{Name: "api", Run: api.Serve})
+	// gRPC на отдельном порту; операции те же, что у HTTP API (пакет service)
+	supervisor.Add(lifecycle.Worker{Name: "grpc", Run: grpcapi.Serve})
+	// Телеметрия и удаленные команды через брокер MQTT, если он задан в конфигурации
+	supervisor.Add(lifecycle.Worker{Name: "mqtt", Run: mqtt.Run})
+	// Выключение и перезагрузка по расписанию; ждут, пока время не подтверждено RTC или NTP
+	supervisor.Add(lifecycle.Worker{Name: "scheduler", Run: scheduler.Run})
+
+	// Постоянная подсистема, исчерпавшая перезапуски, сама не восстановится: /healthz сообщает, что процесс нужно перезапустить
+	health.Register(health.Check{Name: "subsystems", Liveness: true, Run: checkSubsystems(supervisor, func(name string) bool { return !isSetup[name] })})
+	// Однократная настройка не проходит, если на плате нет оборудования (например, модуля RTC); перезапуск процесса
+	// этого не исправит, поэтому ее сбой влияет только на /readyz
+	health.Register(health.Check{Name: "setup", Run: checkSubsystems(supervisor, func(name string) bool { return isSetup[name] })})
+
+	// Перед выходом текущее обновление прошивки должно дойти до конца, а новые не начинаются
+	supervisor.OnStop("update", update.Drain)
+
+	// После передачи работы новому процессу при самообновлении текущий останавливается так же, как по SIGTERM
+	selfupdate.SetShutdown(supervisor.Stop)
+
+	if err := supervisor.Run(ctx); err != nil {
+		log.Printf("shutdown: %v", err)
+		os.Exit(1)
+	}
+}
+
+// once превращает функцию настройки в подсистему, которая завершается после успешного выполнения
+func once(fn func() error) func(context.Context) error {
+	return func(ctx context.Context) error {
+		return fn()
+	}
+}
+
+// checkSubsystems возвращает проверку, которая не проходит, если какая-либо из подсистем, выбранных match,
+// упала окончательно
+func checkSubsystems(supervisor *lifecycle.Supervisor, match func(name string) bool) func(context.Context) (health.Details, error) {
+	return func(ctx context.Context) (health.Details, error) {
+		details := health.Details{}
+		var failed []string
+		for _, status := range supervisor.Status() {
+			if !match(status.Name) {
+				continue
+			}
+			details[status.Name] = status.State
+			if status.State == lifecycle.StateFailed {
+				failed = append(failed, status.Name)
+			}
+		}
+		if len(failed) > 0 {
+			return details, fmt.Errorf("subsystems failed: %s", strings.Join(failed, ", "))
+		}
+		return details, nil
+	}
+}
+
+func ensureDeviceKey() error {
+	_, err := update.EnsureDeviceKey()
+	return err
+}
+
+// reconfigureNetwork заново записывает конфигурацию интерфейсов, если изменились их имена или файлы
+func reconfigureNetwork(old, new config.Config) {
+	previous, current := old.Network, new.Network
+	previous.MonitorInterval, current.MonitorInterval = 0, 0
+	if previous == current {
+		return
+	}
+
+	if err := ethernet.ConfigureEthernet(); err != nil {
+		log.Printf("failed to apply network configuration: %v", err)
+	}
 }
@@ -1,51 +1,61 @@
 package api
 
 import (
+    "context"
+    "encoding/base64"
     "encoding/json"
+    "errors"
     "fmt"
+    "io"
     "log"
+    "net"
     "net/http"
-    "os"
     "strings"
+    "sync"
     "time"
+    "servis/pkg/apierror"
+    "servis/pkg/audit"
+    "servis/pkg/auth"
+    "servis/pkg/certs"
+    "servis/pkg/config"
+    "servis/pkg/health"
+    "servis/pkg/selfupdate"
+    "servis/pkg/service"
     "servis/pkg/update"
+    "servis/pkg/scheduler"
     "servis/pkg/shutdown"
-    "servis/pkg/wifi"
     "github.com/gorilla/mux"
-    "archive/zip"
 )
 
-var selectedZipFilePath string // переменная для хранения выбранного файла прошивки
-
-type NetworkSelection struct {
-    Name     string `json:"name"`
-    Password string `json:"password"`
-}
-
-type ShutdownRequest struct {
-    Comment string `json:"comment"`
+type SelfUpdateRequest struct {
+    SelectedFile string `json:"selected_file"`
 }
 
-type RebootRequest struct {
-    Comment string `json:"comment"`
+type ExportBackupRequest struct {
+    MountPoint string `json:"mount_point"`
 }
 
-type FileInfo struct {
-    Source      string `json:"source"`
-    FileVersion string `json:"file_version"`
+type ExportBackupResponse struct {
+    Path string `json:"path"`
 }
 
-type ZipFileInfo struct {
-    Path   string     `json:"path"`
-    Files  []FileInfo `json:"files"`
+type DeviceKeyResponse struct {
+    Algorithm string `json:"algorithm"`
+    KeyID     string `json:"key_id"`
+    PublicKey string `json:"public_key"`
 }
 
-// enableCORS добавляет необходимые заголовки для поддержки CORS.
+// enableCORS добавляет заголовки CORS для запросов из веб-приложений, источник которых есть в cors_origins.
+// Ответ зависит от заголовка Origin, поэтому кэши предупреждаются заголовком Vary.
 func enableCORS(next http.Handler) http.Handler {
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        w.Header().Set("Access-Control-Allow-Origin", "*")
-        w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-        w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+        w.Header().Add("Vary", "Origin")
+        if origin := r.Header.Get("Origin"); origin != "" && allowedOrigin(origin) {
+            w.Header().Set("Access-Control-Allow-Origin", origin)
+            w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+            w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+            w.Header().Set("Access-Control-Expose-Headers", "Deprecation, Link, Retry-After")
+        }
 
         if r.Method == "OPTIONS" {
             w.WriteHeader(http.StatusOK)
@@ -56,22 +66,125 @@ func enableCORS(next http.Handler) http.Handler {
     })
 }
 
-// RegisterRoutes регистрирует маршруты для HTTP эндпоинтов.
+// allowedOrigin сообщает, что источник есть в cors_origins
+func allowedOrigin(origin string) bool {
+    for _, allowed := range config.Get().CORSOrigins {
+        if strings.EqualFold(origin, allowed) {
+            return true
+        }
+    }
+    return false
+}
+
+// Route описывает HTTP эндпоинт. По таблице маршрутов версии регистрируются обработчики и строится документ OpenAPI,
+// поэтому описание API не может разойтись с обработчиками.
+type Route struct {
+    Method      string
+    Path        string // путь без префикса версии
+    Role        string // минимальная роль; пустая строка — эндпоинт доступен без аутентификации
+    QueryToken  bool   // токен можно передать в параметре access_token (EventSource, WebSocket)
+    Handler     http.HandlerFunc
+    OperationID string
+    Summary     string
+    Query       []QueryParam
+    Request     interface{} // пример типа тела запроса; nil — запрос без тела
+    Response    interface{} // пример типа JSON-ответа; nil — MessageResponse
+    ContentType string      // тип ответа, если он не JSON
+    Errors      []string    // коды ошибок обработчика (кроме ошибок аутентификации, разбора запроса и internal_error)
+}
+
+// QueryParam описывает параметр строки запроса
+type QueryParam struct {
+    Name        string
+    Description string
+}
+
+// Для каждого маршрута указана минимальная роль: viewer только читает состояние,
+// operator управляет устройством и прошивкой, admin управляет учетными данными и самим servis.
+var v1Routes = []Route{
+    {Method: "GET", Path: "/openapi.json", Handler: GetOpenAPI, OperationID: "getOpenAPI", Summary: "Описание API в формате OpenAPI 3", ContentType: "application/json"},
+    {Method: "POST", Path: "/auth/login", Handler: LoginHandler, OperationID: "login", Summary: "Получить токен по логину и паролю", Request: LoginRequest{}, Response: LoginResponse{}, Errors: []string{apierror.CodeInvalidCredentials}},
+    {Method: "GET", Path: "/tls/ca.crt", Handler: GetDeviceCA, OperationID: "getDeviceCA", Summary: "CA устройства для закрепления сертификата", ContentType: "application/x-pem-file"},
+    {Method: "GET", Path: "/tls/info", Role: auth.RoleViewer, Handler: GetTLSInfo, OperationID: "getTLSInfo", Summary: "Сведения о сертификате HTTPS", Response: certs.Status{}},
+    {Method: "GET", Path: "/auth/me", Role: auth.RoleViewer, Handler: GetCurrentIdentity, OperationID: "getCurrentIdentity", Summary: "Текущий пользователь", Response: auth.Identity{}},
+    {Method: "GET", Path: "/auth/users", Role: auth.RoleAdmin, Handler: ListUsersHandler, OperationID: "listUsers", Summary: "Список учетных записей", Response: []auth.User{}},
+    {Method: "POST", Path: "/auth/users", Role: auth.RoleAdmin, Handler: CreateUserHandler, OperationID: "createUser", Summary: "Создать учетную запись или изменить пароль и роль", Request: CreateUserRequest{}, Response: auth.User{}, Errors: []string{apierror.CodeLastAdmin}},
+    {Method: "DELETE", Path: "/auth/users/{username}", Role: auth.RoleAdmin, Handler: DeleteUserHandler, OperationID: "deleteUser", Summary: "Удалить учетную запись", Errors: []string{apierror.CodeNotFound, apierror.CodeLastAdmin}},
+    {Method: "GET", Path: "/auth/tokens", Role: auth.RoleAdmin, Handler: ListTokensHandler, OperationID: "listTokens", Summary: "Список API-токенов", Response: []auth.Token{}},
+    {Method: "POST", Path: "/auth/tokens", Role: auth.RoleAdmin, Handler: CreateTokenHandler, OperationID: "createToken", Summary: "Создать API-токен", Request: CreateTokenRequest{}, Response: CreateTokenResponse{}},
+    {Method: "DELETE", Path: "/auth/tokens/{id}", Role: auth.RoleAdmin, Handler: RevokeTokenHandler, OperationID: "revokeToken", Summary: "Отозвать API-токен", Errors: []string{apierror.CodeNotFound, apierror.CodeLastAdmin}},
+
+    {Method: "GET", Path: "/audit", Role: auth.RoleAdmin, Handler: GetAuditLog, OperationID: "getAuditLog", Summary: "Журнал изменяющих запросов", Query: auditQuery, Response: []audit.Entry{}, Errors: []string{apierror.CodeInvalidRequest}},
+    {Method: "GET", Path: "/audit/verify", Role: auth.RoleAdmin, Handler: VerifyAuditLog, OperationID: "verifyAuditLog", Summary: "Проверить цепочку хешей журнала аудита", Query: auditVerifyQuery, Response: audit.VerifyResult{}, Errors: []string{apierror.CodeInvalidRequest}},
+
+    {Method: "GET", Path: "/events", Role: auth.RoleViewer, QueryToken: true, Handler: StreamEventsSSE, OperationID: "streamEvents", Summary: "Поток событий (Server-Sent Events)", Query: eventQuery, ContentType: "text/event-stream", Errors: []string{apierror.CodeInvalidRequest}},
+    {Method: "GET", Path: "/events/ws", Role: auth.RoleViewer, QueryToken: true, Handler: StreamEventsWS, OperationID: "streamEventsWebSocket", Summary: "Поток событий (WebSocket, сообщения в формате Event)", Query: eventQuery, ContentType: "websocket", Errors: []string{apierror.CodeInvalidRequest}},
+
+    {Method: "GET", Path: "/networks/all", Role: auth.RoleViewer, Handler: GetNetworks, OperationID: "getNetworks", Summary: "Список доступных сетей WiFi", Response: []service.Network{}, Errors: []string{apierror.CodeWifiScanFailed}},
+    {Method: "POST", Path: "/networks/connect", Role: auth.RoleOperator, Handler: ConnectNetwork, OperationID: "connectNetwork", Summary: "Подключиться к сети WiFi", Request: service.NetworkSelection{}, Errors: []string{apierror.CodeWifiConfigFailed, apierror.CodeWifiConnectFailed, apierror.CodeWifiDHCPFailed}},
+    {Method: "GET", Path: "/ethernet", Role: auth.RoleViewer, Handler: GetEthernet, OperationID: "getEthernet", Summary: "Адрес и линк интерфейса Ethernet", Response: service.EthernetStatus{}, Errors: []string{apierror.CodeEthernetUnavailable}},
+    {Method: "POST", Path: "/ethernet", Role: auth.RoleOperator, Handler: ConfigureEthernet, OperationID: "configureEthernet", Summary: "Задать статический адрес Ethernet", Request: service.EthernetSettings{}, Errors: []string{apierror.CodeInvalidRequest, apierror.CodeEthernetConfigFailed}},
+    {Method: "POST", Path: "/shutdown", Role: auth.RoleOperator, Handler: HandleShutdown, OperationID: "shutdown", Summary: "Выключить устройство: получить токен подтверждения, затем подтвердить", Request: service.PowerRequest{}, Response: service.PowerResponse{}, Errors: []string{apierror.CodeInvalidRequest, apierror.CodeInvalidConfirmation, apierror.CodePowerActionPending}},
+    {Method: "POST", Path: "/reboot", Role: auth.RoleOperator, Handler: HandleReboot, OperationID: "reboot", Summary: "Перезагрузить устройство: получить токен подтверждения, затем подтвердить", Request: service.PowerRequest{}, Response: service.PowerResponse{}, Errors: []string{apierror.CodeInvalidRequest, apierror.CodeInvalidConfirmation, apierror.CodePowerActionPending}},
+    {Method: "GET", Path: "/power/pending", Role: auth.RoleViewer, Handler: GetPendingPower, OperationID: "getPendingPower", Summary: "Запланированное выключение или перезагрузка", Response: service.PowerResponse{}},
+    {Method: "DELETE", Path: "/power/pending", Role: auth.RoleOperator, Handler: CancelPendingPower, OperationID: "cancelPendingPower", Summary: "Отменить запланированное выключение или перезагрузку", Response: service.PowerResponse{}, Errors: []string{apierror.CodeNotFound}},
+    {Method: "GET", Path: "/schedules", Role: auth.RoleViewer, Handler: ListSchedulesHandler, OperationID: "listSchedules", Summary: "Расписания выключения и перезагрузки", Response: []scheduler.Schedule{}},
+    {Method: "POST", Path: "/schedules", Role: auth.RoleOperator, Handler: CreateScheduleHandler, OperationID: "createSchedule", Summary: "Запланировать выключение или перезагрузку", Request: ScheduleRequest{}, Response: scheduler.Schedule{}, Errors: []string{apierror.CodeInvalidRequest, apierror.CodeClockNotSynchronized}},
+    {Method: "DELETE", Path: "/schedules/{id}", Role: auth.RoleOperator, Handler: DeleteScheduleHandler, OperationID: "deleteSchedule", Summary: "Удалить расписание", Errors: []string{apierror.CodeNotFound}},
+    {Method: "GET", Path: "/maintenance/window", Role: auth.RoleViewer, Handler: GetMaintenanceWindow, OperationID: "getMaintenanceWindow", Summary: "Состояние окна обслуживания", Response: scheduler.WindowStatus{}},
+    {Method: "GET", Path: "/usb/files", Role: auth.RoleViewer, Handler: GetUSBFiles, OperationID: "listUSBFiles", Summary: "Пакеты прошивки на USB-накопителях", Response: []service.ZipFileInfo{}},
+    {Method: "POST", Path: "/firmware/plan", Role: auth.RoleOperator, Handler: PlanFirmwareUpdate, OperationID: "planFirmwareUpdate", Summary: "Проверить, какие компоненты изменит установка архива", Request: service.UpdateRequest{}, Response: update.UpdatePlan{}, Errors: []string{apierror.CodeArchiveNotFound, apierror.CodeUnknownPackage, apierror.CodeManifestInvalid}},
+    {Method: "POST", Path: "/firmware/update", Role: auth.RoleOperator, Handler: PerformFirmwareUpdate, OperationID: "updateFirmware", Summary: "Установить прошивку из ZIP-архива", Request: service.UpdateRequest{}, Errors: []string{apierror.CodeArchiveNotFound, apierror.CodeUnknownPackage, apierror.CodeManifestInvalid, apierror.CodeWrongDeviceKey, apierror.CodeDecryptionFailed, apierror.CodeHashMismatch, apierror.CodeUpdateInProgress, apierror.CodeUpdateInterrupted, apierror.CodeShuttingDown}},
+    {Method: "POST", Path: "/firmware/rollback", Role: auth.RoleOperator, Handler: RollbackFirmwareHandler, OperationID: "rollbackFirmware", Summary: "Откатить последнее обновление или выбранные компоненты", Request: service.RollbackRequest{}, Response: []update.ComponentRollback{}, Errors: []string{apierror.CodeNotInstalled, apierror.CodeBackupNotFound, apierror.CodeDependencyConflict, apierror.CodeUpdateInProgress, apierror.CodeUpdateInterrupted, apierror.CodeShuttingDown}},
+    {Method: "GET", Path: "/firmware/job", Role: auth.RoleViewer, Handler: GetFirmwareJob, OperationID: "getFirmwareJob", Summary: "Выполняемая или прерванная операция обновления", Response: service.JobResponse{}},
+    {Method: "DELETE", Path: "/firmware/lock", Role: auth.RoleAdmin, Handler: ClearFirmwareLock, OperationID: "clearFirmwareLock", Summary: "Сбросить запись о прерванной операции", Errors: []string{apierror.CodeNotFound, apierror.CodeUpdateInProgress, apierror.CodeShuttingDown}},
+    {Method: "GET", Path: "/firmware/device-key", Role: auth.RoleViewer, Handler: GetDeviceKey, OperationID: "getDeviceKey", Summary: "Открытый ключ устройства для шифрования пакетов", Response: DeviceKeyResponse{}},
+    {Method: "POST", Path: "/firmware/self-update", Role: auth.RoleAdmin, Handler: SelfUpdateHandler, OperationID: "selfUpdate", Summary: "Обновить исполняемый файл servis", Request: SelfUpdateRequest{}, Errors: []string{apierror.CodeSelfUpdateFailed, apierror.CodeArchiveNotFound, apierror.CodeManifestInvalid, apierror.CodeUntrustedPackage, apierror.CodeWrongDeviceKey, apierror.CodeDecryptionFailed, apierror.CodeHashMismatch, apierror.CodeUpdateInProgress, apierror.CodeUpdateInterrupted, apierror.CodeShuttingDown}},
+    {Method: "GET", Path: "/firmware/self-update", Role: auth.RoleViewer, Handler: GetSelfUpdateStatus, OperationID: "getSelfUpdateStatus", Summary: "Результат последнего самообновления", Response: &selfupdate.Status{}},
+    {Method: "GET", Path: "/firmware/backups", Role: auth.RoleViewer, Handler: ListBackupsHandler, OperationID: "listBackups", Summary: "Поколения резервных копий", Response: []update.BackupGeneration{}},
+    {Method: "GET", Path: "/firmware/backups/{id}/archive", Role: auth.RoleOperator, Handler: DownloadBackupHandler, OperationID: "downloadBackup", Summary: "Скачать поколение резервной копии в виде ZIP-архива", ContentType: "application/zip", Errors: []string{apierror.CodeBackupNotFound}},
+    {Method: "POST", Path: "/firmware/backups/{id}/export", Role: auth.RoleOperator, Handler: ExportBackupHandler, OperationID: "exportBackup", Summary: "Сохранить поколение резервной копии на USB-накопитель", Request: ExportBackupRequest{}, Response: ExportBackupResponse{}, Errors: []string{apierror.CodeBackupNotFound, apierror.CodeNotUSBDevice}},
+}
+
+// rootRoutes — маршруты вне версий API: их путь задан внешними соглашениями (Prometheus по умолчанию
+// опрашивает /metrics, балансировщики и мониторинг — /healthz и /readyz). Они не входят в описание OpenAPI
+// и не устаревают вместе с версиями.
+var rootRoutes = []Route{
+    {Method: "GET", Path: "/metrics", Role: auth.RoleViewer, Handler: GetMetrics, OperationID: "getMetrics", Summary: "Метрики в формате Prometheus", ContentType: "text/plain"},
+    {Method: "GET", Path: "/healthz", Handler: GetHealth, OperationID: "getHealth", Summary: "Работоспособность процесса", Response: health.Report{}},
+    {Method: "GET", Path: "/readyz", Handler: GetReadiness, OperationID: "getReadiness", Summary: "Готовность выполнять операции", Response: health.Report{}},
+}
+
+var eventQuery = []QueryParam{
+    {Name: "topics", Description: "Темы через запятую: device, network, update, system"},
+    {Name: "last_event_id", Description: "Отправить события, опубликованные после указанного (аналог заголовка Last-Event-ID)"},
+    {Name: "access_token", Description: "Токен доступа, если нельзя передать заголовок Authorization"},
+}
+
+// RegisterRoutes регистрирует маршруты всех версий API (/api/v1/...), устаревшие пути без префикса
+// и маршруты вне версий (/metrics), а также строит описание OpenAPI каждой версии.
 func RegisterRoutes(r *mux.Router) {
-    r.HandleFunc("/networks/all", GetNetworks).Methods("GET")
-    r.HandleFunc("/networks/connect", ConnectNetwork).Methods("POST")
-    r.HandleFunc("/shutdown", HandleShutdown).Methods("POST")
-    r.HandleFunc("/reboot", HandleReboot).Methods("POST")
-    r.HandleFunc("/usb/files", GetUSBFiles).Methods("GET")
-    r.HandleFunc("/firmware/update", PerformFirmwareUpdate).Methods("POST")
-    r.HandleFunc("/firmware/rollback", RollbackFirmwareHandler).Methods("POST")
+    for _, v := range versions {
+        doc, err := buildOpenAPI(v)
+        if err != nil {
+            log.Printf("failed to build OpenAPI document for %s: %v", v.Name, err)
+        }
+        openAPIDocs[v.Name] = doc
+
+        registerVersion(r, v)
+    }
+    registerLegacy(r, findVersion(legacyVersion))
+    registerRoot(r)
+
+    r.NotFoundHandler = http.HandlerFunc(notFound)
+    r.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowed)
 }
 
 // GetNetworks обрабатывает запрос на получение списка доступных сетей.
 func GetNetworks(w http.ResponseWriter, r *http.Request) {
-    networks, err := wifi.ScanNetworks("wlan0")
+    networks, err := service.Networks()
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writeError(w, err, apierror.CodeWifiScanFailed, "failed to scan wifi networks")
         return
     }
 
@@ -81,219 +194,429 @@ func GetNetworks(w http.ResponseWriter, r *http.Request) {
 
 // ConnectNetwork обрабатывает запрос на подключение к выбранной сети.
 func ConnectNetwork(w http.ResponseWriter, r *http.Request) {
-    var selection NetworkSelection
+    var selection service.NetworkSelection
     if err := json.NewDecoder(r.Body).Decode(&selection); err != nil {
-        http.Error(w, "invalid request payload", http.StatusBadRequest)
+        writeInvalidRequest(w, "invalid request payload", "")
         return
     }
 
-    err := wifi.UpdateNetworkConfig("/etc/wpa_supplicant/wpa_supplicant.conf", selection.Name, selection.Password)
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+    if err := service.ConnectNetwork(selection); err != nil {
+        writeError(w, err, apierror.CodeWifiConnectFailed, "failed to connect to wifi network")
         return
     }
 
-    wifi.StopWpaSupplicant()
+    writeMessage(w, "connected to network")
+}
 
-    _, err = wifi.RunCommand("ip", "link", "set", "wlan0", "down")
+// GetEthernet возвращает адрес и линк интерфейса Ethernet.
+func GetEthernet(w http.ResponseWriter, r *http.Request) {
+    status, err := service.Ethernet()
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writeError(w, err, apierror.CodeEthernetUnavailable, "failed to read ethernet configuration")
         return
     }
-    _, err = wifi.RunCommand("ip", "link", "set", "wlan0", "up")
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(status)
+}
+
+// ConfigureEthernet задает статический адрес интерфейса Ethernet.
+func ConfigureEthernet(w http.ResponseWriter, r *http.Request) {
+    var settings service.EthernetSettings
+    if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+        writeInvalidRequest(w, "invalid request payload", "")
         return
     }
 
-    _, err = wifi.RunCommand("wpa_supplicant", "-B", "-i", "wlan0", "-c", "/etc/wpa_supplicant/wpa_supplicant.conf")
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+    if err := service.ConfigureEthernet(settings); err != nil {
+        writeError(w, err, apierror.CodeEthernetConfigFailed, "failed to update ethernet configuration")
         return
     }
 
-    time.Sleep(5 * time.Second)
+    writeMessage(w, "ethernet configuration applied")
+}
 
-    _, err = wifi.RunCommand("dhclient", "wlan0")
+// HandleShutdown обрабатывает запрос на выключение устройства.
+func HandleShutdown(w http.ResponseWriter, r *http.Request) {
+    handlePower(w, r, shutdown.ActionShutdown)
+}
+
+// HandleReboot обрабатывает запрос на перезагрузку устройства.
+func HandleReboot(w http.ResponseWriter, r *http.Request) {
+    handlePower(w, r, shutdown.ActionReboot)
+}
+
+// handlePower выполняет первый или второй шаг выключения или перезагрузки. Пустое тело запроса
+// равносильно первому шагу без отсрочки.
+func handlePower(w http.ResponseWriter, r *http.Request, action string) {
+    var powerReq service.PowerRequest
+    if err := json.NewDecoder(r.Body).Decode(&powerReq); err != nil && !errors.Is(err, io.EOF) {
+        writeInvalidRequest(w, "invalid request payload", "")
+        return
+    }
+
+    response, err := service.Power(action, powerReq, auth.FromContext(r.Context()).Name)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusInternalServerError)
+        writeError(w, err, apierror.CodeInternal, "failed to "+action)
         return
     }
 
-    w.WriteHeader(http.StatusOK)
-    w.Write([]byte("connected to network"))
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(response)
 }
 
-// HandleShutdown обрабатывает запрос на выключение устройства.
-func HandleShutdown(w http.ResponseWriter, r *http.Request) {
-    var shutdownReq ShutdownRequest
-    if err := json.NewDecoder(r.Body).Decode(&shutdownReq); err != nil {
-        http.Error(w, "invalid request payload", http.StatusBadRequest)
+// GetPendingPower возвращает запланированное выключение или перезагрузку; pending отсутствует, если их нет.
+func GetPendingPower(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(service.PendingPower())
+}
+
+// CancelPendingPower отменяет запланированное выключение или перезагрузку и возвращает отмененное действие.
+func CancelPendingPower(w http.ResponseWriter, r *http.Request) {
+    response, err := service.CancelPower(auth.FromContext(r.Context()).Name)
+    if err != nil {
+        writeError(w, err, apierror.CodeInternal, "failed to cancel pending action")
         return
     }
 
-    if shutdownReq.Comment == "shutdown now" {
-        err := shutdown.Shutdown()
-        if err != nil {
-            http.Error(w, "failed to shutdown the system", http.StatusInternalServerError)
-            return
-        }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(response)
+}
 
-        w.WriteHeader(http.StatusOK)
-        w.Write([]byte("system is shutting down"))
+// GetUSBFiles возвращает список ZIP-файлов на USB-устройствах с информацией о файлах и их версиях.
+func GetUSBFiles(w http.ResponseWriter, r *http.Request) {
+    packages, err := service.USBPackages(r.Context())
+    if err != nil {
+        writeError(w, err, apierror.CodeInternal, "failed to get USB devices")
         return
     }
 
-    http.Error(w, "invalid comment", http.StatusBadRequest)
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(packages)
 }
 
-// HandleReboot обрабатывает запрос на перезагрузку устройства.
-func HandleReboot(w http.ResponseWriter, r *http.Request) {
-    var rebootReq RebootRequest
-    if err := json.NewDecoder(r.Body).Decode(&rebootReq); err != nil {
-        http.Error(w, "invalid request payload", http.StatusBadRequest)
+// PerformFirmwareUpdate обрабатывает запрос на выполнение обновления прошивки.
+func PerformFirmwareUpdate(w http.ResponseWriter, r *http.Request) {
+    var req service.UpdateRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeInvalidRequest(w, "invalid request payload", "")
         return
     }
 
-    if rebootReq.Comment == "reboot now" {
-        err := shutdown.Reboot()
-        if err != nil {
-            http.Error(w, "failed to reboot the system", http.StatusInternalServerError)
-            return
-        }
-
-        w.WriteHeader(http.StatusOK)
-        w.Write([]byte("system is rebooting"))
+    if err := service.UpdateFirmware(req, nil); err != nil {
+        writeError(w, err, apierror.CodeInternal, "failed to update firmware")
         return
     }
 
-    http.Error(w, "invalid comment", http.StatusBadRequest)
+    writeMessage(w, "firmware update completed successfully")
 }
 
-// GetUSBFiles возвращает список ZIP-файлов на USB-устройствах с информацией о файлах и их версиях.
-func GetUSBFiles(w http.ResponseWriter, r *http.Request) {
-    usbDevices, err := update.GetUSBMountPoints()
+// PlanFirmwareUpdate показывает изменения, которые внесет установка архива, без установки.
+func PlanFirmwareUpdate(w http.ResponseWriter, r *http.Request) {
+    var req service.UpdateRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeInvalidRequest(w, "invalid request payload", "")
+        return
+    }
+
+    plan, err := service.PlanFirmwareUpdate(req)
     if err != nil {
-        http.Error(w, fmt.Sprintf("failed to get USB devices: %v", err), http.StatusInternalServerError)
+        writeError(w, err, apierror.CodeInternal, "failed to plan firmware update")
         return
     }
 
-    var zipFilesInfo []ZipFileInfo
-    for _, usbPath := range usbDevices {
-        files, err := os.ReadDir(usbPath)
-        if err != nil {
-            http.Error(w, fmt.Sprintf("failed to read directory %s: %v", usbPath, err), http.StatusInternalServerError)
-            return
-        }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(plan)
+}
 
-        for _, file := range files {
-            if file.Type().IsRegular() && strings.HasSuffix(file.Name(), ".zip") {
-                zipFilePath := usbPath + "/" + file.Name()
-                fileInfos, err := extractFilesInfoFromZip(zipFilePath)
-                if err != nil {
-                    log.Printf("failed to extract file info from %s: %v", zipFilePath, err)
-                    continue
-                }
-                zipFilesInfo = append(zipFilesInfo, ZipFileInfo{
-                    Path:  zipFilePath,
-                    Files: fileInfos,
-                })
-            }
-        }
+// RollbackFirmwareHandler обрабатывает запрос на откат прошивки.
+// Без тела запроса откатывается последнее обновление целиком, со списком destinations — только выбранные компоненты.
+func RollbackFirmwareHandler(w http.ResponseWriter, r *http.Request) {
+    var req service.RollbackRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+        writeInvalidRequest(w, "invalid request payload", "")
+        return
     }
 
-    if len(zipFilesInfo) == 0 {
-        http.Error(w, "no zip files found", http.StatusNotFound)
+    results, err := service.RollbackFirmware(req, nil)
+    if err != nil {
+        writeError(w, err, apierror.CodeInternal, "failed to rollback firmware")
         return
     }
 
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(zipFilesInfo)
+    if len(req.Destinations) > 0 {
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(results)
+        return
+    }
+    writeMessage(w, "firmware rollback completed successfully")
 }
 
-// extractFilesInfoFromZip извлекает информацию о файлах из JSON внутри ZIP-файла
-func extractFilesInfoFromZip(zipFilePath string) ([]FileInfo, error) {
-    zipReader, err := zip.OpenReader(zipFilePath)
+// GetFirmwareJob возвращает текущую или прерванную операцию обновления.
+func GetFirmwareJob(w http.ResponseWriter, r *http.Request) {
+    response, err := service.FirmwareJob()
     if err != nil {
-        return nil, fmt.Errorf("failed to open zip file: %w", err)
+        writeError(w, err, apierror.CodeInternal, "failed to get current job")
+        return
     }
-    defer zipReader.Close()
 
-    firmwareInfo, err := update.FindValidFirmware(&zipReader.Reader)
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(response)
+}
+
+// ClearFirmwareLock сбрасывает запись о прерванной операции обновления.
+func ClearFirmwareLock(w http.ResponseWriter, r *http.Request) {
+    err := update.ClearInterruptedJob()
     if err != nil {
-        return nil, fmt.Errorf("failed to find valid firmware in zip file: %w", err)
+        writeError(w, err, apierror.CodeInternal, "failed to clear lock")
+        return
     }
 
-    var fileInfos []FileInfo
-    for _, file := range firmwareInfo.Files {
-        fileInfos = append(fileInfos, FileInfo{
-            Source:      file.Source,
-            FileVersion: file.FileVersion,
-        })
+    writeMessage(w, "lock cleared")
+}
+
+// GetDeviceKey возвращает открытый ключ устройства, для которого шифруются пакеты прошивки.
+func GetDeviceKey(w http.ResponseWriter, r *http.Request) {
+    key, err := update.EnsureDeviceKey()
+    if err != nil {
+        writeError(w, err, apierror.CodeInternal, "failed to load device key")
+        return
     }
 
-    return fileInfos, nil
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(DeviceKeyResponse{
+        Algorithm: "X25519",
+        KeyID:     update.DeviceKeyID(key.PublicKey()),
+        PublicKey: base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()),
+    })
 }
 
-// PerformFirmwareUpdate обрабатывает запрос на выполнение обновления прошивки.
-func PerformFirmwareUpdate(w http.ResponseWriter, r *http.Request) {
-    var req struct {
-        SelectedFile string `json:"selected_file"`
-    }
+// SelfUpdateHandler устанавливает новую версию servis из подписанного архива прошивки (например, с USB-накопителя).
+// Ответ отправляется уже после того, как новый процесс сообщил о готовности; затем текущий процесс останавливается.
+func SelfUpdateHandler(w http.ResponseWriter, r *http.Request) {
+    var req SelfUpdateRequest
     if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        http.Error(w, "invalid request payload", http.StatusBadRequest)
+        writeInvalidRequest(w, "invalid request payload", "")
         return
     }
 
     if req.SelectedFile == "" {
-        http.Error(w, "no file selected", http.StatusBadRequest)
+        writeInvalidRequest(w, "no file selected", "selected_file")
         return
     }
 
-    selectedZipFilePath = req.SelectedFile
-    versionFilePath := "/root/dt_backend/installed_versions.json"
-    backupDir := "/root/dt_backend/UpdateBackup"
+    settings := config.Get().Update
+    err := update.SelfUpdate(req.SelectedFile, settings.VersionFile, settings.BackupDir)
+    if err != nil {
+        writeError(w, err, apierror.CodeSelfUpdateFailed, "failed to update servis")
+        return
+    }
 
-    err := update.UpdateFirmware(selectedZipFilePath, versionFilePath, backupDir)
+    writeMessage(w, "servis updated, new process is running")
+}
+
+// GetSelfUpdateStatus возвращает результат последнего самообновления.
+func GetSelfUpdateStatus(w http.ResponseWriter, r *http.Request) {
+    status, err := selfupdate.CurrentStatus()
     if err != nil {
-        http.Error(w, fmt.Sprintf("failed to update firmware: %v", err), http.StatusInternalServerError)
+        writeError(w, err, apierror.CodeInternal, "failed to get self-update status")
         return
     }
-    w.WriteHeader(http.StatusOK)
-    w.Write([]byte("Firmware update completed successfully"))
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(status)
 }
 
-// RollbackFirmwareHandler обрабатывает запрос на откат прошивки.
-func RollbackFirmwareHandler(w http.ResponseWriter, r *http.Request) {
-    backupDir := "/root/dt_backend/UpdateBackup"
+// ListBackupsHandler возвращает список поколений резервных копий.
+func ListBackupsHandler(w http.ResponseWriter, r *http.Request) {
+    backupDir := config.Get().Update.BackupDir
 
-    versionFilePath := "/root/dt_backend/installed_versions.json"
-    installedVersions, err := update.LoadInstalledVersions(versionFilePath)
+    backups, err := update.ListBackups(backupDir)
     if err != nil {
-        http.Error(w, fmt.Sprintf("Failed to load installed versions: %v", err), http.StatusInternalServerError)
+        writeError(w, err, apierror.CodeInternal, "failed to list backups")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(backups)
+}
+
+// DownloadBackupHandler отдает поколение резервной копии в виде ZIP-архива.
+func DownloadBackupHandler(w http.ResponseWriter, r *http.Request) {
+    backupDir := config.Get().Update.BackupDir
+    id := mux.Vars(r)["id"]
+
+    if _, err := update.FindBackup(backupDir, id); err != nil {
+        writeError(w, err, apierror.CodeInternal, "failed to find backup")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/zip")
+    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"servis-backup-%s.zip\"", id))
+    if err := update.ExportBackup(backupDir, id, w); err != nil {
+        log.Printf("failed to export backup %s: %v", id, err)
+    }
+}
+
+// ExportBackupHandler сохраняет поколение резервной копии на USB-накопитель.
+func ExportBackupHandler(w http.ResponseWriter, r *http.Request) {
+    backupDir := config.Get().Update.BackupDir
+    id := mux.Vars(r)["id"]
+
+    var req ExportBackupRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeInvalidRequest(w, "invalid request payload", "")
+        return
+    }
+
+    if req.MountPoint == "" {
+        writeInvalidRequest(w, "no mount point selected", "mount_point")
         return
     }
 
-    err = update.RollbackFirmware(backupDir, installedVersions)
+    if _, err := update.FindBackup(backupDir, id); err != nil {
+        writeError(w, err, apierror.CodeInternal, "failed to find backup")
+        return
+    }
+
+    path, err := update.ExportBackupToUSB(backupDir, id, req.MountPoint)
     if err != nil {
-        http.Error(w, fmt.Sprintf("Failed to rollback firmware: %v", err), http.StatusInternalServerError)
+        writeError(w, err, apierror.CodeInternal, "failed to export backup")
         return
     }
 
-    w.WriteHeader(http.StatusOK)
-    w.Write([]byte("Firmware rollback completed successfully"))
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(ExportBackupResponse{Path: path})
 }
 
-// StartServer запускает HTTP сервер с поддержкой CORS.
-func StartServer() {
+// Serve запускает HTTPS сервер с поддержкой CORS и локальный API на Unix-сокете (control_socket) и работает до отмены ctx.
+// При отмене сервер перестает принимать соединения и ждет завершения текущих запросов в пределах
+// shutdown_timeout из конфигурации. При самообновлении слушающий сокет наследуется от предыдущего процесса,
+// поэтому соединения не теряются.
+func Serve(ctx context.Context) error {
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+
+    err := auth.Init()
+    if err != nil {
+        return fmt.Errorf("failed to initialize authentication: %w", err)
+    }
+
+    tlsConfig, err := certs.ServerTLSConfig()
+    if err != nil {
+        return fmt.Errorf("failed to initialize TLS: %w", err)
+    }
+
     r := mux.NewRouter()
     RegisterRoutes(r)
 
-    // Применяем CORS middleware ко всем маршрутам
-    corsRouter := enableCORS(r)
+    // Маршрут, добавленный в обход таблицы маршрутов, не попал бы в описание API
+    err = CheckRoutes(r)
+    if err != nil {
+        return err
+    }
+
+    // Применяем CORS middleware ко всем маршрутам; веб-интерфейс отдается на /ui/ вне роутера API
+    corsRouter := enableCORS(withUI(r))
+
+    listener, err := selfupdate.Listen(config.Get().Listen)
+    if err != nil {
+        return fmt.Errorf("server failed to start: %w", err)
+    }
+
+    server := &http.Server{Handler: corsRouter, TLSConfig: tlsConfig}
+    server.RegisterOnShutdown(stopStreams)
+
+    // Те же маршруты без TLS и токенов для servis ctl; сбой сокета не останавливает HTTPS сервер
+    socketServer := newSocketServer(corsRouter)
+    socketServer.RegisterOnShutdown(stopStreams)
+    unwatchSocket := serveSocket(socketServer)
+    defer unwatchSocket()
+
+    // Перенаправление с HTTP на HTTPS для клиентов, которые еще обращаются к API по http://
+    redirectServer := newRedirectServer()
+    defer redirectServer.Close()
+    unwatchRedirect := serveRedirect(redirectServer)
+    defer unwatchRedirect()
+
+    // Сервер может обслуживать несколько сокетов подряд: при смене адреса в конфигурации
+    // открывается новый сокет, а прежний закрывается, и его Serve завершается с net.ErrClosed
+    served := make(chan error, 1)
+    serve := func(l net.Listener) {
+        served <- server.ServeTLS(l, "", "")
+    }
+    unsubscribe := config.OnChange(func(old, new config.Config) {
+        if old.Listen == new.Listen {
+            return
+        }
+        l, err := selfupdate.Relisten(new.Listen)
+        if err != nil {
+            log.Printf("failed to listen on %s, still serving on %s: %v", new.Listen, old.Listen, err)
+            return
+        }
+        log.Printf("server moved from %s to %s", old.Listen, new.Listen)
+        go serve(l)
+    })
+    defer unsubscribe()
+
+    // Остановка: новые соединения не принимаются, текущие запросы (в том числе обновление прошивки) завершаются
+    stopped := make(chan error, 1)
+    go func() {
+        <-ctx.Done()
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Get().ShutdownTimeout))
+        defer cancel()
+        socketStopped := make(chan error, 1)
+        go func() { socketStopped <- socketServer.Shutdown(shutdownCtx) }()
+        err := server.Shutdown(shutdownCtx)
+        if socketErr := <-socketStopped; err == nil {
+            err = socketErr
+        }
+        stopped <- err
+    }()
 
     log.Println("server is starting...")
-    if err := http.ListenAndServe(":4444", corsRouter); err != nil {
-        log.Fatalf("server failed to start: %v", err)
+    accepting := make(chan struct{})
+    go serve(&acceptListener{Listener: listener, accepting: accepting})
+
+    // О готовности при самообновлении сообщаем, когда сервер принимает соединения и проходит /readyz
+    go func() {
+        select {
+        case <-accepting:
+            selfupdate.Ready(ctx)
+        case <-ctx.Done():
+        }
+    }()
+
+    for err := range served {
+        if errors.Is(err, net.ErrClosed) {
+            continue
+        }
+        if err != nil && err != http.ErrServerClosed {
+            server.Close()
+            socketServer.Close()
+            return fmt.Errorf("server failed: %w", err)
+        }
+        break
+    }
+
+    err = <-stopped
+    if err != nil {
+        server.Close()
+        socketServer.Close()
+        return fmt.Errorf("failed to drain requests: %w", err)
     }
+    log.Println("server stopped")
+    return nil
+}
+
+// acceptListener закрывает accepting при первом вызове Accept: с этого момента сервер принимает соединения
+type acceptListener struct {
+    net.Listener
+    accepting chan struct{}
+    once      sync.Once
+}
+
+func (l *acceptListener) Accept() (net.Conn, error) {
+    l.once.Do(func() { close(l.accepting) })
+    return l.Listener.Accept()
 }
@@ -0,0 +1,181 @@
+package api
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "io"
+    "net/http"
+    "strconv"
+    "time"
+    "servis/pkg/apierror"
+    "servis/pkg/audit"
+    "servis/pkg/auth"
+)
+
+// maxAuditBody — наибольший размер тела запроса, которое сохраняется в журнале аудита
+const maxAuditBody = 64 << 10
+
+var auditQuery = []QueryParam{
+    {Name: "since", Description: "Записи не раньше указанного времени (RFC 3339)"},
+    {Name: "until", Description: "Записи не позже указанного времени (RFC 3339)"},
+    {Name: "principal", Description: "Пользователь, имя токена или CN сертификата"},
+    {Name: "action", Description: "Действие (operationId эндпоинта, например reboot)"},
+    {Name: "outcome", Description: "Результат: success, failure или denied"},
+    {Name: "limit", Description: "Не больше указанного числа последних записей (по умолчанию 100)"},
+}
+
+var auditVerifyQuery = []QueryParam{
+    {Name: "seq", Description: "Номер записи, сохраненной ранее (например, audit_head из состояния MQTT)"},
+    {Name: "hash", Description: "Хеш этой записи; журнал должен ее содержать"},
+}
+
+type auditKey struct{}
+
+// auditState передает пользователя и тело запроса из обработчика, выполняемого после проверки токена, в auditRequest
+type auditState struct {
+    identity *auth.Identity
+    payload  json.RawMessage
+}
+
+// auditRecorder запоминает код ответа и начало тела ответа с ошибкой, чтобы записать код ошибки
+type auditRecorder struct {
+    http.ResponseWriter
+    status int
+    body   bytes.Buffer
+}
+
+func (r *auditRecorder) WriteHeader(status int) {
+    r.status = status
+    r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *auditRecorder) Write(data []byte) (int, error) {
+    if r.status >= 400 && r.body.Len() < 4096 {
+        r.body.Write(data)
+    }
+    return r.ResponseWriter.Write(data)
+}
+
+// auditRequest записывает изменяющий запрос в журнал аудита после его выполнения,
+// в том числе запросы, отклоненные при проверке токена или роли (без тела запроса)
+func auditRequest(route Route, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        state := &auditState{}
+        recorder := &auditRecorder{ResponseWriter: w, status: http.StatusOK}
+        next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), auditKey{}, state)))
+
+        entry := audit.Entry{
+            Principal: "anonymous",
+            Client:    clientAddress(r),
+            Action:    route.OperationID,
+            Method:    r.Method,
+            Path:      r.URL.Path,
+            Payload:   state.payload,
+            Status:    recorder.status,
+            Outcome:   audit.OutcomeSuccess,
+        }
+        if state.identity != nil {
+            entry.Principal = state.identity.Name
+            entry.Role = state.identity.Role
+            entry.TokenID = state.identity.TokenID
+        }
+
+        if recorder.status >= 400 {
+            var response apierror.Response
+            json.Unmarshal(recorder.body.Bytes(), &response)
+            entry.ErrorCode = response.Error.Code
+
+            entry.Outcome = audit.OutcomeFailure
+            switch entry.ErrorCode {
+            case apierror.CodeAuthenticationRequired, apierror.CodeInvalidToken, apierror.CodeInsufficientRole, apierror.CodeInvalidCredentials:
+                entry.Outcome = audit.OutcomeDenied
+            }
+        }
+
+        audit.Log(entry)
+    })
+}
+
+// captureIdentity сохраняет пользователя, прошедшего проверку токена и роли, и тело его запроса для записи
+// в журнал аудита. Тело читается только здесь: запросы без действующего токена не оставляют в журнале
+// произвольных данных.
+func captureIdentity(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if state, ok := r.Context().Value(auditKey{}).(*auditState); ok {
+            state.identity = auth.FromContext(r.Context())
+
+            body, _ := io.ReadAll(io.LimitReader(r.Body, maxAuditBody+1))
+            r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+            if len(body) > maxAuditBody {
+                state.payload = json.RawMessage(`{"truncated":true}`)
+            } else {
+                state.payload = audit.Redact(body)
+            }
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// GetAuditLog возвращает записи журнала аудита с фильтрами из параметров запроса.
+func GetAuditLog(w http.ResponseWriter, r *http.Request) {
+    params := r.URL.Query()
+    query := audit.Query{
+        Principal: params.Get("principal"),
+        Action:    params.Get("action"),
+        Outcome:   params.Get("outcome"),
+        Limit:     100,
+    }
+
+    for name, target := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+        value := params.Get(name)
+        if value == "" {
+            continue
+        }
+        parsed, err := time.Parse(time.RFC3339, value)
+        if err != nil {
+            writeInvalidRequest(w, "invalid "+name+": expected RFC 3339 time", name)
+            return
+        }
+        *target = parsed
+    }
+
+    if value := params.Get("limit"); value != "" {
+        limit, err := strconv.Atoi(value)
+        if err != nil || limit < 1 {
+            writeInvalidRequest(w, "invalid limit", "limit")
+            return
+        }
+        query.Limit = limit
+    }
+
+    entries, err := audit.Find(query)
+    if err != nil {
+        writeError(w, err, apierror.CodeInternal, "failed to read audit log")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(entries)
+}
+
+// VerifyAuditLog проверяет цепочку хешей журнала аудита и, если переданы seq и hash, наличие этой записи.
+func VerifyAuditLog(w http.ResponseWriter, r *http.Request) {
+    var expected *audit.Head
+    params := r.URL.Query()
+    if value := params.Get("seq"); value != "" || params.Get("hash") != "" {
+        seq, err := strconv.ParseUint(value, 10, 64)
+        if err != nil || seq == 0 {
+            writeInvalidRequest(w, "invalid seq", "seq")
+            return
+        }
+        if params.Get("hash") == "" {
+            writeInvalidRequest(w, "hash is required with seq", "hash")
+            return
+        }
+        expected = &audit.Head{Seq: seq, Hash: params.Get("hash")}
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(audit.Verify(expected))
+}
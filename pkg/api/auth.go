@@ -0,0 +1,162 @@
+package api
+
+import (
+    "encoding/json"
+    "net/http"
+    "time"
+    "servis/pkg/apierror"
+    "servis/pkg/auth"
+    "github.com/gorilla/mux"
+)
+
+type LoginRequest struct {
+    Username string `json:"username"`
+    Password string `json:"password"`
+}
+
+type LoginResponse struct {
+    Token     string     `json:"token"`
+    Role      string     `json:"role"`
+    ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type CreateUserRequest struct {
+    Username string `json:"username"`
+    Password string `json:"password"`
+    Role     string `json:"role"`
+}
+
+type CreateTokenRequest struct {
+    Name      string `json:"name"`
+    Role      string `json:"role"`
+    ExpiresIn string `json:"expires_in,omitempty"` // например, "720h"; пустое значение — без срока действия
+}
+
+type CreateTokenResponse struct {
+    Token string      `json:"token"` // возвращается только один раз
+    Info  *auth.Token `json:"info"`
+}
+
+// LoginHandler выдает токен по логину и паролю локальной учетной записи.
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
+    var req LoginRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeInvalidRequest(w, "invalid request payload", "")
+        return
+    }
+
+    plain, token, err := auth.Login(req.Username, req.Password)
+    if err != nil {
+        writeError(w, err, apierror.CodeInternal, "failed to login")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(LoginResponse{
+        Token:     plain,
+        Role:      token.Role,
+        ExpiresAt: token.ExpiresAt,
+    })
+}
+
+// GetCurrentIdentity возвращает пользователя, от имени которого выполняется запрос.
+func GetCurrentIdentity(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(auth.FromContext(r.Context()))
+}
+
+// ListUsersHandler возвращает список локальных учетных записей.
+func ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+    users, err := auth.ListUsers()
+    if err != nil {
+        writeError(w, err, apierror.CodeInternal, "failed to list users")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(users)
+}
+
+// CreateUserHandler создает учетную запись или меняет пароль и роль существующей.
+func CreateUserHandler(w http.ResponseWriter, r *http.Request) {
+    var req CreateUserRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeInvalidRequest(w, "invalid request payload", "")
+        return
+    }
+
+    user, err := auth.CreateUser(req.Username, req.Password, req.Role)
+    if err != nil {
+        writeError(w, err, apierror.CodeInternal, "failed to create user")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(user)
+}
+
+// DeleteUserHandler удаляет учетную запись и ее сессии.
+func DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+    err := auth.DeleteUser(mux.Vars(r)["username"])
+    if err != nil {
+        writeError(w, err, apierror.CodeInternal, "failed to delete user")
+        return
+    }
+
+    writeMessage(w, "user deleted")
+}
+
+// ListTokensHandler возвращает список действующих API-токенов.
+func ListTokensHandler(w http.ResponseWriter, r *http.Request) {
+    tokens, err := auth.ListTokens()
+    if err != nil {
+        writeError(w, err, apierror.CodeInternal, "failed to list tokens")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(tokens)
+}
+
+// CreateTokenHandler создает API-токен. Токен возвращается только в этом ответе.
+func CreateTokenHandler(w http.ResponseWriter, r *http.Request) {
+    var req CreateTokenRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeInvalidRequest(w, "invalid request payload", "")
+        return
+    }
+
+    var ttl time.Duration
+    if req.ExpiresIn != "" {
+        var err error
+        ttl, err = time.ParseDuration(req.ExpiresIn)
+        if err != nil || ttl <= 0 {
+            writeInvalidRequest(w, "invalid expires_in", "expires_in")
+            return
+        }
+    }
+
+    plain, token, err := auth.CreateToken(req.Name, req.Role, ttl)
+    if err != nil {
+        writeError(w, err, apierror.CodeInternal, "failed to create token")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(CreateTokenResponse{
+        Token: plain,
+        Info:  token,
+    })
+}
+
+// RevokeTokenHandler отзывает API-токен.
+func RevokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+    err := auth.RevokeToken(mux.Vars(r)["id"])
+    if err != nil {
+        writeError(w, err, apierror.CodeInternal, "failed to revoke token")
+        return
+    }
+
+    writeMessage(w, "token revoked")
+}
+
@@ -0,0 +1,53 @@
+package api
+
+import (
+    "encoding/json"
+    "net/http"
+    "servis/pkg/apierror"
+    "servis/pkg/service"
+    "servis/pkg/shutdown"
+)
+
+// MessageResponse — ответ на команду, которая не возвращает данных
+type MessageResponse struct {
+    Message string `json:"message"`
+}
+
+// errorDetails — типы деталей для кодов ошибок, у которых они есть; используются в описании OpenAPI
+var errorDetails = map[string]interface{}{
+    apierror.CodeUpdateInProgress:        service.BusyDetails{},
+    apierror.CodeUpdateInterrupted:       service.BusyDetails{},
+    apierror.CodeManifestInvalid:         service.ManifestDetails{},
+    apierror.CodeDependencyConflict:      service.DependencyDetails{},
+    apierror.CodePowerActionPending:      shutdown.Pending{},
+    apierror.CodeMaintenanceWindowClosed: service.WindowDetails{},
+    apierror.CodeRateLimited:             service.RetryDetails{},
+    apierror.CodeLockedOut:               service.RetryDetails{},
+}
+
+// writeError отвечает ошибкой с кодом, соответствующим err. Неизвестная ошибка записывается в журнал,
+// а клиент получает код fallbackCode и сообщение message без подробностей.
+func writeError(w http.ResponseWriter, err error, fallbackCode, message string) {
+    apierror.Write(w, service.Classify(err, fallbackCode, message))
+}
+
+// writeInvalidRequest отвечает ошибкой invalid_request; field — поле запроса, вызвавшее ошибку
+func writeInvalidRequest(w http.ResponseWriter, message, field string) {
+    apierror.Write(w, service.Invalid(message, field))
+}
+
+// writeMessage отвечает на успешную команду
+func writeMessage(w http.ResponseWriter, message string) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(MessageResponse{Message: message})
+}
+
+// notFound отвечает на запрос к неизвестному маршруту
+func notFound(w http.ResponseWriter, r *http.Request) {
+    apierror.Write(w, apierror.New(apierror.CodeNotFound, "no such endpoint"))
+}
+
+// methodNotAllowed отвечает на запрос с неподдерживаемым методом
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+    apierror.Write(w, apierror.New(apierror.CodeMethodNotAllowed, r.Method+" is not allowed for "+r.URL.Path))
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+    "servis/pkg/apierror"
+    "servis/pkg/events"
+    "github.com/gorilla/websocket"
+)
+
+// Интервалы служебных сообщений, по которым клиент и прокси понимают, что соединение живо
+const (
+    sseHeartbeat   = 15 * time.Second
+    wsPingInterval = 30 * time.Second
+    wsWriteTimeout = 10 * time.Second
+)
+
+// streamsCtx отменяется при остановке сервера, чтобы закрыть открытые потоки событий
+var streamsCtx, stopStreams = context.WithCancel(context.Background())
+
+// WebSocket не подчиняется CORS, а клиентский сертификат mTLS браузер отправляет сам, поэтому страница
+// с чужого источника могла бы открыть поток от имени пользователя. Подключение разрешено с того же источника,
+// из cors_origins и без заголовка Origin (клиенты не из браузера).
+var upgrader = websocket.Upgrader{
+    CheckOrigin: checkOrigin,
+}
+
+// checkOrigin сообщает, что подключение WebSocket пришло с разрешенного источника
+func checkOrigin(r *http.Request) bool {
+    origin := r.Header.Get("Origin")
+    if origin == "" || allowedOrigin(origin) {
+        return true
+    }
+    u, err := url.Parse(origin)
+    if err == nil && strings.EqualFold(u.Host, r.Host) {
+        return true
+    }
+    log.Printf("Rejected event stream from origin %s", origin)
+    return false
+}
+
+// truncatedNotice сообщает клиенту, что часть пропущенных событий уже недоступна и состояние нужно перечитать
+var truncatedNotice = map[string]string{"topic": "events", "type": "replay_truncated"}
+
+// subscribeFromRequest подписывается на темы из параметра topics (через запятую) и возвращает
+// события, пропущенные после Last-Event-ID (заголовок) или last_event_id (параметр)
+func subscribeFromRequest(r *http.Request) (*events.Subscription, []events.Event, bool, error) {
+    var topics []string
+    if value := r.URL.Query().Get("topics"); value != "" {
+        for _, topic := range strings.Split(value, ",") {
+            topic = strings.TrimSpace(topic)
+            if !events.ValidTopic(topic) {
+                return nil, nil, false, fmt.Errorf("unknown topic %q", topic)
+            }
+            topics = append(topics, topic)
+        }
+    }
+
+    lastID := r.Header.Get("Last-Event-ID")
+    if lastID == "" {
+        lastID = r.URL.Query().Get("last_event_id")
+    }
+    var afterID uint64
+    if lastID != "" {
+        var err error
+        afterID, err = strconv.ParseUint(lastID, 10, 64)
+        if err != nil {
+            return nil, nil, false, fmt.Errorf("invalid last event id %q", lastID)
+        }
+    }
+
+    sub, replay, truncated := events.Subscribe(topics, afterID)
+    return sub, replay, truncated, nil
+}
+
+// StreamEventsSSE отправляет события в формате Server-Sent Events.
+func StreamEventsSSE(w http.ResponseWriter, r *http.Request) {
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        apierror.Write(w, apierror.New(apierror.CodeInternal, "streaming is not supported"))
+        return
+    }
+
+    sub, replay, truncated, err := subscribeFromRequest(r)
+    if err != nil {
+        apierror.Write(w, apierror.New(apierror.CodeInvalidRequest, err.Error()))
+        return
+    }
+    defer sub.Close()
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("X-Accel-Buffering", "no")
+    w.WriteHeader(http.StatusOK)
+
+    if truncated {
+        writeSSE(w, 0, truncatedNotice)
+    }
+    for _, event := range replay {
+        writeSSE(w, event.ID, event)
+    }
+    flusher.Flush()
+
+    heartbeat := time.NewTicker(sseHeartbeat)
+    defer heartbeat.Stop()
+
+    for {
+        select {
+        case <-r.Context().Done():
+            return
+        case <-streamsCtx.Done():
+            return
+        case event, ok := <-sub.C:
+            if !ok {
+                // Клиент не успевал читать события; после переподключения он получит пропущенные
+                return
+            }
+            writeSSE(w, event.ID, event)
+            flusher.Flush()
+        case <-heartbeat.C:
+            fmt.Fprint(w, ": ping\n\n")
+            flusher.Flush()
+        }
+    }
+}
+
+// writeSSE записывает одно сообщение SSE; id используется браузером как Last-Event-ID при переподключении
+func writeSSE(w http.ResponseWriter, id uint64, payload interface{}) {
+    data, err := json.Marshal(payload)
+    if err != nil {
+        log.Printf("failed to marshal event: %v", err)
+        return
+    }
+    if id > 0 {
+        fmt.Fprintf(w, "id: %d\n", id)
+    }
+    fmt.Fprintf(w, "data: %s\n\n", data)
+}
+
+// StreamEventsWS отправляет события через WebSocket в виде JSON-сообщений.
+func StreamEventsWS(w http.ResponseWriter, r *http.Request) {
+    sub, replay, truncated, err := subscribeFromRequest(r)
+    if err != nil {
+        apierror.Write(w, apierror.New(apierror.CodeInvalidRequest, err.Error()))
+        return
+    }
+    defer sub.Close()
+
+    conn, err := upgrader.Upgrade(w, r, nil)
+    if err != nil {
+        // Upgrader уже отправил клиенту ответ с ошибкой
+        log.Printf("failed to upgrade to websocket: %v", err)
+        return
+    }
+    defer conn.Close()
+
+    // Клиент ничего не отправляет; чтение нужно, чтобы обрабатывать pong и закрытие соединения
+    closed := make(chan struct{})
+    conn.SetReadLimit(512)
+    conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+    conn.SetPongHandler(func(string) error {
+        return conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+    })
+    go func() {
+        defer close(closed)
+        for {
+            if _, _, err := conn.ReadMessage(); err != nil {
+                return
+            }
+        }
+    }()
+
+    write := func(payload interface{}) bool {
+        conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+        return conn.WriteJSON(payload) == nil
+    }
+
+    if truncated && !write(truncatedNotice) {
+        return
+    }
+    for _, event := range replay {
+        if !write(event) {
+            return
+        }
+    }
+
+    ping := time.NewTicker(wsPingInterval)
+    defer ping.Stop()
+
+    for {
+        select {
+        case <-closed:
+            return
+        case <-streamsCtx.Done():
+            conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server is stopping"), time.Now().Add(time.Second))
+            return
+        case event, ok := <-sub.C:
+            if !ok {
+                conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "subscriber is too slow"), time.Now().Add(time.Second))
+                return
+            }
+            if !write(event) {
+                return
+            }
+        case <-ping.C:
+            if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)) != nil {
+                return
+            }
+        }
+    }
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+    "encoding/json"
+    "net/http"
+    "servis/pkg/auth"
+    "servis/pkg/health"
+)
+
+// GetHealth выполняет проверки работоспособности процесса.
+func GetHealth(w http.ResponseWriter, r *http.Request) {
+    writeHealth(w, r, health.Live(r.Context()))
+}
+
+// GetReadiness выполняет все проверки подсистем.
+func GetReadiness(w http.ResponseWriter, r *http.Request) {
+    writeHealth(w, r, health.Ready(r.Context()))
+}
+
+// writeHealth отвечает кодом 200, если все проверки прошли, и 503 в противном случае, чтобы балансировщику
+// и мониторингу было достаточно кода ответа. Сведения и тексты ошибок получают только клиенты с токеном
+// или сертификатом: в них есть адреса и пути устройства.
+func writeHealth(w http.ResponseWriter, r *http.Request, report health.Report) {
+    if auth.Identify(r) == nil {
+        report = report.WithoutDetails()
+    }
+
+    status := http.StatusOK
+    if report.Status != health.StatusOK {
+        status = http.StatusServiceUnavailable
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+    "net/http"
+    "servis/pkg/metrics"
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+    httpRequestsTotal = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+        Namespace: metrics.Namespace,
+        Subsystem: "http",
+        Name:      "requests_total",
+        Help:      "Запросы к API по маршруту, методу и HTTP-коду ответа.",
+    }, []string{"route", "method", "code"})
+
+    httpRequestDuration = metrics.Factory.NewHistogramVec(prometheus.HistogramOpts{
+        Namespace: metrics.Namespace,
+        Subsystem: "http",
+        Name:      "request_duration_seconds",
+        Help:      "Длительность обработки запросов к API; для потоков событий — длительность подключения.",
+        Buckets:   prometheus.DefBuckets,
+    }, []string{"route", "method"})
+)
+
+var metricsHandler = metrics.Handler()
+
+// instrument считает запросы к маршруту и время их обработки. path — шаблон зарегистрированного пути
+// (например, /api/v1/firmware/backups/{id}/archive), поэтому число значений метки не зависит от запросов.
+// Обертка сохраняет http.Flusher и http.Hijacker, нужные потокам событий.
+func instrument(path string, next http.Handler) http.Handler {
+    labels := prometheus.Labels{"route": path}
+    return promhttp.InstrumentHandlerCounter(httpRequestsTotal.MustCurryWith(labels),
+        promhttp.InstrumentHandlerDuration(httpRequestDuration.MustCurryWith(labels), next))
+}
+
+// GetMetrics отдает метрики в текстовом формате Prometheus.
+func GetMetrics(w http.ResponseWriter, r *http.Request) {
+    metricsHandler.ServeHTTP(w, r)
+}
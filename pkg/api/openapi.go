@@ -0,0 +1,435 @@
+package api
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "path"
+    "reflect"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+    "servis/pkg/apierror"
+    "servis/pkg/events"
+    "github.com/gorilla/mux"
+)
+
+// openAPIDocs — описания версий API, построенные при регистрации маршрутов
+var openAPIDocs = make(map[string][]byte)
+
+var pathParamPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+// errorDescriptions — описания HTTP-кодов ошибок
+var errorDescriptions = map[int]string{
+    http.StatusBadRequest:          "Некорректный запрос",
+    http.StatusUnauthorized:        "Токен не передан или недействителен",
+    http.StatusForbidden:           "Недостаточно прав",
+    http.StatusNotFound:            "Не найдено",
+    http.StatusConflict:            "Конфликт с текущим состоянием",
+    http.StatusUnprocessableEntity: "Пакет прошивки не прошел проверку",
+    http.StatusTooManyRequests:     "Превышен лимит запросов или клиент заблокирован после неудачных попыток входа",
+    http.StatusInternalServerError: "Внутренняя ошибка",
+    http.StatusServiceUnavailable:  "Сетевой интерфейс недоступен или servis останавливается",
+}
+
+// schemaNames задает имена схем для типов, имя которых без пакета неоднозначно
+var schemaNames = map[reflect.Type]string{
+    reflect.TypeOf(apierror.Response{}): "ErrorResponse",
+    reflect.TypeOf(apierror.Error{}):    "APIError",
+}
+
+// GetOpenAPI отдает описание версии API, к которой относится запрос, в формате OpenAPI 3.
+func GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+    var doc []byte
+    if v := requestVersion(r); v != nil {
+        doc = openAPIDocs[v.Name]
+    }
+    if doc == nil {
+        apierror.Write(w, apierror.New(apierror.CodeInternal, "routes are not registered"))
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(doc)
+}
+
+// OpenAPI строит описание версии API (например, v1) по ее таблице маршрутов
+func OpenAPI(version string) ([]byte, error) {
+    v := findVersion(version)
+    if v == nil {
+        return nil, fmt.Errorf("unknown API version %s", version)
+    }
+    return buildOpenAPI(v)
+}
+
+// CheckRoutes сверяет маршруты, зарегистрированные в роутере, с описанием API:
+// каждый маршрут роутера должен быть описан, а каждый описанный — зарегистрирован
+func CheckRoutes(r *mux.Router) error {
+    described := make(map[string]bool)
+    for _, key := range registeredPaths() {
+        described[key] = true
+    }
+
+    registered := make(map[string]bool)
+    var problems []string
+    err := r.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+        path, err := route.GetPathTemplate()
+        if err != nil {
+            return nil
+        }
+        methods, err := route.GetMethods()
+        if err != nil {
+            problems = append(problems, fmt.Sprintf("%s: route without methods", path))
+            return nil
+        }
+        for _, method := range methods {
+            key := method + " " + path
+            registered[key] = true
+            if !described[key] {
+                problems = append(problems, fmt.Sprintf("%s is not described in OpenAPI", key))
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return fmt.Errorf("failed to walk routes: %w", err)
+    }
+
+    for key := range described {
+        if !registered[key] {
+            problems = append(problems, fmt.Sprintf("%s is described in OpenAPI but not registered", key))
+        }
+    }
+
+    if len(problems) > 0 {
+        sort.Strings(problems)
+        return fmt.Errorf("routes do not match OpenAPI: %s", strings.Join(problems, "; "))
+    }
+    return nil
+}
+
+// buildOpenAPI строит документ OpenAPI 3 для версии API; схемы тел запросов и ответов строятся по типам Go
+func buildOpenAPI(v *Version) ([]byte, error) {
+    builder := &schemaBuilder{components: make(map[string]interface{}), types: make(map[string]reflect.Type)}
+    paths := make(map[string]map[string]interface{})
+    operationIDs := make(map[string]bool)
+
+    for _, route := range v.Routes {
+        if route.Handler == nil || route.OperationID == "" {
+            return nil, fmt.Errorf("%s %s: handler and operation id are required", route.Method, route.Path)
+        }
+        if operationIDs[route.OperationID] {
+            return nil, fmt.Errorf("duplicate operation id %s", route.OperationID)
+        }
+        operationIDs[route.OperationID] = true
+
+        if paths[route.Path] == nil {
+            paths[route.Path] = make(map[string]interface{})
+        }
+        paths[route.Path][strings.ToLower(route.Method)] = builder.operation(route)
+    }
+
+    // Формат сообщений потока событий
+    builder.schema(reflect.TypeOf(events.Event{}))
+
+    // Детали ошибок; поле details ответа с ошибкой имеет тип, указанный для ее кода
+    for _, details := range errorDetails {
+        builder.schema(reflect.TypeOf(details))
+    }
+
+    if builder.err != nil {
+        return nil, builder.err
+    }
+
+    doc := map[string]interface{}{
+        "openapi": "3.0.3",
+        "info": map[string]interface{}{
+            "title":       "servis API",
+            "version":     v.Release,
+            "description": "API управления устройством: сеть, USB-накопители, обновление прошивки. Вместо токена можно предъявить клиентский сертификат (mTLS), если на устройстве настроен client_ca.crt. Ошибки возвращаются в формате ErrorResponse со стабильным кодом (error.code); коды, возможные для операции, перечислены в x-error-codes ответа. Пути указаны относительно адреса сервера с префиксом версии.",
+            "x-api-version": v.Name,
+            "x-error-codes": apierror.Codes(),
+        },
+        "servers": []interface{}{
+            map[string]interface{}{
+                "url":       "https://{host}:4444" + v.Prefix(),
+                "variables": map[string]interface{}{"host": map[string]interface{}{"default": "localhost"}},
+            },
+        },
+        "paths": paths,
+        "components": map[string]interface{}{
+            "schemas": builder.components,
+            "securitySchemes": map[string]interface{}{
+                "bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer"},
+            },
+        },
+    }
+
+    return json.MarshalIndent(doc, "", "  ")
+}
+
+// schemaBuilder строит JSON Schema по типам Go и собирает именованные структуры в components
+type schemaBuilder struct {
+    components map[string]interface{}
+    types      map[string]reflect.Type
+    err        error
+}
+
+// operation описывает один маршрут
+func (b *schemaBuilder) operation(route Route) map[string]interface{} {
+    op := map[string]interface{}{
+        "operationId": route.OperationID,
+        "summary":     route.Summary,
+        "tags":        []string{strings.Split(strings.TrimPrefix(route.Path, "/"), "/")[0]},
+    }
+
+    var parameters []interface{}
+    for _, match := range pathParamPattern.FindAllStringSubmatch(route.Path, -1) {
+        parameters = append(parameters, map[string]interface{}{
+            "name":     match[1],
+            "in":       "path",
+            "required": true,
+            "schema":   map[string]interface{}{"type": "string"},
+        })
+    }
+    for _, param := range route.Query {
+        parameters = append(parameters, map[string]interface{}{
+            "name":        param.Name,
+            "in":          "query",
+            "description": param.Description,
+            "schema":      map[string]interface{}{"type": "string"},
+        })
+    }
+    if len(parameters) > 0 {
+        op["parameters"] = parameters
+    }
+
+    codes := append([]string{}, route.Errors...)
+    if route.Role == "" {
+        op["security"] = []interface{}{}
+    } else {
+        op["security"] = []interface{}{map[string]interface{}{"bearerAuth": []string{}}}
+        op["x-required-role"] = route.Role
+        codes = append(codes, apierror.CodeAuthenticationRequired, apierror.CodeInvalidToken, apierror.CodeInsufficientRole)
+    }
+
+    if route.Request != nil {
+        requestType := reflect.TypeOf(route.Request)
+        op["requestBody"] = map[string]interface{}{
+            // Тело, у которого все поля необязательные, можно не передавать
+            "required": hasRequiredFields(requestType),
+            "content": map[string]interface{}{
+                "application/json": map[string]interface{}{"schema": b.schema(requestType)},
+            },
+        }
+        codes = append(codes, apierror.CodeInvalidRequest)
+    }
+    codes = append(codes, apierror.CodeRateLimited, apierror.CodeLockedOut, apierror.CodeInternal)
+
+    responses := make(map[string]interface{})
+    switch {
+    case route.Response != nil:
+        responses["200"] = jsonResponse("OK", b.schema(reflect.TypeOf(route.Response)))
+    case route.ContentType == "websocket":
+        responses["101"] = map[string]interface{}{"description": "Соединение переключено на WebSocket"}
+    case route.ContentType == "application/json":
+        responses["200"] = jsonResponse("OK", map[string]interface{}{"type": "object"})
+    case route.ContentType != "":
+        responses["200"] = map[string]interface{}{
+            "description": "OK",
+            "content": map[string]interface{}{
+                route.ContentType: map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}},
+            },
+        }
+    default:
+        responses["200"] = jsonResponse("OK", b.schema(reflect.TypeOf(MessageResponse{})))
+    }
+
+    // Коды ошибок группируются по HTTP-коду ответа
+    byStatus := make(map[int][]string)
+    for _, code := range codes {
+        status := apierror.Status(code)
+        if !containsString(byStatus[status], code) {
+            byStatus[status] = append(byStatus[status], code)
+        }
+    }
+    errorSchema := b.schema(reflect.TypeOf(apierror.Response{}))
+    for status, statusCodes := range byStatus {
+        sort.Strings(statusCodes)
+        response := jsonResponse(fmt.Sprintf("%s (коды: %s)", errorDescriptions[status], strings.Join(statusCodes, ", ")), errorSchema)
+        response["x-error-codes"] = statusCodes
+        responses[strconv.Itoa(status)] = response
+    }
+
+    op["responses"] = responses
+    return op
+}
+
+func jsonResponse(description string, schema interface{}) map[string]interface{} {
+    return map[string]interface{}{
+        "description": description,
+        "content": map[string]interface{}{
+            "application/json": map[string]interface{}{"schema": schema},
+        },
+    }
+}
+
+// schema возвращает JSON Schema для типа Go. Именованные структуры попадают в components и заменяются ссылкой.
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+    switch {
+    case t == reflect.TypeOf(time.Time{}):
+        return map[string]interface{}{"type": "string", "format": "date-time"}
+    case t == reflect.TypeOf(time.Duration(0)):
+        return map[string]interface{}{"type": "integer", "format": "int64", "description": "наносекунды"}
+    case t == reflect.TypeOf(json.RawMessage(nil)):
+        // Произвольный JSON, а не строка base64, как у остальных []byte
+        return map[string]interface{}{}
+    }
+
+    switch t.Kind() {
+    case reflect.Ptr:
+        inner := b.schema(t.Elem())
+        if _, ok := inner["$ref"]; ok {
+            return map[string]interface{}{"allOf": []interface{}{inner}, "nullable": true}
+        }
+        inner["nullable"] = true
+        return inner
+    case reflect.Struct:
+        if t.Name() == "" {
+            return b.object(t)
+        }
+        name := schemaName(t)
+        if existing, ok := b.types[name]; ok {
+            if existing != t && b.err == nil {
+                b.err = fmt.Errorf("schema name %s is used by %s and %s", name, existing, t)
+            }
+        } else {
+            b.types[name] = t
+            b.components[name] = map[string]interface{}{}
+            b.components[name] = b.object(t)
+        }
+        return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+    case reflect.Slice, reflect.Array:
+        if t.Elem().Kind() == reflect.Uint8 {
+            return map[string]interface{}{"type": "string", "format": "byte"}
+        }
+        return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+    case reflect.Map:
+        return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+    case reflect.String:
+        return map[string]interface{}{"type": "string"}
+    case reflect.Bool:
+        return map[string]interface{}{"type": "boolean"}
+    case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+        return map[string]interface{}{"type": "integer", "format": "int64"}
+    case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+        return map[string]interface{}{"type": "integer", "format": "int32"}
+    case reflect.Float32, reflect.Float64:
+        return map[string]interface{}{"type": "number"}
+    case reflect.Interface:
+        return map[string]interface{}{}
+    }
+
+    if b.err == nil {
+        b.err = fmt.Errorf("unsupported type %s in API schema", t)
+    }
+    return map[string]interface{}{}
+}
+
+// schemaName возвращает имя схемы для типа. Типам из других пакетов, кроме service с запросами и ответами API, добавляется имя пакета
+// (update.Job — UpdateJob), чтобы certs.Status и selfupdate.Status не совпадали.
+func schemaName(t reflect.Type) string {
+    if name, ok := schemaNames[t]; ok {
+        return name
+    }
+    pkg := path.Base(t.PkgPath())
+    name := strings.ToLower(t.Name())
+    if pkg == "api" || pkg == "service" || strings.Contains(name, pkg) || strings.Contains(pkg, name) {
+        return t.Name()
+    }
+    return strings.ToUpper(pkg[:1]) + pkg[1:] + t.Name()
+}
+
+// object описывает структуру по ее полям с тегами json
+func (b *schemaBuilder) object(t reflect.Type) map[string]interface{} {
+    properties := make(map[string]interface{})
+    var required []string
+
+    var collect func(t reflect.Type)
+    collect = func(t reflect.Type) {
+        for i := 0; i < t.NumField(); i++ {
+            field := t.Field(i)
+            name, omitempty, skip := jsonField(field)
+            if skip {
+                continue
+            }
+            if field.Anonymous && name == "" {
+                embedded := field.Type
+                if embedded.Kind() == reflect.Ptr {
+                    embedded = embedded.Elem()
+                }
+                collect(embedded)
+                continue
+            }
+            if name == "" {
+                name = field.Name
+            }
+
+            properties[name] = b.schema(field.Type)
+            if !omitempty {
+                required = append(required, name)
+            }
+        }
+    }
+    collect(t)
+
+    schema := map[string]interface{}{"type": "object", "properties": properties}
+    if len(required) > 0 {
+        schema["required"] = required
+    }
+    return schema
+}
+
+// jsonField возвращает имя поля в JSON и признак omitempty; skip — поле не сериализуется
+func jsonField(field reflect.StructField) (name string, omitempty, skip bool) {
+    if !field.IsExported() && !field.Anonymous {
+        return "", false, true
+    }
+
+    tag := field.Tag.Get("json")
+    if tag == "-" {
+        return "", false, true
+    }
+
+    parts := strings.Split(tag, ",")
+    for _, option := range parts[1:] {
+        if option == "omitempty" {
+            omitempty = true
+        }
+    }
+    return parts[0], omitempty, false
+}
+
+func containsString(list []string, value string) bool {
+    for _, item := range list {
+        if item == value {
+            return true
+        }
+    }
+    return false
+}
+
+// hasRequiredFields сообщает, есть ли у тела запроса обязательные поля
+func hasRequiredFields(t reflect.Type) bool {
+    if t.Kind() != reflect.Struct {
+        return true
+    }
+    for i := 0; i < t.NumField(); i++ {
+        if _, omitempty, skip := jsonField(t.Field(i)); !skip && !omitempty {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,503 @@
+package api
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "go/ast"
+    "go/importer"
+    "go/parser"
+    "go/token"
+    "go/types"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "reflect"
+    "runtime"
+    "sort"
+    "strings"
+    "testing"
+    "time"
+    "servis/pkg/audit"
+    "servis/pkg/auth"
+    "servis/pkg/rtc"
+    "servis/pkg/scheduler"
+    "servis/pkg/update"
+    "github.com/gorilla/mux"
+)
+
+// TestOpenAPIDescribesRoutes проверяет, что каждый путь из registeredPaths описан в документе своей версии API
+func TestOpenAPIDescribesRoutes(t *testing.T) {
+    described := make(map[string]bool)
+    for _, v := range versions {
+        data, err := OpenAPI(v.Name)
+        if err != nil {
+            t.Fatalf("failed to build OpenAPI for %s: %v", v.Name, err)
+        }
+        var doc struct {
+            Paths map[string]map[string]json.RawMessage `json:"paths"`
+        }
+        if err := json.Unmarshal(data, &doc); err != nil {
+            t.Fatalf("failed to parse OpenAPI for %s: %v", v.Name, err)
+        }
+        for path, methods := range doc.Paths {
+            for method := range methods {
+                described[strings.ToUpper(method)+" "+v.Prefix()+path] = true
+                if v.Name == legacyVersion {
+                    described[strings.ToUpper(method)+" "+path] = true
+                }
+            }
+        }
+    }
+
+    // Маршруты вне версий не входят в описание OpenAPI
+    root := make(map[string]bool)
+    for _, route := range rootRoutes {
+        root[route.Method+" "+route.Path] = true
+    }
+
+    for _, key := range registeredPaths() {
+        if !described[key] && !root[key] {
+            t.Errorf("%s is registered but not described in OpenAPI", key)
+        }
+    }
+}
+
+// alternativeResponses — ответы, которые обработчик отдает помимо описанного в таблице маршрутов: полный откат
+// (POST /firmware/rollback без тела) отвечает сообщением, для него в клиенте есть RollbackLatest
+var alternativeResponses = map[string]string{
+    "rollbackFirmware": "api.MessageResponse",
+}
+
+// TestRouteTypesMatchHandlers сверяет типы Request и Response в таблице маршрутов с типами, которые обработчик
+// действительно читает из тела запроса и пишет в ответ. Вызовы функций пакета api (например, writeMessage)
+// учитываются так же, как код самого обработчика.
+func TestRouteTypesMatchHandlers(t *testing.T) {
+    pkg, files := checkPackage(t)
+    funcs := make(map[string]*ast.FuncDecl)
+    for _, file := range files {
+        for _, decl := range file.Decls {
+            if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil {
+                funcs[fn.Name.Name] = fn
+            }
+        }
+    }
+
+    var routes []Route
+    for _, v := range versions {
+        routes = append(routes, v.Routes...)
+    }
+    routes = append(routes, rootRoutes...)
+
+    for _, route := range routes {
+        name := handlerName(route.Handler)
+        fn, ok := funcs[name]
+        if !ok {
+            t.Errorf("%s %s: handler %s is not a function of package api", route.Method, route.Path, name)
+            continue
+        }
+        decoded, encoded := jsonTypes(pkg, funcs, fn)
+
+        switch {
+        case route.Request == nil && len(decoded) > 0:
+            t.Errorf("%s %s: route has no request body, but %s decodes %v", route.Method, route.Path, name, decoded)
+        case route.Request != nil && !containsString(decoded, typeName(route.Request)):
+            t.Errorf("%s %s: request is %s, but %s decodes %v", route.Method, route.Path, typeName(route.Request), name, decoded)
+        }
+
+        var expected string
+        switch {
+        case route.Response != nil:
+            expected = typeName(route.Response)
+        case route.ContentType == "":
+            expected = typeName(MessageResponse{})
+        }
+        if expected != "" && !containsString(encoded, expected) {
+            t.Errorf("%s %s: response is %s, but %s encodes %v", route.Method, route.Path, expected, name, encoded)
+        }
+        for _, got := range encoded {
+            if got != expected && got != alternativeResponses[route.OperationID] {
+                t.Errorf("%s %s: %s encodes %s, which is not the documented response %q", route.Method, route.Path, name, got, expected)
+            }
+        }
+    }
+}
+
+// TestResponsesMatchSchema вызывает маршруты через роутер и сверяет ответы, в том числе ошибки, со схемой
+// из документа OpenAPI: ответ с недокументированным полем, типом или кодом ошибки не проходит проверку
+func TestResponsesMatchSchema(t *testing.T) {
+    dir := t.TempDir()
+    oldAudit, oldKey, oldHead := audit.Path, audit.KeyPath, audit.HeadPath
+    oldStore, oldLock, oldNTP := scheduler.StorePath, update.LockFilePath, rtc.NTPSyncedPath
+    audit.Path = filepath.Join(dir, "audit.log")
+    audit.KeyPath = filepath.Join(dir, "audit.key")
+    audit.HeadPath = filepath.Join(dir, "audit.head")
+    scheduler.StorePath = filepath.Join(dir, "schedules.json")
+    update.LockFilePath = filepath.Join(dir, "update.lock")
+    // Часы не подтверждены: создание расписания отвечает ошибкой clock_not_synchronized
+    rtc.NTPSyncedPath = filepath.Join(dir, "synchronized")
+    t.Cleanup(func() {
+        audit.Path, audit.KeyPath, audit.HeadPath = oldAudit, oldKey, oldHead
+        scheduler.StorePath, update.LockFilePath, rtc.NTPSyncedPath = oldStore, oldLock, oldNTP
+    })
+
+    if err := audit.Record(audit.Entry{Principal: "admin", Action: "reboot", Payload: json.RawMessage(`{"delay":5}`), Outcome: audit.OutcomeSuccess}); err != nil {
+        t.Fatal(err)
+    }
+    next := time.Date(2026, 10, 24, 2, 0, 0, 0, time.UTC)
+    stored, _ := json.Marshal([]scheduler.Schedule{{ID: "0a1b2c3d", Action: "reboot", Cron: "0 2 * * 6", CreatedBy: "admin", NextRun: &next}})
+    if err := os.WriteFile(scheduler.StorePath, stored, 0600); err != nil {
+        t.Fatal(err)
+    }
+
+    r := mux.NewRouter()
+    RegisterRoutes(r)
+    v := findVersion(legacyVersion)
+    data, err := OpenAPI(v.Name)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var doc map[string]interface{}
+    if err := json.Unmarshal(data, &doc); err != nil {
+        t.Fatal(err)
+    }
+
+    admin := &auth.Identity{Name: "root", Role: auth.RoleAdmin, TokenID: "unix:0"}
+    tests := []struct {
+        method   string
+        path     string // путь в документе OpenAPI
+        url      string
+        body     string
+        identity *auth.Identity
+        status   int
+    }{
+        {"GET", "/openapi.json", "/openapi.json", "", nil, http.StatusOK},
+        {"GET", "/auth/me", "/auth/me", "", admin, http.StatusOK},
+        {"GET", "/auth/me", "/auth/me", "", nil, http.StatusUnauthorized},
+        {"GET", "/audit", "/audit?action=reboot", "", admin, http.StatusOK},
+        {"GET", "/audit", "/audit?since=yesterday", "", admin, http.StatusBadRequest},
+        {"GET", "/audit/verify", "/audit/verify", "", admin, http.StatusOK},
+        {"GET", "/audit/verify", "/audit/verify?seq=1", "", admin, http.StatusBadRequest},
+        {"GET", "/schedules", "/schedules", "", admin, http.StatusOK},
+        {"POST", "/schedules", "/schedules", `{"action":"reboot","cron":"0 2 * * 6"}`, admin, http.StatusServiceUnavailable},
+        {"POST", "/schedules", "/schedules", `{"action":`, admin, http.StatusBadRequest},
+        {"GET", "/maintenance/window", "/maintenance/window", "", admin, http.StatusOK},
+        {"GET", "/power/pending", "/power/pending", "", admin, http.StatusOK},
+        {"GET", "/firmware/job", "/firmware/job", "", admin, http.StatusOK},
+    }
+    for _, test := range tests {
+        t.Run(test.method+" "+test.url, func(t *testing.T) {
+            req := httptest.NewRequest(test.method, v.Prefix()+test.url, strings.NewReader(test.body))
+            req.RemoteAddr = "unix:0"
+            if test.identity != nil {
+                req = req.WithContext(auth.NewPeerContext(req.Context(), test.identity))
+            }
+            recorder := httptest.NewRecorder()
+            r.ServeHTTP(recorder, req)
+
+            if recorder.Code != test.status {
+                t.Fatalf("status %d, want %d: %s", recorder.Code, test.status, recorder.Body)
+            }
+            if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+                t.Fatalf("content type %q, want application/json", contentType)
+            }
+
+            operation, _ := lookup(doc, "paths", test.path, strings.ToLower(test.method)).(map[string]interface{})
+            if operation == nil {
+                t.Fatalf("%s %s is not described", test.method, test.path)
+            }
+            response, _ := lookup(operation, "responses", fmt.Sprint(test.status)).(map[string]interface{})
+            if response == nil {
+                t.Fatalf("status %d is not described for %s", test.status, operation["operationId"])
+            }
+            schema, _ := lookup(response, "content", "application/json", "schema").(map[string]interface{})
+
+            decoder := json.NewDecoder(recorder.Body)
+            decoder.UseNumber()
+            var body interface{}
+            if err := decoder.Decode(&body); err != nil {
+                t.Fatalf("invalid JSON: %v", err)
+            }
+            for _, problem := range validateSchema(doc, schema, body, "body") {
+                t.Error(problem)
+            }
+
+            // Код ошибки должен быть среди перечисленных для этого HTTP-кода
+            if codes, ok := response["x-error-codes"].([]interface{}); ok {
+                code := lookup(body, "error", "code")
+                if !containsValue(codes, code) {
+                    t.Errorf("error code %v is not listed in %v", code, codes)
+                }
+            }
+        })
+    }
+}
+
+// lookup возвращает значение по пути ключей во вложенных объектах JSON; nil, если его нет
+func lookup(value interface{}, keys ...string) interface{} {
+    for _, key := range keys {
+        object, ok := value.(map[string]interface{})
+        if !ok {
+            return nil
+        }
+        value = object[key]
+    }
+    return value
+}
+
+func containsValue(list []interface{}, value interface{}) bool {
+    for _, item := range list {
+        if item == value {
+            return true
+        }
+    }
+    return false
+}
+
+// validateSchema проверяет значение JSON по схеме из документа OpenAPI и возвращает найденные расхождения.
+// Поддерживается подмножество JSON Schema, которое строит schemaBuilder; поля объекта, не описанные в схеме,
+// считаются расхождением, так как схема строится по тем же типам, что и ответ.
+func validateSchema(doc map[string]interface{}, schema map[string]interface{}, value interface{}, where string) []string {
+    if ref, ok := schema["$ref"].(string); ok {
+        resolved, _ := lookup(doc, strings.Split(strings.TrimPrefix(ref, "#/"), "/")...).(map[string]interface{})
+        if resolved == nil {
+            return []string{fmt.Sprintf("%s: unresolved reference %s", where, ref)}
+        }
+        return validateSchema(doc, resolved, value, where)
+    }
+    if value == nil {
+        if schema["nullable"] == true || len(schema) == 0 {
+            return nil
+        }
+        return []string{where + ": null is not allowed"}
+    }
+    if all, ok := schema["allOf"].([]interface{}); ok {
+        var problems []string
+        for _, item := range all {
+            problems = append(problems, validateSchema(doc, item.(map[string]interface{}), value, where)...)
+        }
+        return problems
+    }
+
+    mismatch := func(kind string) []string {
+        return []string{fmt.Sprintf("%s: %v is not %s", where, value, kind)}
+    }
+    switch schema["type"] {
+    case nil:
+        return nil
+    case "string":
+        text, ok := value.(string)
+        if !ok {
+            return mismatch("a string")
+        }
+        if schema["format"] == "date-time" {
+            if _, err := time.Parse(time.RFC3339, text); err != nil {
+                return mismatch("a date-time")
+            }
+        }
+    case "boolean":
+        if _, ok := value.(bool); !ok {
+            return mismatch("a boolean")
+        }
+    case "integer":
+        number, ok := value.(json.Number)
+        if _, err := number.Int64(); !ok || err != nil {
+            return mismatch("an integer")
+        }
+    case "number":
+        if _, ok := value.(json.Number); !ok {
+            return mismatch("a number")
+        }
+    case "array":
+        items, ok := value.([]interface{})
+        if !ok {
+            return mismatch("an array")
+        }
+        var problems []string
+        itemSchema, _ := schema["items"].(map[string]interface{})
+        for i, item := range items {
+            problems = append(problems, validateSchema(doc, itemSchema, item, fmt.Sprintf("%s[%d]", where, i))...)
+        }
+        return problems
+    case "object":
+        object, ok := value.(map[string]interface{})
+        if !ok {
+            return mismatch("an object")
+        }
+        var problems []string
+        for _, name := range schemaStrings(schema["required"]) {
+            if _, ok := object[name]; !ok {
+                problems = append(problems, fmt.Sprintf("%s: required field %s is missing", where, name))
+            }
+        }
+        properties, hasProperties := schema["properties"].(map[string]interface{})
+        additional, _ := schema["additionalProperties"].(map[string]interface{})
+        names := make([]string, 0, len(object))
+        for name := range object {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+        for _, name := range names {
+            fieldSchema, ok := properties[name].(map[string]interface{})
+            switch {
+            case ok:
+            case additional != nil:
+                fieldSchema = additional
+            case hasProperties:
+                problems = append(problems, fmt.Sprintf("%s: field %s is not described", where, name))
+                continue
+            default:
+                continue
+            }
+            problems = append(problems, validateSchema(doc, fieldSchema, object[name], where+"."+name)...)
+        }
+        return problems
+    }
+    return nil
+}
+
+func schemaStrings(value interface{}) []string {
+    list, _ := value.([]interface{})
+    var result []string
+    for _, item := range list {
+        if text, ok := item.(string); ok {
+            result = append(result, text)
+        }
+    }
+    return result
+}
+
+// TestClientIsGenerated проверяет, что pkg/client/client_gen.go сгенерирован по текущему описанию API
+func TestClientIsGenerated(t *testing.T) {
+    output := filepath.Join(t.TempDir(), "client_gen.go")
+    cmd := exec.Command("go", "run", "gen.go", "-o", output)
+    cmd.Dir = filepath.Join("..", "client")
+    if out, err := cmd.CombinedOutput(); err != nil {
+        t.Fatalf("failed to generate client: %v\n%s", err, out)
+    }
+
+    generated, err := os.ReadFile(output)
+    if err != nil {
+        t.Fatal(err)
+    }
+    committed, err := os.ReadFile(filepath.Join("..", "client", "client_gen.go"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(generated, committed) {
+        t.Errorf("pkg/client/client_gen.go is out of date, run go generate in pkg/client")
+    }
+}
+
+// checkPackage разбирает исходники пакета api (без тестов) и проверяет типы; зависимости загружаются
+// из данных экспорта, которые собирает go list
+func checkPackage(t *testing.T) (*types.Info, []*ast.File) {
+    t.Helper()
+
+    out, err := exec.Command("go", "list", "-export", "-deps", "-f", "{{.ImportPath}}={{.Export}}", ".").Output()
+    if err != nil {
+        t.Fatalf("failed to list dependencies: %v", err)
+    }
+    exports := make(map[string]string)
+    for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+        if path, file, ok := strings.Cut(line, "="); ok {
+            exports[path] = file
+        }
+    }
+
+    fset := token.NewFileSet()
+    names, err := filepath.Glob("*.go")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var files []*ast.File
+    for _, name := range names {
+        if strings.HasSuffix(name, "_test.go") {
+            continue
+        }
+        file, err := parser.ParseFile(fset, name, nil, 0)
+        if err != nil {
+            t.Fatal(err)
+        }
+        files = append(files, file)
+    }
+
+    config := types.Config{Importer: importer.ForCompiler(fset, "gc", func(path string) (io.ReadCloser, error) {
+        return os.Open(exports[path])
+    })}
+    info := &types.Info{Types: make(map[ast.Expr]types.TypeAndValue), Uses: make(map[*ast.Ident]types.Object)}
+    if _, err := config.Check("servis/pkg/api", fset, files, info); err != nil {
+        t.Fatalf("failed to type-check package api: %v", err)
+    }
+    return info, files
+}
+
+// jsonTypes возвращает типы, которые fn и вызываемые ею функции пакета декодируют из JSON (json.Decoder.Decode)
+// и кодируют в JSON (json.Encoder.Encode)
+func jsonTypes(info *types.Info, funcs map[string]*ast.FuncDecl, fn *ast.FuncDecl) (decoded, encoded []string) {
+    visited := make(map[*ast.FuncDecl]bool)
+    var visit func(fn *ast.FuncDecl)
+    visit = func(fn *ast.FuncDecl) {
+        if visited[fn] {
+            return
+        }
+        visited[fn] = true
+
+        ast.Inspect(fn.Body, func(node ast.Node) bool {
+            call, ok := node.(*ast.CallExpr)
+            if !ok {
+                return true
+            }
+            switch callee := call.Fun.(type) {
+            case *ast.Ident:
+                if decl, ok := funcs[callee.Name]; ok {
+                    if _, isFunc := info.Uses[callee].(*types.Func); isFunc {
+                        visit(decl)
+                    }
+                }
+            case *ast.SelectorExpr:
+                method, ok := info.Uses[callee.Sel].(*types.Func)
+                if !ok || len(call.Args) != 1 {
+                    return true
+                }
+                switch method.FullName() {
+                case "(*encoding/json.Decoder).Decode":
+                    decoded = append(decoded, exprType(info, call.Args[0]))
+                case "(*encoding/json.Encoder).Encode":
+                    encoded = append(encoded, exprType(info, call.Args[0]))
+                }
+            }
+            return true
+        })
+    }
+    visit(fn)
+    return decoded, encoded
+}
+
+// exprType возвращает имя типа выражения без указателя, как его печатает reflect
+func exprType(info *types.Info, expr ast.Expr) string {
+    t := info.Types[expr].Type
+    if pointer, ok := t.(*types.Pointer); ok {
+        t = pointer.Elem()
+    }
+    name := types.TypeString(t, func(p *types.Package) string { return p.Name() })
+    return strings.ReplaceAll(name, " ", "")
+}
+
+// typeName возвращает имя типа значения из таблицы маршрутов без указателя
+func typeName(value interface{}) string {
+    t := reflect.TypeOf(value)
+    if t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+    return strings.ReplaceAll(t.String(), " ", "")
+}
+
+// handlerName возвращает имя функции обработчика без пакета
+func handlerName(handler interface{}) string {
+    name := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+    return name[strings.LastIndex(name, ".")+1:]
+}
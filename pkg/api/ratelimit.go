@@ -0,0 +1,111 @@
+package api
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "net"
+    "net/http"
+    "strconv"
+    "strings"
+    "servis/pkg/apierror"
+    "servis/pkg/ratelimit"
+    "servis/pkg/service"
+)
+
+// loginOperation — действие входа по логину и паролю; успешный вход сбрасывает счетчик неудачных попыток клиента
+const loginOperation = "login"
+
+// limitRequest не пропускает запросы клиента, заблокированного после неудачных попыток входа, и запросы сверх
+// лимитов клиента из rate_limit, отвечая ошибкой с заголовком Retry-After. Такие запросы не записываются в журнал аудита,
+// чтобы поток запросов не переполнял его. По ответу учитываются неудачные попытки входа: неверный токен,
+// логин или пароль.
+func limitRequest(route Route, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        client := clientAddress(r)
+        if err := ratelimit.Check(route.OperationID, client); err != nil {
+            writeLimitError(w, err)
+            return
+        }
+
+        recorder := &authRecorder{ResponseWriter: w, status: http.StatusOK}
+        next.ServeHTTP(recorder, r)
+
+        switch {
+        case recorder.status == http.StatusUnauthorized:
+            var response apierror.Response
+            json.Unmarshal(recorder.body.Bytes(), &response)
+            if ratelimit.IsAuthFailure(response.Error.Code) {
+                ratelimit.AuthFailed(client)
+            }
+        case route.OperationID == loginOperation && recorder.status < 400:
+            ratelimit.AuthSucceeded(client)
+        }
+    })
+}
+
+// limitGlobal не пропускает запросы сверх общих лимитов всех клиентов (global и routes.<action>.global).
+// Стоит после проверки токена и роли, поэтому запросы без действующих учетных данных не расходуют общий запас;
+// отклоненные ею запросы записываются в журнал аудита с кодом rate_limited.
+func limitGlobal(route Route, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if err := ratelimit.CheckGlobal(route.OperationID); err != nil {
+            writeLimitError(w, err)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// writeLimitError отвечает ошибкой rate_limited или auth_locked_out с заголовком Retry-After
+func writeLimitError(w http.ResponseWriter, err error) {
+    apiErr := service.Classify(err, apierror.CodeRateLimited, "too many requests")
+    if details, ok := apiErr.Details.(service.RetryDetails); ok {
+        w.Header().Set("Retry-After", strconv.Itoa(details.RetryAfter))
+    }
+    apierror.Write(w, apiErr)
+}
+
+// clientAddress возвращает адрес клиента без порта; для Unix-сокета — unix:<uid>
+func clientAddress(r *http.Request) string {
+    // unix:<uid> похож на адрес с портом, но uid нужен, чтобы различать пользователей сокета
+    if strings.HasPrefix(r.RemoteAddr, socketClient+":") {
+        return r.RemoteAddr
+    }
+    if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+        return host
+    }
+    return r.RemoteAddr
+}
+
+// authRecorder запоминает код ответа и тело ответа 401, чтобы отличить неверные учетные данные от их отсутствия.
+// Обертка сохраняет http.Flusher и http.Hijacker, нужные потокам событий.
+type authRecorder struct {
+    http.ResponseWriter
+    status int
+    body   bytes.Buffer
+}
+
+func (r *authRecorder) WriteHeader(status int) {
+    r.status = status
+    r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *authRecorder) Write(data []byte) (int, error) {
+    if r.status == http.StatusUnauthorized && r.body.Len() < 4096 {
+        r.body.Write(data)
+    }
+    return r.ResponseWriter.Write(data)
+}
+
+func (r *authRecorder) Flush() {
+    http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+func (r *authRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+    return http.NewResponseController(r.ResponseWriter).Hijack()
+}
+
+func (r *authRecorder) Unwrap() http.ResponseWriter {
+    return r.ResponseWriter
+}
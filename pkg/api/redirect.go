@@ -0,0 +1,94 @@
+package api
+
+import (
+    "errors"
+    "log"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+    "servis/pkg/config"
+    "servis/pkg/selfupdate"
+)
+
+// newRedirectServer возвращает сервер, который отвечает на запросы по HTTP перенаправлением на тот же путь
+// по HTTPS. Нужен на время перехода клиентов, обращавшихся к API по http://: сам API по HTTP не доступен.
+func newRedirectServer() *http.Server {
+    return &http.Server{Handler: http.HandlerFunc(redirectToHTTPS)}
+}
+
+// redirectToHTTPS перенаправляет запрос на адрес HTTPS сервера (порт из listen) с кодом 308,
+// чтобы клиенты повторили запрос тем же методом и с тем же телом
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+    host := r.Host
+    if h, _, err := net.SplitHostPort(host); err == nil {
+        host = h
+    }
+    host = strings.Trim(host, "[]")
+    if host == "" {
+        http.Error(w, "Host header is required", http.StatusBadRequest)
+        return
+    }
+    if _, port, err := net.SplitHostPort(config.Get().Listen); err == nil && port != "443" {
+        host = net.JoinHostPort(host, port)
+    } else if strings.Contains(host, ":") {
+        host = "[" + host + "]"
+    }
+    http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+}
+
+// serveRedirect обслуживает server на адресе http_redirect_listen и переходит на новый адрес при его смене
+// в конфигурации. При самообновлении сокет закрывается до запуска нового процесса, чтобы тот занял адрес,
+// и открывается снова, если новая версия не запустилась. Возвращает функцию, которая прекращает следить
+// за конфигурацией и самообновлением; сам сокет закрывается вместе с server.
+func serveRedirect(server *http.Server) func() {
+    var mu sync.Mutex
+    var current net.Listener
+
+    open := func(addr string) {
+        mu.Lock()
+        defer mu.Unlock()
+
+        if current != nil {
+            current.Close()
+            current = nil
+        }
+        if addr == "" {
+            return
+        }
+
+        l, err := net.Listen("tcp", addr)
+        if err != nil {
+            log.Printf("failed to listen on %s for HTTP redirects: %v", addr, err)
+            return
+        }
+        current = l
+
+        log.Printf("HTTP requests on %s are redirected to HTTPS", addr)
+        go func() {
+            err := server.Serve(l)
+            if err != nil && err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+                log.Printf("HTTP redirect server failed: %v", err)
+            }
+        }()
+    }
+
+    open(config.Get().HTTPRedirectListen)
+    unsubscribe := config.OnChange(func(old, new config.Config) {
+        if old.HTTPRedirectListen != new.HTTPRedirectListen {
+            open(new.HTTPRedirectListen)
+        }
+    })
+    cancelUpgrade := selfupdate.OnUpgrade(func(state string) {
+        switch state {
+        case selfupdate.StateRunning:
+            open("")
+        case selfupdate.StateRolledBack:
+            open(config.Get().HTTPRedirectListen)
+        }
+    })
+    return func() {
+        unsubscribe()
+        cancelUpgrade()
+    }
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestRedirectToHTTPS(t *testing.T) {
+    // Порт HTTPS берется из listen; по умолчанию :4444
+    tests := []struct {
+        host     string
+        url      string
+        location string
+    }{
+        {"device.local", "/api/v1/networks/all", "https://device.local:4444/api/v1/networks/all"},
+        {"device.local:80", "/networks/all?refresh=1", "https://device.local:4444/networks/all?refresh=1"},
+        {"192.168.1.10:8080", "/", "https://192.168.1.10:4444/"},
+        {"[fe80::1]:80", "/ui/", "https://[fe80::1]:4444/ui/"},
+    }
+    for _, test := range tests {
+        t.Run(test.host+test.url, func(t *testing.T) {
+            req := httptest.NewRequest("POST", "http://"+test.host+test.url, nil)
+            recorder := httptest.NewRecorder()
+            redirectToHTTPS(recorder, req)
+
+            if recorder.Code != http.StatusPermanentRedirect {
+                t.Fatalf("status %d, want %d", recorder.Code, http.StatusPermanentRedirect)
+            }
+            if location := recorder.Header().Get("Location"); location != test.location {
+                t.Fatalf("Location %q, want %q", location, test.location)
+            }
+        })
+    }
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+    "encoding/json"
+    "net/http"
+    "time"
+    "servis/pkg/apierror"
+    "servis/pkg/auth"
+    "servis/pkg/scheduler"
+    "github.com/gorilla/mux"
+)
+
+// ScheduleRequest — новое расписание: однократное (at) или повторное (cron)
+type ScheduleRequest struct {
+    Action string     `json:"action"`
+    At     *time.Time `json:"at,omitempty"`
+    Cron   string     `json:"cron,omitempty"`
+}
+
+// ListSchedulesHandler возвращает расписания выключения и перезагрузки.
+func ListSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+    schedules, err := scheduler.List()
+    if err != nil {
+        writeError(w, err, apierror.CodeInternal, "failed to list schedules")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(schedules)
+}
+
+// CreateScheduleHandler создает расписание.
+func CreateScheduleHandler(w http.ResponseWriter, r *http.Request) {
+    var req ScheduleRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        writeInvalidRequest(w, "invalid request payload", "")
+        return
+    }
+
+    schedule, err := scheduler.Create(scheduler.Schedule{Action: req.Action, At: req.At, Cron: req.Cron}, auth.FromContext(r.Context()).Name)
+    if err != nil {
+        writeError(w, err, apierror.CodeInternal, "failed to create schedule")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(schedule)
+}
+
+// DeleteScheduleHandler удаляет расписание.
+func DeleteScheduleHandler(w http.ResponseWriter, r *http.Request) {
+    err := scheduler.Delete(mux.Vars(r)["id"], auth.FromContext(r.Context()).Name)
+    if err != nil {
+        writeError(w, err, apierror.CodeInternal, "failed to delete schedule")
+        return
+    }
+
+    writeMessage(w, "schedule deleted")
+}
+
+// GetMaintenanceWindow возвращает состояние окна обслуживания.
+func GetMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(scheduler.Window())
+}
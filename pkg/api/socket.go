@@ -0,0 +1,178 @@
+package api
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "os"
+    "os/user"
+    "path/filepath"
+    "strconv"
+    "sync"
+    "syscall"
+    "servis/pkg/auth"
+    "servis/pkg/config"
+)
+
+// socketClient — адрес клиента Unix-сокета, пользователя которого не удалось определить
+const socketClient = "unix"
+
+// socketClientKey — ключ адреса клиента Unix-сокета (unix:<uid>) в контексте соединения
+type socketClientKey struct{}
+
+// newSocketServer возвращает сервер локального API на Unix-сокете. Токен на сокете не нужен: доступ к нему
+// ограничен правами файла, а пользователь определяется по учетным данным процесса-клиента (SO_PEERCRED).
+func newSocketServer(handler http.Handler) *http.Server {
+    return &http.Server{
+        Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            // Адрес включает uid: лимиты и блокировка после неверных токенов действуют на каждого пользователя
+            // отдельно, а не на всех локальных клиентов сразу
+            r.RemoteAddr = socketClient
+            if client, ok := r.Context().Value(socketClientKey{}).(string); ok {
+                r.RemoteAddr = client
+            }
+            handler.ServeHTTP(w, r)
+        }),
+        ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+            identity, err := peerIdentity(conn)
+            if err != nil {
+                log.Printf("Failed to identify control socket client: %v", err)
+                return ctx
+            }
+            ctx = context.WithValue(ctx, socketClientKey{}, identity.TokenID)
+            return auth.NewPeerContext(ctx, identity)
+        },
+    }
+}
+
+// serveSocket обслуживает server на Unix-сокете control_socket и пересоздает сокет при смене пути или группы
+// в конфигурации. Возвращает функцию, которая прекращает следить за конфигурацией; сам сокет закрывается
+// вместе с server.
+func serveSocket(server *http.Server) func() {
+    var mu sync.Mutex
+    var current net.Listener
+    var currentPath string
+
+    open := func(settings config.Config) {
+        mu.Lock()
+        defer mu.Unlock()
+
+        if current != nil {
+            current.Close()
+            if currentPath != settings.ControlSocket {
+                os.Remove(currentPath)
+            }
+            current, currentPath = nil, ""
+        }
+        if settings.ControlSocket == "" {
+            return
+        }
+
+        l, err := listenSocket(settings.ControlSocket, settings.ControlSocketGroup)
+        if err != nil {
+            log.Printf("failed to listen on control socket %s: %v", settings.ControlSocket, err)
+            return
+        }
+        current, currentPath = l, settings.ControlSocket
+
+        log.Printf("control socket is listening on %s", settings.ControlSocket)
+        go func() {
+            err := server.Serve(l)
+            if err != nil && err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+                log.Printf("control socket server failed: %v", err)
+            }
+        }()
+    }
+
+    open(config.Get())
+    return config.OnChange(func(old, new config.Config) {
+        if old.ControlSocket == new.ControlSocket && old.ControlSocketGroup == new.ControlSocketGroup {
+            return
+        }
+        open(new)
+    })
+}
+
+// listenSocket создает Unix-сокет path с правами 0600 или, если задана группа, 0660 для этой группы
+func listenSocket(path, group string) (net.Listener, error) {
+    gid := -1
+    mode := os.FileMode(0600)
+    if group != "" {
+        g, err := user.LookupGroup(group)
+        if err != nil {
+            return nil, fmt.Errorf("failed to find group %s: %w", group, err)
+        }
+        gid, err = strconv.Atoi(g.Gid)
+        if err != nil {
+            return nil, fmt.Errorf("invalid gid %s of group %s", g.Gid, group)
+        }
+        mode = 0660
+    }
+
+    err := os.MkdirAll(filepath.Dir(path), 0755)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create socket directory: %w", err)
+    }
+
+    // Сокет мог остаться от предыдущего процесса (после остановки или при самообновлении); другой файл не трогаем
+    if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+        os.Remove(path)
+    }
+
+    listener, err := net.Listen("unix", path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to listen: %w", err)
+    }
+    // При самообновлении прежний процесс закрывает сокет уже после того, как новый создал свой по тому же пути
+    listener.(*net.UnixListener).SetUnlinkOnClose(false)
+
+    err = os.Chown(path, -1, gid)
+    if err == nil {
+        err = os.Chmod(path, mode)
+    }
+    if err != nil {
+        listener.Close()
+        os.Remove(path)
+        return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+    }
+
+    return listener, nil
+}
+
+// peerIdentity определяет пользователя по процессу на другом конце Unix-сокета: root получает роль admin,
+// остальные пользователи, которым права файла разрешают подключиться, — operator
+func peerIdentity(conn net.Conn) (*auth.Identity, error) {
+    unixConn, ok := conn.(*net.UnixConn)
+    if !ok {
+        return nil, fmt.Errorf("not a unix socket connection")
+    }
+    raw, err := unixConn.SyscallConn()
+    if err != nil {
+        return nil, err
+    }
+
+    var cred *syscall.Ucred
+    var credErr error
+    err = raw.Control(func(fd uintptr) {
+        cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+    })
+    if err == nil {
+        err = credErr
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to get peer credentials: %w", err)
+    }
+
+    uid := strconv.FormatUint(uint64(cred.Uid), 10)
+    identity := &auth.Identity{Name: "uid:" + uid, Role: auth.RoleOperator, TokenID: "unix:" + uid}
+    if u, err := user.LookupId(uid); err == nil {
+        identity.Name = u.Username
+    }
+    if cred.Uid == 0 {
+        identity.Role = auth.RoleAdmin
+    }
+    return identity, nil
+}
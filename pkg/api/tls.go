@@ -0,0 +1,34 @@
+package api
+
+import (
+    "encoding/json"
+    "net/http"
+    "servis/pkg/apierror"
+    "servis/pkg/certs"
+)
+
+// GetDeviceCA отдает CA устройства, которым подписан сертификат HTTPS, для закрепления на клиентах.
+// Эндпоинт доступен без токена: сертификат открытый, а проверить его отпечаток можно через /tls/info.
+func GetDeviceCA(w http.ResponseWriter, r *http.Request) {
+    data, err := certs.CACertificate()
+    if err != nil {
+        writeError(w, err, apierror.CodeInternal, "failed to load device CA")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/x-pem-file")
+    w.Header().Set("Content-Disposition", "attachment; filename=\"servis-ca.crt\"")
+    w.Write(data)
+}
+
+// GetTLSInfo возвращает сведения об используемом сертификате HTTPS и отпечатки для закрепления.
+func GetTLSInfo(w http.ResponseWriter, r *http.Request) {
+    status, err := certs.CurrentStatus()
+    if err != nil {
+        writeError(w, err, apierror.CodeInternal, "failed to get TLS status")
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+    "net/http"
+    "strings"
+    "servis/pkg/webui"
+)
+
+// uiPrefix — путь, под которым отдается встроенный веб-интерфейс
+const uiPrefix = "/ui/"
+
+// withUI отдает встроенный веб-интерфейс на /ui/ и перенаправляет на него корень, остальные запросы передает API.
+// Интерфейс — статические файлы без аутентификации: вход выполняется в браузере через /api/v1/auth/login.
+func withUI(api http.Handler) http.Handler {
+    ui := http.StripPrefix(strings.TrimSuffix(uiPrefix, "/"), webui.Handler())
+
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch {
+        case r.URL.Path == "/" || r.URL.Path+"/" == uiPrefix:
+            http.Redirect(w, r, uiPrefix, http.StatusFound)
+        case strings.HasPrefix(r.URL.Path, uiPrefix):
+            if r.Method != http.MethodGet && r.Method != http.MethodHead {
+                methodNotAllowed(w, r)
+                return
+            }
+            ui.ServeHTTP(w, r)
+        default:
+            api.ServeHTTP(w, r)
+        }
+    })
+}
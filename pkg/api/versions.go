@@ -0,0 +1,134 @@
+package api
+
+import (
+    "context"
+    "log"
+    "net/http"
+    "sync"
+    "servis/pkg/auth"
+    "github.com/gorilla/mux"
+)
+
+// Version — версия API: маршруты доступны под префиксом /api/<Name> и описываются отдельным документом OpenAPI.
+// Обработчики общие для всех версий. Новая версия получает свою таблицу маршрутов, в которой заменяет
+// только изменившиеся эндпоинты; если обработчику нужно отличать версии, он получает ее через requestVersion.
+type Version struct {
+    Name    string  // имя версии в пути, например v1
+    Release string  // версия описания OpenAPI; увеличивается при изменении запросов или ответов
+    Routes  []Route // пути указываются без префикса версии
+}
+
+// Prefix возвращает префикс путей версии
+func (v *Version) Prefix() string {
+    return "/api/" + v.Name
+}
+
+// versions — поддерживаемые версии API
+var versions = []*Version{
+    {Name: "v1", Release: "1.0.0", Routes: v1Routes},
+}
+
+// legacyVersion — версия, маршруты которой также доступны по старым путям без префикса.
+// Эти пути устарели: ответы на них содержат заголовки Deprecation и Link на путь с префиксом.
+const legacyVersion = "v1"
+
+type versionKey struct{}
+
+// findVersion возвращает версию по имени
+func findVersion(name string) *Version {
+    for _, v := range versions {
+        if v.Name == name {
+            return v
+        }
+    }
+    return nil
+}
+
+// requestVersion возвращает версию API, к которой относится запрос (для устаревших путей — legacyVersion);
+// nil, если запрос пришел не через таблицу маршрутов
+func requestVersion(r *http.Request) *Version {
+    v, _ := r.Context().Value(versionKey{}).(*Version)
+    return v
+}
+
+// routeHandler оборачивает обработчик маршрута проверкой роли и лимитов частоты запросов и привязывает запрос
+// к версии API. Лимиты клиента проверяются до аутентификации, общие — после. Изменяющие запросы (все, кроме GET)
+// записываются в журнал аудита.
+func routeHandler(v *Version, route Route) http.Handler {
+    var handler http.Handler = route.Handler
+    audited := route.Method != http.MethodGet
+    if audited {
+        handler = captureIdentity(handler)
+    }
+    if route.Role != "" {
+        // Общие лимиты всех клиентов расходуются только после проверки токена и роли
+        handler = auth.Require(route.Role, limitGlobal(route, handler).ServeHTTP)
+    }
+    if audited {
+        handler = auditRequest(route, handler)
+    }
+    if route.QueryToken {
+        handler = auth.AllowQueryToken(handler)
+    }
+    handler = limitRequest(route, handler)
+
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), versionKey{}, v)))
+    })
+}
+
+// legacyPaths запоминает устаревшие пути, о запросах к которым уже сделана запись в журнале
+var legacyPaths sync.Map
+
+// deprecated помечает ответ на запрос по устаревшему пути и указывает путь, которым его следует заменить
+func deprecated(v *Version, route Route, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        successor := v.Prefix() + r.URL.Path
+        w.Header().Set("Deprecation", "true")
+        w.Header().Add("Link", "<"+successor+">; rel=\"successor-version\"")
+
+        if _, seen := legacyPaths.LoadOrStore(route.Method+" "+route.Path, true); !seen {
+            log.Printf("deprecated path %s %s requested by %s, use %s", r.Method, r.URL.Path, r.RemoteAddr, successor)
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// registerVersion регистрирует маршруты версии под ее префиксом
+func registerVersion(r *mux.Router, v *Version) {
+    for _, route := range v.Routes {
+        path := v.Prefix() + route.Path
+        r.Handle(path, instrument(path, routeHandler(v, route))).Methods(route.Method)
+    }
+}
+
+// registerLegacy регистрирует маршруты версии по старым путям без префикса
+func registerLegacy(r *mux.Router, v *Version) {
+    for _, route := range v.Routes {
+        r.Handle(route.Path, instrument(route.Path, deprecated(v, route, routeHandler(v, route)))).Methods(route.Method)
+    }
+}
+
+// registerRoot регистрирует маршруты вне версий API; версия запроса для них не задана
+func registerRoot(r *mux.Router) {
+    for _, route := range rootRoutes {
+        r.Handle(route.Path, instrument(route.Path, routeHandler(nil, route))).Methods(route.Method)
+    }
+}
+
+// registeredPaths возвращает все пути, которые должны быть зарегистрированы в роутере, в виде "МЕТОД путь"
+func registeredPaths() []string {
+    var paths []string
+    for _, v := range versions {
+        for _, route := range v.Routes {
+            paths = append(paths, route.Method+" "+v.Prefix()+route.Path)
+            if v.Name == legacyVersion {
+                paths = append(paths, route.Method+" "+route.Path)
+            }
+        }
+    }
+    for _, route := range rootRoutes {
+        paths = append(paths, route.Method+" "+route.Path)
+    }
+    return paths
+}
@@ -0,0 +1,157 @@
+// Package apierror описывает формат ошибок API. Каждая ошибка содержит стабильный код,
+// по которому клиент выбирает реакцию и перевод, сообщение для человека и детали.
+package apierror
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+    "sort"
+)
+
+// Коды ошибок. Коды не меняются между версиями; новые коды только добавляются.
+const (
+    CodeInvalidRequest         = "invalid_request"
+    CodeAuthenticationRequired = "authentication_required"
+    CodeInvalidToken           = "invalid_token"
+    CodeInvalidCredentials     = "invalid_credentials"
+    CodeInsufficientRole       = "insufficient_role"
+    CodeNotFound               = "not_found"
+    CodeMethodNotAllowed       = "method_not_allowed"
+    CodeLastAdmin              = "last_admin"
+    CodeRateLimited            = "rate_limited"
+    CodeLockedOut              = "auth_locked_out"
+
+    CodeArchiveNotFound    = "archive_not_found"
+    CodeUnknownPackage     = "unknown_package"
+    CodeManifestInvalid    = "manifest_invalid"
+    CodeUntrustedPackage   = "untrusted_package"
+    CodeWrongDeviceKey     = "wrong_device_key"
+    CodeDecryptionFailed   = "decryption_failed"
+    CodeHashMismatch       = "hash_mismatch"
+    CodeUpdateInProgress   = "update_in_progress"
+    CodeUpdateInterrupted  = "update_interrupted"
+    CodeDependencyConflict = "dependency_conflict"
+    CodeBackupNotFound     = "backup_not_found"
+    CodeNotInstalled       = "component_not_installed"
+    CodeNotUSBDevice       = "not_usb_device"
+    CodeSelfUpdateFailed   = "self_update_failed"
+    CodeShuttingDown       = "shutting_down"
+    CodeDownloadFailed     = "download_failed"
+
+    CodeWifiScanFailed       = "wifi_scan_failed"
+    CodeWifiConfigFailed     = "wifi_config_failed"
+    CodeWifiConnectFailed    = "wifi_connect_failed"
+    CodeWifiDHCPFailed       = "wifi_dhcp_failed"
+    CodeEthernetUnavailable  = "ethernet_unavailable"
+    CodeEthernetConfigFailed = "ethernet_config_failed"
+
+    CodeInvalidConfirmation     = "invalid_confirmation"
+    CodePowerActionPending      = "power_action_pending"
+    CodeClockNotSynchronized    = "clock_not_synchronized"
+    CodeMaintenanceWindowClosed = "maintenance_window_closed"
+    CodeSystemCommandFailed     = "system_command_failed"
+    CodeInternal                = "internal_error"
+)
+
+// statuses — HTTP-код для каждого кода ошибки
+var statuses = map[string]int{
+    CodeInvalidRequest:         http.StatusBadRequest,
+    CodeAuthenticationRequired: http.StatusUnauthorized,
+    CodeInvalidToken:           http.StatusUnauthorized,
+    CodeInvalidCredentials:     http.StatusUnauthorized,
+    CodeInsufficientRole:       http.StatusForbidden,
+    CodeNotFound:               http.StatusNotFound,
+    CodeMethodNotAllowed:       http.StatusMethodNotAllowed,
+    CodeLastAdmin:              http.StatusConflict,
+    CodeRateLimited:            http.StatusTooManyRequests,
+    CodeLockedOut:              http.StatusTooManyRequests,
+
+    CodeArchiveNotFound:    http.StatusNotFound,
+    CodeUnknownPackage:     http.StatusBadRequest,
+    CodeManifestInvalid:    http.StatusUnprocessableEntity,
+    CodeUntrustedPackage:   http.StatusUnprocessableEntity,
+    CodeWrongDeviceKey:     http.StatusUnprocessableEntity,
+    CodeDecryptionFailed:   http.StatusUnprocessableEntity,
+    CodeHashMismatch:       http.StatusConflict,
+    CodeUpdateInProgress:   http.StatusConflict,
+    CodeUpdateInterrupted:  http.StatusConflict,
+    CodeDependencyConflict: http.StatusConflict,
+    CodeBackupNotFound:     http.StatusNotFound,
+    CodeNotInstalled:       http.StatusBadRequest,
+    CodeNotUSBDevice:       http.StatusBadRequest,
+    CodeSelfUpdateFailed:   http.StatusInternalServerError,
+    CodeShuttingDown:       http.StatusServiceUnavailable,
+    CodeDownloadFailed:     http.StatusBadGateway,
+
+    CodeWifiScanFailed:       http.StatusServiceUnavailable,
+    CodeWifiConfigFailed:     http.StatusInternalServerError,
+    CodeWifiConnectFailed:    http.StatusServiceUnavailable,
+    CodeWifiDHCPFailed:       http.StatusServiceUnavailable,
+    CodeEthernetUnavailable:  http.StatusServiceUnavailable,
+    CodeEthernetConfigFailed: http.StatusInternalServerError,
+
+    CodeInvalidConfirmation:     http.StatusBadRequest,
+    CodePowerActionPending:      http.StatusConflict,
+    CodeClockNotSynchronized:    http.StatusServiceUnavailable,
+    CodeMaintenanceWindowClosed: http.StatusConflict,
+    CodeSystemCommandFailed:     http.StatusInternalServerError,
+    CodeInternal:                http.StatusInternalServerError,
+}
+
+// Error — ошибка API
+type Error struct {
+    Code    string      `json:"code"`
+    Message string      `json:"message"`
+    Details interface{} `json:"details,omitempty"`
+}
+
+// Response — тело ответа с ошибкой
+type Response struct {
+    Error Error `json:"error"`
+}
+
+func (e *Error) Error() string {
+    return e.Code + ": " + e.Message
+}
+
+// Status возвращает HTTP-код для кода ошибки
+func Status(code string) int {
+    if status, ok := statuses[code]; ok {
+        return status
+    }
+    return http.StatusInternalServerError
+}
+
+// Codes возвращает все известные коды ошибок по алфавиту
+func Codes() []string {
+    var codes []string
+    for code := range statuses {
+        codes = append(codes, code)
+    }
+    sort.Strings(codes)
+    return codes
+}
+
+// New создает ошибку с кодом и сообщением
+func New(code, message string) *Error {
+    return &Error{Code: code, Message: message}
+}
+
+// WithDetails добавляет к ошибке детали
+func (e *Error) WithDetails(details interface{}) *Error {
+    e.Details = details
+    return e
+}
+
+// Write отправляет ошибку клиенту с HTTP-кодом, соответствующим коду ошибки
+func Write(w http.ResponseWriter, e *Error) {
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("X-Content-Type-Options", "nosniff")
+    w.WriteHeader(Status(e.Code))
+
+    err := json.NewEncoder(w).Encode(Response{Error: *e})
+    if err != nil {
+        log.Printf("Failed to write error response: %v", err)
+    }
+}
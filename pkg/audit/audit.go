@@ -0,0 +1,577 @@
+// Package audit ведет журнал изменяющих действий: кто, откуда, с какими параметрами и с каким результатом.
+// Журнал только дополняется; каждая запись содержит HMAC предыдущей, вычисленный ключом устройства, поэтому
+// изменение или удаление записи обнаруживается функцией Verify, а пересчитать цепочку без ключа нельзя.
+// Последняя запись (Head) хранится отдельно и публикуется в состоянии MQTT, чтобы обнаружить и усечение журнала.
+package audit
+
+import (
+    "bufio"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Path — файл журнала аудита (одна запись JSON на строку)
+var Path = "/root/dt_backend/audit.log"
+
+// KeyPath — ключ HMAC цепочки записей; создается при первой записи и не покидает устройство
+var KeyPath = "/root/dt_backend/audit.key"
+
+// HeadPath — номер и хеш последней записи; по нему Verify обнаруживает удаление записей в конце журнала
+var HeadPath = "/root/dt_backend/audit.head"
+
+// AlgHMAC — алгоритм хеша записей. Записи без alg созданы до появления ключа и хешированы SHA-256.
+const AlgHMAC = "hmac-sha256"
+
+var (
+    // MaxSize — размер журнала, после которого он переносится в Path.1 и записи продолжаются в новом файле
+    MaxSize int64 = 10 << 20
+    // MaxFiles — сколько перенесенных файлов хранить (Path.1 — самый новый); более старые удаляются
+    MaxFiles = 5
+)
+
+// Результаты действия
+const (
+    OutcomeSuccess = "success"
+    OutcomeFailure = "failure"
+    OutcomeDenied  = "denied" // запрос отклонен при аутентификации или проверке роли
+)
+
+// Entry — запись журнала
+type Entry struct {
+    Seq       uint64          `json:"seq"`
+    Time      time.Time       `json:"time"`
+    Principal string          `json:"principal"` // пользователь, имя токена или CN сертификата; system — действие самого servis
+    Role      string          `json:"role,omitempty"`
+    TokenID   string          `json:"token_id,omitempty"`
+    Client    string          `json:"client,omitempty"` // адрес клиента
+    Action    string          `json:"action"`           // operationId эндпоинта или имя внутреннего действия
+    Method    string          `json:"method,omitempty"`
+    Path      string          `json:"path,omitempty"`
+    Payload   json.RawMessage `json:"payload,omitempty"` // тело запроса без паролей и ключей
+    Status    int             `json:"status,omitempty"`  // HTTP-код ответа
+    Outcome   string          `json:"outcome"`
+    ErrorCode string          `json:"error_code,omitempty"`
+    Alg       string          `json:"alg,omitempty"`
+    PrevHash  string          `json:"prev_hash"`
+    Hash      string          `json:"hash"`
+}
+
+// Head — номер и хеш последней записи журнала. Внешняя система, сохранившая Head, может позже проверить,
+// что журнал по-прежнему содержит эту запись (Verify).
+type Head struct {
+    Seq  uint64 `json:"seq"`
+    Hash string `json:"hash"`
+}
+
+// Query — условия выборки записей; пустые поля не ограничивают выборку
+type Query struct {
+    Since     time.Time
+    Until     time.Time
+    Principal string
+    Action    string
+    Outcome   string
+    Limit     int // вернуть не больше Limit последних подходящих записей
+}
+
+// VerifyResult — результат проверки цепочки хешей
+type VerifyResult struct {
+    Valid     bool   `json:"valid"`
+    Entries   uint64 `json:"entries"`
+    FirstSeq  uint64 `json:"first_seq,omitempty"`  // первая сохранившаяся запись, если старые файлы удалены ротацией
+    BrokenSeq uint64 `json:"broken_seq,omitempty"` // первая запись, не совпадающая с цепочкой
+    Problem   string `json:"problem,omitempty"`
+    Head      *Head  `json:"head,omitempty"` // последняя запись журнала
+}
+
+var (
+    mu       sync.Mutex
+    loaded   bool
+    lastSeq  uint64
+    lastHash string
+    key      []byte
+)
+
+// sensitiveKeys — поля тела запроса, значения которых не попадают в журнал
+var sensitiveKeys = []string{"password", "psk", "secret", "token", "private_key", "passphrase"}
+
+// Redact возвращает тело запроса JSON, в котором значения паролей, токенов и ключей заменены на "[redacted]".
+// От тела, не являющегося JSON, записывается только размер.
+func Redact(body []byte) json.RawMessage {
+    if len(strings.TrimSpace(string(body))) == 0 {
+        return nil
+    }
+
+    var value interface{}
+    if err := json.Unmarshal(body, &value); err != nil {
+        data, _ := json.Marshal(map[string]interface{}{"non_json_bytes": len(body)})
+        return data
+    }
+
+    data, err := json.Marshal(redactValue(value))
+    if err != nil {
+        return nil
+    }
+    return data
+}
+
+func redactValue(value interface{}) interface{} {
+    switch v := value.(type) {
+    case map[string]interface{}:
+        for key, inner := range v {
+            if isSensitive(key) {
+                v[key] = "[redacted]"
+                continue
+            }
+            v[key] = redactValue(inner)
+        }
+    case []interface{}:
+        for i, inner := range v {
+            v[i] = redactValue(inner)
+        }
+    }
+    return value
+}
+
+func isSensitive(key string) bool {
+    key = strings.ToLower(key)
+    for _, sensitive := range sensitiveKeys {
+        if strings.Contains(key, sensitive) {
+            return true
+        }
+    }
+    return false
+}
+
+// Record добавляет запись в журнал; Seq, Time, PrevHash и Hash заполняются автоматически
+func Record(entry Entry) error {
+    mu.Lock()
+    defer mu.Unlock()
+
+    if !loaded {
+        loadTail()
+    }
+
+    entry.Seq = lastSeq + 1
+    entry.Time = time.Now().UTC()
+    entry.Alg = AlgHMAC
+    entry.PrevHash = lastHash
+    entry.Hash = ""
+
+    hash, err := entryHash(entry)
+    if err != nil {
+        return err
+    }
+    entry.Hash = hash
+
+    line, err := json.Marshal(entry)
+    if err != nil {
+        return fmt.Errorf("failed to marshal audit entry: %w", err)
+    }
+
+    err = os.MkdirAll(filepath.Dir(Path), 0700)
+    if err != nil {
+        return fmt.Errorf("failed to create audit directory: %w", err)
+    }
+
+    err = rotate(int64(len(line) + 1))
+    if err != nil {
+        return err
+    }
+
+    file, err := os.OpenFile(Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+    if err != nil {
+        return fmt.Errorf("failed to open audit log: %w", err)
+    }
+    defer file.Close()
+
+    _, err = file.Write(append(line, '\n'))
+    if err != nil {
+        return fmt.Errorf("failed to write audit entry: %w", err)
+    }
+    err = file.Sync()
+    if err != nil {
+        return fmt.Errorf("failed to sync audit log: %w", err)
+    }
+
+    lastSeq, lastHash = entry.Seq, entry.Hash
+    return saveHead(Head{Seq: lastSeq, Hash: lastHash})
+}
+
+// CurrentHead возвращает номер и хеш последней записи журнала
+func CurrentHead() Head {
+    mu.Lock()
+    defer mu.Unlock()
+
+    if !loaded {
+        loadTail()
+    }
+    return Head{Seq: lastSeq, Hash: lastHash}
+}
+
+// Log записывает действие и пишет в журнал процесса, если записать его не удалось
+func Log(entry Entry) {
+    if err := Record(entry); err != nil {
+        log.Printf("Failed to record audit entry %s by %s: %v", entry.Action, entry.Principal, err)
+    }
+}
+
+// entryHash вычисляет HMAC записи с пустым полем Hash (SHA-256 для записей без alg);
+// в него входит хеш предыдущей записи
+func entryHash(entry Entry) (string, error) {
+    entry.Hash = ""
+    data, err := json.Marshal(entry)
+    if err != nil {
+        return "", fmt.Errorf("failed to marshal audit entry: %w", err)
+    }
+
+    switch entry.Alg {
+    case "":
+        sum := sha256.Sum256(data)
+        return hex.EncodeToString(sum[:]), nil
+    case AlgHMAC:
+        key, err := loadKey()
+        if err != nil {
+            return "", err
+        }
+        mac := hmac.New(sha256.New, key)
+        mac.Write(data)
+        return hex.EncodeToString(mac.Sum(nil)), nil
+    default:
+        return "", fmt.Errorf("unknown audit hash algorithm %q", entry.Alg)
+    }
+}
+
+// loadKey читает ключ HMAC или создает его при первой записи
+func loadKey() ([]byte, error) {
+    if key != nil {
+        return key, nil
+    }
+
+    data, err := os.ReadFile(KeyPath)
+    if os.IsNotExist(err) {
+        data = make([]byte, 32)
+        if _, err := rand.Read(data); err != nil {
+            return nil, fmt.Errorf("failed to generate audit key: %w", err)
+        }
+        err = os.MkdirAll(filepath.Dir(KeyPath), 0700)
+        if err != nil {
+            return nil, fmt.Errorf("failed to create audit directory: %w", err)
+        }
+        // O_EXCL: ключ, созданный раньше, не заменяется
+        file, err := os.OpenFile(KeyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+        if err != nil {
+            return nil, fmt.Errorf("failed to create audit key: %w", err)
+        }
+        _, err = file.Write(data)
+        closeErr := file.Close()
+        if err == nil {
+            err = closeErr
+        }
+        if err != nil {
+            return nil, fmt.Errorf("failed to write audit key: %w", err)
+        }
+    } else if err != nil {
+        return nil, fmt.Errorf("failed to read audit key: %w", err)
+    }
+    if len(data) < 32 {
+        return nil, fmt.Errorf("audit key %s is too short", KeyPath)
+    }
+
+    key = data
+    return key, nil
+}
+
+// loadHead читает сохраненную последнюю запись; nil, если записей еще не было
+func loadHead() (*Head, error) {
+    data, err := os.ReadFile(HeadPath)
+    if os.IsNotExist(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read audit head: %w", err)
+    }
+
+    var head Head
+    err = json.Unmarshal(data, &head)
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse audit head: %w", err)
+    }
+    return &head, nil
+}
+
+// saveHead атомарно сохраняет последнюю запись
+func saveHead(head Head) error {
+    data, err := json.Marshal(head)
+    if err != nil {
+        return fmt.Errorf("failed to marshal audit head: %w", err)
+    }
+
+    tmp := HeadPath + ".tmp"
+    err = os.WriteFile(tmp, data, 0600)
+    if err != nil {
+        return fmt.Errorf("failed to write audit head: %w", err)
+    }
+    err = os.Rename(tmp, HeadPath)
+    if err != nil {
+        return fmt.Errorf("failed to save audit head: %w", err)
+    }
+    return nil
+}
+
+// loadTail находит номер и хеш последней записи, чтобы продолжить цепочку.
+// Если журнал поврежден, цепочка продолжается от последней прочитанной записи, а повреждение покажет Verify.
+func loadTail() {
+    // Текущий файл может быть пустым, если servis остановился сразу после ротации
+    paths := files()
+    for i := len(paths) - 1; i >= 0 && lastSeq == 0; i-- {
+        err := scanFile(paths[i], func(entry Entry) bool {
+            lastSeq, lastHash = entry.Seq, entry.Hash
+            return true
+        })
+        if err != nil {
+            log.Printf("Audit log is damaged, continuing after entry %d: %v", lastSeq, err)
+        }
+    }
+
+    // Если записи в конце журнала удалены, нумерация продолжается после сохраненной последней записи:
+    // иначе новые записи заняли бы номера удаленных и скрыли усечение
+    head, err := loadHead()
+    if err != nil {
+        log.Printf("Failed to load audit head: %v", err)
+    }
+    if head != nil && head.Seq > lastSeq {
+        log.Printf("Audit log ends at entry %d, but entry %d was written; continuing after it", lastSeq, head.Seq)
+        lastSeq, lastHash = head.Seq, head.Hash
+    }
+    loaded = true
+}
+
+// rotatedPath возвращает имя n-го перенесенного файла журнала
+func rotatedPath(n int) string {
+    return Path + "." + strconv.Itoa(n)
+}
+
+// files возвращает существующие файлы журнала от самого старого к текущему
+func files() []string {
+    var paths []string
+    for n := MaxFiles; n >= 1; n-- {
+        if _, err := os.Stat(rotatedPath(n)); err == nil {
+            paths = append(paths, rotatedPath(n))
+        }
+    }
+    return append(paths, Path)
+}
+
+// rotate переносит журнал в Path.1, если после добавления size байт он превысит MaxSize; прежние копии
+// сдвигаются, самая старая удаляется. Цепочка продолжается: первая запись нового файла ссылается на
+// последнюю запись перенесенного.
+func rotate(size int64) error {
+    info, err := os.Stat(Path)
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("failed to stat audit log: %w", err)
+    }
+    if info.Size() == 0 || info.Size()+size <= MaxSize {
+        return nil
+    }
+
+    err = os.Remove(rotatedPath(MaxFiles))
+    if err != nil && !os.IsNotExist(err) {
+        return fmt.Errorf("failed to remove old audit log: %w", err)
+    }
+    for n := MaxFiles - 1; n >= 1; n-- {
+        err := os.Rename(rotatedPath(n), rotatedPath(n+1))
+        if err != nil && !os.IsNotExist(err) {
+            return fmt.Errorf("failed to rotate audit log: %w", err)
+        }
+    }
+    err = os.Rename(Path, rotatedPath(1))
+    if err != nil {
+        return fmt.Errorf("failed to rotate audit log: %w", err)
+    }
+    return nil
+}
+
+// scan читает записи всех файлов журнала по порядку, пока fn возвращает true
+func scan(fn func(entry Entry) bool) error {
+    stopped := false
+    for _, path := range files() {
+        err := scanFile(path, func(entry Entry) bool {
+            stopped = !fn(entry)
+            return !stopped
+        })
+        if err != nil || stopped {
+            return err
+        }
+    }
+    return nil
+}
+
+// scanFile читает записи одного файла журнала по порядку, пока fn возвращает true
+func scanFile(path string, fn func(entry Entry) bool) error {
+    file, err := os.Open(path)
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("failed to open audit log: %w", err)
+    }
+    defer file.Close()
+
+    scanner := bufio.NewScanner(file)
+    scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+    line := 0
+    for scanner.Scan() {
+        line++
+        var entry Entry
+        if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+            return fmt.Errorf("invalid audit entry in %s on line %d: %w", filepath.Base(path), line, err)
+        }
+        if !fn(entry) {
+            return nil
+        }
+    }
+    if err := scanner.Err(); err != nil {
+        return fmt.Errorf("failed to read audit log %s: %w", filepath.Base(path), err)
+    }
+    return nil
+}
+
+// Find возвращает записи, подходящие под условия, в порядке записи. Файлы читаются от текущего к более
+// старым, пока не набрано Limit записей, поэтому обычный запрос последних записей не читает весь архив.
+func Find(q Query) ([]Entry, error) {
+    mu.Lock()
+    defer mu.Unlock()
+
+    entries := []Entry{}
+    paths := files()
+    for i := len(paths) - 1; i >= 0; i-- {
+        var matched []Entry
+        err := scanFile(paths[i], func(entry Entry) bool {
+            switch {
+            case !q.Since.IsZero() && entry.Time.Before(q.Since):
+            case !q.Until.IsZero() && entry.Time.After(q.Until):
+            case q.Principal != "" && entry.Principal != q.Principal:
+            case q.Action != "" && entry.Action != q.Action:
+            case q.Outcome != "" && entry.Outcome != q.Outcome:
+            default:
+                matched = append(matched, entry)
+            }
+            return true
+        })
+        if err != nil {
+            return nil, err
+        }
+
+        entries = append(matched, entries...)
+        if q.Limit > 0 && len(entries) >= q.Limit {
+            break
+        }
+    }
+
+    if q.Limit > 0 && len(entries) > q.Limit {
+        entries = entries[len(entries)-q.Limit:]
+    }
+    return entries, nil
+}
+
+// Verify проверяет, что записи всех файлов журнала идут подряд и каждая ссылается на хеш предыдущей,
+// а журнал не короче сохраненной последней записи. Если expected задан (например, Head из состояния MQTT),
+// журнал должен содержать эту запись с тем же хешем. Если старые файлы удалены ротацией, проверка
+// начинается с первой сохранившейся записи.
+func Verify(expected *Head) *VerifyResult {
+    mu.Lock()
+    defer mu.Unlock()
+
+    // Все места для перенесенных файлов заняты: самые старые записи могли быть удалены ротацией
+    rotatedAway := len(files()) > MaxFiles
+
+    result := &VerifyResult{Valid: true}
+    nextSeq := uint64(1)
+    prevHash := ""
+    keyed := false
+    expectedHash := ""
+    err := scan(func(entry Entry) bool {
+        if result.Entries == 0 && entry.Seq > 1 && rotatedAway {
+            result.FirstSeq = entry.Seq
+            nextSeq, prevHash = entry.Seq, entry.PrevHash
+        }
+        result.Entries++
+
+        hash, err := entryHash(entry)
+        switch {
+        case entry.Seq != nextSeq:
+            result.Problem = fmt.Sprintf("expected entry %d, found %d", nextSeq, entry.Seq)
+        case entry.PrevHash != prevHash:
+            result.Problem = "previous hash does not match"
+        case keyed && entry.Alg != AlgHMAC:
+            // Запись без ключа после записей с ключом могла быть дописана без доступа к ключу
+            result.Problem = "entry is not signed with the device key"
+        case err != nil || !hmac.Equal([]byte(hash), []byte(entry.Hash)):
+            result.Problem = "entry hash does not match its contents"
+        default:
+            keyed = keyed || entry.Alg == AlgHMAC
+            nextSeq++
+            prevHash = entry.Hash
+            result.Head = &Head{Seq: entry.Seq, Hash: entry.Hash}
+            if expected != nil && entry.Seq == expected.Seq {
+                expectedHash = entry.Hash
+            }
+            return true
+        }
+
+        result.Valid = false
+        result.BrokenSeq = nextSeq
+        return false
+    })
+    if err != nil {
+        return broken(result, nextSeq, err.Error())
+    }
+    if !result.Valid {
+        return result
+    }
+
+    head, err := loadHead()
+    if err != nil {
+        return broken(result, 0, err.Error())
+    }
+    if head != nil && head.Seq >= nextSeq {
+        return broken(result, nextSeq, fmt.Sprintf("log is truncated: entry %d was written, log ends at %d", head.Seq, nextSeq-1))
+    }
+    if head != nil && head.Seq == nextSeq-1 && head.Hash != prevHash {
+        return broken(result, head.Seq, "last entry does not match the stored head")
+    }
+
+    if expected != nil && expected.Seq > 0 {
+        switch {
+        case expected.Seq >= nextSeq:
+            return broken(result, nextSeq, fmt.Sprintf("log is truncated: expected entry %d, log ends at %d", expected.Seq, nextSeq-1))
+        case expectedHash == "":
+            return broken(result, 0, fmt.Sprintf("entry %d is older than the retained log", expected.Seq))
+        case expectedHash != expected.Hash:
+            return broken(result, expected.Seq, fmt.Sprintf("entry %d does not match the expected head", expected.Seq))
+        }
+    }
+    return result
+}
+
+// broken отмечает результат проверки как недействительный
+func broken(result *VerifyResult, seq uint64, problem string) *VerifyResult {
+    result.Valid = false
+    result.BrokenSeq = seq
+    result.Problem = problem
+    return result
+}
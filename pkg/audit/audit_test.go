@@ -0,0 +1,230 @@
+package audit
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// setupLog направляет журнал во временный каталог и сбрасывает состояние пакета
+func setupLog(t *testing.T) {
+    t.Helper()
+    dir := t.TempDir()
+    oldPath, oldKey, oldHead := Path, KeyPath, HeadPath
+    oldSize, oldFiles := MaxSize, MaxFiles
+    Path = filepath.Join(dir, "audit.log")
+    KeyPath = filepath.Join(dir, "audit.key")
+    HeadPath = filepath.Join(dir, "audit.head")
+    reset()
+    t.Cleanup(func() {
+        Path, KeyPath, HeadPath = oldPath, oldKey, oldHead
+        MaxSize, MaxFiles = oldSize, oldFiles
+        reset()
+    })
+}
+
+// reset забывает прочитанный хвост журнала и ключ, как после перезапуска
+func reset() {
+    loaded, lastSeq, lastHash, key = false, 0, "", nil
+}
+
+func recordN(t *testing.T, n int) {
+    t.Helper()
+    for i := 0; i < n; i++ {
+        err := Record(Entry{Principal: "admin", Action: "reboot", Payload: json.RawMessage(`{"delay":5}`), Outcome: OutcomeSuccess})
+        if err != nil {
+            t.Fatalf("Record: %v", err)
+        }
+    }
+}
+
+func readLines(t *testing.T) []string {
+    t.Helper()
+    data, err := os.ReadFile(Path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func writeLines(t *testing.T, lines []string) {
+    t.Helper()
+    data := ""
+    if len(lines) > 0 {
+        data = strings.Join(lines, "\n") + "\n"
+    }
+    if err := os.WriteFile(Path, []byte(data), 0600); err != nil {
+        t.Fatal(err)
+    }
+}
+
+// editEntry изменяет запись в строке i; хеш пересчитывается, только если это делает edit
+func editEntry(t *testing.T, lines []string, i int, edit func(entry *Entry)) []string {
+    t.Helper()
+    var entry Entry
+    if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+        t.Fatal(err)
+    }
+    edit(&entry)
+    data, err := json.Marshal(entry)
+    if err != nil {
+        t.Fatal(err)
+    }
+    edited := append([]string(nil), lines...)
+    edited[i] = string(data)
+    return edited
+}
+
+func TestVerifyValidChain(t *testing.T) {
+    setupLog(t)
+    recordN(t, 5)
+
+    result := Verify(nil)
+    if !result.Valid || result.Entries != 5 {
+        t.Fatalf("Verify = %+v, want 5 valid entries", result)
+    }
+    if result.Head == nil || *result.Head != CurrentHead() || result.Head.Seq != 5 {
+        t.Fatalf("head %+v, current %+v", result.Head, CurrentHead())
+    }
+
+    if result := Verify(result.Head); !result.Valid {
+        t.Fatalf("Verify with current head: %+v", result)
+    }
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+    tests := []struct {
+        name      string
+        tamper    func(t *testing.T, lines []string) []string
+        brokenSeq uint64
+        problem   string
+    }{
+        {"modified payload", func(t *testing.T, lines []string) []string {
+            return editEntry(t, lines, 2, func(entry *Entry) { entry.Payload = json.RawMessage(`{"delay":0}`) })
+        }, 3, "does not match its contents"},
+        {"deleted entry", func(t *testing.T, lines []string) []string {
+            return append(append([]string(nil), lines[:2]...), lines[3:]...)
+        }, 3, "expected entry 3, found 4"},
+        {"swapped entries", func(t *testing.T, lines []string) []string {
+            swapped := append([]string(nil), lines...)
+            swapped[1], swapped[2] = swapped[2], swapped[1]
+            return swapped
+        }, 2, "expected entry 2, found 3"},
+        {"rehashed without key", func(t *testing.T, lines []string) []string {
+            // Без ключа можно пересчитать только SHA-256 записи старого формата
+            return editEntry(t, lines, 4, func(entry *Entry) {
+                entry.Outcome = OutcomeFailure
+                entry.Alg = ""
+                entry.Hash = ""
+                data, _ := json.Marshal(entry)
+                sum := sha256.Sum256(data)
+                entry.Hash = hex.EncodeToString(sum[:])
+            })
+        }, 5, "not signed with the device key"},
+        {"truncated tail", func(t *testing.T, lines []string) []string {
+            return lines[:3]
+        }, 4, "log is truncated"},
+        {"emptied log", func(t *testing.T, lines []string) []string {
+            return nil
+        }, 1, "log is truncated"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            setupLog(t)
+            recordN(t, 5)
+            writeLines(t, test.tamper(t, readLines(t)))
+
+            result := Verify(nil)
+            if result.Valid {
+                t.Fatalf("Verify = %+v, want invalid", result)
+            }
+            if result.BrokenSeq != test.brokenSeq || !strings.Contains(result.Problem, test.problem) {
+                t.Fatalf("broken at %d (%s), want %d (%s)", result.BrokenSeq, result.Problem, test.brokenSeq, test.problem)
+            }
+        })
+    }
+}
+
+func TestVerifyExpectedHead(t *testing.T) {
+    setupLog(t)
+    recordN(t, 3)
+    published := CurrentHead()
+    recordN(t, 2)
+
+    if result := Verify(&published); !result.Valid {
+        t.Fatalf("Verify with published head: %+v", result)
+    }
+
+    // Сохраненная последняя запись удалена вместе с хвостом журнала: остается только опубликованная
+    writeLines(t, readLines(t)[:2])
+    os.Remove(HeadPath)
+    if result := Verify(nil); !result.Valid {
+        t.Fatalf("Verify without head: %+v, want valid", result)
+    }
+    result := Verify(&published)
+    if result.Valid || !strings.Contains(result.Problem, "log is truncated") {
+        t.Fatalf("Verify with published head = %+v, want truncation", result)
+    }
+
+    forged := Head{Seq: 2, Hash: published.Hash}
+    if result := Verify(&forged); result.Valid || result.BrokenSeq != 2 {
+        t.Fatalf("Verify with wrong hash = %+v, want broken entry 2", result)
+    }
+}
+
+func TestRecordContinuesAfterTruncation(t *testing.T) {
+    setupLog(t)
+    recordN(t, 5)
+    writeLines(t, readLines(t)[:3])
+
+    // После перезапуска нумерация продолжается после удаленных записей, и пропуск остается видимым
+    reset()
+    recordN(t, 1)
+    if head := CurrentHead(); head.Seq != 6 {
+        t.Fatalf("head after truncation is %d, want 6", head.Seq)
+    }
+    result := Verify(nil)
+    if result.Valid || result.BrokenSeq != 4 {
+        t.Fatalf("Verify = %+v, want broken entry 4", result)
+    }
+}
+
+func TestRotationKeepsChain(t *testing.T) {
+    setupLog(t)
+    recordN(t, 1)
+    MaxSize = int64(len(readLines(t)[0])+1) * 3
+    MaxFiles = 2
+
+    recordN(t, 11)
+    for _, path := range []string{Path, rotatedPath(1), rotatedPath(2)} {
+        if _, err := os.Stat(path); err != nil {
+            t.Fatalf("missing %s: %v", filepath.Base(path), err)
+        }
+    }
+    if _, err := os.Stat(rotatedPath(3)); !os.IsNotExist(err) {
+        t.Fatalf("%s kept beyond MaxFiles", filepath.Base(rotatedPath(3)))
+    }
+
+    result := Verify(nil)
+    if !result.Valid || result.FirstSeq == 0 || result.Head.Seq != 12 {
+        t.Fatalf("Verify = %+v, want valid chain ending at 12 with older entries rotated away", result)
+    }
+
+    entries, err := Find(Query{Limit: 5})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(entries) != 5 || entries[0].Seq != 8 || entries[4].Seq != 12 {
+        t.Fatalf("Find returned %d entries starting at %d, want 8..12", len(entries), entries[0].Seq)
+    }
+
+    // Удаление перенесенного файла не выдается за ротацию
+    os.Remove(rotatedPath(2))
+    if result := Verify(nil); result.Valid {
+        t.Fatalf("Verify after removing %s = %+v, want invalid", filepath.Base(rotatedPath(2)), result)
+    }
+}
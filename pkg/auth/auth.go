@@ -0,0 +1,550 @@
+package auth
+
+import (
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strings"
+    "sync"
+    "time"
+
+    "golang.org/x/crypto/bcrypt"
+)
+
+// StorePath — файл с учетными записями и API-токенами
+var StorePath = "/root/dt_backend/auth.json"
+
+// InitialTokenPath — файл, в который записывается токен администратора при первом запуске
+var InitialTokenPath = "/root/dt_backend/initial_admin.token"
+
+// SessionTTL — время жизни токена, выданного при входе по логину и паролю
+var SessionTTL = 12 * time.Hour
+
+// Роли в порядке возрастания прав: каждая следующая включает права предыдущей
+const (
+    RoleViewer   = "viewer"
+    RoleOperator = "operator"
+    RoleAdmin    = "admin"
+)
+
+var roleLevels = map[string]int{
+    RoleViewer:   1,
+    RoleOperator: 2,
+    RoleAdmin:    3,
+}
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,31}$`)
+
+// Ошибки учетных записей
+var (
+    ErrInvalidCredentials = errors.New("invalid credentials")
+    ErrNotFound           = errors.New("not found")
+    ErrLastAdmin          = errors.New("cannot remove the last admin")
+    ErrInvalidInput       = errors.New("invalid input")
+)
+
+// User описывает локальную учетную запись
+type User struct {
+    Username     string    `json:"username"`
+    PasswordHash string    `json:"password_hash,omitempty"`
+    Role         string    `json:"role"`
+    CreatedAt    time.Time `json:"created_at"`
+}
+
+// Token описывает API-токен. Сам токен не хранится, только его SHA-256.
+type Token struct {
+    ID        string     `json:"id"`
+    Name      string     `json:"name"`
+    Hash      string     `json:"hash,omitempty"`
+    Role      string     `json:"role"`
+    Username  string     `json:"username,omitempty"` // владелец токена, выданного при входе
+    CreatedAt time.Time  `json:"created_at"`
+    ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Identity описывает того, кто выполняет запрос
+type Identity struct {
+    Name    string `json:"name"`
+    Role    string `json:"role"`
+    TokenID string `json:"token_id"`
+}
+
+type store struct {
+    Users  []User  `json:"users"`
+    Tokens []Token `json:"tokens"`
+}
+
+var (
+    mu     sync.Mutex
+    loaded *store
+
+    dummyHashOnce sync.Once
+    dummyHash     []byte
+)
+
+// ValidRole проверяет, что роль известна
+func ValidRole(role string) bool {
+    _, ok := roleLevels[role]
+    return ok
+}
+
+// HasRole сообщает, достаточно ли прав у роли для требуемой
+func HasRole(role, required string) bool {
+    return roleLevels[role] >= roleLevels[required]
+}
+
+// Init загружает хранилище учетных данных. Если в нем нет ни одного администратора,
+// создается токен администратора и записывается в InitialTokenPath.
+func Init() error {
+    mu.Lock()
+    defer mu.Unlock()
+
+    s, err := load()
+    if err != nil {
+        return err
+    }
+
+    for _, user := range s.Users {
+        if user.Role == RoleAdmin {
+            return nil
+        }
+    }
+    for _, token := range s.Tokens {
+        if token.Role == RoleAdmin && !token.expired(time.Now()) {
+            return nil
+        }
+    }
+
+    plain, token, err := newToken("initial admin", RoleAdmin, "", nil)
+    if err != nil {
+        return err
+    }
+    s.Tokens = append(s.Tokens, token)
+    err = save(s)
+    if err != nil {
+        return err
+    }
+
+    err = ioutil.WriteFile(InitialTokenPath, []byte(plain+"\n"), 0600)
+    if err != nil {
+        return fmt.Errorf("failed to write initial admin token: %w", err)
+    }
+    log.Printf("No admin credentials found, initial admin token written to %s", InitialTokenPath)
+    return nil
+}
+
+// Authenticate проверяет API-токен и возвращает его владельца
+func Authenticate(plain string) (*Identity, error) {
+    id, secret, ok := strings.Cut(plain, ".")
+    if !ok || id == "" || secret == "" {
+        return nil, ErrInvalidCredentials
+    }
+
+    mu.Lock()
+    defer mu.Unlock()
+
+    s, err := load()
+    if err != nil {
+        return nil, err
+    }
+
+    for _, token := range s.Tokens {
+        if token.ID != id {
+            continue
+        }
+        if subtle.ConstantTimeCompare([]byte(token.Hash), []byte(hashSecret(secret))) != 1 {
+            return nil, ErrInvalidCredentials
+        }
+        if token.expired(time.Now()) {
+            return nil, ErrInvalidCredentials
+        }
+
+        name := token.Name
+        if token.Username != "" {
+            name = token.Username
+        }
+        return &Identity{Name: name, Role: token.Role, TokenID: token.ID}, nil
+    }
+
+    return nil, ErrInvalidCredentials
+}
+
+// Login проверяет логин и пароль и выдает токен с временем жизни SessionTTL
+func Login(username, password string) (string, *Token, error) {
+    mu.Lock()
+    defer mu.Unlock()
+
+    s, err := load()
+    if err != nil {
+        return "", nil, err
+    }
+
+    var user *User
+    for i := range s.Users {
+        if s.Users[i].Username == username {
+            user = &s.Users[i]
+            break
+        }
+    }
+    if user == nil {
+        // Пароль проверяется и для несуществующего пользователя, чтобы время ответа не выдавало имена
+        dummyHashOnce.Do(func() {
+            dummyHash, _ = bcrypt.GenerateFromPassword([]byte("servis"), bcrypt.DefaultCost)
+        })
+        bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+        return "", nil, ErrInvalidCredentials
+    }
+    if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+        return "", nil, ErrInvalidCredentials
+    }
+
+    expiresAt := time.Now().Add(SessionTTL)
+    plain, token, err := newToken("session", user.Role, user.Username, &expiresAt)
+    if err != nil {
+        return "", nil, err
+    }
+
+    s.Tokens = append(pruneExpired(s.Tokens), token)
+    err = save(s)
+    if err != nil {
+        return "", nil, err
+    }
+    return plain, &token, nil
+}
+
+// ListUsers возвращает учетные записи без хэшей паролей
+func ListUsers() ([]User, error) {
+    mu.Lock()
+    defer mu.Unlock()
+
+    s, err := load()
+    if err != nil {
+        return nil, err
+    }
+
+    users := []User{}
+    for _, user := range s.Users {
+        user.PasswordHash = ""
+        users = append(users, user)
+    }
+    return users, nil
+}
+
+// CreateUser создает учетную запись или меняет пароль и роль существующей
+func CreateUser(username, password, role string) (*User, error) {
+    if !usernamePattern.MatchString(username) {
+        return nil, fmt.Errorf("%w: invalid username %q", ErrInvalidInput, username)
+    }
+    if len(password) < 8 {
+        return nil, fmt.Errorf("%w: password must be at least 8 characters", ErrInvalidInput)
+    }
+    if !ValidRole(role) {
+        return nil, fmt.Errorf("%w: unknown role %q", ErrInvalidInput, role)
+    }
+
+    hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+    if err != nil {
+        return nil, fmt.Errorf("failed to hash password: %w", err)
+    }
+
+    mu.Lock()
+    defer mu.Unlock()
+
+    s, err := load()
+    if err != nil {
+        return nil, err
+    }
+
+    user := User{Username: username, PasswordHash: string(hash), Role: role, CreatedAt: time.Now()}
+    replaced := false
+    for i := range s.Users {
+        if s.Users[i].Username == username {
+            if s.Users[i].Role == RoleAdmin && role != RoleAdmin && adminCount(s) == 1 {
+                return nil, ErrLastAdmin
+            }
+            user.CreatedAt = s.Users[i].CreatedAt
+            s.Users[i] = user
+            replaced = true
+        }
+    }
+    if !replaced {
+        s.Users = append(s.Users, user)
+    }
+
+    // Старые сессии пользователя больше не действительны
+    s.Tokens = revokeSessions(s.Tokens, username)
+
+    err = save(s)
+    if err != nil {
+        return nil, err
+    }
+
+    user.PasswordHash = ""
+    return &user, nil
+}
+
+// DeleteUser удаляет учетную запись и выданные ей токены
+func DeleteUser(username string) error {
+    mu.Lock()
+    defer mu.Unlock()
+
+    s, err := load()
+    if err != nil {
+        return err
+    }
+
+    var users []User
+    found := false
+    for _, user := range s.Users {
+        if user.Username == username {
+            if user.Role == RoleAdmin && adminCount(s) == 1 {
+                return ErrLastAdmin
+            }
+            found = true
+            continue
+        }
+        users = append(users, user)
+    }
+    if !found {
+        return fmt.Errorf("user %s: %w", username, ErrNotFound)
+    }
+
+    s.Users = users
+    s.Tokens = revokeSessions(s.Tokens, username)
+    return save(s)
+}
+
+// ListTokens возвращает действующие API-токены без хэшей
+func ListTokens() ([]Token, error) {
+    mu.Lock()
+    defer mu.Unlock()
+
+    s, err := load()
+    if err != nil {
+        return nil, err
+    }
+
+    tokens := []Token{}
+    now := time.Now()
+    for _, token := range s.Tokens {
+        if token.expired(now) {
+            continue
+        }
+        token.Hash = ""
+        tokens = append(tokens, token)
+    }
+    return tokens, nil
+}
+
+// CreateToken создает API-токен. Токен возвращается только один раз, в хранилище остается его хэш.
+func CreateToken(name, role string, ttl time.Duration) (string, *Token, error) {
+    if strings.TrimSpace(name) == "" {
+        return "", nil, fmt.Errorf("%w: token name is required", ErrInvalidInput)
+    }
+    if !ValidRole(role) {
+        return "", nil, fmt.Errorf("%w: unknown role %q", ErrInvalidInput, role)
+    }
+
+    var expiresAt *time.Time
+    if ttl > 0 {
+        expires := time.Now().Add(ttl)
+        expiresAt = &expires
+    }
+
+    mu.Lock()
+    defer mu.Unlock()
+
+    s, err := load()
+    if err != nil {
+        return "", nil, err
+    }
+
+    plain, token, err := newToken(name, role, "", expiresAt)
+    if err != nil {
+        return "", nil, err
+    }
+
+    s.Tokens = append(pruneExpired(s.Tokens), token)
+    err = save(s)
+    if err != nil {
+        return "", nil, err
+    }
+
+    token.Hash = ""
+    return plain, &token, nil
+}
+
+// RevokeToken отзывает API-токен
+func RevokeToken(id string) error {
+    mu.Lock()
+    defer mu.Unlock()
+
+    s, err := load()
+    if err != nil {
+        return err
+    }
+
+    var tokens []Token
+    found := false
+    for _, token := range s.Tokens {
+        if token.ID == id {
+            found = true
+            continue
+        }
+        tokens = append(tokens, token)
+    }
+    if !found {
+        return fmt.Errorf("token %s: %w", id, ErrNotFound)
+    }
+
+    s.Tokens = tokens
+    if adminCount(s) == 0 {
+        return ErrLastAdmin
+    }
+    return save(s)
+}
+
+// newToken генерирует токен вида "<id>.<secret>" и запись о нем для хранилища
+func newToken(name, role, username string, expiresAt *time.Time) (string, Token, error) {
+    idBytes := make([]byte, 8)
+    secretBytes := make([]byte, 32)
+    if _, err := rand.Read(idBytes); err != nil {
+        return "", Token{}, fmt.Errorf("failed to generate token: %w", err)
+    }
+    if _, err := rand.Read(secretBytes); err != nil {
+        return "", Token{}, fmt.Errorf("failed to generate token: %w", err)
+    }
+
+    id := hex.EncodeToString(idBytes)
+    secret := base64.RawURLEncoding.EncodeToString(secretBytes)
+
+    token := Token{
+        ID:        id,
+        Name:      name,
+        Hash:      hashSecret(secret),
+        Role:      role,
+        Username:  username,
+        CreatedAt: time.Now(),
+        ExpiresAt: expiresAt,
+    }
+    return id + "." + secret, token, nil
+}
+
+// hashSecret возвращает SHA-256 секретной части токена. Секрет случайный и длинный,
+// поэтому медленный хэш, как для паролей, не нужен.
+func hashSecret(secret string) string {
+    sum := sha256.Sum256([]byte(secret))
+    return hex.EncodeToString(sum[:])
+}
+
+func (t *Token) expired(now time.Time) bool {
+    return t.ExpiresAt != nil && now.After(*t.ExpiresAt)
+}
+
+// pruneExpired удаляет истекшие токены
+func pruneExpired(tokens []Token) []Token {
+    var result []Token
+    now := time.Now()
+    for _, token := range tokens {
+        if !token.expired(now) {
+            result = append(result, token)
+        }
+    }
+    return result
+}
+
+// revokeSessions удаляет токены, выданные пользователю при входе
+func revokeSessions(tokens []Token, username string) []Token {
+    var result []Token
+    for _, token := range tokens {
+        if token.Username != username {
+            result = append(result, token)
+        }
+    }
+    return result
+}
+
+// adminCount считает учетные записи и действующие токены с ролью администратора, не привязанные к сессиям
+func adminCount(s *store) int {
+    count := 0
+    for _, user := range s.Users {
+        if user.Role == RoleAdmin {
+            count++
+        }
+    }
+    now := time.Now()
+    for _, token := range s.Tokens {
+        if token.Role == RoleAdmin && token.Username == "" && !token.expired(now) {
+            count++
+        }
+    }
+    return count
+}
+
+// load возвращает копию хранилища, при первом обращении читая его с диска.
+// Изменения копии становятся действующими только после успешного save.
+func load() (*store, error) {
+    if loaded != nil {
+        return loaded.clone(), nil
+    }
+
+    data, err := ioutil.ReadFile(StorePath)
+    if os.IsNotExist(err) {
+        loaded = &store{}
+        return loaded.clone(), nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read auth store: %w", err)
+    }
+
+    var s store
+    err = json.Unmarshal(data, &s)
+    if err != nil {
+        return nil, fmt.Errorf("failed to unmarshal auth store: %w", err)
+    }
+    loaded = &s
+    return loaded.clone(), nil
+}
+
+func (s *store) clone() *store {
+    return &store{
+        Users:  append([]User(nil), s.Users...),
+        Tokens: append([]Token(nil), s.Tokens...),
+    }
+}
+
+// save атомарно записывает хранилище на диск с правами только для владельца
+func save(s *store) error {
+    data, err := json.MarshalIndent(s, "", "  ")
+    if err != nil {
+        return fmt.Errorf("failed to marshal auth store: %w", err)
+    }
+
+    err = os.MkdirAll(filepath.Dir(StorePath), 0700)
+    if err != nil {
+        return fmt.Errorf("failed to create auth store directory: %w", err)
+    }
+
+    tmp := StorePath + ".tmp"
+    err = ioutil.WriteFile(tmp, data, 0600)
+    if err != nil {
+        return fmt.Errorf("failed to write auth store: %w", err)
+    }
+    err = os.Rename(tmp, StorePath)
+    if err != nil {
+        return fmt.Errorf("failed to write auth store: %w", err)
+    }
+
+    loaded = s
+    return nil
+}
@@ -0,0 +1,160 @@
+package auth
+
+import (
+    "context"
+    "crypto/tls"
+    "errors"
+    "log"
+    "net/http"
+    "strings"
+    "servis/pkg/apierror"
+)
+
+type contextKey struct{}
+
+// peerKey — ключ пользователя, которого определил транспорт (NewPeerContext)
+type peerKey struct{}
+
+// Require пропускает запрос к обработчику, только если в заголовке Authorization передан
+// действующий токен, клиент предъявил проверенный сертификат (mTLS) или подключился через Unix-сокет
+// (NewPeerContext) с ролью не ниже требуемой
+func Require(role string, next http.HandlerFunc) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        header := r.Header.Get("Authorization")
+        identity := transportIdentity(r)
+
+        if header != "" || identity == nil {
+            plain, ok := strings.CutPrefix(header, "Bearer ")
+            if !ok || plain == "" {
+                w.Header().Set("WWW-Authenticate", `Bearer realm="servis"`)
+                apierror.Write(w, apierror.New(apierror.CodeAuthenticationRequired, "authentication required"))
+                return
+            }
+
+            var err error
+            identity, err = Authenticate(strings.TrimSpace(plain))
+            if errors.Is(err, ErrInvalidCredentials) {
+                w.Header().Set("WWW-Authenticate", `Bearer realm="servis", error="invalid_token"`)
+                apierror.Write(w, apierror.New(apierror.CodeInvalidToken, "invalid or expired token"))
+                return
+            }
+            if err != nil {
+                log.Printf("Failed to authenticate request: %v", err)
+                apierror.Write(w, apierror.New(apierror.CodeInternal, "failed to authenticate"))
+                return
+            }
+        }
+
+        if !HasRole(identity.Role, role) {
+            apierror.Write(w, apierror.New(apierror.CodeInsufficientRole, "insufficient role: "+role+" required").
+                WithDetails(map[string]string{"required_role": role, "role": identity.Role}))
+            return
+        }
+
+        next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), identity)))
+    })
+}
+
+// AuthorizeToken проверяет токен plain и роль его владельца для интерфейсов без HTTP-заголовков (gRPC, MQTT).
+// Ошибки те же, что у Require; при недостаточной роли возвращается и пользователь, чтобы записать его в журнал аудита.
+func AuthorizeToken(plain, role string) (*Identity, error) {
+    if plain == "" {
+        return nil, apierror.New(apierror.CodeAuthenticationRequired, "authentication required")
+    }
+
+    identity, err := Authenticate(strings.TrimSpace(plain))
+    if errors.Is(err, ErrInvalidCredentials) {
+        return nil, apierror.New(apierror.CodeInvalidToken, "invalid or expired token")
+    }
+    if err != nil {
+        log.Printf("Failed to authenticate request: %v", err)
+        return nil, apierror.New(apierror.CodeInternal, "failed to authenticate")
+    }
+    return identity, CheckRole(identity, role)
+}
+
+// CheckRole возвращает ошибку insufficient_role, если роль пользователя ниже требуемой
+func CheckRole(identity *Identity, role string) error {
+    if HasRole(identity.Role, role) {
+        return nil
+    }
+    return apierror.New(apierror.CodeInsufficientRole, "insufficient role: "+role+" required").
+        WithDetails(map[string]string{"required_role": role, "role": identity.Role})
+}
+
+// Identify возвращает пользователя по токену или клиентскому сертификату, не требуя их: nil, если учетные данные
+// не переданы или недействительны. Нужна эндпоинтам, доступным без аутентификации, которые отдают
+// аутентифицированным клиентам больше сведений.
+func Identify(r *http.Request) *Identity {
+    plain, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+    if !ok || plain == "" {
+        return transportIdentity(r)
+    }
+    identity, err := Authenticate(strings.TrimSpace(plain))
+    if err != nil {
+        return nil
+    }
+    return identity
+}
+
+// CertificateIdentity возвращает пользователя по клиентскому сертификату, проверенному при установке TLS
+// (nil, если сертификат не предъявлен). Имя берется из CN, роль — из первого OU, совпадающего с известной ролью;
+// без него выдается viewer.
+func CertificateIdentity(state *tls.ConnectionState) *Identity {
+    if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+        return nil
+    }
+
+    cert := state.VerifiedChains[0][0]
+    identity := &Identity{Name: cert.Subject.CommonName, Role: RoleViewer, TokenID: "cert:" + cert.SerialNumber.Text(16)}
+    for _, unit := range cert.Subject.OrganizationalUnit {
+        if ValidRole(unit) {
+            identity.Role = unit
+            break
+        }
+    }
+    return identity
+}
+
+// transportIdentity возвращает пользователя, подтвержденного самим соединением: по клиентскому сертификату
+// или по учетным данным процесса на другом конце Unix-сокета
+func transportIdentity(r *http.Request) *Identity {
+    if identity := CertificateIdentity(r.TLS); identity != nil {
+        return identity
+    }
+    identity, _ := r.Context().Value(peerKey{}).(*Identity)
+    return identity
+}
+
+// NewPeerContext возвращает контекст соединения, пользователя которого определил транспорт (например, Unix-сокет
+// по учетным данным процесса-клиента). Запросы такого соединения проходят Require без токена.
+func NewPeerContext(ctx context.Context, identity *Identity) context.Context {
+    return context.WithValue(ctx, peerKey{}, identity)
+}
+
+// AllowQueryToken принимает токен из параметра access_token, если заголовок Authorization не передан.
+// Нужен для EventSource и WebSocket в браузере, которые не позволяют задать заголовки.
+func AllowQueryToken(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        query := r.URL.Query()
+        if token := query.Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+            r = r.Clone(r.Context())
+            r.Header.Set("Authorization", "Bearer "+token)
+            // Токен убирается из URL, чтобы не попасть в журналы
+            query.Del("access_token")
+            r.URL.RawQuery = query.Encode()
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// NewContext возвращает контекст запроса, выполняемого пользователем identity
+func NewContext(ctx context.Context, identity *Identity) context.Context {
+    return context.WithValue(ctx, contextKey{}, identity)
+}
+
+// FromContext возвращает пользователя, выполняющего запрос
+func FromContext(ctx context.Context) *Identity {
+    identity, _ := ctx.Value(contextKey{}).(*Identity)
+    return identity
+}
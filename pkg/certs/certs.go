@@ -0,0 +1,466 @@
+package certs
+
+import (
+    "bufio"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/hex"
+    "encoding/pem"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "math/big"
+    "net"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Dir — директория с сертификатами и ключами TLS
+var Dir = "/root/dt_backend/tls"
+
+// RequireClientCert — требовать клиентский сертификат у всех клиентов.
+// По умолчанию сертификат проверяется, только если клиент его предъявил, а остальные входят по токену.
+var RequireClientCert = false
+
+// Срок действия сертификатов и порог их перевыпуска
+var (
+    CAValidity     = 20 * 365 * 24 * time.Hour
+    ServerValidity = 397 * 24 * time.Hour
+    RenewBefore    = 30 * 24 * time.Hour
+)
+
+// Имена файлов в Dir
+const (
+    caCertFile     = "ca.crt"
+    caKeyFile      = "ca.key"
+    serverCertFile = "server.crt"
+    serverKeyFile  = "server.key"
+    customCertFile = "custom.crt"    // сертификат, предоставленный пользователем (цепочка в PEM)
+    customKeyFile  = "custom.key"    // ключ к нему
+    clientCAFile   = "client_ca.crt" // CA для проверки клиентских сертификатов (mTLS)
+)
+
+// Источники сертификата сервера
+const (
+    SourceGenerated = "generated"
+    SourceCustom    = "custom"
+)
+
+// reissueInterval ограничивает частоту перевыпуска сертификата при смене адресов
+const reissueInterval = time.Minute
+
+// Status описывает используемый сертификат сервера
+type Status struct {
+    Source            string    `json:"source"`
+    Subject           string    `json:"subject"`
+    DNSNames          []string  `json:"dns_names"`
+    IPAddresses       []string  `json:"ip_addresses"`
+    NotBefore         time.Time `json:"not_before"`
+    NotAfter          time.Time `json:"not_after"`
+    Fingerprint       string    `json:"fingerprint_sha256"`
+    CAFingerprint     string    `json:"ca_fingerprint_sha256"`
+    ClientAuth        bool      `json:"client_auth"`
+    RequireClientCert bool      `json:"require_client_cert"`
+}
+
+var (
+    mu          sync.Mutex
+    current     *tls.Certificate
+    source      string
+    caCert      *x509.Certificate
+    caKey       *ecdsa.PrivateKey
+    lastReissue time.Time
+)
+
+// ServerTLSConfig подготавливает сертификаты и возвращает настройки TLS для HTTP сервера.
+// Если в Dir есть custom.crt и custom.key, используются они, иначе сертификат выпускается
+// от CA устройства. Если есть client_ca.crt, включается проверка клиентских сертификатов.
+func ServerTLSConfig() (*tls.Config, error) {
+    err := Reload()
+    if err != nil {
+        return nil, err
+    }
+
+    config := &tls.Config{
+        MinVersion:     tls.VersionTLS12,
+        GetCertificate: getCertificate,
+    }
+
+    clientCAs, err := loadClientCAs()
+    if err != nil {
+        return nil, err
+    }
+    if clientCAs != nil {
+        config.ClientCAs = clientCAs
+        config.ClientAuth = tls.VerifyClientCertIfGiven
+        if RequireClientCert {
+            config.ClientAuth = tls.RequireAndVerifyClientCert
+        }
+        log.Printf("TLS client certificate authentication enabled")
+    }
+
+    return config, nil
+}
+
+// Reload заново загружает сертификаты с диска; при необходимости выпускает CA и сертификат сервера
+func Reload() error {
+    mu.Lock()
+    defer mu.Unlock()
+
+    err := os.MkdirAll(Dir, 0700)
+    if err != nil {
+        return fmt.Errorf("failed to create TLS directory: %w", err)
+    }
+
+    ca, key, err := ensureCA()
+    if err != nil {
+        return err
+    }
+    caCert, caKey = ca, key
+
+    custom, err := loadCustom()
+    if err != nil {
+        return err
+    }
+    if custom != nil {
+        current, source = custom, SourceCustom
+        log.Printf("Using custom TLS certificate %s", filepath.Join(Dir, customCertFile))
+        return nil
+    }
+
+    cert, err := tls.LoadX509KeyPair(filepath.Join(Dir, serverCertFile), filepath.Join(Dir, serverKeyFile))
+    if err == nil && !needsReissue(&cert, nil) {
+        cert.Certificate = append(cert.Certificate, caCert.Raw)
+        current, source = &cert, SourceGenerated
+        return nil
+    }
+
+    issued, err := issueServerCert()
+    if err != nil {
+        return err
+    }
+    current, source = issued, SourceGenerated
+    return nil
+}
+
+// CACertificate возвращает CA устройства в формате PEM для закрепления (pinning) на клиентах
+func CACertificate() ([]byte, error) {
+    data, err := ioutil.ReadFile(filepath.Join(Dir, caCertFile))
+    if err != nil {
+        return nil, fmt.Errorf("failed to read device CA: %w", err)
+    }
+    return data, nil
+}
+
+// CurrentStatus возвращает сведения об используемом сертификате сервера
+func CurrentStatus() (*Status, error) {
+    mu.Lock()
+    defer mu.Unlock()
+
+    if current == nil || caCert == nil {
+        return nil, fmt.Errorf("TLS is not initialized")
+    }
+
+    leaf, err := x509.ParseCertificate(current.Certificate[0])
+    if err != nil {
+        return nil, fmt.Errorf("failed to parse server certificate: %w", err)
+    }
+
+    status := &Status{
+        Source:            source,
+        Subject:           leaf.Subject.String(),
+        DNSNames:          leaf.DNSNames,
+        NotBefore:         leaf.NotBefore,
+        NotAfter:          leaf.NotAfter,
+        Fingerprint:       fingerprint(leaf.Raw),
+        CAFingerprint:     fingerprint(caCert.Raw),
+        RequireClientCert: RequireClientCert,
+    }
+    for _, ip := range leaf.IPAddresses {
+        status.IPAddresses = append(status.IPAddresses, ip.String())
+    }
+    _, err = os.Stat(filepath.Join(Dir, clientCAFile))
+    status.ClientAuth = err == nil
+
+    return status, nil
+}
+
+// getCertificate отдает текущий сертификат и перевыпускает сгенерированный,
+// если он скоро истекает или клиент подключился по адресу, которого нет в сертификате
+func getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+    mu.Lock()
+    defer mu.Unlock()
+
+    if source != SourceGenerated || time.Since(lastReissue) < reissueInterval {
+        return current, nil
+    }
+
+    var localIP net.IP
+    if hello.Conn != nil {
+        if addr, ok := hello.Conn.LocalAddr().(*net.TCPAddr); ok {
+            localIP = addr.IP
+        }
+    }
+    if !needsReissue(current, localIP) {
+        return current, nil
+    }
+
+    issued, err := issueServerCert()
+    if err != nil {
+        log.Printf("Failed to reissue TLS certificate: %v", err)
+        return current, nil
+    }
+    current = issued
+    return current, nil
+}
+
+// needsReissue проверяет срок действия сертификата и наличие в нем адреса подключения
+func needsReissue(cert *tls.Certificate, localIP net.IP) bool {
+    leaf, err := x509.ParseCertificate(cert.Certificate[0])
+    if err != nil {
+        return true
+    }
+    if time.Until(leaf.NotAfter) < RenewBefore {
+        return true
+    }
+    if caCert != nil && leaf.CheckSignatureFrom(caCert) != nil {
+        return true
+    }
+    if localIP == nil || localIP.IsUnspecified() {
+        return false
+    }
+    for _, ip := range leaf.IPAddresses {
+        if ip.Equal(localIP) {
+            return false
+        }
+    }
+    return true
+}
+
+// ensureCA загружает CA устройства или создает его при первом запуске
+func ensureCA() (*x509.Certificate, *ecdsa.PrivateKey, error) {
+    certPath := filepath.Join(Dir, caCertFile)
+    keyPath := filepath.Join(Dir, caKeyFile)
+
+    pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+    if err == nil {
+        cert, err := x509.ParseCertificate(pair.Certificate[0])
+        if err != nil {
+            return nil, nil, fmt.Errorf("failed to parse device CA: %w", err)
+        }
+        key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+        if !ok {
+            return nil, nil, fmt.Errorf("device CA key is not an ECDSA key")
+        }
+        return cert, key, nil
+    }
+    if !os.IsNotExist(err) {
+        return nil, nil, fmt.Errorf("failed to load device CA: %w", err)
+    }
+
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        return nil, nil, fmt.Errorf("failed to generate device CA key: %w", err)
+    }
+
+    serial, err := randomSerial()
+    if err != nil {
+        return nil, nil, err
+    }
+
+    now := time.Now()
+    template := &x509.Certificate{
+        SerialNumber:          serial,
+        Subject:               pkix.Name{CommonName: "servis device CA " + deviceID(), Organization: []string{"servis"}},
+        NotBefore:             now.Add(-time.Hour),
+        NotAfter:              now.Add(CAValidity),
+        KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+        BasicConstraintsValid: true,
+        IsCA:                  true,
+        MaxPathLenZero:        true,
+    }
+
+    der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+    if err != nil {
+        return nil, nil, fmt.Errorf("failed to create device CA: %w", err)
+    }
+
+    err = writeKeyPair(certPath, keyPath, der, key)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    cert, err := x509.ParseCertificate(der)
+    if err != nil {
+        return nil, nil, fmt.Errorf("failed to parse device CA: %w", err)
+    }
+    log.Printf("Generated device CA %s", fingerprint(der))
+    return cert, key, nil
+}
+
+// issueServerCert выпускает сертификат сервера от CA устройства для имени хоста и всех текущих адресов
+func issueServerCert() (*tls.Certificate, error) {
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        return nil, fmt.Errorf("failed to generate server key: %w", err)
+    }
+
+    serial, err := randomSerial()
+    if err != nil {
+        return nil, err
+    }
+
+    hostname, err := os.Hostname()
+    if err != nil || hostname == "" {
+        hostname = "servis"
+    }
+
+    now := time.Now()
+    template := &x509.Certificate{
+        SerialNumber: serial,
+        Subject:      pkix.Name{CommonName: hostname, Organization: []string{"servis"}, SerialNumber: deviceID()},
+        NotBefore:    now.Add(-time.Hour),
+        NotAfter:     now.Add(ServerValidity),
+        KeyUsage:     x509.KeyUsageDigitalSignature,
+        ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+        DNSNames:     []string{hostname, hostname + ".local", "localhost"},
+        IPAddresses:  localAddresses(),
+    }
+
+    der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create server certificate: %w", err)
+    }
+
+    err = writeKeyPair(filepath.Join(Dir, serverCertFile), filepath.Join(Dir, serverKeyFile), der, key)
+    if err != nil {
+        return nil, err
+    }
+
+    cert, err := tls.LoadX509KeyPair(filepath.Join(Dir, serverCertFile), filepath.Join(Dir, serverKeyFile))
+    if err != nil {
+        return nil, fmt.Errorf("failed to load server certificate: %w", err)
+    }
+    // Клиенту отдается вся цепочка, чтобы было достаточно закрепить CA устройства
+    cert.Certificate = append(cert.Certificate, caCert.Raw)
+
+    lastReissue = time.Now()
+    log.Printf("Issued TLS certificate for %s, %v", strings.Join(template.DNSNames, ", "), template.IPAddresses)
+    return &cert, nil
+}
+
+// loadCustom загружает сертификат, предоставленный пользователем, если он есть
+func loadCustom() (*tls.Certificate, error) {
+    certPath := filepath.Join(Dir, customCertFile)
+    keyPath := filepath.Join(Dir, customKeyFile)
+
+    if _, err := os.Stat(certPath); os.IsNotExist(err) {
+        return nil, nil
+    }
+
+    cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load custom certificate: %w", err)
+    }
+    return &cert, nil
+}
+
+// loadClientCAs загружает CA для проверки клиентских сертификатов, если он задан
+func loadClientCAs() (*x509.CertPool, error) {
+    data, err := ioutil.ReadFile(filepath.Join(Dir, clientCAFile))
+    if os.IsNotExist(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read client CA: %w", err)
+    }
+
+    pool := x509.NewCertPool()
+    if !pool.AppendCertsFromPEM(data) {
+        return nil, fmt.Errorf("no certificates found in %s", clientCAFile)
+    }
+    return pool, nil
+}
+
+// writeKeyPair сохраняет сертификат и закрытый ключ в формате PEM
+func writeKeyPair(certPath, keyPath string, der []byte, key *ecdsa.PrivateKey) error {
+    keyDER, err := x509.MarshalECPrivateKey(key)
+    if err != nil {
+        return fmt.Errorf("failed to marshal private key: %w", err)
+    }
+
+    err = ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+    if err != nil {
+        return fmt.Errorf("failed to write private key: %w", err)
+    }
+
+    err = ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+    if err != nil {
+        return fmt.Errorf("failed to write certificate: %w", err)
+    }
+    return nil
+}
+
+// localAddresses возвращает адреса всех сетевых интерфейсов устройства
+func localAddresses() []net.IP {
+    ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+
+    addrs, err := net.InterfaceAddrs()
+    if err != nil {
+        log.Printf("Failed to get interface addresses: %v", err)
+        return ips
+    }
+
+    for _, addr := range addrs {
+        ipNet, ok := addr.(*net.IPNet)
+        if !ok || ipNet.IP.IsLoopback() {
+            continue
+        }
+        ips = append(ips, ipNet.IP)
+    }
+    return ips
+}
+
+// deviceID возвращает серийный номер Raspberry Pi или, если его нет, machine-id
+func deviceID() string {
+    file, err := os.Open("/proc/cpuinfo")
+    if err == nil {
+        defer file.Close()
+        scanner := bufio.NewScanner(file)
+        for scanner.Scan() {
+            key, value, ok := strings.Cut(scanner.Text(), ":")
+            if ok && strings.TrimSpace(key) == "Serial" {
+                return strings.TrimSpace(value)
+            }
+        }
+    }
+
+    data, err := ioutil.ReadFile("/etc/machine-id")
+    if err == nil {
+        return strings.TrimSpace(string(data))
+    }
+    return "unknown"
+}
+
+// randomSerial генерирует серийный номер сертификата
+func randomSerial() (*big.Int, error) {
+    serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+    if err != nil {
+        return nil, fmt.Errorf("failed to generate serial number: %w", err)
+    }
+    return serial, nil
+}
+
+// fingerprint возвращает SHA-256 сертификата в hex
+func fingerprint(der []byte) string {
+    sum := sha256.Sum256(der)
+    return hex.EncodeToString(sum[:])
+}
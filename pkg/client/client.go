@@ -0,0 +1,189 @@
+// Package client — клиент API servis для интеграционных инструментов.
+// Методы и типы в client_gen.go генерируются по описанию OpenAPI командой go generate.
+package client
+
+//go:generate go run gen.go
+
+import (
+    "bytes"
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Client выполняет запросы к API одного устройства
+type Client struct {
+    BaseURL    string // например, https://192.168.1.10:4444
+    Token      string // API-токен; пустой, если клиент аутентифицируется сертификатом
+    HTTPClient *http.Client
+}
+
+// Error — ответ API с ошибкой. Code — стабильный код ошибки (например, update_in_progress),
+// Details — детали в формате, описанном для кода (например, BusyDetails).
+type Error struct {
+    StatusCode int
+    Code       string
+    Message    string
+    Details    json.RawMessage
+    RetryAfter time.Duration // из заголовка Retry-After ответов rate_limited и auth_locked_out; 0 — не передан
+}
+
+func (e *Error) Error() string {
+    return fmt.Sprintf("servis API returned %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// IsStatus сообщает, что err — ответ API с указанным HTTP-кодом
+func IsStatus(err error, status int) bool {
+    var apiErr *Error
+    return errors.As(err, &apiErr) && apiErr.StatusCode == status
+}
+
+// IsCode сообщает, что err — ответ API с указанным кодом ошибки
+func IsCode(err error, code string) bool {
+    var apiErr *Error
+    return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// New создает клиент. Если httpClient равен nil, используется http.DefaultClient.
+func New(baseURL, token string, httpClient *http.Client) *Client {
+    if httpClient == nil {
+        httpClient = http.DefaultClient
+    }
+    return &Client{BaseURL: strings.TrimSuffix(baseURL, "/"), Token: token, HTTPClient: httpClient}
+}
+
+// HTTPClientWithCA возвращает HTTP-клиент, который доверяет корневому сертификату устройства
+// (GET /tls/ca.crt); certificates — необязательные клиентские сертификаты для mTLS.
+func HTTPClientWithCA(caPEM []byte, certificates ...tls.Certificate) (*http.Client, error) {
+    pool := x509.NewCertPool()
+    if !pool.AppendCertsFromPEM(caPEM) {
+        return nil, errors.New("no certificates found in CA PEM")
+    }
+
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.TLSClientConfig = &tls.Config{
+        RootCAs:      pool,
+        Certificates: certificates,
+        MinVersion:   tls.VersionTLS12,
+    }
+    return &http.Client{Transport: transport}, nil
+}
+
+// HTTPClientUnix возвращает HTTP-клиент, который подключается к локальному API через Unix-сокет path
+// (control_socket). Адрес в BaseURL при этом не используется, кроме схемы: например, http://servis.
+func HTTPClientUnix(path string) *http.Client {
+    transport := http.DefaultTransport.(*http.Transport).Clone()
+    transport.Proxy = nil
+    transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+        var dialer net.Dialer
+        return dialer.DialContext(ctx, "unix", path)
+    }
+    return &http.Client{Transport: transport}
+}
+
+// RollbackLatest откатывает последнее обновление целиком (POST /api/v1/firmware/rollback без тела, роль operator).
+// В этом случае сервер отвечает сообщением, а не списком компонентов, как RollbackFirmware с destinations.
+func (c *Client) RollbackLatest(ctx context.Context) (MessageResponse, error) {
+    var result MessageResponse
+    err := c.doJSON(ctx, "POST", "/api/v1/firmware/rollback", nil, nil, &result)
+    return result, err
+}
+
+// do выполняет запрос и возвращает ответ с кодом 2xx; остальные ответы превращаются в *Error
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+    target := c.BaseURL + path
+    if len(query) > 0 {
+        target += "?" + query.Encode()
+    }
+
+    var reader io.Reader
+    if body != nil {
+        data, err := json.Marshal(body)
+        if err != nil {
+            return nil, fmt.Errorf("failed to marshal request: %w", err)
+        }
+        reader = bytes.NewReader(data)
+    }
+
+    req, err := http.NewRequestWithContext(ctx, method, target, reader)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create request: %w", err)
+    }
+    if body != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+    if c.Token != "" {
+        req.Header.Set("Authorization", "Bearer "+c.Token)
+    }
+
+    resp, err := c.HTTPClient.Do(req)
+    if err != nil {
+        return nil, fmt.Errorf("failed to send request: %w", err)
+    }
+
+    if resp.StatusCode < 200 || resp.StatusCode > 299 {
+        defer resp.Body.Close()
+        data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+        apiErr := &Error{StatusCode: resp.StatusCode}
+        if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+            apiErr.RetryAfter = time.Duration(seconds) * time.Second
+        }
+        var body ErrorResponse
+        if json.Unmarshal(data, &body) != nil || body.Error.Code == "" {
+            // Ответ не от servis (например, от прокси)
+            apiErr.Message = strings.TrimSpace(string(data))
+            return nil, apiErr
+        }
+        apiErr.Code, apiErr.Message, apiErr.Details = body.Error.Code, body.Error.Message, body.Error.Details
+        return nil, apiErr
+    }
+    return resp, nil
+}
+
+// doJSON выполняет запрос и декодирует ответ в out
+func (c *Client) doJSON(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+    resp, err := c.do(ctx, method, path, query, body)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+        return fmt.Errorf("failed to decode response: %w", err)
+    }
+    return nil
+}
+
+// doText выполняет запрос и возвращает текст ответа
+func (c *Client) doText(ctx context.Context, method, path string, query url.Values, body interface{}) (string, error) {
+    resp, err := c.do(ctx, method, path, query, body)
+    if err != nil {
+        return "", err
+    }
+    defer resp.Body.Close()
+
+    data, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return "", fmt.Errorf("failed to read response: %w", err)
+    }
+    return string(data), nil
+}
+
+// doStream выполняет запрос и возвращает тело ответа; закрыть его должен вызывающий
+func (c *Client) doStream(ctx context.Context, method, path string, query url.Values, body interface{}) (io.ReadCloser, error) {
+    resp, err := c.do(ctx, method, path, query, body)
+    if err != nil {
+        return nil, err
+    }
+    return resp.Body, nil
+}
@@ -0,0 +1,620 @@
+// Code generated by gen.go from the servis OpenAPI document. DO NOT EDIT.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/url"
+	"time"
+)
+
+type APIError struct {
+	Code    string          `json:"code"`
+	Details json.RawMessage `json:"details,omitempty"`
+	Message string          `json:"message"`
+}
+
+type AuditEntry struct {
+	Action    string          `json:"action"`
+	Alg       string          `json:"alg,omitempty"`
+	Client    string          `json:"client,omitempty"`
+	ErrorCode string          `json:"error_code,omitempty"`
+	Hash      string          `json:"hash"`
+	Method    string          `json:"method,omitempty"`
+	Outcome   string          `json:"outcome"`
+	Path      string          `json:"path,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	PrevHash  string          `json:"prev_hash"`
+	Principal string          `json:"principal"`
+	Role      string          `json:"role,omitempty"`
+	Seq       int64           `json:"seq"`
+	Status    int64           `json:"status,omitempty"`
+	Time      time.Time       `json:"time"`
+	TokenID   string          `json:"token_id,omitempty"`
+}
+
+type AuditHead struct {
+	Hash string `json:"hash"`
+	Seq  int64  `json:"seq"`
+}
+
+type AuditVerifyResult struct {
+	BrokenSeq int64      `json:"broken_seq,omitempty"`
+	Entries   int64      `json:"entries"`
+	FirstSeq  int64      `json:"first_seq,omitempty"`
+	Head      *AuditHead `json:"head,omitempty"`
+	Problem   string     `json:"problem,omitempty"`
+	Valid     bool       `json:"valid"`
+}
+
+type AuthIdentity struct {
+	Name    string `json:"name"`
+	Role    string `json:"role"`
+	TokenID string `json:"token_id"`
+}
+
+type AuthToken struct {
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Hash      string     `json:"hash,omitempty"`
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Role      string     `json:"role"`
+	Username  string     `json:"username,omitempty"`
+}
+
+type AuthUser struct {
+	CreatedAt    time.Time `json:"created_at"`
+	PasswordHash string    `json:"password_hash,omitempty"`
+	Role         string    `json:"role"`
+	Username     string    `json:"username"`
+}
+
+type BusyDetails struct {
+	Job UpdateJob `json:"job"`
+}
+
+type CertsStatus struct {
+	CAFingerprintSHA256 string    `json:"ca_fingerprint_sha256"`
+	ClientAuth          bool      `json:"client_auth"`
+	DNSNames            []string  `json:"dns_names"`
+	FingerprintSHA256   string    `json:"fingerprint_sha256"`
+	IPAddresses         []string  `json:"ip_addresses"`
+	NotAfter            time.Time `json:"not_after"`
+	NotBefore           time.Time `json:"not_before"`
+	RequireClientCert   bool      `json:"require_client_cert"`
+	Source              string    `json:"source"`
+	Subject             string    `json:"subject"`
+}
+
+type CreateTokenRequest struct {
+	ExpiresIn string `json:"expires_in,omitempty"`
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+}
+
+type CreateTokenResponse struct {
+	Info  *AuthToken `json:"info"`
+	Token string     `json:"token"`
+}
+
+type CreateUserRequest struct {
+	Password string `json:"password"`
+	Role     string `json:"role"`
+	Username string `json:"username"`
+}
+
+type DependencyDetails struct {
+	Dependents map[string][]string `json:"dependents"`
+}
+
+type DeviceKeyResponse struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"key_id"`
+	PublicKey string `json:"public_key"`
+}
+
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+type EthernetSettings struct {
+	Address string   `json:"address"`
+	DNS     []string `json:"dns"`
+	Gateway string   `json:"gateway"`
+	Netmask string   `json:"netmask"`
+}
+
+type EthernetStatus struct {
+	Address   string   `json:"address,omitempty"`
+	DNS       []string `json:"dns,omitempty"`
+	Gateway   string   `json:"gateway,omitempty"`
+	Interface string   `json:"interface"`
+	LinkUp    bool     `json:"link_up"`
+	Netmask   string   `json:"netmask,omitempty"`
+}
+
+type Event struct {
+	Data  json.RawMessage `json:"data,omitempty"`
+	ID    int64           `json:"id"`
+	Time  time.Time       `json:"time"`
+	Topic string          `json:"topic"`
+	Type  string          `json:"type"`
+}
+
+type ExportBackupRequest struct {
+	MountPoint string `json:"mount_point"`
+}
+
+type ExportBackupResponse struct {
+	Path string `json:"path"`
+}
+
+type FileInfo struct {
+	FileVersion string `json:"file_version"`
+	Source      string `json:"source"`
+}
+
+type JobResponse struct {
+	Interrupted bool       `json:"interrupted"`
+	Job         *UpdateJob `json:"job"`
+}
+
+type LoginRequest struct {
+	Password string `json:"password"`
+	Username string `json:"username"`
+}
+
+type LoginResponse struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+	Role      string     `json:"role"`
+	Token     string     `json:"token"`
+}
+
+type ManifestDetails struct {
+	Problems []UpdateManifestProblem `json:"problems"`
+}
+
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+type Network struct {
+	Name    string `json:"name"`
+	Quality string `json:"quality,omitempty"`
+}
+
+type NetworkSelection struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+type PackageInfo struct {
+	Compatible  bool       `json:"compatible"`
+	Description string     `json:"description,omitempty"`
+	Files       []FileInfo `json:"files"`
+	Issues      []string   `json:"issues,omitempty"`
+	Name        string     `json:"name"`
+}
+
+type PowerRequest struct {
+	ConfirmToken string `json:"confirm_token,omitempty"`
+	Delay        string `json:"delay,omitempty"`
+}
+
+type PowerResponse struct {
+	Confirmation *ShutdownConfirmation `json:"confirmation,omitempty"`
+	Pending      *ShutdownPending      `json:"pending,omitempty"`
+}
+
+type RetryDetails struct {
+	RetryAfter int64 `json:"retry_after"`
+}
+
+type RollbackRequest struct {
+	Destinations      []string `json:"destinations,omitempty"`
+	IncludeDependents bool     `json:"include_dependents,omitempty"`
+}
+
+type Schedule struct {
+	Action     string     `json:"action"`
+	At         *time.Time `json:"at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	CreatedBy  string     `json:"created_by"`
+	Cron       string     `json:"cron,omitempty"`
+	ID         string     `json:"id"`
+	LastError  string     `json:"last_error,omitempty"`
+	LastResult string     `json:"last_result,omitempty"`
+	LastRun    *time.Time `json:"last_run,omitempty"`
+	NextRun    *time.Time `json:"next_run,omitempty"`
+	RunAt      *time.Time `json:"run_at,omitempty"`
+}
+
+type ScheduleRequest struct {
+	Action string     `json:"action"`
+	At     *time.Time `json:"at,omitempty"`
+	Cron   string     `json:"cron,omitempty"`
+}
+
+type SchedulerWindowStatus struct {
+	ClosesAt   *time.Time `json:"closes_at,omitempty"`
+	Configured bool       `json:"configured"`
+	Duration   string     `json:"duration,omitempty"`
+	Open       bool       `json:"open"`
+	OpensAt    *time.Time `json:"opens_at,omitempty"`
+	Window     string     `json:"window,omitempty"`
+}
+
+type SelfUpdateRequest struct {
+	SelectedFile string `json:"selected_file"`
+}
+
+type SelfupdateStatus struct {
+	Binary     string    `json:"binary"`
+	ChildPid   int64     `json:"child_pid,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	State      string    `json:"state"`
+}
+
+type ShutdownConfirmation struct {
+	Action       string         `json:"action"`
+	ConfirmToken string         `json:"confirm_token"`
+	Delay        string         `json:"delay"`
+	ExpiresAt    time.Time      `json:"expires_at"`
+	Impact       ShutdownImpact `json:"impact"`
+}
+
+type ShutdownImpact struct {
+	MountedUSB []string   `json:"mounted_usb,omitempty"`
+	UpdateJob  *UpdateJob `json:"update_job,omitempty"`
+	Warnings   []string   `json:"warnings,omitempty"`
+}
+
+type ShutdownPending struct {
+	Action      string    `json:"action"`
+	ExecuteAt   time.Time `json:"execute_at"`
+	RequestedBy string    `json:"requested_by"`
+	SecondsLeft int64     `json:"seconds_left"`
+}
+
+type UpdateBackupEntry struct {
+	Destination string            `json:"destination"`
+	FileVersion string            `json:"file_version"`
+	IsDir       bool              `json:"is_dir"`
+	Name        string            `json:"name,omitempty"`
+	Requires    map[string]string `json:"requires,omitempty"`
+	Restored    bool              `json:"restored,omitempty"`
+}
+
+type UpdateBackupGeneration struct {
+	Compressed bool                `json:"compressed"`
+	CreatedAt  time.Time           `json:"created_at"`
+	Entries    []UpdateBackupEntry `json:"entries"`
+	ID         string              `json:"id"`
+	Size       int64               `json:"size"`
+	Source     string              `json:"source"`
+}
+
+type UpdateComponentRollback struct {
+	BackupID    string `json:"backup_id"`
+	Destination string `json:"destination"`
+	FromVersion string `json:"from_version"`
+	ToVersion   string `json:"to_version"`
+}
+
+type UpdateJob struct {
+	ID        string    `json:"id"`
+	Operation string    `json:"operation"`
+	Pid       int64     `json:"pid"`
+	Source    string    `json:"source,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+type UpdateManifestProblem struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type UpdatePlan struct {
+	Archive   string                `json:"archive"`
+	Changes   []UpdatePlannedChange `json:"changes"`
+	Encrypted bool                  `json:"encrypted"`
+	Issues    []string              `json:"issues,omitempty"`
+	Signature string                `json:"signature"`
+}
+
+type UpdatePlannedChange struct {
+	Action           string `json:"action"`
+	Destination      string `json:"destination"`
+	InstalledVersion string `json:"installed_version,omitempty"`
+	IsDir            bool   `json:"is_dir"`
+	Modified         bool   `json:"modified,omitempty"`
+	NewVersion       string `json:"new_version"`
+}
+
+type UpdateRequest struct {
+	Packages     []string `json:"packages,omitempty"`
+	SelectedFile string   `json:"selected_file"`
+}
+
+type WindowDetails struct {
+	OpensAt time.Time `json:"opens_at"`
+}
+
+type ZipFileInfo struct {
+	Compatible bool                    `json:"compatible"`
+	Encrypted  bool                    `json:"encrypted"`
+	Error      string                  `json:"error,omitempty"`
+	Files      []FileInfo              `json:"files"`
+	Issues     []string                `json:"issues,omitempty"`
+	Packages   []PackageInfo           `json:"packages,omitempty"`
+	Path       string                  `json:"path"`
+	Problems   []UpdateManifestProblem `json:"problems,omitempty"`
+	Signature  string                  `json:"signature,omitempty"`
+}
+
+// GetAuditLog — Журнал изменяющих запросов (GET /api/v1/audit, роль admin)
+func (c *Client) GetAuditLog(ctx context.Context, query url.Values) ([]AuditEntry, error) {
+	var result []AuditEntry
+	err := c.doJSON(ctx, "GET", "/api/v1/audit", query, nil, &result)
+	return result, err
+}
+
+// VerifyAuditLog — Проверить цепочку хешей журнала аудита (GET /api/v1/audit/verify, роль admin)
+func (c *Client) VerifyAuditLog(ctx context.Context, query url.Values) (AuditVerifyResult, error) {
+	var result AuditVerifyResult
+	err := c.doJSON(ctx, "GET", "/api/v1/audit/verify", query, nil, &result)
+	return result, err
+}
+
+// Login — Получить токен по логину и паролю (POST /api/v1/auth/login)
+func (c *Client) Login(ctx context.Context, body *LoginRequest) (LoginResponse, error) {
+	var result LoginResponse
+	err := c.doJSON(ctx, "POST", "/api/v1/auth/login", nil, body, &result)
+	return result, err
+}
+
+// GetCurrentIdentity — Текущий пользователь (GET /api/v1/auth/me, роль viewer)
+func (c *Client) GetCurrentIdentity(ctx context.Context) (AuthIdentity, error) {
+	var result AuthIdentity
+	err := c.doJSON(ctx, "GET", "/api/v1/auth/me", nil, nil, &result)
+	return result, err
+}
+
+// ListTokens — Список API-токенов (GET /api/v1/auth/tokens, роль admin)
+func (c *Client) ListTokens(ctx context.Context) ([]AuthToken, error) {
+	var result []AuthToken
+	err := c.doJSON(ctx, "GET", "/api/v1/auth/tokens", nil, nil, &result)
+	return result, err
+}
+
+// CreateToken — Создать API-токен (POST /api/v1/auth/tokens, роль admin)
+func (c *Client) CreateToken(ctx context.Context, body *CreateTokenRequest) (CreateTokenResponse, error) {
+	var result CreateTokenResponse
+	err := c.doJSON(ctx, "POST", "/api/v1/auth/tokens", nil, body, &result)
+	return result, err
+}
+
+// RevokeToken — Отозвать API-токен (DELETE /api/v1/auth/tokens/{id}, роль admin)
+func (c *Client) RevokeToken(ctx context.Context, id string) (MessageResponse, error) {
+	var result MessageResponse
+	err := c.doJSON(ctx, "DELETE", "/api/v1/auth/tokens/"+url.PathEscape(id), nil, nil, &result)
+	return result, err
+}
+
+// ListUsers — Список учетных записей (GET /api/v1/auth/users, роль admin)
+func (c *Client) ListUsers(ctx context.Context) ([]AuthUser, error) {
+	var result []AuthUser
+	err := c.doJSON(ctx, "GET", "/api/v1/auth/users", nil, nil, &result)
+	return result, err
+}
+
+// CreateUser — Создать учетную запись или изменить пароль и роль (POST /api/v1/auth/users, роль admin)
+func (c *Client) CreateUser(ctx context.Context, body *CreateUserRequest) (AuthUser, error) {
+	var result AuthUser
+	err := c.doJSON(ctx, "POST", "/api/v1/auth/users", nil, body, &result)
+	return result, err
+}
+
+// DeleteUser — Удалить учетную запись (DELETE /api/v1/auth/users/{username}, роль admin)
+func (c *Client) DeleteUser(ctx context.Context, username string) (MessageResponse, error) {
+	var result MessageResponse
+	err := c.doJSON(ctx, "DELETE", "/api/v1/auth/users/"+url.PathEscape(username), nil, nil, &result)
+	return result, err
+}
+
+// GetEthernet — Адрес и линк интерфейса Ethernet (GET /api/v1/ethernet, роль viewer)
+func (c *Client) GetEthernet(ctx context.Context) (EthernetStatus, error) {
+	var result EthernetStatus
+	err := c.doJSON(ctx, "GET", "/api/v1/ethernet", nil, nil, &result)
+	return result, err
+}
+
+// ConfigureEthernet — Задать статический адрес Ethernet (POST /api/v1/ethernet, роль operator)
+func (c *Client) ConfigureEthernet(ctx context.Context, body *EthernetSettings) (MessageResponse, error) {
+	var result MessageResponse
+	err := c.doJSON(ctx, "POST", "/api/v1/ethernet", nil, body, &result)
+	return result, err
+}
+
+// StreamEvents — Поток событий (Server-Sent Events) (GET /api/v1/events, роль viewer)
+func (c *Client) StreamEvents(ctx context.Context, query url.Values) (io.ReadCloser, error) {
+	return c.doStream(ctx, "GET", "/api/v1/events", query, nil)
+}
+
+// StreamEventsWebSocket (GET /api/v1/events/ws) использует WebSocket и не поддерживается клиентом
+
+// ListBackups — Поколения резервных копий (GET /api/v1/firmware/backups, роль viewer)
+func (c *Client) ListBackups(ctx context.Context) ([]UpdateBackupGeneration, error) {
+	var result []UpdateBackupGeneration
+	err := c.doJSON(ctx, "GET", "/api/v1/firmware/backups", nil, nil, &result)
+	return result, err
+}
+
+// DownloadBackup — Скачать поколение резервной копии в виде ZIP-архива (GET /api/v1/firmware/backups/{id}/archive, роль operator)
+func (c *Client) DownloadBackup(ctx context.Context, id string) (io.ReadCloser, error) {
+	return c.doStream(ctx, "GET", "/api/v1/firmware/backups/"+url.PathEscape(id)+"/archive", nil, nil)
+}
+
+// ExportBackup — Сохранить поколение резервной копии на USB-накопитель (POST /api/v1/firmware/backups/{id}/export, роль operator)
+func (c *Client) ExportBackup(ctx context.Context, id string, body *ExportBackupRequest) (ExportBackupResponse, error) {
+	var result ExportBackupResponse
+	err := c.doJSON(ctx, "POST", "/api/v1/firmware/backups/"+url.PathEscape(id)+"/export", nil, body, &result)
+	return result, err
+}
+
+// GetDeviceKey — Открытый ключ устройства для шифрования пакетов (GET /api/v1/firmware/device-key, роль viewer)
+func (c *Client) GetDeviceKey(ctx context.Context) (DeviceKeyResponse, error) {
+	var result DeviceKeyResponse
+	err := c.doJSON(ctx, "GET", "/api/v1/firmware/device-key", nil, nil, &result)
+	return result, err
+}
+
+// GetFirmwareJob — Выполняемая или прерванная операция обновления (GET /api/v1/firmware/job, роль viewer)
+func (c *Client) GetFirmwareJob(ctx context.Context) (JobResponse, error) {
+	var result JobResponse
+	err := c.doJSON(ctx, "GET", "/api/v1/firmware/job", nil, nil, &result)
+	return result, err
+}
+
+// ClearFirmwareLock — Сбросить запись о прерванной операции (DELETE /api/v1/firmware/lock, роль admin)
+func (c *Client) ClearFirmwareLock(ctx context.Context) (MessageResponse, error) {
+	var result MessageResponse
+	err := c.doJSON(ctx, "DELETE", "/api/v1/firmware/lock", nil, nil, &result)
+	return result, err
+}
+
+// PlanFirmwareUpdate — Проверить, какие компоненты изменит установка архива (POST /api/v1/firmware/plan, роль operator)
+func (c *Client) PlanFirmwareUpdate(ctx context.Context, body *UpdateRequest) (UpdatePlan, error) {
+	var result UpdatePlan
+	err := c.doJSON(ctx, "POST", "/api/v1/firmware/plan", nil, body, &result)
+	return result, err
+}
+
+// RollbackFirmware — Откатить последнее обновление или выбранные компоненты (POST /api/v1/firmware/rollback, роль operator)
+func (c *Client) RollbackFirmware(ctx context.Context, body *RollbackRequest) ([]UpdateComponentRollback, error) {
+	var result []UpdateComponentRollback
+	err := c.doJSON(ctx, "POST", "/api/v1/firmware/rollback", nil, body, &result)
+	return result, err
+}
+
+// GetSelfUpdateStatus — Результат последнего самообновления (GET /api/v1/firmware/self-update, роль viewer)
+func (c *Client) GetSelfUpdateStatus(ctx context.Context) (*SelfupdateStatus, error) {
+	var result *SelfupdateStatus
+	err := c.doJSON(ctx, "GET", "/api/v1/firmware/self-update", nil, nil, &result)
+	return result, err
+}
+
+// SelfUpdate — Обновить исполняемый файл servis (POST /api/v1/firmware/self-update, роль admin)
+func (c *Client) SelfUpdate(ctx context.Context, body *SelfUpdateRequest) (MessageResponse, error) {
+	var result MessageResponse
+	err := c.doJSON(ctx, "POST", "/api/v1/firmware/self-update", nil, body, &result)
+	return result, err
+}
+
+// UpdateFirmware — Установить прошивку из ZIP-архива (POST /api/v1/firmware/update, роль operator)
+func (c *Client) UpdateFirmware(ctx context.Context, body *UpdateRequest) (MessageResponse, error) {
+	var result MessageResponse
+	err := c.doJSON(ctx, "POST", "/api/v1/firmware/update", nil, body, &result)
+	return result, err
+}
+
+// GetMaintenanceWindow — Состояние окна обслуживания (GET /api/v1/maintenance/window, роль viewer)
+func (c *Client) GetMaintenanceWindow(ctx context.Context) (SchedulerWindowStatus, error) {
+	var result SchedulerWindowStatus
+	err := c.doJSON(ctx, "GET", "/api/v1/maintenance/window", nil, nil, &result)
+	return result, err
+}
+
+// GetNetworks — Список доступных сетей WiFi (GET /api/v1/networks/all, роль viewer)
+func (c *Client) GetNetworks(ctx context.Context) ([]Network, error) {
+	var result []Network
+	err := c.doJSON(ctx, "GET", "/api/v1/networks/all", nil, nil, &result)
+	return result, err
+}
+
+// ConnectNetwork — Подключиться к сети WiFi (POST /api/v1/networks/connect, роль operator)
+func (c *Client) ConnectNetwork(ctx context.Context, body *NetworkSelection) (MessageResponse, error) {
+	var result MessageResponse
+	err := c.doJSON(ctx, "POST", "/api/v1/networks/connect", nil, body, &result)
+	return result, err
+}
+
+// GetOpenAPI — Описание API в формате OpenAPI 3 (GET /api/v1/openapi.json)
+func (c *Client) GetOpenAPI(ctx context.Context) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := c.doJSON(ctx, "GET", "/api/v1/openapi.json", nil, nil, &result)
+	return result, err
+}
+
+// CancelPendingPower — Отменить запланированное выключение или перезагрузку (DELETE /api/v1/power/pending, роль operator)
+func (c *Client) CancelPendingPower(ctx context.Context) (PowerResponse, error) {
+	var result PowerResponse
+	err := c.doJSON(ctx, "DELETE", "/api/v1/power/pending", nil, nil, &result)
+	return result, err
+}
+
+// GetPendingPower — Запланированное выключение или перезагрузка (GET /api/v1/power/pending, роль viewer)
+func (c *Client) GetPendingPower(ctx context.Context) (PowerResponse, error) {
+	var result PowerResponse
+	err := c.doJSON(ctx, "GET", "/api/v1/power/pending", nil, nil, &result)
+	return result, err
+}
+
+// Reboot — Перезагрузить устройство: получить токен подтверждения, затем подтвердить (POST /api/v1/reboot, роль operator)
+func (c *Client) Reboot(ctx context.Context, body *PowerRequest) (PowerResponse, error) {
+	var result PowerResponse
+	err := c.doJSON(ctx, "POST", "/api/v1/reboot", nil, body, &result)
+	return result, err
+}
+
+// ListSchedules — Расписания выключения и перезагрузки (GET /api/v1/schedules, роль viewer)
+func (c *Client) ListSchedules(ctx context.Context) ([]Schedule, error) {
+	var result []Schedule
+	err := c.doJSON(ctx, "GET", "/api/v1/schedules", nil, nil, &result)
+	return result, err
+}
+
+// CreateSchedule — Запланировать выключение или перезагрузку (POST /api/v1/schedules, роль operator)
+func (c *Client) CreateSchedule(ctx context.Context, body *ScheduleRequest) (Schedule, error) {
+	var result Schedule
+	err := c.doJSON(ctx, "POST", "/api/v1/schedules", nil, body, &result)
+	return result, err
+}
+
+// DeleteSchedule — Удалить расписание (DELETE /api/v1/schedules/{id}, роль operator)
+func (c *Client) DeleteSchedule(ctx context.Context, id string) (MessageResponse, error) {
+	var result MessageResponse
+	err := c.doJSON(ctx, "DELETE", "/api/v1/schedules/"+url.PathEscape(id), nil, nil, &result)
+	return result, err
+}
+
+// Shutdown — Выключить устройство: получить токен подтверждения, затем подтвердить (POST /api/v1/shutdown, роль operator)
+func (c *Client) Shutdown(ctx context.Context, body *PowerRequest) (PowerResponse, error) {
+	var result PowerResponse
+	err := c.doJSON(ctx, "POST", "/api/v1/shutdown", nil, body, &result)
+	return result, err
+}
+
+// GetDeviceCA — CA устройства для закрепления сертификата (GET /api/v1/tls/ca.crt)
+func (c *Client) GetDeviceCA(ctx context.Context) (io.ReadCloser, error) {
+	return c.doStream(ctx, "GET", "/api/v1/tls/ca.crt", nil, nil)
+}
+
+// GetTLSInfo — Сведения о сертификате HTTPS (GET /api/v1/tls/info, роль viewer)
+func (c *Client) GetTLSInfo(ctx context.Context) (CertsStatus, error) {
+	var result CertsStatus
+	err := c.doJSON(ctx, "GET", "/api/v1/tls/info", nil, nil, &result)
+	return result, err
+}
+
+// ListUSBFiles — Пакеты прошивки на USB-накопителях (GET /api/v1/usb/files, роль viewer)
+func (c *Client) ListUSBFiles(ctx context.Context) ([]ZipFileInfo, error) {
+	var result []ZipFileInfo
+	err := c.doJSON(ctx, "GET", "/api/v1/usb/files", nil, nil, &result)
+	return result, err
+}
@@ -0,0 +1,294 @@
+//go:build ignore
+
+// gen.go генерирует client_gen.go по описанию OpenAPI из пакета api:
+// типы из components/schemas и по одному методу Client на каждую операцию.
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "go/format"
+    "log"
+    "os"
+    "regexp"
+    "sort"
+    "strings"
+    "servis/pkg/api"
+)
+
+type schema map[string]interface{}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+// serverPathPattern выделяет путь из адреса сервера (https://{host}:4444/api/v1 — /api/v1)
+var serverPathPattern = regexp.MustCompile(`^[a-z]+://[^/]+(/.*)?$`)
+
+// apiVersion — версия API, для которой генерируется клиент
+const apiVersion = "v1"
+
+// initialisms — части имен, которые в Go пишутся заглавными буквами
+var initialisms = map[string]string{"id": "ID", "ip": "IP", "url": "URL", "ca": "CA", "dns": "DNS", "sha256": "SHA256", "tls": "TLS", "usb": "USB", "ws": "WS"}
+
+// output — файл, в который записывается клиент; тест пакета api генерирует клиент во временный файл
+// и сравнивает его с client_gen.go
+var output = flag.String("o", "client_gen.go", "файл сгенерированного клиента")
+
+func main() {
+    flag.Parse()
+
+    data, err := api.OpenAPI(apiVersion)
+    if err != nil {
+        log.Fatalf("Failed to build OpenAPI document: %v", err)
+    }
+
+    var doc struct {
+        Servers []struct {
+            URL string `json:"url"`
+        } `json:"servers"`
+        Paths      map[string]map[string]schema `json:"paths"`
+        Components struct {
+            Schemas map[string]schema `json:"schemas"`
+        } `json:"components"`
+    }
+    if err := json.Unmarshal(data, &doc); err != nil {
+        log.Fatalf("Failed to parse OpenAPI document: %v", err)
+    }
+
+    // Пути операций указаны относительно адреса сервера, который включает префикс версии
+    var basePath string
+    if len(doc.Servers) > 0 {
+        if match := serverPathPattern.FindStringSubmatch(doc.Servers[0].URL); match != nil {
+            basePath = strings.TrimSuffix(match[1], "/")
+        }
+    }
+
+    var buf bytes.Buffer
+
+    var names []string
+    for name := range doc.Components.Schemas {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        writeType(&buf, name, doc.Components.Schemas[name])
+    }
+
+    var paths []string
+    for path := range doc.Paths {
+        paths = append(paths, path)
+    }
+    sort.Strings(paths)
+    for _, path := range paths {
+        var methods []string
+        for method := range doc.Paths[path] {
+            methods = append(methods, method)
+        }
+        sort.Strings(methods)
+        for _, method := range methods {
+            writeMethod(&buf, strings.ToUpper(method), basePath+path, doc.Paths[path][method])
+        }
+    }
+
+    // Импортируются только пакеты, которые используются в сгенерированном коде
+    var header bytes.Buffer
+    header.WriteString("// Code generated by gen.go from the servis OpenAPI document. DO NOT EDIT.\n\n")
+    header.WriteString("package client\n\nimport (\n")
+    for _, pkg := range []string{"context", "encoding/json", "io", "net/url", "time"} {
+        if bytes.Contains(buf.Bytes(), []byte(pkg[strings.LastIndex(pkg, "/")+1:]+".")) {
+            fmt.Fprintf(&header, "%q\n", pkg)
+        }
+    }
+    header.WriteString(")\n\n")
+    header.Write(buf.Bytes())
+
+    source, err := format.Source(header.Bytes())
+    if err != nil {
+        log.Fatalf("Failed to format generated code: %v\n%s", err, header.Bytes())
+    }
+    if err := os.WriteFile(*output, source, 0644); err != nil {
+        log.Fatalf("Failed to write %s: %v", *output, err)
+    }
+}
+
+// writeType описывает схему из components как тип Go
+func writeType(buf *bytes.Buffer, name string, s schema) {
+    if description, ok := s["description"].(string); ok {
+        fmt.Fprintf(buf, "// %s — %s\n", name, description)
+    }
+    if s["type"] != "object" || s["properties"] == nil {
+        fmt.Fprintf(buf, "type %s %s\n\n", name, goType(s))
+        return
+    }
+
+    required := make(map[string]bool)
+    if list, ok := s["required"].([]interface{}); ok {
+        for _, field := range list {
+            required[field.(string)] = true
+        }
+    }
+
+    properties := s["properties"].(map[string]interface{})
+    var fields []string
+    for field := range properties {
+        fields = append(fields, field)
+    }
+    sort.Strings(fields)
+
+    fmt.Fprintf(buf, "type %s struct {\n", name)
+    for _, field := range fields {
+        tag := field
+        if !required[field] {
+            tag += ",omitempty"
+        }
+        fmt.Fprintf(buf, "%s %s `json:\"%s\"`\n", goName(field), goType(schema(properties[field].(map[string]interface{}))), tag)
+    }
+    buf.WriteString("}\n\n")
+}
+
+// writeMethod описывает операцию как метод Client
+func writeMethod(buf *bytes.Buffer, method, path string, op schema) {
+    operationID := op["operationId"].(string)
+    name := goName(operationID)
+    responses := op["responses"].(map[string]interface{})
+
+    if _, ok := responses["101"]; ok {
+        fmt.Fprintf(buf, "// %s (%s %s) использует WebSocket и не поддерживается клиентом\n\n", name, method, path)
+        return
+    }
+
+    args := []string{"ctx context.Context"}
+    pathExpr := fmt.Sprintf("%q", path)
+    for _, match := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+        param := lowerFirst(goName(match[1]))
+        args = append(args, param+" string")
+        pathExpr = strings.Replace(pathExpr, match[0], `" + url.PathEscape(`+param+`) + "`, 1)
+    }
+    pathExpr = strings.TrimSuffix(pathExpr, ` + ""`)
+
+    query := "nil"
+    if parameters, ok := op["parameters"].([]interface{}); ok {
+        for _, parameter := range parameters {
+            if parameter.(map[string]interface{})["in"] == "query" {
+                args = append(args, "query url.Values")
+                query = "query"
+                break
+            }
+        }
+    }
+
+    body := "nil"
+    if requestBody, ok := op["requestBody"].(map[string]interface{}); ok {
+        content := requestBody["content"].(map[string]interface{})["application/json"].(map[string]interface{})
+        args = append(args, "body "+pointerTo(goType(schema(content["schema"].(map[string]interface{})))))
+        body = "body"
+    }
+
+    fmt.Fprintf(buf, "// %s — %s (%s %s", name, op["summary"], method, path)
+    if role, ok := op["x-required-role"].(string); ok {
+        fmt.Fprintf(buf, ", роль %s", role)
+    }
+    buf.WriteString(")\n")
+
+    signature := fmt.Sprintf("func (c *Client) %s(%s)", name, strings.Join(args, ", "))
+    call := fmt.Sprintf("ctx, %q, %s, %s, %s", method, pathExpr, query, body)
+
+    ok := responses["200"].(map[string]interface{})
+    content := ok["content"].(map[string]interface{})
+    switch {
+    case content["text/plain"] != nil:
+        fmt.Fprintf(buf, "%s (string, error) {\nreturn c.doText(%s)\n}\n\n", signature, call)
+    case content["application/json"] != nil:
+        resultType := goType(schema(content["application/json"].(map[string]interface{})["schema"].(map[string]interface{})))
+        if resultType == "map[string]interface{}" {
+            resultType = "json.RawMessage"
+        }
+        fmt.Fprintf(buf, "%s (%s, error) {\nvar result %s\nerr := c.doJSON(%s, &result)\nreturn result, err\n}\n\n", signature, resultType, resultType, call)
+    default:
+        fmt.Fprintf(buf, "%s (io.ReadCloser, error) {\nreturn c.doStream(%s)\n}\n\n", signature, call)
+    }
+}
+
+// goType возвращает тип Go для схемы
+func goType(s schema) string {
+    if ref, ok := s["$ref"].(string); ok {
+        return strings.TrimPrefix(ref, "#/components/schemas/")
+    }
+    if allOf, ok := s["allOf"].([]interface{}); ok && len(allOf) == 1 {
+        inner := goType(schema(allOf[0].(map[string]interface{})))
+        if s["nullable"] == true {
+            return "*" + inner
+        }
+        return inner
+    }
+    if s["oneOf"] != nil {
+        return "json.RawMessage"
+    }
+
+    var result string
+    switch s["type"] {
+    case "string":
+        switch s["format"] {
+        case "date-time":
+            result = "time.Time"
+        case "byte":
+            result = "[]byte"
+        default:
+            result = "string"
+        }
+    case "boolean":
+        result = "bool"
+    case "integer":
+        if s["format"] == "int32" {
+            result = "int32"
+        } else {
+            result = "int64"
+        }
+    case "number":
+        result = "float64"
+    case "array":
+        return "[]" + goType(schema(s["items"].(map[string]interface{})))
+    case "object":
+        if additional, ok := s["additionalProperties"].(map[string]interface{}); ok {
+            return "map[string]" + goType(schema(additional))
+        }
+        return "map[string]interface{}"
+    default:
+        // Произвольное значение (данные события, детали ошибки) разбирается вызывающим по его типу
+        return "json.RawMessage"
+    }
+
+    if s["nullable"] == true {
+        return "*" + result
+    }
+    return result
+}
+
+// pointerTo возвращает указатель на именованный тип тела запроса
+func pointerTo(t string) string {
+    if strings.HasPrefix(t, "*") || strings.HasPrefix(t, "[]") || strings.HasPrefix(t, "map[") {
+        return t
+    }
+    return "*" + t
+}
+
+// goName переводит snake_case и camelCase в экспортируемое имя Go
+func goName(name string) string {
+    var result strings.Builder
+    for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == '-' }) {
+        if initialism, ok := initialisms[strings.ToLower(part)]; ok {
+            result.WriteString(initialism)
+            continue
+        }
+        result.WriteString(strings.ToUpper(part[:1]) + part[1:])
+    }
+    return result.String()
+}
+
+func lowerFirst(name string) string {
+    if upper := strings.ToUpper(name); upper == name {
+        return strings.ToLower(name)
+    }
+    return strings.ToLower(name[:1]) + name[1:]
+}
@@ -0,0 +1,674 @@
+// Package config — настройки servis: значения по умолчанию, файл конфигурации, переменные окружения
+// и флаги командной строки (в порядке возрастания приоритета). По SIGHUP файл перечитывается,
+// а подсистемы получают новые значения через Get и OnChange без перезапуска процесса.
+package config
+
+import (
+    "bytes"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "net"
+    "net/url"
+    "os"
+    "os/signal"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "syscall"
+    "time"
+    "servis/pkg/audit"
+    "servis/pkg/cron"
+    "servis/pkg/events"
+)
+
+// Path — файл конфигурации; задается флагом -config или переменной SERVIS_CONFIG. Файл необязателен.
+var Path = "/root/dt_backend/servis.json"
+
+// envPrefix — префикс переменных окружения: флаг -wifi-interface соответствует SERVIS_WIFI_INTERFACE
+const envPrefix = "SERVIS_"
+
+// Config — настройки servis
+type Config struct {
+    Listen             string            `json:"listen"`               // адрес HTTPS сервера
+    HTTPRedirectListen string            `json:"http_redirect_listen"` // адрес HTTP сервера, перенаправляющего на HTTPS; пусто — отключен
+    GRPCListen         string            `json:"grpc_listen"`          // адрес сервера gRPC; пусто — gRPC отключен
+    ControlSocket      string            `json:"control_socket"`       // Unix-сокет локального API для servis ctl; пусто — отключен
+    ControlSocketGroup string            `json:"control_socket_group"` // группа, которой открыт доступ к сокету; пусто — только root
+    CORSOrigins        StringList        `json:"cors_origins"`         // источники веб-приложений, которым браузер разрешит запросы к API
+    ShutdownTimeout    Duration          `json:"shutdown_timeout"`     // время на завершение запросов и операций обновления при остановке
+    Network            NetworkConfig     `json:"network"`
+    Update             UpdateConfig      `json:"update"`
+    RTC                RTCConfig         `json:"rtc"`
+    Health             HealthConfig      `json:"health"`
+    Maintenance        MaintenanceConfig `json:"maintenance"`
+    MQTT               MQTTConfig        `json:"mqtt"`
+    RateLimit          RateLimitConfig   `json:"rate_limit"`
+}
+
+// NetworkConfig — сетевые интерфейсы и их конфигурационные файлы
+type NetworkConfig struct {
+    WifiInterface     string   `json:"wifi_interface"`
+    EthernetInterface string   `json:"ethernet_interface"`
+    WpaSupplicantConf string   `json:"wpa_supplicant_conf"`
+    InterfacesFile    string   `json:"interfaces_file"`
+    MonitorInterval   Duration `json:"monitor_interval"` // период проверки состояния WiFi и Ethernet
+}
+
+// UpdateConfig — файлы обновления прошивки
+type UpdateConfig struct {
+    VersionFile string `json:"version_file"` // installed_versions.json
+    BackupDir   string `json:"backup_dir"`   // каталог поколений резервных копий
+}
+
+// RTCConfig — настройки модуля реального времени
+type RTCConfig struct {
+    BootConfig string `json:"boot_config"` // config.txt, в котором включается I2C
+}
+
+// HealthConfig — пороги проверок готовности (/readyz)
+type HealthConfig struct {
+    MinFreeSpaceMB int `json:"min_free_space_mb"` // минимум свободного места на разделе с резервными копиями
+}
+
+// MaintenanceConfig — окно обслуживания, в котором выполняются действия по расписанию и автоматические обновления
+type MaintenanceConfig struct {
+    Window         string   `json:"window"`          // начало окна в формате cron, например "0 2 * * 6"; пусто — без ограничений
+    WindowDuration Duration `json:"window_duration"` // длительность окна
+}
+
+// MQTTConfig — подключение к брокеру MQTT для телеметрии и удаленных команд
+type MQTTConfig struct {
+    Broker         string   `json:"broker"`       // адрес брокера: tcp://host:1883, ssl://host:8883, ws://, wss://; пусто — MQTT отключен
+    DeviceID       string   `json:"device_id"`    // имя устройства в дереве тем; пусто — имя хоста
+    TopicPrefix    string   `json:"topic_prefix"` // корень дерева тем: <topic_prefix>/<device_id>/...
+    Username       string   `json:"username"`
+    Password       string   `json:"password"`
+    CAFile         string   `json:"ca_file"`         // CA брокера для ssl:// и wss://; пусто — системные корневые сертификаты
+    StatusInterval Duration `json:"status_interval"` // период публикации состояния
+}
+
+// RateLimitConfig — ограничение частоты запросов ко всем интерфейсам (HTTP API, gRPC, MQTT) и блокировка
+// клиента после повторных неудачных попыток входа
+type RateLimitConfig struct {
+    Client       Rate        `json:"client"`        // все запросы одного клиента
+    Global       Rate        `json:"global"`        // все запросы всех клиентов вместе
+    Routes       RouteLimits `json:"routes"`        // лимиты отдельных действий по operationId, в дополнение к общим
+    AuthFailures int         `json:"auth_failures"` // неудачных попыток входа за lockout, после которых клиент блокируется; 0 — не блокировать
+    Lockout      Duration    `json:"lockout"`       // длительность блокировки
+}
+
+// Defaults возвращает настройки по умолчанию
+func Defaults() Config {
+    return Config{
+        Listen:          ":4444",
+        GRPCListen:      ":4445",
+        ControlSocket:   "/run/servis/servis.sock",
+        ShutdownTimeout: Duration(2 * time.Minute),
+        Network: NetworkConfig{
+            WifiInterface:     "wlan0",
+            EthernetInterface: "eth0",
+            WpaSupplicantConf: "/etc/wpa_supplicant/wpa_supplicant.conf",
+            InterfacesFile:    "/etc/network/interfaces",
+            MonitorInterval:   Duration(5 * time.Second),
+        },
+        Update: UpdateConfig{
+            VersionFile: "/root/dt_backend/installed_versions.json",
+            BackupDir:   "/root/dt_backend/UpdateBackup",
+        },
+        RTC: RTCConfig{
+            BootConfig: "/boot/config.txt",
+        },
+        Health: HealthConfig{
+            MinFreeSpaceMB: 200,
+        },
+        Maintenance: MaintenanceConfig{
+            WindowDuration: Duration(2 * time.Hour),
+        },
+        MQTT: MQTTConfig{
+            TopicPrefix:    "servis",
+            StatusInterval: Duration(time.Minute),
+        },
+        RateLimit: RateLimitConfig{
+            Client: Rate{Count: 600, Period: time.Minute},
+            Global: Rate{Count: 1200, Period: time.Minute},
+            Routes: RouteLimits{
+                // Вход выполняется без токена, поэтому общий лимит к нему не применяется (см. ratelimit.CheckGlobal)
+                "login": {Client: Rate{Count: 10, Period: time.Minute}},
+                // Каждое подключение перезапускает wpa_supplicant, а настройка Ethernet — интерфейс:
+                // частые вызовы оставляют устройство без сети
+                "connectNetwork":    {Client: Rate{Count: 3, Period: time.Minute}, Global: Rate{Count: 3, Period: time.Minute}},
+                "configureEthernet": {Client: Rate{Count: 3, Period: time.Minute}, Global: Rate{Count: 3, Period: time.Minute}},
+            },
+            AuthFailures: 5,
+            Lockout:      Duration(15 * time.Minute),
+        },
+    }
+}
+
+// Duration — длительность, которая в файле и флагах записывается строкой ("5s", "1m")
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+    return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+    var value string
+    if err := json.Unmarshal(data, &value); err != nil {
+        return fmt.Errorf("duration must be a string like \"5s\": %w", err)
+    }
+    return d.Set(value)
+}
+
+// Set разбирает длительность; вместе со String реализует flag.Value
+func (d *Duration) Set(value string) error {
+    parsed, err := time.ParseDuration(value)
+    if err != nil {
+        return err
+    }
+    *d = Duration(parsed)
+    return nil
+}
+
+func (d *Duration) String() string {
+    return time.Duration(*d).String()
+}
+
+// Rate — лимит вида "10/1m": не больше 10 запросов за минуту, допустимое число запросов восполняется равномерно.
+// Нулевое значение (пустая строка) — без ограничения.
+type Rate struct {
+    Count  int
+    Period time.Duration
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+    return json.Marshal(r.String())
+}
+
+func (r *Rate) UnmarshalJSON(data []byte) error {
+    var value string
+    if err := json.Unmarshal(data, &value); err != nil {
+        return fmt.Errorf("rate must be a string like \"10/1m\": %w", err)
+    }
+    return r.Set(value)
+}
+
+// Set разбирает лимит; вместе со String реализует flag.Value
+func (r *Rate) Set(value string) error {
+    if value == "" {
+        *r = Rate{}
+        return nil
+    }
+    count, period, ok := strings.Cut(value, "/")
+    if !ok {
+        return fmt.Errorf("rate %q must look like 10/1m", value)
+    }
+    parsedCount, err := strconv.Atoi(count)
+    if err != nil || parsedCount < 1 {
+        return fmt.Errorf("rate %q: count must be a positive number", value)
+    }
+    parsedPeriod, err := time.ParseDuration(period)
+    if err != nil || parsedPeriod <= 0 {
+        return fmt.Errorf("rate %q: period must be a positive duration", value)
+    }
+    *r = Rate{Count: parsedCount, Period: parsedPeriod}
+    return nil
+}
+
+func (r *Rate) String() string {
+    if r.Count == 0 {
+        return ""
+    }
+    return strconv.Itoa(r.Count) + "/" + r.Period.String()
+}
+
+// Unlimited сообщает, что лимит не задан
+func (r Rate) Unlimited() bool {
+    return r.Count == 0
+}
+
+// RouteLimit — лимиты одного действия: для каждого клиента и для всех клиентов вместе
+type RouteLimit struct {
+    Client Rate `json:"client"`
+    Global Rate `json:"global"`
+}
+
+// RouteLimits — лимиты действий по operationId. Во флаге и переменной окружения записываются
+// как "login=10/1m,connectNetwork=3/1m:3/1m" (лимит клиента, после двоеточия — общий)
+// и заменяют все лимиты действий; записи в файле заменяют лимиты по умолчанию только для указанных действий.
+type RouteLimits map[string]RouteLimit
+
+func (l *RouteLimits) Set(value string) error {
+    limits := make(RouteLimits)
+    for _, item := range strings.Split(value, ",") {
+        item = strings.TrimSpace(item)
+        if item == "" {
+            continue
+        }
+        action, rates, ok := strings.Cut(item, "=")
+        if !ok || action == "" {
+            return fmt.Errorf("route limit %q must look like action=10/1m:30/1m", item)
+        }
+        clientRate, globalRate, _ := strings.Cut(rates, ":")
+        var limit RouteLimit
+        if err := limit.Client.Set(clientRate); err != nil {
+            return fmt.Errorf("route limit %s: %w", action, err)
+        }
+        if err := limit.Global.Set(globalRate); err != nil {
+            return fmt.Errorf("route limit %s: %w", action, err)
+        }
+        limits[action] = limit
+    }
+    *l = limits
+    return nil
+}
+
+func (l *RouteLimits) String() string {
+    var items []string
+    for action, limit := range *l {
+        items = append(items, action+"="+limit.Client.String()+":"+limit.Global.String())
+    }
+    sort.Strings(items)
+    return strings.Join(items, ",")
+}
+
+// StringList — список строк; во флаге и переменной окружения записывается через запятую
+type StringList []string
+
+func (l *StringList) Set(value string) error {
+    var items StringList
+    for _, item := range strings.Split(value, ",") {
+        if item = strings.TrimSpace(item); item != "" {
+            items = append(items, item)
+        }
+    }
+    *l = items
+    return nil
+}
+
+func (l *StringList) String() string {
+    return strings.Join(*l, ",")
+}
+
+type stringValue string
+
+func (s *stringValue) Set(value string) error {
+    *s = stringValue(value)
+    return nil
+}
+
+func (s *stringValue) String() string {
+    return string(*s)
+}
+
+type intValue int
+
+func (i *intValue) Set(value string) error {
+    parsed, err := strconv.Atoi(value)
+    if err != nil {
+        return err
+    }
+    *i = intValue(parsed)
+    return nil
+}
+
+func (i *intValue) String() string {
+    return strconv.Itoa(int(*i))
+}
+
+// setting — настройка, которую можно задать переменной окружения и флагом
+type setting struct {
+    name  string
+    usage string
+    value func(c *Config) flag.Value
+}
+
+func (s setting) env() string {
+    return envPrefix + strings.ToUpper(strings.ReplaceAll(s.name, "-", "_"))
+}
+
+var settings = []setting{
+    {"listen", "адрес HTTPS сервера", func(c *Config) flag.Value { return (*stringValue)(&c.Listen) }},
+    {"http-redirect-listen", "адрес HTTP сервера, перенаправляющего на HTTPS (пусто — отключен)", func(c *Config) flag.Value { return (*stringValue)(&c.HTTPRedirectListen) }},
+    {"grpc-listen", "адрес сервера gRPC (пусто — отключен)", func(c *Config) flag.Value { return (*stringValue)(&c.GRPCListen) }},
+    {"control-socket", "Unix-сокет локального API (пусто — отключен)", func(c *Config) flag.Value { return (*stringValue)(&c.ControlSocket) }},
+    {"control-socket-group", "группа, которой открыт доступ к Unix-сокету", func(c *Config) flag.Value { return (*stringValue)(&c.ControlSocketGroup) }},
+    {"cors-origins", "источники (Origin), которым разрешены запросы к API из браузера, через запятую", func(c *Config) flag.Value { return &c.CORSOrigins }},
+    {"shutdown-timeout", "время на корректную остановку", func(c *Config) flag.Value { return &c.ShutdownTimeout }},
+    {"wifi-interface", "интерфейс WiFi", func(c *Config) flag.Value { return (*stringValue)(&c.Network.WifiInterface) }},
+    {"ethernet-interface", "интерфейс Ethernet", func(c *Config) flag.Value { return (*stringValue)(&c.Network.EthernetInterface) }},
+    {"wpa-supplicant-conf", "конфигурационный файл wpa_supplicant", func(c *Config) flag.Value { return (*stringValue)(&c.Network.WpaSupplicantConf) }},
+    {"interfaces-file", "конфигурационный файл сетевых интерфейсов", func(c *Config) flag.Value { return (*stringValue)(&c.Network.InterfacesFile) }},
+    {"monitor-interval", "период проверки состояния сети", func(c *Config) flag.Value { return &c.Network.MonitorInterval }},
+    {"version-file", "файл установленных версий компонентов", func(c *Config) flag.Value { return (*stringValue)(&c.Update.VersionFile) }},
+    {"backup-dir", "каталог резервных копий обновлений", func(c *Config) flag.Value { return (*stringValue)(&c.Update.BackupDir) }},
+    {"boot-config", "файл config.txt для включения I2C", func(c *Config) flag.Value { return (*stringValue)(&c.RTC.BootConfig) }},
+    {"min-free-space-mb", "минимум свободного места (МБ) для готовности", func(c *Config) flag.Value { return (*intValue)(&c.Health.MinFreeSpaceMB) }},
+    {"maintenance-window", "начало окна обслуживания в формате cron (пусто — без ограничений)", func(c *Config) flag.Value { return (*stringValue)(&c.Maintenance.Window) }},
+    {"maintenance-window-duration", "длительность окна обслуживания", func(c *Config) flag.Value { return &c.Maintenance.WindowDuration }},
+    {"mqtt-broker", "адрес брокера MQTT (пусто — отключен)", func(c *Config) flag.Value { return (*stringValue)(&c.MQTT.Broker) }},
+    {"mqtt-device-id", "имя устройства в темах MQTT (пусто — имя хоста)", func(c *Config) flag.Value { return (*stringValue)(&c.MQTT.DeviceID) }},
+    {"mqtt-topic-prefix", "корень дерева тем MQTT", func(c *Config) flag.Value { return (*stringValue)(&c.MQTT.TopicPrefix) }},
+    {"mqtt-username", "имя пользователя брокера MQTT", func(c *Config) flag.Value { return (*stringValue)(&c.MQTT.Username) }},
+    {"mqtt-password", "пароль брокера MQTT", func(c *Config) flag.Value { return (*stringValue)(&c.MQTT.Password) }},
+    {"mqtt-ca-file", "CA брокера MQTT", func(c *Config) flag.Value { return (*stringValue)(&c.MQTT.CAFile) }},
+    {"mqtt-status-interval", "период публикации состояния в MQTT", func(c *Config) flag.Value { return &c.MQTT.StatusInterval }},
+    {"rate-limit-client", "лимит запросов одного клиента, например 600/1m (пусто — без ограничения)", func(c *Config) flag.Value { return &c.RateLimit.Client }},
+    {"rate-limit-global", "лимит запросов всех клиентов вместе (пусто — без ограничения)", func(c *Config) flag.Value { return &c.RateLimit.Global }},
+    {"rate-limit-routes", "лимиты действий: operationId=клиент:общий через запятую", func(c *Config) flag.Value { return &c.RateLimit.Routes }},
+    {"rate-limit-auth-failures", "неудачных попыток входа до блокировки клиента (0 — не блокировать)", func(c *Config) flag.Value { return (*intValue)(&c.RateLimit.AuthFailures) }},
+    {"rate-limit-lockout", "длительность блокировки клиента после неудачных попыток входа", func(c *Config) flag.Value { return &c.RateLimit.Lockout }},
+}
+
+// mqttSchemes — схемы адреса брокера, которые поддерживает клиент MQTT
+var mqttSchemes = map[string]bool{"tcp": true, "mqtt": true, "ssl": true, "tls": true, "mqtts": true, "ws": true, "wss": true}
+
+var interfacePattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,15}$`)
+
+// Validate проверяет настройки и возвращает все найденные проблемы одной ошибкой
+func (c Config) Validate() error {
+    var problems []string
+
+    if err := checkListen(c.Listen); err != nil {
+        problems = append(problems, fmt.Sprintf("listen: %v", err))
+    }
+    if c.GRPCListen != "" {
+        if err := checkListen(c.GRPCListen); err != nil {
+            problems = append(problems, fmt.Sprintf("grpc_listen: %v", err))
+        } else if c.GRPCListen == c.Listen {
+            problems = append(problems, "grpc_listen: must differ from listen")
+        }
+    }
+
+    if c.HTTPRedirectListen != "" {
+        if err := checkListen(c.HTTPRedirectListen); err != nil {
+            problems = append(problems, fmt.Sprintf("http_redirect_listen: %v", err))
+        } else if c.HTTPRedirectListen == c.Listen || c.HTTPRedirectListen == c.GRPCListen {
+            problems = append(problems, "http_redirect_listen: must differ from listen and grpc_listen")
+        }
+    }
+
+    if c.ControlSocket != "" && !filepath.IsAbs(c.ControlSocket) {
+        problems = append(problems, fmt.Sprintf("control_socket: path %q must be absolute", c.ControlSocket))
+    }
+
+    for name, value := range map[string]string{
+        "network.wifi_interface":     c.Network.WifiInterface,
+        "network.ethernet_interface": c.Network.EthernetInterface,
+    } {
+        if !interfacePattern.MatchString(value) {
+            problems = append(problems, fmt.Sprintf("%s: invalid interface name %q", name, value))
+        }
+    }
+
+    for name, value := range map[string]string{
+        "network.wpa_supplicant_conf": c.Network.WpaSupplicantConf,
+        "network.interfaces_file":     c.Network.InterfacesFile,
+        "update.version_file":         c.Update.VersionFile,
+        "update.backup_dir":           c.Update.BackupDir,
+        "rtc.boot_config":             c.RTC.BootConfig,
+    } {
+        if !filepath.IsAbs(value) {
+            problems = append(problems, fmt.Sprintf("%s: path %q must be absolute", name, value))
+        }
+    }
+
+    for _, origin := range c.CORSOrigins {
+        if parsed, err := url.Parse(origin); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || parsed.Path != "" || parsed.RawQuery != "" {
+            problems = append(problems, fmt.Sprintf("cors_origins: %q must be an origin like https://host:port", origin))
+        }
+    }
+
+    if time.Duration(c.ShutdownTimeout) < time.Second {
+        problems = append(problems, "shutdown_timeout: must be at least 1s")
+    }
+    if time.Duration(c.Network.MonitorInterval) < time.Second {
+        problems = append(problems, "network.monitor_interval: must be at least 1s")
+    }
+
+    if c.Health.MinFreeSpaceMB < 0 {
+        problems = append(problems, "health.min_free_space_mb: must not be negative")
+    }
+
+    if c.Maintenance.Window != "" {
+        if _, err := cron.Parse(c.Maintenance.Window); err != nil {
+            problems = append(problems, fmt.Sprintf("maintenance.window: %v", err))
+        }
+    }
+    if time.Duration(c.Maintenance.WindowDuration) < time.Minute {
+        problems = append(problems, "maintenance.window_duration: must be at least 1m")
+    }
+
+    if c.MQTT.Broker != "" {
+        if broker, err := url.Parse(c.MQTT.Broker); err != nil || broker.Host == "" || !mqttSchemes[broker.Scheme] {
+            problems = append(problems, fmt.Sprintf("mqtt.broker: %q must be an address like tcp://host:1883", c.MQTT.Broker))
+        }
+    }
+    if c.MQTT.TopicPrefix == "" || strings.ContainsAny(c.MQTT.TopicPrefix, "+#") {
+        problems = append(problems, "mqtt.topic_prefix: must be non-empty and must not contain + or #")
+    }
+    if strings.ContainsAny(c.MQTT.DeviceID, "/+#") {
+        problems = append(problems, "mqtt.device_id: must not contain /, + or #")
+    }
+    if c.MQTT.CAFile != "" && !filepath.IsAbs(c.MQTT.CAFile) {
+        problems = append(problems, fmt.Sprintf("mqtt.ca_file: path %q must be absolute", c.MQTT.CAFile))
+    }
+    if time.Duration(c.MQTT.StatusInterval) < time.Second {
+        problems = append(problems, "mqtt.status_interval: must be at least 1s")
+    }
+
+    if c.RateLimit.AuthFailures < 0 {
+        problems = append(problems, "rate_limit.auth_failures: must not be negative")
+    }
+    if c.RateLimit.AuthFailures > 0 && time.Duration(c.RateLimit.Lockout) < time.Second {
+        problems = append(problems, "rate_limit.lockout: must be at least 1s")
+    }
+    for action := range c.RateLimit.Routes {
+        if action == "" {
+            problems = append(problems, "rate_limit.routes: action must not be empty")
+        }
+    }
+
+    if len(problems) > 0 {
+        sort.Strings(problems)
+        return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+    }
+    return nil
+}
+
+// checkListen проверяет адрес сервера вида [host]:port
+func checkListen(addr string) error {
+    host, port, err := net.SplitHostPort(addr)
+    if err != nil {
+        return err
+    }
+    if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+        return fmt.Errorf("invalid port %q", port)
+    }
+    if host != "" && net.ParseIP(host) == nil && host != "localhost" {
+        return fmt.Errorf("host %q is not an IP address", host)
+    }
+    return nil
+}
+
+var (
+    mu        sync.Mutex
+    current   = Defaults()
+    overrides = make(map[string]string) // значения флагов командной строки; сохраняются при перечитывании
+    listeners []listener
+    lastID    int
+)
+
+type listener struct {
+    id int
+    fn func(old, new Config)
+}
+
+// Init разбирает флаги командной строки и загружает конфигурацию
+func Init(args []string) error {
+    defaults := Defaults()
+    fs := flag.NewFlagSet("servis", flag.ContinueOnError)
+
+    path := Path
+    if value, ok := os.LookupEnv(envPrefix + "CONFIG"); ok {
+        path = value
+    }
+    fs.StringVar(&path, "config", path, "файл конфигурации")
+
+    for _, s := range settings {
+        fs.String(s.name, s.value(&defaults).String(), s.usage+" (переменная "+s.env()+")")
+    }
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+
+    mu.Lock()
+    Path = path
+    fs.Visit(func(f *flag.Flag) {
+        if f.Name != "config" {
+            overrides[f.Name] = f.Value.String()
+        }
+    })
+    mu.Unlock()
+
+    cfg, err := Load(path)
+    if err != nil {
+        return err
+    }
+
+    mu.Lock()
+    current = cfg
+    mu.Unlock()
+    return nil
+}
+
+// Load читает файл конфигурации и применяет переменные окружения и флаги командной строки
+func Load(path string) (Config, error) {
+    cfg := Defaults()
+
+    data, err := os.ReadFile(path)
+    switch {
+    case os.IsNotExist(err):
+        // Без файла используются значения по умолчанию
+    case err != nil:
+        return cfg, fmt.Errorf("failed to read config: %w", err)
+    default:
+        decoder := json.NewDecoder(bytes.NewReader(data))
+        // Опечатка в имени настройки не должна молча оставлять значение по умолчанию
+        decoder.DisallowUnknownFields()
+        if err := decoder.Decode(&cfg); err != nil {
+            return cfg, fmt.Errorf("failed to parse config %s: %w", path, err)
+        }
+    }
+
+    mu.Lock()
+    defer mu.Unlock()
+    for _, s := range settings {
+        if value, ok := os.LookupEnv(s.env()); ok {
+            if err := s.value(&cfg).Set(value); err != nil {
+                return cfg, fmt.Errorf("invalid %s: %w", s.env(), err)
+            }
+        }
+        if value, ok := overrides[s.name]; ok {
+            if err := s.value(&cfg).Set(value); err != nil {
+                return cfg, fmt.Errorf("invalid -%s: %w", s.name, err)
+            }
+        }
+    }
+
+    return cfg, cfg.Validate()
+}
+
+// Get возвращает текущие настройки
+func Get() Config {
+    mu.Lock()
+    defer mu.Unlock()
+    return current
+}
+
+// OnChange регистрирует функцию, которая вызывается после перечитывания конфигурации, если настройки изменились.
+// Возвращает функцию отмены регистрации для подсистем, которые перезапускаются.
+func OnChange(fn func(old, new Config)) (cancel func()) {
+    mu.Lock()
+    defer mu.Unlock()
+    lastID++
+    id := lastID
+    listeners = append(listeners, listener{id: id, fn: fn})
+
+    return func() {
+        mu.Lock()
+        defer mu.Unlock()
+        for i, l := range listeners {
+            if l.id == id {
+                listeners = append(listeners[:i], listeners[i+1:]...)
+                return
+            }
+        }
+    }
+}
+
+// Reload перечитывает конфигурацию. Если новая конфигурация некорректна, остаются прежние настройки.
+// Результат записывается в журнал аудита.
+func Reload() error {
+    mu.Lock()
+    path := Path
+    mu.Unlock()
+
+    cfg, err := Load(path)
+    if err != nil {
+        payload, _ := json.Marshal(map[string]interface{}{"path": path, "error": err.Error()})
+        audit.Log(audit.Entry{Principal: "system", Action: "config_reload", Payload: payload, Outcome: audit.OutcomeFailure})
+        return err
+    }
+
+    mu.Lock()
+    old := current
+    current = cfg
+    fns := append([]listener{}, listeners...)
+    mu.Unlock()
+
+    changed := Changed(old, cfg)
+    payload, _ := json.Marshal(map[string]interface{}{"path": path, "changed": changed})
+    audit.Log(audit.Entry{Principal: "system", Action: "config_reload", Payload: payload, Outcome: audit.OutcomeSuccess})
+    if len(changed) == 0 {
+        log.Printf("Configuration reloaded from %s, nothing changed", path)
+        return nil
+    }
+
+    log.Printf("Configuration reloaded from %s, changed: %s", path, strings.Join(changed, ", "))
+    for _, l := range fns {
+        l.fn(old, cfg)
+    }
+    events.Publish(events.TopicSystem, "config_reloaded", map[string]interface{}{"changed": changed})
+    return nil
+}
+
+// Changed возвращает имена настроек (как у флагов), значения которых различаются
+func Changed(old, new Config) []string {
+    var changed []string
+    for _, s := range settings {
+        if s.value(&old).String() != s.value(&new).String() {
+            changed = append(changed, s.name)
+        }
+    }
+    return changed
+}
+
+// WatchSignals перечитывает конфигурацию при получении SIGHUP
+func WatchSignals() {
+    signals := make(chan os.Signal, 1)
+    signal.Notify(signals, syscall.SIGHUP)
+
+    go func() {
+        for range signals {
+            if err := Reload(); err != nil {
+                log.Printf("Failed to reload configuration, keeping previous settings: %v", err)
+            }
+        }
+    }()
+}
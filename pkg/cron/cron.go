@@ -0,0 +1,170 @@
+// Package cron разбирает выражения cron из пяти полей (минута, час, день месяца, месяц, день недели)
+// и вычисляет по ним время следующего срабатывания. Поддерживаются *, списки через запятую,
+// диапазоны a-b и шаг /n; день недели 0 и 7 — воскресенье.
+package cron
+
+import (
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// ErrInvalid возвращается для некорректного выражения
+var ErrInvalid = errors.New("invalid cron expression")
+
+// field — допустимые значения поля в виде битовой маски
+type field uint64
+
+func (f field) has(value int) bool {
+    return f&(1<<uint(value)) != 0
+}
+
+// bounds — границы значений полей по порядку
+var bounds = [5]struct {
+    name     string
+    min, max int
+}{
+    {"minute", 0, 59},
+    {"hour", 0, 23},
+    {"day of month", 1, 31},
+    {"month", 1, 12},
+    {"day of week", 0, 7},
+}
+
+// Schedule — разобранное выражение
+type Schedule struct {
+    expr                          string
+    minute, hour, dom, month, dow field
+    domAny, dowAny                bool // поле задано как *
+}
+
+// Parse разбирает выражение
+func Parse(expr string) (*Schedule, error) {
+    parts := strings.Fields(expr)
+    if len(parts) != len(bounds) {
+        return nil, fmt.Errorf("%w %q: expected %d fields, got %d", ErrInvalid, expr, len(bounds), len(parts))
+    }
+
+    var fields [5]field
+    for i, part := range parts {
+        parsed, err := parseField(part, bounds[i].min, bounds[i].max)
+        if err != nil {
+            return nil, fmt.Errorf("%w %q: %s: %v", ErrInvalid, expr, bounds[i].name, err)
+        }
+        fields[i] = parsed
+    }
+
+    // 7 — другое обозначение воскресенья
+    dow := fields[4]
+    if dow.has(7) {
+        dow |= 1
+    }
+    return &Schedule{
+        expr:   strings.Join(parts, " "),
+        minute: fields[0],
+        hour:   fields[1],
+        dom:    fields[2],
+        month:  fields[3],
+        dow:    dow,
+        domAny: parts[2] == "*",
+        dowAny: parts[4] == "*",
+    }, nil
+}
+
+func parseField(text string, min, max int) (field, error) {
+    var result field
+    for _, item := range strings.Split(text, ",") {
+        rangeText, stepText, hasStep := strings.Cut(item, "/")
+        step := 1
+        if hasStep {
+            var err error
+            step, err = strconv.Atoi(stepText)
+            if err != nil || step < 1 {
+                return 0, fmt.Errorf("invalid step %q", stepText)
+            }
+        }
+
+        low, high := min, max
+        if rangeText != "*" {
+            lowText, highText, isRange := strings.Cut(rangeText, "-")
+            var err error
+            low, err = strconv.Atoi(lowText)
+            if err != nil {
+                return 0, fmt.Errorf("invalid value %q", lowText)
+            }
+            high = low
+            if isRange {
+                high, err = strconv.Atoi(highText)
+                if err != nil {
+                    return 0, fmt.Errorf("invalid value %q", highText)
+                }
+            } else if hasStep {
+                high = max
+            }
+        }
+        if low < min || high > max || low > high {
+            return 0, fmt.Errorf("%q is out of range %d-%d", rangeText, min, max)
+        }
+
+        for value := low; value <= high; value += step {
+            result |= 1 << uint(value)
+        }
+    }
+    return result, nil
+}
+
+// String возвращает выражение
+func (s *Schedule) String() string {
+    return s.expr
+}
+
+// dayMatches проверяет день месяца и день недели. Как в cron, если оба поля ограничены,
+// достаточно совпадения одного из них.
+func (s *Schedule) dayMatches(t time.Time) bool {
+    domMatch := s.dom.has(t.Day())
+    dowMatch := s.dow.has(int(t.Weekday()))
+    if s.domAny || s.dowAny {
+        return domMatch && dowMatch
+    }
+    return domMatch || dowMatch
+}
+
+// Next возвращает первое время срабатывания строго после t (с точностью до минуты, в часовом поясе t).
+// Если выражение не срабатывает в ближайшие 5 лет (например, 30 февраля), возвращается нулевое время.
+// При переходе на летнее время пропущенное местное время не наступает и срабатывание пропускается,
+// а повторяющийся при переходе на зимнее время час не вызывает повторного срабатывания.
+func (s *Schedule) Next(t time.Time) time.Time {
+    loc := t.Location()
+    after := wallClock(t)
+    t = t.Truncate(time.Minute).Add(time.Minute)
+    limit := t.Year() + 5
+
+    for t.Year() <= limit {
+        if !s.month.has(int(t.Month())) {
+            t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+            continue
+        }
+        if !s.dayMatches(t) {
+            t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+            continue
+        }
+        if !s.hour.has(t.Hour()) {
+            t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+            continue
+        }
+        if !s.minute.has(t.Minute()) || !wallClock(t).After(after) {
+            t = t.Add(time.Minute)
+            continue
+        }
+        return t
+    }
+    return time.Time{}
+}
+
+// wallClock возвращает местное время t без часового пояса, чтобы сравнивать показания часов
+// в повторяющемся при переходе на зимнее время часе
+func wallClock(t time.Time) time.Time {
+    return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
@@ -0,0 +1,155 @@
+package cron
+
+import (
+    "errors"
+    "testing"
+    "time"
+    _ "time/tzdata"
+)
+
+func TestParseRejectsInvalid(t *testing.T) {
+    tests := []string{
+        "",
+        "* * * *",
+        "* * * * * *",
+        "60 * * * *",
+        "* 24 * * *",
+        "* * 0 * *",
+        "* * 32 * *",
+        "* * * 0 *",
+        "* * * 13 *",
+        "* * * * 8",
+        "5-1 * * * *",
+        "*/0 * * * *",
+        "*/x * * * *",
+        "a * * * *",
+        "1-x * * * *",
+        "1,,2 * * * *",
+    }
+    for _, expr := range tests {
+        t.Run(expr, func(t *testing.T) {
+            if _, err := Parse(expr); !errors.Is(err, ErrInvalid) {
+                t.Fatalf("Parse(%q) error %v, want ErrInvalid", expr, err)
+            }
+        })
+    }
+}
+
+func TestParseNormalizesSpaces(t *testing.T) {
+    s, err := Parse("  0  2 * *\t6 ")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if s.String() != "0 2 * * 6" {
+        t.Fatalf("String() = %q", s.String())
+    }
+}
+
+func TestNext(t *testing.T) {
+    at := func(value string) time.Time {
+        parsed, err := time.Parse("2006-01-02 15:04:05", value)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return parsed
+    }
+
+    // 2026-10-18 — воскресенье
+    tests := []struct {
+        name string
+        expr string
+        from string
+        want string // пусто — не срабатывает
+    }{
+        {"every minute", "* * * * *", "2026-10-18 10:07:30", "2026-10-18 10:08:00"},
+        {"strictly after", "0 12 * * *", "2026-10-18 12:00:00", "2026-10-19 12:00:00"},
+        {"same day", "0 12 * * *", "2026-10-18 11:59:59", "2026-10-18 12:00:00"},
+        {"step", "*/15 * * * *", "2026-10-18 10:07:00", "2026-10-18 10:15:00"},
+        {"step wraps hour", "*/15 * * * *", "2026-10-18 10:45:00", "2026-10-18 11:00:00"},
+        {"step from value", "5/20 * * * *", "2026-10-18 10:26:00", "2026-10-18 10:45:00"},
+        {"range", "0 9-17 * * *", "2026-10-18 17:00:00", "2026-10-19 09:00:00"},
+        {"range with step", "0 9-17/4 * * *", "2026-10-18 10:00:00", "2026-10-18 13:00:00"},
+        {"list", "0 0 1,15 * *", "2026-10-02 00:00:00", "2026-10-15 00:00:00"},
+        {"list and range", "30 1,3-4 * * *", "2026-10-18 01:30:00", "2026-10-18 03:30:00"},
+        {"month", "0 0 1 3 *", "2026-10-18 00:00:00", "2027-03-01 00:00:00"},
+        {"year rollover", "0 0 1 1 *", "2026-12-31 23:59:00", "2027-01-01 00:00:00"},
+        {"day of month only", "0 0 20 * *", "2026-10-20 00:00:00", "2026-11-20 00:00:00"},
+        {"day of week only", "0 0 * * 5", "2026-10-18 00:00:00", "2026-10-23 00:00:00"},
+        {"day of month or week: month day first", "0 0 20 * 5", "2026-10-18 00:00:00", "2026-10-20 00:00:00"},
+        {"day of month or week: weekday first", "0 0 20 * 5", "2026-10-20 00:00:00", "2026-10-23 00:00:00"},
+        {"sunday as 0", "0 0 * * 0", "2026-10-18 00:00:00", "2026-10-25 00:00:00"},
+        {"sunday as 7", "0 0 * * 7", "2026-10-18 00:00:00", "2026-10-25 00:00:00"},
+        {"range up to 7", "0 0 * * 5-7", "2026-10-24 00:00:00", "2026-10-25 00:00:00"},
+        {"range up to 7 skips monday", "0 0 * * 5-7", "2026-10-25 00:00:00", "2026-10-30 00:00:00"},
+        {"leap day", "0 0 29 2 *", "2026-03-01 00:00:00", "2028-02-29 00:00:00"},
+        {"february 30 never occurs", "0 0 30 2 *", "2026-10-18 00:00:00", ""},
+        {"april 31 never occurs", "0 0 31 4 *", "2026-10-18 00:00:00", ""},
+        {"31st skips short months", "0 0 31 * *", "2026-10-31 00:00:00", "2026-12-31 00:00:00"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            s, err := Parse(test.expr)
+            if err != nil {
+                t.Fatal(err)
+            }
+            got := s.Next(at(test.from))
+            if test.want == "" {
+                if !got.IsZero() {
+                    t.Fatalf("Next = %s, want zero time", got)
+                }
+                return
+            }
+            if want := at(test.want); !got.Equal(want) {
+                t.Fatalf("Next = %s, want %s", got, want)
+            }
+        })
+    }
+}
+
+func TestNextDaylightSaving(t *testing.T) {
+    loc, err := time.LoadLocation("Europe/Berlin")
+    if err != nil {
+        t.Fatal(err)
+    }
+    at := func(value string) time.Time {
+        parsed, err := time.ParseInLocation("2006-01-02 15:04 MST", value, loc)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return parsed
+    }
+
+    // 29 марта 2026 часы переводятся с 02:00 на 03:00, 25 октября — с 03:00 на 02:00
+    tests := []struct {
+        name string
+        expr string
+        from string
+        want []string
+    }{
+        {"skipped local time does not fire", "30 2 * * *", "2026-03-28 03:00 CET",
+            []string{"2026-03-30 02:30 CEST"}},
+        {"hourly across spring gap", "0 * * * *", "2026-03-29 00:30 CET",
+            []string{"2026-03-29 01:00 CET", "2026-03-29 03:00 CEST", "2026-03-29 04:00 CEST"}},
+        {"repeated local time fires once", "30 2 * * *", "2026-10-25 02:10 CEST",
+            []string{"2026-10-25 02:30 CEST", "2026-10-26 02:30 CET"}},
+        {"hourly across repeated hour", "0 * * * *", "2026-10-25 00:30 CEST",
+            []string{"2026-10-25 01:00 CEST", "2026-10-25 02:00 CEST", "2026-10-25 03:00 CET"}},
+        {"every 15 minutes in repeated hour", "*/15 2 * * *", "2026-10-25 02:40 CEST",
+            []string{"2026-10-25 02:45 CEST", "2026-10-26 02:00 CET"}},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            s, err := Parse(test.expr)
+            if err != nil {
+                t.Fatal(err)
+            }
+            next := at(test.from)
+            for _, want := range test.want {
+                next = s.Next(next)
+                if !next.Equal(at(want)) {
+                    t.Fatalf("Next = %s, want %s", next, want)
+                }
+            }
+        })
+    }
+}
@@ -0,0 +1,332 @@
+package ctl
+
+import (
+    "flag"
+    "fmt"
+    "strings"
+    "time"
+    "servis/pkg/client"
+)
+
+func wifiScan(c *ctl, args []string) error {
+    if len(args) != 0 {
+        return errUsage
+    }
+
+    networks, err := c.client.GetNetworks(c.ctx)
+    if err != nil {
+        return err
+    }
+    if done, err := c.output(networks); done {
+        return err
+    }
+
+    var rows []string
+    for _, network := range networks {
+        rows = append(rows, network.Name+"\t"+orDash(network.Quality))
+    }
+    c.table("NAME\tQUALITY", rows)
+    return nil
+}
+
+func wifiConnect(c *ctl, args []string) error {
+    if len(args) < 1 || len(args) > 2 {
+        return errUsage
+    }
+
+    selection := client.NetworkSelection{Name: args[0]}
+    if len(args) == 2 {
+        selection.Password = args[1]
+    }
+    // Пароль в аргументах попадает в историю shell и список процессов, поэтому его можно передать через stdin
+    if selection.Password == "-" {
+        password, err := c.stdin.ReadString('\n')
+        if err != nil && password == "" {
+            return fmt.Errorf("failed to read password: %w", err)
+        }
+        selection.Password = strings.TrimRight(password, "\r\n")
+    }
+
+    response, err := c.client.ConnectNetwork(c.ctx, &selection)
+    if err != nil {
+        return err
+    }
+    return c.message(response)
+}
+
+func ethShow(c *ctl, args []string) error {
+    if len(args) != 0 {
+        return errUsage
+    }
+
+    status, err := c.client.GetEthernet(c.ctx)
+    if err != nil {
+        return err
+    }
+    if done, err := c.output(status); done {
+        return err
+    }
+
+    link := "down"
+    if status.LinkUp {
+        link = "up"
+    }
+    address := status.Address
+    if address != "" && status.Netmask != "" {
+        address += "/" + status.Netmask
+    }
+    c.table("INTERFACE\tLINK\tADDRESS\tGATEWAY\tDNS", []string{
+        status.Interface + "\t" + link + "\t" + orDash(address) + "\t" + orDash(status.Gateway) + "\t" + orDash(strings.Join(status.DNS, ",")),
+    })
+    return nil
+}
+
+func ethSet(c *ctl, args []string) error {
+    flags := flag.NewFlagSet("eth set", flag.ContinueOnError)
+    address := flags.String("address", "", "")
+    netmask := flags.String("netmask", "", "")
+    gateway := flags.String("gateway", "", "")
+    dns := flags.String("dns", "", "")
+    if err := parseFlags(flags, args); err != nil {
+        return err
+    }
+    if flags.NArg() != 0 || flags.NFlag() == 0 {
+        return errUsage
+    }
+
+    // Незаданные значения берутся из текущей конфигурации интерфейса
+    current, err := c.client.GetEthernet(c.ctx)
+    if err != nil && flags.NFlag() < 4 {
+        return err
+    }
+    settings := client.EthernetSettings{Address: current.Address, Netmask: current.Netmask, Gateway: current.Gateway, DNS: current.DNS}
+    flags.Visit(func(f *flag.Flag) {
+        switch f.Name {
+        case "address":
+            settings.Address = *address
+        case "netmask":
+            settings.Netmask = *netmask
+        case "gateway":
+            settings.Gateway = *gateway
+        case "dns":
+            settings.DNS = strings.Split(*dns, ",")
+        }
+    })
+
+    response, err := c.client.ConfigureEthernet(c.ctx, &settings)
+    if err != nil {
+        return err
+    }
+    return c.message(response)
+}
+
+func usbList(c *ctl, args []string) error {
+    if len(args) != 0 {
+        return errUsage
+    }
+
+    archives, err := c.client.ListUSBFiles(c.ctx)
+    if err != nil {
+        return err
+    }
+    if done, err := c.output(archives); done {
+        return err
+    }
+
+    var rows []string
+    for _, archive := range archives {
+        var packages []string
+        for _, pkg := range archive.Packages {
+            packages = append(packages, pkg.Name)
+        }
+        notes := archive.Error
+        if notes == "" {
+            notes = strings.Join(archive.Issues, "; ")
+        }
+        rows = append(rows, fmt.Sprintf("%s\t%s\t%s\t%t\t%s", archive.Path, orDash(strings.Join(packages, ",")), orDash(archive.Signature), archive.Compatible, orDash(notes)))
+    }
+    c.table("PATH\tPACKAGES\tSIGNATURE\tCOMPATIBLE\tNOTES", rows)
+    return nil
+}
+
+func fwPlan(c *ctl, args []string) error {
+    if len(args) < 1 {
+        return errUsage
+    }
+
+    plan, err := c.client.PlanFirmwareUpdate(c.ctx, &client.UpdateRequest{SelectedFile: args[0], Packages: args[1:]})
+    if err != nil {
+        return err
+    }
+    if done, err := c.output(plan); done {
+        return err
+    }
+    c.printPlan(plan)
+    return nil
+}
+
+// printPlan выводит изменения по компонентам и препятствия к установке
+func (c *ctl) printPlan(plan client.UpdatePlan) {
+    var rows []string
+    for _, change := range plan.Changes {
+        rows = append(rows, change.Destination+"\t"+orDash(change.InstalledVersion)+"\t"+change.NewVersion+"\t"+change.Action)
+    }
+    c.table("DESTINATION\tINSTALLED\tNEW\tACTION", rows)
+
+    if len(plan.Issues) > 0 {
+        fmt.Fprintln(c.stdout)
+        fmt.Fprintln(c.stdout, "Проблемы:")
+        for _, issue := range plan.Issues {
+            fmt.Fprintln(c.stdout, "  "+issue)
+        }
+    }
+}
+
+func fwUpdate(c *ctl, args []string) error {
+    flags := flag.NewFlagSet("fw update", flag.ContinueOnError)
+    yes := flags.Bool("yes", false, "")
+    if err := parseFlags(flags, args); err != nil {
+        return err
+    }
+    if flags.NArg() < 1 {
+        return errUsage
+    }
+    req := client.UpdateRequest{SelectedFile: flags.Arg(0), Packages: flags.Args()[1:]}
+
+    if !*yes {
+        plan, err := c.client.PlanFirmwareUpdate(c.ctx, &req)
+        if err != nil {
+            return err
+        }
+        c.printPlan(plan)
+        fmt.Fprintln(c.stdout)
+        if !c.confirm("Установить " + req.SelectedFile + "?") {
+            fmt.Fprintln(c.stdout, "Отменено")
+            return nil
+        }
+    }
+
+    fmt.Fprintln(c.stderr, "Обновление выполняется, не выключайте устройство...")
+    response, err := c.client.UpdateFirmware(c.ctx, &req)
+    if err != nil {
+        return err
+    }
+    return c.message(response)
+}
+
+func fwRollback(c *ctl, args []string) error {
+    flags := flag.NewFlagSet("fw rollback", flag.ContinueOnError)
+    yes := flags.Bool("yes", false, "")
+    includeDependents := flags.Bool("include-dependents", false, "")
+    if err := parseFlags(flags, args); err != nil {
+        return err
+    }
+    destinations := flags.Args()
+
+    if len(destinations) == 0 {
+        if !*yes && !c.confirm("Откатить последнее обновление целиком?") {
+            fmt.Fprintln(c.stdout, "Отменено")
+            return nil
+        }
+        response, err := c.client.RollbackLatest(c.ctx)
+        if err != nil {
+            return err
+        }
+        return c.message(response)
+    }
+
+    if !*yes && !c.confirm("Откатить "+strings.Join(destinations, ", ")+"?") {
+        fmt.Fprintln(c.stdout, "Отменено")
+        return nil
+    }
+    results, err := c.client.RollbackFirmware(c.ctx, &client.RollbackRequest{Destinations: destinations, IncludeDependents: *includeDependents})
+    if err != nil {
+        return err
+    }
+    if done, err := c.output(results); done {
+        return err
+    }
+
+    var rows []string
+    for _, result := range results {
+        rows = append(rows, result.Destination+"\t"+orDash(result.FromVersion)+"\t"+orDash(result.ToVersion)+"\t"+result.BackupID)
+    }
+    c.table("DESTINATION\tFROM\tTO\tBACKUP", rows)
+    return nil
+}
+
+func fwHistory(c *ctl, args []string) error {
+    if len(args) != 0 {
+        return errUsage
+    }
+
+    backups, err := c.client.ListBackups(c.ctx)
+    if err != nil {
+        return err
+    }
+    if done, err := c.output(backups); done {
+        return err
+    }
+
+    // В резервной копии записаны версии компонентов до обновления; прочерк — компонент был установлен впервые
+    var rows []string
+    for _, backup := range backups {
+        var replaced []string
+        for _, entry := range backup.Entries {
+            replaced = append(replaced, entry.Destination+"@"+orDash(entry.FileVersion))
+        }
+        rows = append(rows, backup.ID+"\t"+backup.CreatedAt.Local().Format(time.DateTime)+"\t"+orDash(backup.Source)+"\t"+orDash(strings.Join(replaced, ", ")))
+    }
+    c.table("ID\tCREATED\tSOURCE\tREPLACED", rows)
+    return nil
+}
+
+func powerReboot(c *ctl, args []string) error {
+    flags := flag.NewFlagSet("power reboot", flag.ContinueOnError)
+    yes := flags.Bool("yes", false, "")
+    delay := flags.String("delay", "", "")
+    if err := parseFlags(flags, args); err != nil {
+        return err
+    }
+    if flags.NArg() != 0 {
+        return errUsage
+    }
+
+    // Перезагрузка подтверждается в два шага: сервер описывает последствия и выдает токен подтверждения
+    prepared, err := c.client.Reboot(c.ctx, &client.PowerRequest{Delay: *delay})
+    if err != nil {
+        return err
+    }
+    confirmation := prepared.Confirmation
+    if confirmation == nil {
+        return fmt.Errorf("server returned no confirmation token")
+    }
+
+    if !*yes {
+        if job := confirmation.Impact.UpdateJob; job != nil {
+            fmt.Fprintf(c.stdout, "Выполняется операция %s %s\n", job.Operation, job.ID)
+        }
+        for _, mount := range confirmation.Impact.MountedUSB {
+            fmt.Fprintln(c.stdout, "Смонтирован USB-накопитель "+mount)
+        }
+        for _, warning := range confirmation.Impact.Warnings {
+            fmt.Fprintln(c.stdout, "Внимание: "+warning)
+        }
+        if !c.confirm("Перезагрузить устройство?") {
+            fmt.Fprintln(c.stdout, "Отменено")
+            return nil
+        }
+    }
+
+    scheduled, err := c.client.Reboot(c.ctx, &client.PowerRequest{ConfirmToken: confirmation.ConfirmToken})
+    if err != nil {
+        return err
+    }
+    if done, err := c.output(scheduled); done {
+        return err
+    }
+    if scheduled.Pending != nil {
+        fmt.Fprintf(c.stdout, "Перезагрузка запланирована на %s\n", scheduled.Pending.ExecuteAt.Local().Format(time.DateTime))
+    }
+    return nil
+}
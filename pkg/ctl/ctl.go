@@ -0,0 +1,203 @@
+// Package ctl — команды servis ctl для работы у консоли устройства. Команды обращаются к локальному API
+// через Unix-сокет (control_socket), поэтому токен не нужен: доступ определяется правами на файл сокета.
+package ctl
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+    "syscall"
+    "text/tabwriter"
+    "servis/pkg/client"
+    "servis/pkg/config"
+)
+
+// command — подкоманда servis ctl
+type command struct {
+    Group   string
+    Name    string
+    Args    string // аргументы для справки
+    Summary string
+    Run     func(c *ctl, args []string) error
+}
+
+var commands = []command{
+    {Group: "wifi", Name: "scan", Summary: "Список сетей WiFi", Run: wifiScan},
+    {Group: "wifi", Name: "connect", Args: "<ssid> [<пароль> | -]", Summary: "Подключиться к сети WiFi (- — прочитать пароль из stdin)", Run: wifiConnect},
+    {Group: "eth", Name: "show", Summary: "Адрес и линк интерфейса Ethernet", Run: ethShow},
+    {Group: "eth", Name: "set", Args: "[-address <ip>] [-netmask <префикс>] [-gateway <ip>] [-dns <ip,ip>]", Summary: "Задать статический адрес Ethernet (незаданные значения остаются прежними)", Run: ethSet},
+    {Group: "usb", Name: "list", Summary: "Пакеты прошивки на USB-накопителях", Run: usbList},
+    {Group: "fw", Name: "plan", Args: "<архив> [<пакет>...]", Summary: "Показать, какие компоненты изменит установка", Run: fwPlan},
+    {Group: "fw", Name: "update", Args: "[-yes] <архив> [<пакет>...]", Summary: "Установить прошивку из архива", Run: fwUpdate},
+    {Group: "fw", Name: "rollback", Args: "[-yes] [-include-dependents] [<компонент>...]", Summary: "Откатить последнее обновление или выбранные компоненты", Run: fwRollback},
+    {Group: "fw", Name: "history", Summary: "Обновления, сохраненные в резервных копиях", Run: fwHistory},
+    {Group: "power", Name: "reboot", Args: "[-yes] [-delay <время>]", Summary: "Перезагрузить устройство", Run: powerReboot},
+}
+
+// errUsage — команда вызвана с неверными аргументами; Run выводит ее синтаксис
+var errUsage = errors.New("invalid arguments")
+
+// ctl — состояние одного запуска servis ctl
+type ctl struct {
+    ctx    context.Context
+    client *client.Client
+    json   bool
+    stdin  *bufio.Reader
+    stdout io.Writer
+    stderr io.Writer
+}
+
+// Run выполняет servis ctl с аргументами после ctl и возвращает код завершения процесса
+func Run(args []string) int {
+    flags := flag.NewFlagSet("servis ctl", flag.ContinueOnError)
+    socket := flags.String("socket", defaultSocket(), "Unix-сокет локального API")
+    jsonOutput := flags.Bool("json", false, "выводить ответы API в формате JSON")
+    flags.Usage = func() { usage(flags) }
+    if err := flags.Parse(args); err != nil {
+        if err == flag.ErrHelp {
+            return 0
+        }
+        return 2
+    }
+
+    rest := flags.Args()
+    if len(rest) < 2 {
+        usage(flags)
+        return 2
+    }
+    cmd := findCommand(rest[0], rest[1])
+    if cmd == nil {
+        fmt.Fprintf(os.Stderr, "servis ctl: unknown command %s\n", strings.Join(rest[:2], " "))
+        usage(flags)
+        return 2
+    }
+
+    c := &ctl{
+        ctx:    context.Background(),
+        client: client.New("http://servis", "", client.HTTPClientUnix(*socket)),
+        json:   *jsonOutput,
+        stdin:  bufio.NewReader(os.Stdin),
+        stdout: os.Stdout,
+        stderr: os.Stderr,
+    }
+    err := cmd.Run(c, rest[2:])
+    if errors.Is(err, errUsage) {
+        fmt.Fprintf(os.Stderr, "usage: servis ctl %s %s %s\n", cmd.Group, cmd.Name, cmd.Args)
+        return 2
+    }
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "servis ctl: %s\n", describe(err, *socket))
+        return 1
+    }
+    return 0
+}
+
+// defaultSocket возвращает путь к сокету из SERVIS_CONTROL_SOCKET или путь по умолчанию
+func defaultSocket() string {
+    if path := os.Getenv("SERVIS_CONTROL_SOCKET"); path != "" {
+        return path
+    }
+    return config.Defaults().ControlSocket
+}
+
+func usage(flags *flag.FlagSet) {
+    out := flags.Output()
+    fmt.Fprintln(out, "usage: servis ctl [-socket <путь>] [-json] <группа> <команда> [аргументы]")
+    fmt.Fprintln(out)
+    w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+    for _, cmd := range commands {
+        fmt.Fprintf(w, "  %s %s %s\t%s\n", cmd.Group, cmd.Name, cmd.Args, cmd.Summary)
+    }
+    w.Flush()
+    fmt.Fprintln(out)
+    flags.PrintDefaults()
+}
+
+func findCommand(group, name string) *command {
+    for i := range commands {
+        if commands[i].Group == group && commands[i].Name == name {
+            return &commands[i]
+        }
+    }
+    return nil
+}
+
+// describe переводит ошибку в сообщение для техника: ошибки API — кодом и текстом, ошибки подключения — подсказкой
+func describe(err error, socket string) string {
+    var apiErr *client.Error
+    switch {
+    case errors.As(err, &apiErr) && apiErr.Code != "":
+        return fmt.Sprintf("%s: %s", apiErr.Code, apiErr.Message)
+    case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, os.ErrNotExist):
+        return fmt.Sprintf("servis is not listening on %s (is it running with control_socket enabled?)", socket)
+    case errors.Is(err, os.ErrPermission):
+        return fmt.Sprintf("no access to %s: run as root or as a member of control_socket_group", socket)
+    }
+    return err.Error()
+}
+
+// output выводит v в формате JSON, если задан флаг -json, и возвращает true; иначе вывод остается команде
+func (c *ctl) output(v interface{}) (bool, error) {
+    if !c.json {
+        return false, nil
+    }
+    data, err := json.MarshalIndent(v, "", "  ")
+    if err != nil {
+        return true, fmt.Errorf("failed to marshal response: %w", err)
+    }
+    fmt.Fprintln(c.stdout, string(data))
+    return true, nil
+}
+
+// message выводит ответ с сообщением
+func (c *ctl) message(response client.MessageResponse) error {
+    if done, err := c.output(response); done {
+        return err
+    }
+    fmt.Fprintln(c.stdout, response.Message)
+    return nil
+}
+
+// table выводит строки, разделенные табуляцией, выровненными колонками
+func (c *ctl) table(header string, rows []string) {
+    w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+    fmt.Fprintln(w, header)
+    for _, row := range rows {
+        fmt.Fprintln(w, row)
+    }
+    w.Flush()
+}
+
+// confirm спрашивает подтверждение; пустой ответ и конец ввода означают отказ
+func (c *ctl) confirm(question string) bool {
+    fmt.Fprintf(c.stderr, "%s [y/N]: ", question)
+    answer, _ := c.stdin.ReadString('\n')
+    switch strings.ToLower(strings.TrimSpace(answer)) {
+    case "y", "yes", "д", "да":
+        return true
+    }
+    return false
+}
+
+// parseFlags разбирает флаги подкоманды; неверные флаги означают errUsage
+func parseFlags(flags *flag.FlagSet, args []string) error {
+    flags.SetOutput(io.Discard)
+    if err := flags.Parse(args); err != nil {
+        return errUsage
+    }
+    return nil
+}
+
+// orDash заменяет пустое значение прочерком в таблицах
+func orDash(value string) string {
+    if value == "" {
+        return "-"
+    }
+    return value
+}
@@ -1,18 +1,45 @@
 package device
 
 import (
-    //"context"
+    "context"
     "fmt"
     "log"
     "os"
     "os/exec"
     "path/filepath"
     "strings"
+    "sync/atomic"
     "time"
+    "servis/pkg/events"
+    "servis/pkg/health"
+    "servis/pkg/metrics"
 
     "github.com/fsnotify/fsnotify"
+    "github.com/prometheus/client_golang/prometheus"
 )
 
+var usbEventsTotal = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+    Namespace: metrics.Namespace,
+    Subsystem: "usb",
+    Name:      "events_total",
+    Help:      "События USB-накопителей: inserted, removed, mounted, mount_failed.",
+}, []string{"event"})
+
+// watching — Run отслеживает /dev
+var watching atomic.Bool
+
+func init() {
+    health.Register(health.Check{Name: "device_watcher", Liveness: true, Run: checkWatcher})
+}
+
+// checkWatcher проверяет, что новые накопители обнаруживаются и монтируются
+func checkWatcher(ctx context.Context) (health.Details, error) {
+    if !watching.Load() {
+        return nil, fmt.Errorf("device watcher is not running")
+    }
+    return health.Details{"path": "/dev"}, nil
+}
+
 // CreateMediaDirectory создаёт директорию /media, если она отсутствует
 func CreateMediaDirectory() error {
     if _, err := os.Stat("/media"); os.IsNotExist(err) {
@@ -29,7 +56,8 @@ func cleanDeviceName(name string) string {
     return strings.TrimLeft(filepath.Base(name), "│─└├")
 }
 
-// mountDevice монтирует устройство
+// mountDevice монтирует устройство. Ошибка монтирования одного накопителя записывается в журнал
+// и не останавливает мониторинг.
 func mountDevice(device string) {
     deviceName := cleanDeviceName(device)
     mountPoint := fmt.Sprintf("/media/%s", deviceName)
@@ -37,14 +65,18 @@ func mountDevice(device string) {
     // Создаем точку монтирования, если она не существует
     err := os.MkdirAll(mountPoint, 0755)
     if err != nil {
-        log.Fatalf("Failed to create mount point %s: %v", mountPoint, err)
+        log.Printf("Failed to create mount point %s: %v", mountPoint, err)
+        usbEventsTotal.WithLabelValues("mount_failed").Inc()
+        return
     }
 
     // Определяем, есть ли разделы у устройства
     cmd := exec.Command("lsblk", "-no", "MOUNTPOINT", device)
     output, err := cmd.Output()
     if err != nil {
-        log.Fatalf("Failed to list partitions for device %s: %v", device, err)
+        log.Printf("Failed to list partitions for device %s: %v", device, err)
+        usbEventsTotal.WithLabelValues("mount_failed").Inc()
+        return
     }
 
     // Если устройство уже смонтировано, выходим
@@ -56,60 +88,86 @@ func mountDevice(device string) {
     // Если нет разделов, монтируем само устройство
     if err := exec.Command("mount", device, mountPoint).Run(); err == nil {
         log.Printf("Successfully mounted %s to %s", device, mountPoint)
+        publishMounted(device, mountPoint)
         return
     }
 
     // Если монтирование устройства не удалось, пробуем монтировать его разделы
     partDevice := fmt.Sprintf("%s1", device)
     if err := exec.Command("mount", partDevice, mountPoint).Run(); err != nil {
-        log.Fatalf("Failed to mount device or partition %s: %v", partDevice, err)
+        log.Printf("Failed to mount device or partition %s: %v", partDevice, err)
+        usbEventsTotal.WithLabelValues("mount_failed").Inc()
+        return
     }
     log.Printf("Successfully mounted %s to %s", partDevice, mountPoint)
+    publishMounted(partDevice, mountPoint)
+}
+
+// publishMounted сообщает о смонтированном накопителе
+func publishMounted(device, mountPoint string) {
+    usbEventsTotal.WithLabelValues("mounted").Inc()
+    events.Publish(events.TopicDevice, "usb_mounted", map[string]string{
+        "device":      device,
+        "mount_point": mountPoint,
+    })
+}
+
+// isStorageDevice проверяет, что путь в /dev относится к накопителю
+func isStorageDevice(name string) bool {
+    return strings.HasPrefix(name, "/dev/sd") || strings.HasPrefix(name, "/dev/nvme")
 }
 
-// Start запускает процесс мониторинга устройств
-func Start() {
+// Run отслеживает появление и удаление накопителей в /dev и монтирует новые, пока не отменен ctx
+func Run(ctx context.Context) error {
     err := CreateMediaDirectory()
     if err != nil {
-        log.Fatalf("Failed to create /media directory: %v", err)
+        return err
     }
 
     watcher, err := fsnotify.NewWatcher()
     if err != nil {
-        log.Fatalf("Failed to create watcher: %v", err)
+        return fmt.Errorf("failed to create watcher: %w", err)
     }
     defer watcher.Close()
 
-    done := make(chan bool)
-    go func() {
-        for {
-            select {
-            case event, ok := <-watcher.Events:
-                if !ok {
-                    return
-                }
-                if event.Op&fsnotify.Create == fsnotify.Create {
-                    if strings.HasPrefix(event.Name, "/dev/sd") || strings.HasPrefix(event.Name, "/dev/nvme") {
-                        log.Printf("Detected new device: %s", event.Name)
-                        // Небольшая задержка для корректной инициализации устройства
-                        time.Sleep(1 * time.Second)
-                        mountDevice(event.Name)
-                    }
-                }
-            case err, ok := <-watcher.Errors:
-                if !ok {
-                    return
-                }
-                log.Printf("Watcher error: %v", err)
-            }
-        }
-    }()
-
     err = watcher.Add("/dev")
     if err != nil {
-        log.Fatalf("Failed to add /dev to watcher: %v", err)
+        return fmt.Errorf("failed to add /dev to watcher: %w", err)
     }
 
+    watching.Store(true)
+    defer watching.Store(false)
+
     log.Println("Device monitoring started...")
-    <-done
+    for {
+        select {
+        case <-ctx.Done():
+            log.Println("Device monitoring stopped")
+            return nil
+        case event, ok := <-watcher.Events:
+            if !ok {
+                return fmt.Errorf("watcher closed unexpectedly")
+            }
+            if event.Op&fsnotify.Create == fsnotify.Create {
+                if isStorageDevice(event.Name) {
+                    log.Printf("Detected new device: %s", event.Name)
+                    events.Publish(events.TopicDevice, "usb_inserted", map[string]string{"device": event.Name})
+                    usbEventsTotal.WithLabelValues("inserted").Inc()
+                    // Небольшая задержка для корректной инициализации устройства
+                    time.Sleep(1 * time.Second)
+                    mountDevice(event.Name)
+                }
+            }
+            if event.Op&fsnotify.Remove == fsnotify.Remove && isStorageDevice(event.Name) {
+                log.Printf("Device removed: %s", event.Name)
+                events.Publish(events.TopicDevice, "usb_removed", map[string]string{"device": event.Name})
+                usbEventsTotal.WithLabelValues("removed").Inc()
+            }
+        case err, ok := <-watcher.Errors:
+            if !ok {
+                return fmt.Errorf("watcher closed unexpectedly")
+            }
+            log.Printf("Watcher error: %v", err)
+        }
+    }
 }
@@ -2,12 +2,73 @@ package ethernet
 
 import (
     "bytes"
+    "context"
+    "encoding/binary"
+    "encoding/hex"
+    "errors"
     "fmt"
     "io/ioutil"
+    "net"
     "os/exec"
     "strings"
+    "time"
+    "servis/pkg/config"
+    "servis/pkg/events"
+    "servis/pkg/health"
+    "servis/pkg/metrics"
+    "github.com/prometheus/client_golang/prometheus"
 )
 
+// Ошибки настройки Ethernet; возвращаются обернутыми через %w
+var (
+    ErrUnavailable  = errors.New("ethernet interface is unavailable")
+    ErrConfigFailed = errors.New("failed to update ethernet configuration")
+)
+
+var linkUpGauge = metrics.Factory.NewGaugeVec(prometheus.GaugeOpts{
+    Namespace: metrics.Namespace,
+    Subsystem: "ethernet",
+    Name:      "link_up",
+    Help:      "1, если на интерфейсе Ethernet есть линк.",
+}, []string{"interface"})
+
+func init() {
+    health.Register(health.Check{Name: "network", Run: checkDefaultRoute})
+}
+
+// DefaultRoute возвращает интерфейс и шлюз маршрута по умолчанию из /proc/net/route
+func DefaultRoute() (string, net.IP, error) {
+    content, err := ioutil.ReadFile("/proc/net/route")
+    if err != nil {
+        return "", nil, fmt.Errorf("failed to read routing table: %w", err)
+    }
+
+    // Строка маршрута: "eth0 00000000 0101A8C0 0003 ..." — интерфейс, назначение и шлюз в hex (little-endian)
+    for _, line := range strings.Split(string(content), "\n") {
+        fields := strings.Fields(line)
+        if len(fields) < 3 || fields[1] != "00000000" {
+            continue
+        }
+        raw, err := hex.DecodeString(fields[2])
+        if err != nil || len(raw) != 4 {
+            return "", nil, fmt.Errorf("invalid gateway %q in routing table", fields[2])
+        }
+        gateway := make(net.IP, 4)
+        binary.BigEndian.PutUint32(gateway, binary.LittleEndian.Uint32(raw))
+        return fields[0], gateway, nil
+    }
+    return "", nil, fmt.Errorf("no default route")
+}
+
+// checkDefaultRoute проверяет, что у устройства есть маршрут по умолчанию через Ethernet или WiFi
+func checkDefaultRoute(ctx context.Context) (health.Details, error) {
+    iface, gateway, err := DefaultRoute()
+    if err != nil {
+        return nil, err
+    }
+    return health.Details{"interface": iface, "gateway": gateway.String()}, nil
+}
+
 // RunCommand выполняет команду в shell
 func RunCommand(name string, args ...string) (string, error) {
     cmd := exec.Command(name, args...)
@@ -71,8 +132,13 @@ func GetEthernetInfo(interfaceName string) (string, string, string, string, erro
     return ipAddr, netmask, gateway, strings.Join(dnsServers, " "), nil
 }
 
-// UpdateEthernetConfig обновляет конфигурационный файл Ethernet и WiFi
+// UpdateEthernetConfig обновляет конфигурационный файл Ethernet и WiFi.
+// Имена интерфейсов и файл wpa_supplicant берутся из конфигурации.
 func UpdateEthernetConfig(filePath, ipAddr, netmask, gateway, dns string) error {
+    network := config.Get().Network
+    ethernetInterface := network.EthernetInterface
+    wifiInterface := network.WifiInterface
+
     content, err := ioutil.ReadFile(filePath)
     if err != nil {
         return err
@@ -87,10 +153,10 @@ func UpdateEthernetConfig(filePath, ipAddr, netmask, gateway, dns string) error
 
     for _, line := range lines {
         trimmedLine := strings.TrimSpace(line)
-        if strings.HasPrefix(trimmedLine, "iface eth0 inet static") {
+        if strings.HasPrefix(trimmedLine, "iface "+ethernetInterface+" inet static") {
             insideEthernetBlock = true
             ethernetBlockExists = true
-        } else if strings.HasPrefix(trimmedLine, "iface wlan0 inet dhcp") {
+        } else if strings.HasPrefix(trimmedLine, "iface "+wifiInterface+" inet dhcp") {
             insideWifiBlock = true
             wifiBlockExists = true
         }
@@ -141,25 +207,25 @@ func UpdateEthernetConfig(filePath, ipAddr, netmask, gateway, dns string) error
     if !ethernetBlockExists {
         newEthernetBlock := fmt.Sprintf(`
 # Ethernet
-allow-hotplug eth0
-iface eth0 inet static
-    address %s
-    netmask %s
-    gateway %s
-    dns-nameservers %s
+allow-hotplug %[1]s
+iface %[1]s inet static
+    address %[2]s
+    netmask %[3]s
+    gateway %[4]s
+    dns-nameservers %[5]s
     metric 100
-`, ipAddr, netmask, gateway, dns)
+`, ethernetInterface, ipAddr, netmask, gateway, dns)
         newLines = append(newLines, newEthernetBlock)
     }
 
     if !wifiBlockExists {
-        newWifiBlock := `
+        newWifiBlock := fmt.Sprintf(`
 # WiFi
-allow-hotplug wlan0
-iface wlan0 inet dhcp
+allow-hotplug %[1]s
+iface %[1]s inet dhcp
     metric 200
-wpa-conf /etc/wpa_supplicant/wpa_supplicant.conf
-`
+wpa-conf %[2]s
+`, wifiInterface, network.WpaSupplicantConf)
         newLines = append(newLines, newWifiBlock)
     }
 
@@ -172,18 +238,96 @@ wpa-conf /etc/wpa_supplicant/wpa_supplicant.conf
 
 // ConfigureEthernet выполняет настройку Ethernet и WiFi
 func ConfigureEthernet() error {
-    ipAddr, netmask, gateway, dns, err := GetEthernetInfo("eth0")
+    network := config.Get().Network
+    ipAddr, netmask, gateway, dns, err := GetEthernetInfo(network.EthernetInterface)
     if err != nil {
-        return fmt.Errorf("error getting ethernet info: %w", err)
+        return fmt.Errorf("%w: %v", ErrUnavailable, err)
     }
 
-    configFilePath := "/etc/network/interfaces"  // Путь к конфигурационному файлу Ethernet
+    configFilePath := network.InterfacesFile  // Путь к конфигурационному файлу Ethernet
 
     // Вызов функции для обновления конфигурации Ethernet и WiFi
     err = UpdateEthernetConfig(configFilePath, ipAddr, netmask, gateway, dns)
     if err != nil {
-        return fmt.Errorf("error updating ethernet config: %w", err)
+        return fmt.Errorf("%w: %v", ErrConfigFailed, err)
+    }
+
+    return nil
+}
+
+// SetStatic записывает статический адрес интерфейса Ethernet в файл интерфейсов и применяет его,
+// перезапуская интерфейс через ifdown/ifup. Имя интерфейса и файл берутся из конфигурации.
+func SetStatic(ipAddr, netmask, gateway, dns string) error {
+    network := config.Get().Network
+
+    err := UpdateEthernetConfig(network.InterfacesFile, ipAddr, netmask, gateway, dns)
+    if err != nil {
+        return fmt.Errorf("%w: %v", ErrConfigFailed, err)
+    }
+
+    // ifdown завершается ошибкой, если интерфейс поднят не через ifupdown; перезапуску это не мешает
+    RunCommand("ifdown", "--force", network.EthernetInterface)
+    output, err := RunCommand("ifup", network.EthernetInterface)
+    if err != nil {
+        return fmt.Errorf("%w: %v\nOutput: %s", ErrConfigFailed, err, output)
     }
 
     return nil
 }
+
+// LinkUp сообщает, есть ли линк на интерфейсе
+func LinkUp(interfaceName string) bool {
+    carrier, err := ioutil.ReadFile(fmt.Sprintf("/sys/class/net/%s/carrier", interfaceName))
+    return err == nil && strings.TrimSpace(string(carrier)) == "1"
+}
+
+// MonitorLink периодически проверяет наличие линка и адреса на интерфейсе Ethernet и публикует события
+// ethernet_connected (при появлении линка или смене адреса) и ethernet_disconnected, а также обновляет метрику линка.
+// Интерфейс и период проверки берутся из конфигурации на каждой итерации. MonitorLink работает до отмены ctx.
+func MonitorLink(ctx context.Context) error {
+    currentInterface := ""
+    connected := false
+    currentIP := ""
+
+    for {
+        network := config.Get().Network
+        interfaceName := network.EthernetInterface
+
+        if interfaceName != currentInterface {
+            linkUpGauge.DeleteLabelValues(currentInterface)
+            if connected {
+                events.Publish(events.TopicNetwork, "ethernet_disconnected", map[string]string{
+                    "interface": currentInterface,
+                })
+            }
+            currentInterface, connected, currentIP = interfaceName, false, ""
+        }
+
+        up := LinkUp(interfaceName)
+        linkUpGauge.WithLabelValues(interfaceName).Set(metrics.Bool(up))
+
+        ipAddr := ""
+        if up {
+            ipAddr, _, _, _, _ = GetEthernetInfo(interfaceName)
+        }
+
+        if up && ipAddr != "" && (!connected || ipAddr != currentIP) {
+            events.Publish(events.TopicNetwork, "ethernet_connected", map[string]string{
+                "interface": interfaceName,
+                "ip":        ipAddr,
+            })
+            connected, currentIP = true, ipAddr
+        } else if !up && connected {
+            events.Publish(events.TopicNetwork, "ethernet_disconnected", map[string]string{
+                "interface": interfaceName,
+            })
+            connected, currentIP = false, ""
+        }
+
+        select {
+        case <-ctx.Done():
+            return nil
+        case <-time.After(time.Duration(network.MonitorInterval)):
+        }
+    }
+}
@@ -0,0 +1,139 @@
+package events
+
+import (
+    "sync"
+    "time"
+)
+
+// Темы событий
+const (
+    TopicDevice  = "device"  // USB-накопители
+    TopicNetwork = "network" // WiFi и Ethernet
+    TopicUpdate  = "update"  // обновление и откат прошивки
+    TopicSystem  = "system"  // выключение, перезагрузка и перечитывание конфигурации
+)
+
+// ValidTopic проверяет, что тема известна
+func ValidTopic(topic string) bool {
+    switch topic {
+    case TopicDevice, TopicNetwork, TopicUpdate, TopicSystem:
+        return true
+    }
+    return false
+}
+
+// HistorySize — количество последних событий, которые хранятся для повторной отправки после переподключения
+var HistorySize = 256
+
+// subscriberBuffer — размер очереди подписчика. Подписчик, который не успевает читать, отключается
+// и при переподключении получает пропущенные события из истории.
+const subscriberBuffer = 64
+
+// Event описывает одно событие
+type Event struct {
+    ID    uint64      `json:"id"`
+    Topic string      `json:"topic"`
+    Type  string      `json:"type"`
+    Time  time.Time   `json:"time"`
+    Data  interface{} `json:"data,omitempty"`
+}
+
+// Subscription — подписка на события выбранных тем
+type Subscription struct {
+    C <-chan Event
+
+    ch     chan Event
+    topics map[string]bool
+    closed bool
+}
+
+var (
+    mu          sync.Mutex
+    lastID      uint64
+    history     []Event
+    subscribers = make(map[*Subscription]bool)
+)
+
+// Publish отправляет событие всем подписчикам темы и сохраняет его в истории
+func Publish(topic, eventType string, data interface{}) {
+    mu.Lock()
+    defer mu.Unlock()
+
+    lastID++
+    event := Event{ID: lastID, Topic: topic, Type: eventType, Time: time.Now(), Data: data}
+
+    history = append(history, event)
+    if len(history) > HistorySize {
+        history = history[len(history)-HistorySize:]
+    }
+
+    for sub := range subscribers {
+        if !sub.matches(topic) {
+            continue
+        }
+        select {
+        case sub.ch <- event:
+        default:
+            // Подписчик не успевает читать события: отключаем его, чтобы не задерживать остальных
+            sub.close()
+        }
+    }
+}
+
+// Subscribe подписывается на события указанных тем (пустой список — все темы).
+// Если afterID больше нуля, возвращаются события из истории, опубликованные после него;
+// truncated сообщает, что часть пропущенных событий уже вытеснена из истории.
+func Subscribe(topics []string, afterID uint64) (sub *Subscription, replay []Event, truncated bool) {
+    mu.Lock()
+    defer mu.Unlock()
+
+    ch := make(chan Event, subscriberBuffer)
+    sub = &Subscription{C: ch, ch: ch}
+    if len(topics) > 0 {
+        sub.topics = make(map[string]bool)
+        for _, topic := range topics {
+            sub.topics[topic] = true
+        }
+    }
+    subscribers[sub] = true
+
+    if afterID == 0 {
+        return sub, nil, false
+    }
+
+    // После перезапуска servis нумерация начинается заново, поэтому клиенту отправляется вся история
+    if afterID > lastID {
+        afterID = 0
+        truncated = true
+    }
+    if len(history) > 0 && history[0].ID > afterID+1 {
+        truncated = true
+    }
+
+    for _, event := range history {
+        if event.ID > afterID && sub.matches(event.Topic) {
+            replay = append(replay, event)
+        }
+    }
+    return sub, replay, truncated
+}
+
+// Close отменяет подписку
+func (s *Subscription) Close() {
+    mu.Lock()
+    defer mu.Unlock()
+    s.close()
+}
+
+func (s *Subscription) close() {
+    if s.closed {
+        return
+    }
+    s.closed = true
+    delete(subscribers, s)
+    close(s.ch)
+}
+
+func (s *Subscription) matches(topic string) bool {
+    return s.topics == nil || s.topics[topic]
+}
@@ -0,0 +1,73 @@
+package grpcapi
+
+import (
+    "encoding/json"
+    "net/http"
+    "time"
+    "servis/pkg/apierror"
+    "servis/pkg/service"
+    "google.golang.org/genproto/googleapis/rpc/errdetails"
+    "google.golang.org/grpc/codes"
+    "google.golang.org/grpc/status"
+    "google.golang.org/protobuf/protoadapt"
+    "google.golang.org/protobuf/types/known/durationpb"
+)
+
+// errorDomain — домен ошибок servis в google.rpc.ErrorInfo
+const errorDomain = "servis"
+
+// grpcCodes сопоставляет HTTP-коды ошибок API с кодами gRPC
+var grpcCodes = map[int]codes.Code{
+    http.StatusBadRequest:          codes.InvalidArgument,
+    http.StatusUnauthorized:        codes.Unauthenticated,
+    http.StatusForbidden:           codes.PermissionDenied,
+    http.StatusNotFound:            codes.NotFound,
+    http.StatusMethodNotAllowed:    codes.Unimplemented,
+    http.StatusConflict:            codes.FailedPrecondition,
+    http.StatusUnprocessableEntity: codes.InvalidArgument,
+    http.StatusTooManyRequests:     codes.ResourceExhausted,
+    http.StatusServiceUnavailable:  codes.Unavailable,
+    http.StatusInternalServerError: codes.Internal,
+}
+
+// apiError возвращает ошибку API для err. Операции пакета service уже возвращают *apierror.Error;
+// прочие ошибки (например, разбора сообщения) классифицируются так же, как в HTTP API.
+func apiError(err error) *apierror.Error {
+    return service.Classify(err, apierror.CodeInternal, "internal error")
+}
+
+// toStatus переводит ошибку в статус gRPC. Код ошибки API передается в ErrorInfo.reason,
+// детали ошибки (если есть) — в ErrorInfo.metadata["details"] в формате JSON. Для ошибок лимита частоты
+// время до повторной попытки, как и заголовок Retry-After в HTTP API, передается в google.rpc.RetryInfo.
+func toStatus(err error) *status.Status {
+    if err == nil {
+        return status.New(codes.OK, "")
+    }
+    if st, ok := status.FromError(err); ok {
+        return st
+    }
+
+    apiErr := apiError(err)
+    code, ok := grpcCodes[apierror.Status(apiErr.Code)]
+    if !ok {
+        code = codes.Unknown
+    }
+
+    info := &errdetails.ErrorInfo{Reason: apiErr.Code, Domain: errorDomain}
+    if apiErr.Details != nil {
+        if data, err := json.Marshal(apiErr.Details); err == nil {
+            info.Metadata = map[string]string{"details": string(data)}
+        }
+    }
+
+    details := []protoadapt.MessageV1{info}
+    if retry, ok := apiErr.Details.(service.RetryDetails); ok {
+        details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(time.Duration(retry.RetryAfter) * time.Second)})
+    }
+
+    st, detailErr := status.New(code, apiErr.Message).WithDetails(details...)
+    if detailErr != nil {
+        return status.New(code, apiErr.Message)
+    }
+    return st
+}
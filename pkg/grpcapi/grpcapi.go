@@ -0,0 +1,324 @@
+// Package grpcapi — gRPC-интерфейс servis (servispb/servis.proto) на отдельном порту (grpc_listen).
+// Операции выполняет пакет service, как и HTTP API, поэтому поведение и коды ошибок двух интерфейсов совпадают;
+// здесь только преобразование сообщений, проверка токена или сертификата, аудит и метрики.
+package grpcapi
+
+import (
+    "context"
+    "crypto/tls"
+    "errors"
+    "fmt"
+    "log"
+    "net"
+    "sync"
+    "time"
+    "servis/pkg/auth"
+    "servis/pkg/certs"
+    "servis/pkg/config"
+    "servis/pkg/grpcapi/servispb"
+    "servis/pkg/service"
+    "servis/pkg/shutdown"
+    "servis/pkg/update"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/credentials"
+    "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type server struct {
+    servispb.UnimplementedServisServer
+}
+
+// Serve запускает сервер gRPC на адресе grpc_listen и работает до отмены ctx. Если адрес пустой,
+// ждет, пока он появится в конфигурации; при смене адреса сервер переходит на новый сокет.
+func Serve(ctx context.Context) error {
+    err := auth.Init()
+    if err != nil {
+        return fmt.Errorf("failed to initialize authentication: %w", err)
+    }
+
+    tlsConfig, err := certs.ServerTLSConfig()
+    if err != nil {
+        return fmt.Errorf("failed to initialize TLS: %w", err)
+    }
+
+    s := grpc.NewServer(
+        grpc.Creds(credentials.NewTLS(tlsConfig)),
+        grpc.ChainUnaryInterceptor(unaryInterceptor),
+        grpc.ChainStreamInterceptor(streamInterceptor),
+    )
+    servispb.RegisterServisServer(s, &server{})
+
+    var (
+        mu       sync.Mutex
+        listener net.Listener
+    )
+    failed := make(chan error, 1)
+    // listen переводит сервер на адрес addr (пустой — перестать принимать соединения) и закрывает прежний сокет
+    listen := func(addr string) error {
+        mu.Lock()
+        defer mu.Unlock()
+
+        var created net.Listener
+        if addr != "" {
+            var err error
+            created, err = net.Listen("tcp", addr)
+            if err != nil {
+                return err
+            }
+            go func() {
+                err := s.Serve(created)
+                if err != nil && !errors.Is(err, net.ErrClosed) {
+                    select {
+                    case failed <- err:
+                    default:
+                    }
+                }
+            }()
+        }
+        if listener != nil {
+            listener.Close()
+        }
+        listener = created
+        return nil
+    }
+
+    addr := config.Get().GRPCListen
+    err = listen(addr)
+    if err != nil {
+        return fmt.Errorf("grpc server failed to start: %w", err)
+    }
+    if addr == "" {
+        log.Println("grpc server is disabled")
+    } else {
+        log.Printf("grpc server is listening on %s", addr)
+    }
+
+    unsubscribe := config.OnChange(func(old, new config.Config) {
+        if old.GRPCListen == new.GRPCListen {
+            return
+        }
+        err := listen(new.GRPCListen)
+        if err != nil {
+            log.Printf("failed to listen on %s, still serving grpc on %s: %v", new.GRPCListen, old.GRPCListen, err)
+            return
+        }
+        log.Printf("grpc server moved from %q to %q", old.GRPCListen, new.GRPCListen)
+    })
+    defer unsubscribe()
+
+    select {
+    case <-ctx.Done():
+    case err := <-failed:
+        s.Stop()
+        return fmt.Errorf("grpc server failed: %w", err)
+    }
+
+    // Остановка: новые вызовы не принимаются, начатые (в том числе обновление прошивки) завершаются
+    stopped := make(chan struct{})
+    go func() {
+        s.GracefulStop()
+        close(stopped)
+    }()
+    select {
+    case <-stopped:
+    case <-time.After(time.Duration(config.Get().ShutdownTimeout)):
+        s.Stop()
+        return errors.New("failed to drain grpc calls")
+    }
+    log.Println("grpc server stopped")
+    return nil
+}
+
+func (*server) ListNetworks(ctx context.Context, req *servispb.ListNetworksRequest) (*servispb.ListNetworksResponse, error) {
+    networks, err := service.Networks()
+    if err != nil {
+        return nil, err
+    }
+
+    response := &servispb.ListNetworksResponse{}
+    for _, network := range networks {
+        response.Networks = append(response.Networks, &servispb.Network{Name: network.Name, Quality: network.Quality})
+    }
+    return response, nil
+}
+
+func (*server) ConnectNetwork(ctx context.Context, req *servispb.ConnectNetworkRequest) (*servispb.ConnectNetworkResponse, error) {
+    err := service.ConnectNetwork(service.NetworkSelection{Name: req.Name, Password: req.Password})
+    if err != nil {
+        return nil, err
+    }
+    return &servispb.ConnectNetworkResponse{Message: "connected to network"}, nil
+}
+
+func (*server) Shutdown(ctx context.Context, req *servispb.PowerRequest) (*servispb.PowerResponse, error) {
+    return power(ctx, shutdown.ActionShutdown, req)
+}
+
+func (*server) Reboot(ctx context.Context, req *servispb.PowerRequest) (*servispb.PowerResponse, error) {
+    return power(ctx, shutdown.ActionReboot, req)
+}
+
+// power выполняет первый или второй шаг выключения или перезагрузки
+func power(ctx context.Context, action string, req *servispb.PowerRequest) (*servispb.PowerResponse, error) {
+    response, err := service.Power(action, service.PowerRequest{Delay: req.Delay, ConfirmToken: req.ConfirmToken}, auth.FromContext(ctx).Name)
+    if err != nil {
+        return nil, err
+    }
+    return toPowerResponse(response), nil
+}
+
+func (*server) GetPendingPower(ctx context.Context, req *servispb.GetPendingPowerRequest) (*servispb.PowerResponse, error) {
+    return toPowerResponse(service.PendingPower()), nil
+}
+
+func (*server) CancelPendingPower(ctx context.Context, req *servispb.CancelPendingPowerRequest) (*servispb.PowerResponse, error) {
+    response, err := service.CancelPower(auth.FromContext(ctx).Name)
+    if err != nil {
+        return nil, err
+    }
+    return toPowerResponse(response), nil
+}
+
+func (*server) ListUSBFiles(ctx context.Context, req *servispb.ListUSBFilesRequest) (*servispb.ListUSBFilesResponse, error) {
+    packages, err := service.USBPackages(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    response := &servispb.ListUSBFilesResponse{}
+    for _, zipFile := range packages {
+        info := &servispb.ZipFileInfo{
+            Path:       zipFile.Path,
+            Files:      toFileInfos(zipFile.Files),
+            Signature:  zipFile.Signature,
+            Encrypted:  zipFile.Encrypted,
+            Compatible: zipFile.Compatible,
+            Issues:     zipFile.Issues,
+            Error:      zipFile.Error,
+        }
+        for _, pkg := range zipFile.Packages {
+            info.Packages = append(info.Packages, &servispb.PackageInfo{
+                Name:        pkg.Name,
+                Description: pkg.Description,
+                Files:       toFileInfos(pkg.Files),
+                Compatible:  pkg.Compatible,
+                Issues:      pkg.Issues,
+            })
+        }
+        for _, problem := range zipFile.Problems {
+            info.Problems = append(info.Problems, &servispb.ManifestProblem{Field: problem.Field, Message: problem.Message})
+        }
+        response.Files = append(response.Files, info)
+    }
+    return response, nil
+}
+
+// UpdateFirmware передает ход установки потоком. Если клиент отключился, установка не прерывается,
+// как и при обрыве HTTP-запроса: остановить ее на середине значит оставить прошивку в промежуточном состоянии.
+func (*server) UpdateFirmware(req *servispb.UpdateFirmwareRequest, stream servispb.Servis_UpdateFirmwareServer) error {
+    err := service.UpdateFirmware(service.UpdateRequest{SelectedFile: req.SelectedFile, Packages: req.Packages}, sendProgress(stream))
+    if err != nil {
+        return err
+    }
+    return stream.Send(&servispb.FirmwareEvent{Event: &servispb.FirmwareEvent_Result{Result: &servispb.FirmwareResult{
+        Message: "firmware update completed successfully",
+    }}})
+}
+
+// RollbackFirmware передает ход отката потоком; без destinations откатывается последнее обновление целиком
+func (*server) RollbackFirmware(req *servispb.RollbackFirmwareRequest, stream servispb.Servis_RollbackFirmwareServer) error {
+    rollbackReq := service.RollbackRequest{Destinations: req.Destinations, IncludeDependents: req.IncludeDependents}
+    results, err := service.RollbackFirmware(rollbackReq, sendProgress(stream))
+    if err != nil {
+        return err
+    }
+
+    result := &servispb.FirmwareResult{Message: "firmware rollback completed successfully"}
+    for _, rollback := range results {
+        result.Rollbacks = append(result.Rollbacks, &servispb.ComponentRollback{
+            Destination: rollback.Destination,
+            FromVersion: rollback.FromVersion,
+            ToVersion:   rollback.ToVersion,
+            BackupId:    rollback.BackupID,
+        })
+    }
+    return stream.Send(&servispb.FirmwareEvent{Event: &servispb.FirmwareEvent_Result{Result: result}})
+}
+
+func (*server) GetFirmwareJob(ctx context.Context, req *servispb.GetFirmwareJobRequest) (*servispb.FirmwareJobResponse, error) {
+    response, err := service.FirmwareJob()
+    if err != nil {
+        return nil, err
+    }
+    return &servispb.FirmwareJobResponse{Job: toJob(response.Job), Interrupted: response.Interrupted}, nil
+}
+
+// sendProgress возвращает функцию, передающую ход операции в поток; ошибки отправки (клиент отключился) пропускаются
+func sendProgress(stream grpc.ServerStreamingServer[servispb.FirmwareEvent]) func(service.Progress) {
+    return func(p service.Progress) {
+        stream.Send(&servispb.FirmwareEvent{Event: &servispb.FirmwareEvent_Progress{Progress: &servispb.Progress{
+            Operation:   p.Operation,
+            Stage:       p.Stage,
+            JobId:       p.JobID,
+            Destination: p.Destination,
+            Done:        int64(p.Done),
+            Total:       int64(p.Total),
+            Error:       p.Error,
+        }}})
+    }
+}
+
+func toPowerResponse(response *service.PowerResponse) *servispb.PowerResponse {
+    result := &servispb.PowerResponse{}
+    if c := response.Confirmation; c != nil {
+        result.Confirmation = &servispb.Confirmation{
+            ConfirmToken: c.Token,
+            Action:       c.Action,
+            Delay:        c.Delay,
+            ExpiresAt:    timestamppb.New(c.ExpiresAt),
+            Impact: &servispb.Impact{
+                UpdateJob:  toJob(c.Impact.UpdateJob),
+                MountedUsb: c.Impact.MountedUSB,
+                Warnings:   c.Impact.Warnings,
+            },
+        }
+    }
+    if p := response.Pending; p != nil {
+        result.Pending = &servispb.Pending{
+            Action:      p.Action,
+            RequestedBy: p.RequestedBy,
+            ExecuteAt:   timestamppb.New(p.ExecuteAt),
+            SecondsLeft: int64(p.SecondsLeft),
+        }
+    }
+    return result
+}
+
+func toJob(job *update.Job) *servispb.Job {
+    if job == nil {
+        return nil
+    }
+    return &servispb.Job{
+        Id:        job.ID,
+        Operation: job.Operation,
+        Source:    job.Source,
+        StartedAt: timestamppb.New(job.StartedAt),
+        Pid:       int64(job.PID),
+    }
+}
+
+func toFileInfos(files []service.FileInfo) []*servispb.FileInfo {
+    var result []*servispb.FileInfo
+    for _, file := range files {
+        result = append(result, &servispb.FileInfo{Source: file.Source, FileVersion: file.FileVersion})
+    }
+    return result
+}
+
+// peerTLS возвращает параметры TLS соединения, по которому пришел вызов
+func peerTLS(info credentials.AuthInfo) *tls.ConnectionState {
+    if tlsInfo, ok := info.(credentials.TLSInfo); ok {
+        return &tlsInfo.State
+    }
+    return nil
+}
@@ -0,0 +1,211 @@
+package grpcapi
+
+import (
+    "context"
+    "net"
+    "strings"
+    "time"
+    "servis/pkg/apierror"
+    "servis/pkg/audit"
+    "servis/pkg/auth"
+    "servis/pkg/grpcapi/servispb"
+    "servis/pkg/metrics"
+    "servis/pkg/ratelimit"
+    "github.com/prometheus/client_golang/prometheus"
+    "google.golang.org/grpc"
+    "google.golang.org/grpc/metadata"
+    "google.golang.org/grpc/peer"
+    "google.golang.org/protobuf/encoding/protojson"
+    "google.golang.org/protobuf/proto"
+)
+
+var (
+    grpcRequestsTotal = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+        Namespace: metrics.Namespace,
+        Subsystem: "grpc",
+        Name:      "requests_total",
+        Help:      "Вызовы gRPC по методу и коду статуса.",
+    }, []string{"method", "code"})
+
+    grpcRequestDuration = metrics.Factory.NewHistogramVec(prometheus.HistogramOpts{
+        Namespace: metrics.Namespace,
+        Subsystem: "grpc",
+        Name:      "request_duration_seconds",
+        Help:      "Длительность вызовов gRPC; для потоковых — до завершения потока.",
+        Buckets:   prometheus.DefBuckets,
+    }, []string{"method"})
+)
+
+// method — требования метода gRPC. OperationID совпадает с operationId эндпоинта HTTP API,
+// поэтому записи аудита обоих интерфейсов ищутся одним фильтром action.
+type method struct {
+    Role        string
+    OperationID string
+    Audited     bool // изменяющий вызов записывается в журнал аудита
+}
+
+var methods = map[string]method{
+    servispb.Servis_ListNetworks_FullMethodName:       {Role: auth.RoleViewer, OperationID: "getNetworks"},
+    servispb.Servis_ConnectNetwork_FullMethodName:     {Role: auth.RoleOperator, OperationID: "connectNetwork", Audited: true},
+    servispb.Servis_Shutdown_FullMethodName:           {Role: auth.RoleOperator, OperationID: "shutdown", Audited: true},
+    servispb.Servis_Reboot_FullMethodName:             {Role: auth.RoleOperator, OperationID: "reboot", Audited: true},
+    servispb.Servis_GetPendingPower_FullMethodName:    {Role: auth.RoleViewer, OperationID: "getPendingPower"},
+    servispb.Servis_CancelPendingPower_FullMethodName: {Role: auth.RoleOperator, OperationID: "cancelPendingPower", Audited: true},
+    servispb.Servis_ListUSBFiles_FullMethodName:       {Role: auth.RoleViewer, OperationID: "listUSBFiles"},
+    servispb.Servis_UpdateFirmware_FullMethodName:     {Role: auth.RoleOperator, OperationID: "updateFirmware", Audited: true},
+    servispb.Servis_RollbackFirmware_FullMethodName:   {Role: auth.RoleOperator, OperationID: "rollbackFirmware", Audited: true},
+    servispb.Servis_GetFirmwareJob_FullMethodName:     {Role: auth.RoleViewer, OperationID: "getFirmwareJob"},
+}
+
+// call — вызов gRPC на время его обработки
+type call struct {
+    method   string
+    identity *auth.Identity
+    request  proto.Message // для аудита; у потоковых методов — первое сообщение клиента
+}
+
+func unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+    c := &call{method: info.FullMethod}
+    c.request, _ = req.(proto.Message)
+
+    var response interface{}
+    err := c.serve(ctx, func(ctx context.Context) error {
+        var err error
+        response, err = handler(ctx, req)
+        return err
+    })
+    return response, err
+}
+
+func streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+    c := &call{method: info.FullMethod}
+    return c.serve(ss.Context(), func(ctx context.Context) error {
+        return handler(srv, &serverStream{ServerStream: ss, ctx: ctx, call: c})
+    })
+}
+
+// serve проверяет лимиты частоты вызовов и права на вызов, выполняет его и записывает результат в метрики
+// и журнал аудита. Лимиты и блокировка после неудачных попыток входа общие с HTTP API; вызовы, отклоненные
+// лимитом клиента, как и HTTP-запросы, не записываются в журнал аудита.
+func (c *call) serve(ctx context.Context, handler func(ctx context.Context) error) error {
+    started := time.Now()
+    m, known := methods[c.method]
+    client := peerAddress(ctx)
+
+    var err error
+    limited := false
+    if !known {
+        err = apierror.New(apierror.CodeNotFound, "no such method")
+    } else if err = ratelimit.Check(m.OperationID, client); err != nil {
+        limited = true
+    } else if c.identity, err = authenticate(ctx, m.Role); err == nil {
+        // Общие лимиты всех клиентов расходуются только после проверки токена, как в HTTP API
+        if err = ratelimit.CheckGlobal(m.OperationID); err == nil {
+            err = handler(auth.NewContext(ctx, c.identity))
+        }
+    } else if ratelimit.IsAuthFailure(apiError(err).Code) {
+        ratelimit.AuthFailed(client)
+    }
+
+    st := toStatus(err)
+    grpcRequestsTotal.WithLabelValues(c.method, st.Code().String()).Inc()
+    grpcRequestDuration.WithLabelValues(c.method).Observe(time.Since(started).Seconds())
+    if m.Audited && !limited {
+        c.audit(ctx, m, err)
+    }
+    return st.Err()
+}
+
+// peerAddress возвращает IP-адрес клиента без порта
+func peerAddress(ctx context.Context) string {
+    p, ok := peer.FromContext(ctx)
+    if !ok {
+        return ""
+    }
+    address := p.Addr.String()
+    if host, _, err := net.SplitHostPort(address); err == nil {
+        return host
+    }
+    return address
+}
+
+// authenticate возвращает пользователя по токену из метаданных authorization или по клиентскому сертификату
+// и проверяет, что его роль не ниже требуемой. Ошибки те же, что у HTTP API.
+func authenticate(ctx context.Context, role string) (*auth.Identity, error) {
+    var identity *auth.Identity
+    if p, ok := peer.FromContext(ctx); ok {
+        identity = auth.CertificateIdentity(peerTLS(p.AuthInfo))
+    }
+
+    header := metadata.ValueFromIncomingContext(ctx, "authorization")
+    if len(header) == 0 && identity != nil {
+        return identity, auth.CheckRole(identity, role)
+    }
+
+    plain := ""
+    if len(header) > 0 {
+        if token, ok := strings.CutPrefix(header[0], "Bearer "); ok {
+            plain = token
+        }
+    }
+    return auth.AuthorizeToken(plain, role)
+}
+
+// audit записывает изменяющий вызов в журнал аудита так же, как изменяющий HTTP-запрос;
+// в Method записывается GRPC, в Path — полное имя метода
+func (c *call) audit(ctx context.Context, m method, err error) {
+    entry := audit.Entry{
+        Principal: "anonymous",
+        Client:    peerAddress(ctx),
+        Action:    m.OperationID,
+        Method:    "GRPC",
+        Path:      c.method,
+        Status:    200,
+        Outcome:   audit.OutcomeSuccess,
+    }
+    // Тело вызова записывается только для прошедших проверку токена, как в HTTP API
+    if c.identity != nil {
+        entry.Principal = c.identity.Name
+        entry.Role = c.identity.Role
+        entry.TokenID = c.identity.TokenID
+        if c.request != nil {
+            if body, err := (protojson.MarshalOptions{UseProtoNames: true}).Marshal(c.request); err == nil {
+                entry.Payload = audit.Redact(body)
+            }
+        }
+    }
+
+    if err != nil {
+        apiErr := apiError(err)
+        entry.ErrorCode = apiErr.Code
+        entry.Status = apierror.Status(apiErr.Code)
+
+        entry.Outcome = audit.OutcomeFailure
+        switch entry.ErrorCode {
+        case apierror.CodeAuthenticationRequired, apierror.CodeInvalidToken, apierror.CodeInsufficientRole:
+            entry.Outcome = audit.OutcomeDenied
+        }
+    }
+
+    audit.Log(entry)
+}
+
+// serverStream передает обработчику потокового метода контекст с пользователем и запоминает первое
+// сообщение клиента для журнала аудита
+type serverStream struct {
+    grpc.ServerStream
+    ctx  context.Context
+    call *call
+}
+
+func (s *serverStream) Context() context.Context {
+    return s.ctx
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+    err := s.ServerStream.RecvMsg(m)
+    if err == nil && s.call.request == nil {
+        s.call.request, _ = m.(proto.Message)
+    }
+    return err
+}
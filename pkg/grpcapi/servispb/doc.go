@@ -0,0 +1,4 @@
+// Package servispb — код, сгенерированный из servis.proto
+package servispb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative servis.proto
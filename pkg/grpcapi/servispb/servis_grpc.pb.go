@@ -0,0 +1,493 @@
+// gRPC-интерфейс servis. Операции и их ошибки совпадают с HTTP API (/api/v1): оба транспорта
+// вызывают пакет service. Код ошибки API передается в google.rpc.ErrorInfo.reason, детали — в metadata["details"] (JSON).
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v27.3.0
+// source: servis.proto
+
+package servispb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Servis_ListNetworks_FullMethodName       = "/servis.v1.Servis/ListNetworks"
+	Servis_ConnectNetwork_FullMethodName     = "/servis.v1.Servis/ConnectNetwork"
+	Servis_Shutdown_FullMethodName           = "/servis.v1.Servis/Shutdown"
+	Servis_Reboot_FullMethodName             = "/servis.v1.Servis/Reboot"
+	Servis_GetPendingPower_FullMethodName    = "/servis.v1.Servis/GetPendingPower"
+	Servis_CancelPendingPower_FullMethodName = "/servis.v1.Servis/CancelPendingPower"
+	Servis_ListUSBFiles_FullMethodName       = "/servis.v1.Servis/ListUSBFiles"
+	Servis_UpdateFirmware_FullMethodName     = "/servis.v1.Servis/UpdateFirmware"
+	Servis_RollbackFirmware_FullMethodName   = "/servis.v1.Servis/RollbackFirmware"
+	Servis_GetFirmwareJob_FullMethodName     = "/servis.v1.Servis/GetFirmwareJob"
+)
+
+// ServisClient is the client API for Servis service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ServisClient interface {
+	// Список доступных сетей WiFi (viewer)
+	ListNetworks(ctx context.Context, in *ListNetworksRequest, opts ...grpc.CallOption) (*ListNetworksResponse, error)
+	// Подключиться к сети WiFi (operator)
+	ConnectNetwork(ctx context.Context, in *ConnectNetworkRequest, opts ...grpc.CallOption) (*ConnectNetworkResponse, error)
+	// Выключить устройство: без confirm_token возвращается токен подтверждения, с ним действие планируется (operator)
+	Shutdown(ctx context.Context, in *PowerRequest, opts ...grpc.CallOption) (*PowerResponse, error)
+	// Перезагрузить устройство в два шага, как Shutdown (operator)
+	Reboot(ctx context.Context, in *PowerRequest, opts ...grpc.CallOption) (*PowerResponse, error)
+	// Запланированное выключение или перезагрузка (viewer)
+	GetPendingPower(ctx context.Context, in *GetPendingPowerRequest, opts ...grpc.CallOption) (*PowerResponse, error)
+	// Отменить запланированное выключение или перезагрузку (operator)
+	CancelPendingPower(ctx context.Context, in *CancelPendingPowerRequest, opts ...grpc.CallOption) (*PowerResponse, error)
+	// Пакеты прошивки на USB-накопителях (viewer)
+	ListUSBFiles(ctx context.Context, in *ListUSBFilesRequest, opts ...grpc.CallOption) (*ListUSBFilesResponse, error)
+	// Установить прошивку из ZIP-архива; ход установки передается потоком, последнее сообщение — результат (operator)
+	UpdateFirmware(ctx context.Context, in *UpdateFirmwareRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[FirmwareEvent], error)
+	// Откатить последнее обновление или выбранные компоненты с потоком хода отката (operator)
+	RollbackFirmware(ctx context.Context, in *RollbackFirmwareRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[FirmwareEvent], error)
+	// Выполняемая или прерванная операция обновления (viewer)
+	GetFirmwareJob(ctx context.Context, in *GetFirmwareJobRequest, opts ...grpc.CallOption) (*FirmwareJobResponse, error)
+}
+
+type servisClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewServisClient(cc grpc.ClientConnInterface) ServisClient {
+	return &servisClient{cc}
+}
+
+func (c *servisClient) ListNetworks(ctx context.Context, in *ListNetworksRequest, opts ...grpc.CallOption) (*ListNetworksResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListNetworksResponse)
+	err := c.cc.Invoke(ctx, Servis_ListNetworks_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *servisClient) ConnectNetwork(ctx context.Context, in *ConnectNetworkRequest, opts ...grpc.CallOption) (*ConnectNetworkResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ConnectNetworkResponse)
+	err := c.cc.Invoke(ctx, Servis_ConnectNetwork_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *servisClient) Shutdown(ctx context.Context, in *PowerRequest, opts ...grpc.CallOption) (*PowerResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PowerResponse)
+	err := c.cc.Invoke(ctx, Servis_Shutdown_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *servisClient) Reboot(ctx context.Context, in *PowerRequest, opts ...grpc.CallOption) (*PowerResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PowerResponse)
+	err := c.cc.Invoke(ctx, Servis_Reboot_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *servisClient) GetPendingPower(ctx context.Context, in *GetPendingPowerRequest, opts ...grpc.CallOption) (*PowerResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PowerResponse)
+	err := c.cc.Invoke(ctx, Servis_GetPendingPower_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *servisClient) CancelPendingPower(ctx context.Context, in *CancelPendingPowerRequest, opts ...grpc.CallOption) (*PowerResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PowerResponse)
+	err := c.cc.Invoke(ctx, Servis_CancelPendingPower_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *servisClient) ListUSBFiles(ctx context.Context, in *ListUSBFilesRequest, opts ...grpc.CallOption) (*ListUSBFilesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListUSBFilesResponse)
+	err := c.cc.Invoke(ctx, Servis_ListUSBFiles_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *servisClient) UpdateFirmware(ctx context.Context, in *UpdateFirmwareRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[FirmwareEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Servis_ServiceDesc.Streams[0], Servis_UpdateFirmware_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[UpdateFirmwareRequest, FirmwareEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Servis_UpdateFirmwareClient = grpc.ServerStreamingClient[FirmwareEvent]
+
+func (c *servisClient) RollbackFirmware(ctx context.Context, in *RollbackFirmwareRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[FirmwareEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Servis_ServiceDesc.Streams[1], Servis_RollbackFirmware_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[RollbackFirmwareRequest, FirmwareEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Servis_RollbackFirmwareClient = grpc.ServerStreamingClient[FirmwareEvent]
+
+func (c *servisClient) GetFirmwareJob(ctx context.Context, in *GetFirmwareJobRequest, opts ...grpc.CallOption) (*FirmwareJobResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(FirmwareJobResponse)
+	err := c.cc.Invoke(ctx, Servis_GetFirmwareJob_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ServisServer is the server API for Servis service.
+// All implementations must embed UnimplementedServisServer
+// for forward compatibility.
+type ServisServer interface {
+	// Список доступных сетей WiFi (viewer)
+	ListNetworks(context.Context, *ListNetworksRequest) (*ListNetworksResponse, error)
+	// Подключиться к сети WiFi (operator)
+	ConnectNetwork(context.Context, *ConnectNetworkRequest) (*ConnectNetworkResponse, error)
+	// Выключить устройство: без confirm_token возвращается токен подтверждения, с ним действие планируется (operator)
+	Shutdown(context.Context, *PowerRequest) (*PowerResponse, error)
+	// Перезагрузить устройство в два шага, как Shutdown (operator)
+	Reboot(context.Context, *PowerRequest) (*PowerResponse, error)
+	// Запланированное выключение или перезагрузка (viewer)
+	GetPendingPower(context.Context, *GetPendingPowerRequest) (*PowerResponse, error)
+	// Отменить запланированное выключение или перезагрузку (operator)
+	CancelPendingPower(context.Context, *CancelPendingPowerRequest) (*PowerResponse, error)
+	// Пакеты прошивки на USB-накопителях (viewer)
+	ListUSBFiles(context.Context, *ListUSBFilesRequest) (*ListUSBFilesResponse, error)
+	// Установить прошивку из ZIP-архива; ход установки передается потоком, последнее сообщение — результат (operator)
+	UpdateFirmware(*UpdateFirmwareRequest, grpc.ServerStreamingServer[FirmwareEvent]) error
+	// Откатить последнее обновление или выбранные компоненты с потоком хода отката (operator)
+	RollbackFirmware(*RollbackFirmwareRequest, grpc.ServerStreamingServer[FirmwareEvent]) error
+	// Выполняемая или прерванная операция обновления (viewer)
+	GetFirmwareJob(context.Context, *GetFirmwareJobRequest) (*FirmwareJobResponse, error)
+	mustEmbedUnimplementedServisServer()
+}
+
+// UnimplementedServisServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedServisServer struct{}
+
+func (UnimplementedServisServer) ListNetworks(context.Context, *ListNetworksRequest) (*ListNetworksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListNetworks not implemented")
+}
+func (UnimplementedServisServer) ConnectNetwork(context.Context, *ConnectNetworkRequest) (*ConnectNetworkResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ConnectNetwork not implemented")
+}
+func (UnimplementedServisServer) Shutdown(context.Context, *PowerRequest) (*PowerResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Shutdown not implemented")
+}
+func (UnimplementedServisServer) Reboot(context.Context, *PowerRequest) (*PowerResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reboot not implemented")
+}
+func (UnimplementedServisServer) GetPendingPower(context.Context, *GetPendingPowerRequest) (*PowerResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPendingPower not implemented")
+}
+func (UnimplementedServisServer) CancelPendingPower(context.Context, *CancelPendingPowerRequest) (*PowerResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelPendingPower not implemented")
+}
+func (UnimplementedServisServer) ListUSBFiles(context.Context, *ListUSBFilesRequest) (*ListUSBFilesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListUSBFiles not implemented")
+}
+func (UnimplementedServisServer) UpdateFirmware(*UpdateFirmwareRequest, grpc.ServerStreamingServer[FirmwareEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method UpdateFirmware not implemented")
+}
+func (UnimplementedServisServer) RollbackFirmware(*RollbackFirmwareRequest, grpc.ServerStreamingServer[FirmwareEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method RollbackFirmware not implemented")
+}
+func (UnimplementedServisServer) GetFirmwareJob(context.Context, *GetFirmwareJobRequest) (*FirmwareJobResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetFirmwareJob not implemented")
+}
+func (UnimplementedServisServer) mustEmbedUnimplementedServisServer() {}
+func (UnimplementedServisServer) testEmbeddedByValue()                {}
+
+// UnsafeServisServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ServisServer will
+// result in compilation errors.
+type UnsafeServisServer interface {
+	mustEmbedUnimplementedServisServer()
+}
+
+func RegisterServisServer(s grpc.ServiceRegistrar, srv ServisServer) {
+	// If the following call pancis, it indicates UnimplementedServisServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Servis_ServiceDesc, srv)
+}
+
+func _Servis_ListNetworks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListNetworksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServisServer).ListNetworks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Servis_ListNetworks_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServisServer).ListNetworks(ctx, req.(*ListNetworksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Servis_ConnectNetwork_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ConnectNetworkRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServisServer).ConnectNetwork(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Servis_ConnectNetwork_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServisServer).ConnectNetwork(ctx, req.(*ConnectNetworkRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Servis_Shutdown_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PowerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServisServer).Shutdown(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Servis_Shutdown_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServisServer).Shutdown(ctx, req.(*PowerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Servis_Reboot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PowerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServisServer).Reboot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Servis_Reboot_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServisServer).Reboot(ctx, req.(*PowerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Servis_GetPendingPower_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPendingPowerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServisServer).GetPendingPower(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Servis_GetPendingPower_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServisServer).GetPendingPower(ctx, req.(*GetPendingPowerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Servis_CancelPendingPower_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelPendingPowerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServisServer).CancelPendingPower(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Servis_CancelPendingPower_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServisServer).CancelPendingPower(ctx, req.(*CancelPendingPowerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Servis_ListUSBFiles_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListUSBFilesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServisServer).ListUSBFiles(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Servis_ListUSBFiles_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServisServer).ListUSBFiles(ctx, req.(*ListUSBFilesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Servis_UpdateFirmware_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(UpdateFirmwareRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServisServer).UpdateFirmware(m, &grpc.GenericServerStream[UpdateFirmwareRequest, FirmwareEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Servis_UpdateFirmwareServer = grpc.ServerStreamingServer[FirmwareEvent]
+
+func _Servis_RollbackFirmware_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(RollbackFirmwareRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ServisServer).RollbackFirmware(m, &grpc.GenericServerStream[RollbackFirmwareRequest, FirmwareEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Servis_RollbackFirmwareServer = grpc.ServerStreamingServer[FirmwareEvent]
+
+func _Servis_GetFirmwareJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetFirmwareJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ServisServer).GetFirmwareJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Servis_GetFirmwareJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ServisServer).GetFirmwareJob(ctx, req.(*GetFirmwareJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Servis_ServiceDesc is the grpc.ServiceDesc for Servis service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Servis_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "servis.v1.Servis",
+	HandlerType: (*ServisServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListNetworks",
+			Handler:    _Servis_ListNetworks_Handler,
+		},
+		{
+			MethodName: "ConnectNetwork",
+			Handler:    _Servis_ConnectNetwork_Handler,
+		},
+		{
+			MethodName: "Shutdown",
+			Handler:    _Servis_Shutdown_Handler,
+		},
+		{
+			MethodName: "Reboot",
+			Handler:    _Servis_Reboot_Handler,
+		},
+		{
+			MethodName: "GetPendingPower",
+			Handler:    _Servis_GetPendingPower_Handler,
+		},
+		{
+			MethodName: "CancelPendingPower",
+			Handler:    _Servis_CancelPendingPower_Handler,
+		},
+		{
+			MethodName: "ListUSBFiles",
+			Handler:    _Servis_ListUSBFiles_Handler,
+		},
+		{
+			MethodName: "GetFirmwareJob",
+			Handler:    _Servis_GetFirmwareJob_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "UpdateFirmware",
+			Handler:       _Servis_UpdateFirmware_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "RollbackFirmware",
+			Handler:       _Servis_RollbackFirmware_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "servis.proto",
+}
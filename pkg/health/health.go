@@ -0,0 +1,159 @@
+// Package health собирает проверки состояния, которые регистрируют подсистемы servis.
+// Сервер API отдает их результаты на /healthz (проверки работоспособности процесса)
+// и /readyz (все проверки: готовность выполнять операции).
+package health
+
+import (
+    "context"
+    "fmt"
+    "sort"
+    "sync"
+    "time"
+)
+
+// Состояние проверки и отчета
+const (
+    StatusOK      = "ok"
+    StatusFailing = "failing"
+)
+
+// DefaultTimeout — время на проверку, если в Check не задано другое
+const DefaultTimeout = 5 * time.Second
+
+// Details — сведения о состоянии подсистемы, например текущая операция или свободное место
+type Details map[string]interface{}
+
+// Check — проверка подсистемы. Run возвращает сведения о состоянии и ошибку, если подсистема неисправна;
+// сведения отдаются и вместе с ошибкой.
+type Check struct {
+    Name     string
+    Liveness bool          // проверка входит и в /healthz: ее провал означает, что процесс нужно перезапустить
+    Timeout  time.Duration // 0 — DefaultTimeout
+    Run      func(ctx context.Context) (Details, error)
+}
+
+// Result — результат одной проверки
+type Result struct {
+    Name       string  `json:"name"`
+    Status     string  `json:"status"`
+    Error      string  `json:"error,omitempty"`
+    Details    Details `json:"details,omitempty"`
+    DurationMS float64 `json:"duration_ms"` // время выполнения проверки
+}
+
+// Report — результаты проверок; Status — failing, если не прошла хотя бы одна проверка
+type Report struct {
+    Status    string    `json:"status"`
+    CheckedAt time.Time `json:"checked_at"`
+    Checks    []Result  `json:"checks"`
+}
+
+// WithoutDetails возвращает отчет без сведений и текстов ошибок для клиентов без аутентификации
+func (r Report) WithoutDetails() Report {
+    checks := make([]Result, len(r.Checks))
+    for i, result := range r.Checks {
+        result.Error, result.Details = "", nil
+        checks[i] = result
+    }
+    r.Checks = checks
+    return r
+}
+
+var (
+    mu     sync.Mutex
+    checks = make(map[string]Check)
+)
+
+// Register добавляет проверку; проверка с тем же именем заменяется
+func Register(c Check) {
+    mu.Lock()
+    defer mu.Unlock()
+    checks[c.Name] = c
+}
+
+// Live выполняет проверки работоспособности процесса (Liveness)
+func Live(ctx context.Context) Report {
+    return run(ctx, true)
+}
+
+// Ready выполняет все проверки
+func Ready(ctx context.Context) Report {
+    return run(ctx, false)
+}
+
+// run выполняет проверки одновременно; проверка, не уложившаяся в свой Timeout, считается непройденной
+func run(ctx context.Context, livenessOnly bool) Report {
+    mu.Lock()
+    var selected []Check
+    for _, c := range checks {
+        if c.Liveness || !livenessOnly {
+            selected = append(selected, c)
+        }
+    }
+    mu.Unlock()
+    sort.Slice(selected, func(i, j int) bool { return selected[i].Name < selected[j].Name })
+
+    report := Report{Status: StatusOK, CheckedAt: time.Now(), Checks: make([]Result, len(selected))}
+    var wg sync.WaitGroup
+    for i, c := range selected {
+        wg.Add(1)
+        go func(i int, c Check) {
+            defer wg.Done()
+            report.Checks[i] = runCheck(ctx, c)
+        }(i, c)
+    }
+    wg.Wait()
+
+    for _, result := range report.Checks {
+        if result.Status != StatusOK {
+            report.Status = StatusFailing
+        }
+    }
+    return report
+}
+
+type outcome struct {
+    details Details
+    err     error
+}
+
+func runCheck(ctx context.Context, c Check) Result {
+    timeout := c.Timeout
+    if timeout == 0 {
+        timeout = DefaultTimeout
+    }
+    ctx, cancel := context.WithTimeout(ctx, timeout)
+    defer cancel()
+
+    started := time.Now()
+    done := make(chan outcome, 1)
+    go func() {
+        defer func() {
+            if r := recover(); r != nil {
+                done <- outcome{err: fmt.Errorf("check panicked: %v", r)}
+            }
+        }()
+        details, err := c.Run(ctx)
+        done <- outcome{details: details, err: err}
+    }()
+
+    var result outcome
+    select {
+    case result = <-done:
+    case <-ctx.Done():
+        result.err = fmt.Errorf("check did not finish within %s", timeout)
+    }
+
+    status := StatusOK
+    message := ""
+    if result.err != nil {
+        status, message = StatusFailing, result.err.Error()
+    }
+    return Result{
+        Name:       c.Name,
+        Status:     status,
+        Error:      message,
+        Details:    result.details,
+        DurationMS: float64(time.Since(started).Microseconds()) / 1000,
+    }
+}
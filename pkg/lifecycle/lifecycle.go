@@ -0,0 +1,272 @@
+// Package lifecycle запускает подсистемы servis с общим контекстом, перезапускает упавшие
+// с нарастающей задержкой и корректно останавливает их при завершении процесса.
+package lifecycle
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "runtime/debug"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Состояния подсистемы
+const (
+    StateRunning    = "running"
+    StateRestarting = "restarting" // подсистема упала и ждет перезапуска
+    StateFinished   = "finished"   // подсистема завершила работу без ошибки
+    StateFailed     = "failed"     // исчерпано число перезапусков
+    StateStopped    = "stopped"    // подсистема остановлена при завершении процесса
+)
+
+// Worker описывает подсистему. Run работает до отмены ctx; возврат nil означает, что работа завершена
+// (например, однократная настройка), а ошибка или паника — сбой, после которого Run запускается снова.
+type Worker struct {
+    Name        string
+    Run         func(ctx context.Context) error
+    MaxRestarts int // 0 — перезапускать без ограничений
+}
+
+// WorkerStatus — состояние подсистемы
+type WorkerStatus struct {
+    Name      string    `json:"name"`
+    State     string    `json:"state"`
+    Restarts  int       `json:"restarts"`
+    LastError string    `json:"last_error,omitempty"`
+    Since     time.Time `json:"since"`
+}
+
+// Supervisor запускает подсистемы и следит за ними
+type Supervisor struct {
+    MinBackoff  time.Duration // задержка перед первым перезапуском; удваивается после каждого сбоя
+    MaxBackoff  time.Duration
+    StableAfter time.Duration // после такого времени работы без сбоя задержка сбрасывается
+
+    mu              sync.Mutex
+    shutdownTimeout time.Duration
+    workers         []Worker
+    stops           []Worker
+    statuses        map[string]*WorkerStatus
+    cancel          context.CancelFunc
+    done            chan struct{}
+}
+
+// New создает супервизор с задержками перезапуска от 1 секунды до 1 минуты
+func New() *Supervisor {
+    return &Supervisor{
+        MinBackoff:      time.Second,
+        MaxBackoff:      time.Minute,
+        StableAfter:     time.Minute,
+        shutdownTimeout: 30 * time.Second,
+        statuses:        make(map[string]*WorkerStatus),
+        done:            make(chan struct{}),
+    }
+}
+
+// SetShutdownTimeout задает время на остановку подсистем и действий OnStop; можно менять во время работы
+func (s *Supervisor) SetShutdownTimeout(timeout time.Duration) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.shutdownTimeout = timeout
+}
+
+// Add добавляет подсистему; подсистемы, добавленные после Run, не запускаются
+func (s *Supervisor) Add(w Worker) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.workers = append(s.workers, w)
+    s.statuses[w.Name] = &WorkerStatus{Name: w.Name, State: StateRunning, Since: time.Now()}
+}
+
+// OnStop добавляет действие при остановке. Действия выполняются одновременно с остановкой подсистем
+// и получают контекст с тем же сроком, что и подсистемы (например, ожидание завершения обновления прошивки).
+func (s *Supervisor) OnStop(name string, fn func(ctx context.Context) error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.stops = append(s.stops, Worker{Name: name, Run: fn})
+}
+
+// Run запускает подсистемы и ждет отмены ctx, после чего останавливает их.
+// Возвращает ошибку, если подсистемы или действия OnStop не завершились за время, заданное SetShutdownTimeout.
+func (s *Supervisor) Run(ctx context.Context) error {
+    defer close(s.done)
+
+    ctx, cancel := context.WithCancel(ctx)
+    s.mu.Lock()
+    s.cancel = cancel
+    workers := append([]Worker{}, s.workers...)
+    stops := append([]Worker{}, s.stops...)
+    s.mu.Unlock()
+    defer cancel()
+
+    var wg sync.WaitGroup
+    for _, w := range workers {
+        wg.Add(1)
+        go func(w Worker) {
+            defer wg.Done()
+            s.supervise(ctx, w)
+        }(w)
+    }
+
+    <-ctx.Done()
+    log.Printf("Stopping subsystems...")
+
+    s.mu.Lock()
+    timeout := s.shutdownTimeout
+    s.mu.Unlock()
+    stopCtx, stopCancel := context.WithTimeout(context.Background(), timeout)
+    defer stopCancel()
+
+    var stopErrs []string
+    var stopMu sync.Mutex
+    for _, stop := range stops {
+        wg.Add(1)
+        go func(stop Worker) {
+            defer wg.Done()
+            if err := stop.Run(stopCtx); err != nil {
+                stopMu.Lock()
+                stopErrs = append(stopErrs, fmt.Sprintf("%s: %v", stop.Name, err))
+                stopMu.Unlock()
+            }
+        }(stop)
+    }
+
+    stopped := make(chan struct{})
+    go func() {
+        wg.Wait()
+        close(stopped)
+    }()
+
+    select {
+    case <-stopped:
+    case <-stopCtx.Done():
+        return fmt.Errorf("subsystems did not stop within %s: %s", timeout, strings.Join(s.running(), ", "))
+    }
+
+    if len(stopErrs) > 0 {
+        sort.Strings(stopErrs)
+        return fmt.Errorf("failed to stop cleanly: %s", strings.Join(stopErrs, "; "))
+    }
+    log.Printf("All subsystems stopped")
+    return nil
+}
+
+// Stop останавливает подсистемы и ждет завершения Run или истечения ctx
+func (s *Supervisor) Stop(ctx context.Context) error {
+    s.mu.Lock()
+    cancel := s.cancel
+    s.mu.Unlock()
+    if cancel == nil {
+        return fmt.Errorf("supervisor is not running")
+    }
+    cancel()
+
+    select {
+    case <-s.done:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// Status возвращает состояние подсистем по имени
+func (s *Supervisor) Status() []WorkerStatus {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var statuses []WorkerStatus
+    for _, status := range s.statuses {
+        statuses = append(statuses, *status)
+    }
+    sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+    return statuses
+}
+
+// supervise выполняет подсистему и перезапускает ее после сбоев
+func (s *Supervisor) supervise(ctx context.Context, w Worker) {
+    backoff := s.MinBackoff
+    restarts := 0
+
+    for {
+        started := time.Now()
+        s.setState(w.Name, StateRunning, restarts, nil)
+        err := runSafely(ctx, w)
+
+        if ctx.Err() != nil {
+            if err != nil {
+                log.Printf("Subsystem %s stopped with error: %v", w.Name, err)
+            }
+            s.setState(w.Name, StateStopped, restarts, err)
+            return
+        }
+        if err == nil {
+            s.setState(w.Name, StateFinished, restarts, nil)
+            return
+        }
+
+        if w.MaxRestarts > 0 && restarts >= w.MaxRestarts {
+            log.Printf("Subsystem %s failed, giving up after %d restarts: %v", w.Name, restarts, err)
+            s.setState(w.Name, StateFailed, restarts, err)
+            return
+        }
+
+        if time.Since(started) >= s.StableAfter {
+            backoff = s.MinBackoff
+        }
+        log.Printf("Subsystem %s failed, restarting in %s: %v", w.Name, backoff, err)
+        s.setState(w.Name, StateRestarting, restarts, err)
+
+        select {
+        case <-time.After(backoff):
+        case <-ctx.Done():
+            s.setState(w.Name, StateStopped, restarts, err)
+            return
+        }
+
+        restarts++
+        backoff *= 2
+        if backoff > s.MaxBackoff {
+            backoff = s.MaxBackoff
+        }
+    }
+}
+
+// runSafely выполняет подсистему, превращая панику в ошибку
+func runSafely(ctx context.Context, w Worker) (err error) {
+    defer func() {
+        if r := recover(); r != nil {
+            err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+        }
+    }()
+    return w.Run(ctx)
+}
+
+func (s *Supervisor) setState(name, state string, restarts int, err error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    status := s.statuses[name]
+    if status.State != state {
+        status.Since = time.Now()
+    }
+    status.State = state
+    status.Restarts = restarts
+    status.LastError = ""
+    if err != nil {
+        status.LastError = err.Error()
+    }
+}
+
+// running возвращает имена подсистем, которые еще не остановились
+func (s *Supervisor) running() []string {
+    var names []string
+    for _, status := range s.Status() {
+        if status.State == StateRunning || status.State == StateRestarting {
+            names = append(names, status.Name)
+        }
+    }
+    return names
+}
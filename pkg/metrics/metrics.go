@@ -0,0 +1,41 @@
+// Package metrics — метрики servis в формате Prometheus. Пакеты объявляют свои метрики через Factory
+// рядом с кодом, который они описывают, а сервер API отдает содержимое Registry на /metrics.
+package metrics
+
+import (
+    "log"
+    "net/http"
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/collectors"
+    "github.com/prometheus/client_golang/prometheus/promauto"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace — префикс имен метрик servis
+const Namespace = "servis"
+
+// Registry содержит метрики servis, а также метрики среды выполнения Go и процесса
+var Registry = prometheus.NewRegistry()
+
+// Factory регистрирует создаваемые метрики в Registry
+var Factory = promauto.With(Registry)
+
+func init() {
+    Registry.MustRegister(
+        collectors.NewGoCollector(),
+        collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+    )
+}
+
+// Handler отдает метрики в текстовом формате Prometheus
+func Handler() http.Handler {
+    return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{ErrorLog: log.Default()})
+}
+
+// Bool переводит состояние в значение метрики: 1 — да, 0 — нет
+func Bool(value bool) float64 {
+    if value {
+        return 1
+    }
+    return 0
+}
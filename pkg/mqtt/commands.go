@@ -0,0 +1,228 @@
+package mqtt
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "strings"
+    "sync"
+    "time"
+    "servis/pkg/apierror"
+    "servis/pkg/audit"
+    "servis/pkg/auth"
+    "servis/pkg/ratelimit"
+    "servis/pkg/service"
+    "servis/pkg/shutdown"
+    paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Command — сообщение в теме commands/<команда>. Token — API-токен servis с ролью не ниже требуемой;
+// аргументы команды передаются полями того же объекта.
+type Command struct {
+    ID    string `json:"id"` // идентификатор, который возвращается в ответе; повторная доставка с тем же id пропускается
+    Token string `json:"token"`
+}
+
+// Response — ответ в теме responses/<команда>. Долгие команды (update) сначала отвечают accepted,
+// а по завершении — succeeded или failed.
+type Response struct {
+    ID      string          `json:"id"`
+    Command string          `json:"command"`
+    Status  string          `json:"status"` // accepted, succeeded или failed
+    Result  interface{}     `json:"result,omitempty"`
+    Error   *apierror.Error `json:"error,omitempty"`
+    Time    time.Time       `json:"time"`
+}
+
+// Состояния ответа на команду
+const (
+    StatusAccepted  = "accepted"
+    StatusSucceeded = "succeeded"
+    StatusFailed    = "failed"
+)
+
+// command — команда MQTT. OperationID совпадает с operationId эндпоинта HTTP API с тем же действием,
+// поэтому записи аудита всех интерфейсов ищутся одним фильтром action.
+type command struct {
+    OperationID string
+    Role        string
+    LongRunning bool
+    Run         func(ctx context.Context, payload []byte, identity *auth.Identity) (interface{}, error)
+}
+
+// commands — команды, принимаемые через MQTT; все выполняются пакетом service, как и запросы HTTP API
+var commands = map[string]command{
+    // Перезагрузка в два шага, как POST /reboot: первая команда возвращает confirm_token, вторая с ним планирует перезагрузку
+    "reboot": {OperationID: "reboot", Role: auth.RoleOperator, Run: func(ctx context.Context, payload []byte, identity *auth.Identity) (interface{}, error) {
+        var req service.PowerRequest
+        if err := decode(payload, &req); err != nil {
+            return nil, err
+        }
+        return service.Power(shutdown.ActionReboot, req, identity.Name)
+    }},
+    "update": {OperationID: "updateFirmwareFromURL", Role: auth.RoleOperator, LongRunning: true, Run: func(ctx context.Context, payload []byte, identity *auth.Identity) (interface{}, error) {
+        var req service.URLUpdateRequest
+        if err := decode(payload, &req); err != nil {
+            return nil, err
+        }
+        if err := service.UpdateFirmwareFromURL(ctx, req, nil); err != nil {
+            return nil, err
+        }
+        return map[string]string{"message": "firmware update completed successfully"}, nil
+    }},
+    "connect_wifi": {OperationID: "connectNetwork", Role: auth.RoleOperator, Run: func(ctx context.Context, payload []byte, identity *auth.Identity) (interface{}, error) {
+        var req service.NetworkSelection
+        if err := decode(payload, &req); err != nil {
+            return nil, err
+        }
+        if err := service.ConnectNetwork(req); err != nil {
+            return nil, err
+        }
+        return map[string]string{"message": "connected to network"}, nil
+    }},
+}
+
+// commandClient — клиент команд MQTT в лимитах частоты и журнале аудита: адрес отправителя через брокер
+// неизвестен, поэтому все команды учитываются как от одного клиента. Блокировка после неверных токенов к нему
+// не применяется: она закрыла бы команды всем отправителям сразу.
+const commandClient = "mqtt"
+
+// decode разбирает аргументы команды
+func decode(payload []byte, v interface{}) error {
+    if err := json.Unmarshal(payload, v); err != nil {
+        return service.Invalid("invalid command payload", "")
+    }
+    return nil
+}
+
+// handleCommand выполняет команду из темы commands/<команда> и публикует ответ
+func (s *session) handleCommand(client paho.Client, msg paho.Message) {
+    name := strings.TrimPrefix(msg.Topic(), s.topic("commands/"))
+    // Сохраненная брокером команда выполнялась бы при каждом подключении
+    if msg.Retained() {
+        log.Printf("Ignoring retained mqtt command %s", name)
+        return
+    }
+
+    var header Command
+    if err := json.Unmarshal(msg.Payload(), &header); err != nil || header.ID == "" {
+        s.respond(name, "", nil, service.Invalid("command must be a JSON object with id", "id"))
+        return
+    }
+    if !s.recent.add(header.ID) {
+        log.Printf("Ignoring redelivered mqtt command %s %s", name, header.ID)
+        return
+    }
+
+    cmd, known := commands[name]
+    if !known {
+        s.respond(name, header.ID, nil, apierror.New(apierror.CodeNotFound, "unknown command "+name))
+        return
+    }
+
+    // Как и в HTTP API, отклоненные лимитом клиента команды не записываются в журнал аудита
+    if err := ratelimit.Limit(cmd.OperationID, commandClient); err != nil {
+        s.respond(name, header.ID, nil, err)
+        return
+    }
+
+    identity, err := auth.AuthorizeToken(header.Token, cmd.Role)
+    if err == nil {
+        err = ratelimit.CheckGlobal(cmd.OperationID)
+    }
+    var result interface{}
+    if err == nil {
+        if cmd.LongRunning {
+            s.publish("responses/"+name, qosReliable, false, Response{ID: header.ID, Command: name, Status: StatusAccepted, Time: time.Now()})
+        }
+        result, err = cmd.Run(s.ctx, msg.Payload(), identity)
+    }
+
+    s.respond(name, header.ID, result, err)
+    s.audit(msg, cmd, identity, err)
+}
+
+// respond публикует итог команды и учитывает его в метриках
+func (s *session) respond(name, id string, result interface{}, err error) {
+    response := Response{ID: id, Command: name, Status: StatusSucceeded, Result: result, Time: time.Now()}
+    outcome := audit.OutcomeSuccess
+    if err != nil {
+        response.Status = StatusFailed
+        response.Result = nil
+        response.Error = service.Classify(err, apierror.CodeInternal, "failed to execute "+name)
+        outcome = outcomeOf(response.Error.Code)
+    }
+
+    label := name
+    if _, known := commands[name]; !known {
+        label = "unknown"
+    }
+    commandsTotal.WithLabelValues(label, outcome).Inc()
+    s.publish("responses/"+name, qosReliable, false, response)
+}
+
+// audit записывает команду в журнал аудита так же, как изменяющий HTTP-запрос;
+// в Method записывается MQTT, в Path — тема команды
+func (s *session) audit(msg paho.Message, cmd command, identity *auth.Identity, err error) {
+    entry := audit.Entry{
+        Principal: "anonymous",
+        Client:    commandClient,
+        Action:    cmd.OperationID,
+        Method:    "MQTT",
+        Path:      msg.Topic(),
+        Status:    200,
+        Outcome:   audit.OutcomeSuccess,
+    }
+    // Аргументы команды записываются только для прошедших проверку токена, как в HTTP API
+    if identity != nil {
+        entry.Principal = identity.Name
+        entry.Role = identity.Role
+        entry.TokenID = identity.TokenID
+        entry.Payload = audit.Redact(msg.Payload())
+    }
+    if err != nil {
+        apiErr := service.Classify(err, apierror.CodeInternal, "failed to execute command")
+        entry.ErrorCode = apiErr.Code
+        entry.Status = apierror.Status(apiErr.Code)
+        entry.Outcome = outcomeOf(apiErr.Code)
+    }
+    audit.Log(entry)
+}
+
+// outcomeOf возвращает результат для журнала аудита и метрик по коду ошибки
+func outcomeOf(code string) string {
+    switch code {
+    case apierror.CodeAuthenticationRequired, apierror.CodeInvalidToken, apierror.CodeInsufficientRole:
+        return audit.OutcomeDenied
+    }
+    return audit.OutcomeFailure
+}
+
+// recentIDs помнит идентификаторы последних команд: брокер может доставить сообщение QoS 1 повторно
+type recentIDs struct {
+    mu    sync.Mutex
+    size  int
+    order []string
+    seen  map[string]bool
+}
+
+func newRecentIDs(size int) *recentIDs {
+    return &recentIDs{size: size, seen: make(map[string]bool)}
+}
+
+// add запоминает id и возвращает false, если он уже встречался
+func (r *recentIDs) add(id string) bool {
+    r.mu.Lock()
+    defer r.mu.Unlock()
+
+    if r.seen[id] {
+        return false
+    }
+    r.seen[id] = true
+    r.order = append(r.order, id)
+    if len(r.order) > r.size {
+        delete(r.seen, r.order[0])
+        r.order = r.order[1:]
+    }
+    return true
+}
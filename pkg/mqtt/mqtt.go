@@ -0,0 +1,322 @@
+// Package mqtt подключает servis к брокеру MQTT: публикует состояние устройства и события в дерево тем
+// устройства и выполняет команды, полученные из брокера.
+//
+// Дерево тем (корень — <topic_prefix>/<device_id>):
+//   - status — состояние устройства (Status) с флагом retain; при потере связи брокер публикует {"online": false};
+//   - events/<тема> — события шины (device, network, update, system);
+//   - commands/<команда> — команды (Command), на которые servis подписан;
+//   - responses/<команда> — ответы на команды (Response).
+package mqtt
+
+import (
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+    "strconv"
+    "sync/atomic"
+    "time"
+    "servis/pkg/audit"
+    "servis/pkg/config"
+    "servis/pkg/events"
+    "servis/pkg/health"
+    "servis/pkg/metrics"
+    "servis/pkg/scheduler"
+    "servis/pkg/selfupdate"
+    "servis/pkg/shutdown"
+    "servis/pkg/update"
+    "github.com/prometheus/client_golang/prometheus"
+    paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+    // qosReliable — уровень доставки состояния, команд и ответов: не меньше одного раза
+    qosReliable = 1
+    // qosEvents — уровень доставки событий: они повторяются в следующем состоянии, поэтому потеря допустима
+    qosEvents = 0
+    // publishTimeout — сколько ждать подтверждения публикации
+    publishTimeout = 5 * time.Second
+)
+
+var (
+    connectedGauge = metrics.Factory.NewGauge(prometheus.GaugeOpts{
+        Namespace: metrics.Namespace,
+        Subsystem: "mqtt",
+        Name:      "connected",
+        Help:      "1, если servis подключен к брокеру MQTT.",
+    })
+
+    commandsTotal = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+        Namespace: metrics.Namespace,
+        Subsystem: "mqtt",
+        Name:      "commands_total",
+        Help:      "Команды, полученные через MQTT, по результату (success, failure или denied).",
+    }, []string{"command", "result"})
+)
+
+// Status — состояние устройства в теме status
+type Status struct {
+    Online            bool                    `json:"online"`
+    Time              time.Time               `json:"time"`
+    Ready             string                  `json:"ready,omitempty"` // результат проверок готовности: ok или failing
+    Components        []update.InstalledFile  `json:"components,omitempty"`
+    UpdateJob         *update.Job             `json:"update_job,omitempty"`
+    UpdateInterrupted bool                    `json:"update_interrupted,omitempty"`
+    PendingPower      *shutdown.Pending       `json:"pending_power,omitempty"`
+    Maintenance       *scheduler.WindowStatus `json:"maintenance_window,omitempty"`
+    AuditHead         *audit.Head             `json:"audit_head,omitempty"` // последняя запись журнала аудита
+}
+
+// session — подключение к брокеру с настройками, действующими до их изменения
+type session struct {
+    client paho.Client
+    root   string
+    ctx    context.Context
+    recent *recentIDs
+    // handingOver — идет самообновление: команды принимает новый процесс
+    handingOver atomic.Bool
+}
+
+// Run поддерживает подключение к брокеру из конфигурации (mqtt.broker) и работает до отмены ctx.
+// Пока брокер не задан, ждет его появления; при изменении настроек MQTT переподключается.
+// Потерянное соединение восстанавливается клиентом MQTT автоматически.
+func Run(ctx context.Context) error {
+    changed := make(chan struct{}, 1)
+    unsubscribe := config.OnChange(func(old, new config.Config) {
+        if old.MQTT == new.MQTT {
+            return
+        }
+        select {
+        case changed <- struct{}{}:
+        default:
+        }
+    })
+    defer unsubscribe()
+
+    for {
+        settings := config.Get().MQTT
+        if settings.Broker == "" {
+            select {
+            case <-ctx.Done():
+                return nil
+            case <-changed:
+                continue
+            }
+        }
+
+        err := serve(ctx, settings, changed)
+        if err != nil {
+            return err
+        }
+        if ctx.Err() != nil {
+            return nil
+        }
+        log.Println("MQTT settings changed, reconnecting")
+    }
+}
+
+// serve работает с брокером до отмены ctx или изменения настроек
+func serve(ctx context.Context, settings config.MQTTConfig, changed <-chan struct{}) error {
+    deviceID := settings.DeviceID
+    if deviceID == "" {
+        hostname, err := os.Hostname()
+        if err != nil {
+            return fmt.Errorf("failed to get hostname for mqtt device id: %w", err)
+        }
+        deviceID = hostname
+    }
+
+    s := &session{root: settings.TopicPrefix + "/" + deviceID, ctx: ctx, recent: newRecentIDs(100)}
+    options, err := s.clientOptions(settings, deviceID)
+    if err != nil {
+        return err
+    }
+    s.client = paho.NewClient(options)
+
+    // Новый процесс подключается к брокеру до передачи ему работы; чтобы команду не выполнили оба процесса,
+    // текущий отписывается от команд еще до его запуска и подписывается снова, если новая версия не запустилась
+    cancelUpgrade := selfupdate.OnUpgrade(func(state string) {
+        switch state {
+        case selfupdate.StateRunning:
+            s.handingOver.Store(true)
+            s.unsubscribeCommands()
+        case selfupdate.StateRolledBack:
+            s.handingOver.Store(false)
+            s.subscribeCommands(s.client)
+        }
+    })
+    defer cancelUpgrade()
+
+    // Клиент подключается в фоне и повторяет попытки, пока брокер недоступен
+    s.client.Connect()
+    defer func() {
+        // После самообновления новый процесс уже сообщил, что устройство в сети
+        if s.client.IsConnectionOpen() && !selfupdate.HandedOff() {
+            s.publish("status", qosReliable, true, Status{Online: false, Time: time.Now()})
+        }
+        s.client.Disconnect(250)
+        connectedGauge.Set(0)
+    }()
+
+    sub, _, _ := events.Subscribe(nil, 0)
+    defer func() { sub.Close() }()
+
+    ticker := time.NewTicker(time.Duration(settings.StatusInterval))
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return nil
+        case <-changed:
+            return nil
+        case <-ticker.C:
+            s.publishStatus()
+        case event, ok := <-sub.C:
+            if !ok {
+                // Подписка закрыта, потому что публикация не успевала за событиями: часть событий пропущена
+                sub, _, _ = events.Subscribe(nil, 0)
+                continue
+            }
+            s.publishEvent(event)
+        }
+    }
+}
+
+// clientOptions возвращает настройки клиента MQTT: last will, TLS и обработчики подключения
+func (s *session) clientOptions(settings config.MQTTConfig, deviceID string) (*paho.ClientOptions, error) {
+    tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+    if settings.CAFile != "" {
+        data, err := os.ReadFile(settings.CAFile)
+        if err != nil {
+            return nil, fmt.Errorf("failed to read mqtt CA file: %w", err)
+        }
+        pool := x509.NewCertPool()
+        if !pool.AppendCertsFromPEM(data) {
+            return nil, fmt.Errorf("no certificates found in mqtt CA file %s", settings.CAFile)
+        }
+        tlsConfig.RootCAs = pool
+    }
+
+    // Идентификатор клиента включает pid: при самообновлении старый и новый процессы подключены одновременно,
+    // и с одним идентификатором брокер отключал бы их по очереди
+    will, _ := json.Marshal(Status{Online: false})
+    options := paho.NewClientOptions().
+        AddBroker(settings.Broker).
+        SetClientID("servis-"+deviceID+"-"+strconv.Itoa(os.Getpid())).
+        SetUsername(settings.Username).
+        SetPassword(settings.Password).
+        SetTLSConfig(tlsConfig).
+        SetWill(s.topic("status"), string(will), qosReliable, true).
+        // Команды, отправленные пока устройство было отключено, не выполняются после подключения:
+        // перезагрузка через несколько часов после запроса была бы неожиданной
+        SetCleanSession(true).
+        SetAutoReconnect(true).
+        SetConnectRetry(true).
+        SetConnectRetryInterval(10 * time.Second).
+        SetMaxReconnectInterval(time.Minute).
+        // Обработчики сообщений выполняются параллельно: обновление прошивки не задерживает другие команды
+        SetOrderMatters(false)
+
+    options.SetOnConnectHandler(func(client paho.Client) {
+        log.Printf("MQTT connected to %s as %s", settings.Broker, s.root)
+        connectedGauge.Set(1)
+        s.subscribeCommands(client)
+        s.publishStatus()
+    })
+    options.SetConnectionLostHandler(func(client paho.Client, err error) {
+        log.Printf("MQTT connection to %s lost: %v", settings.Broker, err)
+        connectedGauge.Set(0)
+    })
+    return options, nil
+}
+
+// subscribeCommands подписывается на темы команд, если команды не переданы новому процессу
+func (s *session) subscribeCommands(client paho.Client) {
+    if s.handingOver.Load() || selfupdate.HandedOff() || !client.IsConnectionOpen() {
+        return
+    }
+    token := client.Subscribe(s.topic("commands/+"), qosReliable, s.handleCommand)
+    if token.WaitTimeout(publishTimeout) && token.Error() != nil {
+        log.Printf("Failed to subscribe to mqtt commands: %v", token.Error())
+    }
+}
+
+// unsubscribeCommands отписывается от тем команд
+func (s *session) unsubscribeCommands() {
+    if !s.client.IsConnectionOpen() {
+        return
+    }
+    token := s.client.Unsubscribe(s.topic("commands/+"))
+    if token.WaitTimeout(publishTimeout) && token.Error() != nil {
+        log.Printf("Failed to unsubscribe from mqtt commands: %v", token.Error())
+    }
+}
+
+// topic возвращает полное имя темы в дереве устройства
+func (s *session) topic(name string) string {
+    return s.root + "/" + name
+}
+
+// publish отправляет value в формате JSON в тему name. Пока соединения нет, сообщение не отправляется:
+// состояние будет опубликовано заново после подключения.
+func (s *session) publish(name string, qos byte, retained bool, value interface{}) {
+    if !s.client.IsConnectionOpen() {
+        return
+    }
+    payload, err := json.Marshal(value)
+    if err != nil {
+        log.Printf("Failed to encode mqtt message for %s: %v", name, err)
+        return
+    }
+    token := s.client.Publish(s.topic(name), qos, retained, payload)
+    if qos > 0 && token.WaitTimeout(publishTimeout) && token.Error() != nil {
+        log.Printf("Failed to publish mqtt message to %s: %v", name, token.Error())
+    }
+}
+
+// publishEvent пересылает событие шины; после событий обновления и питания публикуется и новое состояние
+func (s *session) publishEvent(event events.Event) {
+    s.publish("events/"+event.Topic, qosEvents, false, event)
+    if event.Topic == events.TopicUpdate || event.Topic == events.TopicSystem {
+        s.publishStatus()
+    }
+}
+
+// publishStatus публикует текущее состояние устройства
+func (s *session) publishStatus() {
+    if !s.client.IsConnectionOpen() {
+        return
+    }
+    s.publish("status", qosReliable, true, currentStatus(s.ctx))
+}
+
+// currentStatus собирает состояние устройства; недоступные сведения пропускаются
+func currentStatus(ctx context.Context) Status {
+    status := Status{Online: true, Time: time.Now(), PendingPower: shutdown.Current()}
+
+    status.Ready = health.Ready(ctx).Status
+
+    settings := config.Get().Update
+    if installed, err := update.LoadInstalledVersions(settings.VersionFile); err == nil {
+        status.Components = installed.Files
+    }
+    if job, interrupted, err := update.CurrentJob(); err == nil {
+        status.UpdateJob = job
+        status.UpdateInterrupted = interrupted
+    }
+
+    window := scheduler.Window()
+    if window.Configured {
+        status.Maintenance = &window
+    }
+
+    // Сохраненные брокером номер и хеш позволяют позже обнаружить изменение или усечение журнала (audit/verify)
+    if head := audit.CurrentHead(); head.Seq > 0 {
+        status.AuditHead = &head
+    }
+    return status
+}
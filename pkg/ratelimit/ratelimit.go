@@ -0,0 +1,304 @@
+// Package ratelimit — ограничение частоты запросов и временная блокировка клиентов после повторных неудачных
+// попыток входа. Лимиты общие для HTTP API, gRPC и MQTT: действие — operationId эндпоинта, клиент — IP-адрес
+// (для Unix-сокета — unix:<uid>, для MQTT — mqtt). Лимиты читаются из конфигурации (rate_limit) при каждом запросе.
+//
+// До аутентификации проверяются только блокировка и лимиты самого клиента (Check), общие лимиты всех клиентов —
+// после нее (CheckGlobal): запросы без действующих учетных данных не расходуют общий запас остальных клиентов.
+package ratelimit
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "math"
+    "sync"
+    "time"
+    "servis/pkg/apierror"
+    "servis/pkg/audit"
+    "servis/pkg/config"
+    "servis/pkg/events"
+    "servis/pkg/metrics"
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+    ErrRateLimited = errors.New("too many requests")
+    ErrLockedOut   = errors.New("too many failed authentication attempts")
+)
+
+// LimitError — запрос отклонен лимитом или блокировкой; повторить его можно через RetryAfter
+type LimitError struct {
+    Err        error
+    RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+    return fmt.Sprintf("%v, retry after %d s", e.Err, e.Seconds())
+}
+
+func (e *LimitError) Unwrap() error {
+    return e.Err
+}
+
+// Seconds возвращает RetryAfter в целых секундах с округлением вверх, как в заголовке Retry-After
+func (e *LimitError) Seconds() int {
+    return int(math.Ceil(e.RetryAfter.Seconds()))
+}
+
+// Причины отказа в метрике servis_ratelimit_rejected_total
+const (
+    reasonRateLimited = "rate_limited"
+    reasonLockedOut   = "locked_out"
+)
+
+var (
+    rejectedTotal = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+        Namespace: metrics.Namespace,
+        Subsystem: "ratelimit",
+        Name:      "rejected_total",
+        Help:      "Запросы, отклоненные лимитом частоты или блокировкой клиента, по действию и причине.",
+    }, []string{"action", "reason"})
+
+    lockoutsTotal = metrics.Factory.NewCounter(prometheus.CounterOpts{
+        Namespace: metrics.Namespace,
+        Subsystem: "ratelimit",
+        Name:      "lockouts_total",
+        Help:      "Блокировки клиентов после повторных неудачных попыток входа.",
+    })
+)
+
+// sweepInterval — период удаления восполнившихся лимитов и истекших блокировок
+const sweepInterval = time.Minute
+
+var (
+    mu        sync.Mutex
+    buckets   = make(map[string]*bucket)
+    failures  = make(map[string]*failureState)
+    lastSweep time.Time
+)
+
+// bucket — число запросов, оставшееся клиенту или действию; восполняется равномерно за период лимита
+type bucket struct {
+    tokens float64
+    last   time.Time
+    full   time.Time // когда запас восполнится полностью; после этого запись можно удалить
+}
+
+// refill пересчитывает запас на момент now по лимиту rate
+func (b *bucket) refill(rate config.Rate, now time.Time) {
+    capacity := float64(rate.Count)
+    if b.last.IsZero() {
+        b.tokens = capacity
+    } else {
+        b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*capacity/rate.Period.Seconds())
+    }
+    b.last = now
+    b.updateFull(rate)
+}
+
+// wait возвращает, сколько ждать до следующего разрешенного запроса; 0 — запрос можно выполнить сейчас
+func (b *bucket) wait(rate config.Rate) time.Duration {
+    if b.tokens >= 1 {
+        return 0
+    }
+    return time.Duration((1 - b.tokens) * float64(rate.Period) / float64(rate.Count))
+}
+
+// take расходует один запрос
+func (b *bucket) take(rate config.Rate) {
+    b.tokens--
+    b.updateFull(rate)
+}
+
+func (b *bucket) updateFull(rate config.Rate) {
+    b.full = b.last.Add(time.Duration((float64(rate.Count) - b.tokens) * float64(rate.Period) / float64(rate.Count)))
+}
+
+// failureState — неудачные попытки входа клиента и срок его блокировки
+type failureState struct {
+    attempts    []time.Time
+    lockedUntil time.Time
+}
+
+// limit — лимит и запас, к которому он применяется
+type limit struct {
+    key  string
+    rate config.Rate
+}
+
+// Check проверяет, что client не заблокирован после неудачных попыток входа, и учитывает его запрос к действию
+// action в лимитах клиента (общем и rate_limit.routes.<action>.client). Вызывается до аутентификации.
+// Ошибка — *LimitError с ErrLockedOut или ErrRateLimited.
+func Check(action, client string) error {
+    if err := checkLockout(action, client); err != nil {
+        return err
+    }
+    return Limit(action, client)
+}
+
+// Limit учитывает запрос client к действию action в лимитах клиента, не проверяя блокировку. Нужен транспортам,
+// где клиент не отличим от остальных (команды MQTT): блокировка по такому ключу закрыла бы доступ всем.
+func Limit(action, client string) error {
+    settings := config.Get().RateLimit
+    limits := []limit{{"client:" + client, settings.Client}}
+    if route, ok := settings.Routes[action]; ok {
+        limits = append(limits, limit{"route:" + action + ":" + client, route.Client})
+    }
+    return allow(action, limits)
+}
+
+// CheckGlobal учитывает запрос к действию action в лимитах всех клиентов (global и rate_limit.routes.<action>.global).
+// Вызывается после аутентификации, поэтому эти лимиты расходуют только запросы с действующими учетными данными.
+func CheckGlobal(action string) error {
+    settings := config.Get().RateLimit
+    limits := []limit{{"global", settings.Global}}
+    if route, ok := settings.Routes[action]; ok {
+        limits = append(limits, limit{"route:" + action, route.Global})
+    }
+    return allow(action, limits)
+}
+
+// allow учитывает запрос к действию action в лимитах limits. Запрос должен уложиться во все лимиты, иначе
+// возвращается *LimitError с ErrRateLimited и временем до следующего разрешенного запроса; отклоненный запрос
+// не расходует лимиты.
+func allow(action string, limits []limit) error {
+    mu.Lock()
+    defer mu.Unlock()
+    now := time.Now()
+    sweep(now)
+
+    var retryAfter time.Duration
+    var active []*bucket
+    var rates []config.Rate
+    for _, l := range limits {
+        if l.rate.Unlimited() {
+            continue
+        }
+        b, ok := buckets[l.key]
+        if !ok {
+            b = &bucket{}
+            buckets[l.key] = b
+        }
+        b.refill(l.rate, now)
+        if wait := b.wait(l.rate); wait > retryAfter {
+            retryAfter = wait
+        }
+        active = append(active, b)
+        rates = append(rates, l.rate)
+    }
+
+    if retryAfter > 0 {
+        rejectedTotal.WithLabelValues(action, reasonRateLimited).Inc()
+        return &LimitError{Err: ErrRateLimited, RetryAfter: retryAfter}
+    }
+    for i, b := range active {
+        b.take(rates[i])
+    }
+    return nil
+}
+
+// checkLockout возвращает *LimitError с ErrLockedOut, если client заблокирован после неудачных попыток входа;
+// action — действие запроса для метрики отклоненных запросов
+func checkLockout(action, client string) error {
+    mu.Lock()
+    defer mu.Unlock()
+
+    state, ok := failures[client]
+    if !ok {
+        return nil
+    }
+    if wait := time.Until(state.lockedUntil); wait > 0 {
+        rejectedTotal.WithLabelValues(action, reasonLockedOut).Inc()
+        return &LimitError{Err: ErrLockedOut, RetryAfter: wait}
+    }
+    return nil
+}
+
+// AuthFailed учитывает неудачную попытку входа client (неверный токен, логин или пароль). После auth_failures
+// попыток за время lockout клиент блокируется на lockout; блокировка записывается в журнал аудита.
+func AuthFailed(client string) {
+    settings := config.Get().RateLimit
+    if settings.AuthFailures == 0 {
+        return
+    }
+    lockout := time.Duration(settings.Lockout)
+
+    mu.Lock()
+    now := time.Now()
+    state, ok := failures[client]
+    if !ok {
+        state = &failureState{}
+        failures[client] = state
+    }
+    if now.Before(state.lockedUntil) {
+        mu.Unlock()
+        return
+    }
+
+    // Учитываются только попытки за последние lockout
+    attempts := state.attempts[:0]
+    for _, at := range state.attempts {
+        if now.Sub(at) < lockout {
+            attempts = append(attempts, at)
+        }
+    }
+    state.attempts = append(attempts, now)
+    if len(state.attempts) < settings.AuthFailures {
+        mu.Unlock()
+        return
+    }
+    state.attempts = nil
+    state.lockedUntil = now.Add(lockout)
+    lockedUntil := state.lockedUntil
+    mu.Unlock()
+
+    lockoutsTotal.Inc()
+    log.Printf("Client %s locked out for %s after %d failed authentication attempts", client, lockout, settings.AuthFailures)
+    payload, _ := json.Marshal(map[string]interface{}{"failures": settings.AuthFailures, "locked_until": lockedUntil})
+    audit.Log(audit.Entry{Principal: "system", Client: client, Action: "auth_lockout", Payload: payload, Outcome: audit.OutcomeDenied})
+    events.Publish(events.TopicSystem, "client_locked_out", map[string]interface{}{"client": client, "locked_until": lockedUntil})
+}
+
+// IsAuthFailure сообщает, что код ошибки API означает неудачную попытку входа: переданы неверный токен,
+// логин или пароль. Запрос без учетных данных попыткой не считается.
+func IsAuthFailure(code string) bool {
+    return code == apierror.CodeInvalidToken || code == apierror.CodeInvalidCredentials
+}
+
+// AuthSucceeded сбрасывает счетчик неудачных попыток client после успешного входа по логину и паролю
+func AuthSucceeded(client string) {
+    mu.Lock()
+    defer mu.Unlock()
+
+    if state, ok := failures[client]; ok && !time.Now().Before(state.lockedUntil) {
+        delete(failures, client)
+    }
+}
+
+// sweep удаляет восполнившиеся лимиты и записи о неудачных попытках, которые больше не влияют на решения,
+// чтобы память не росла с числом клиентов. Вызывается под mu.
+func sweep(now time.Time) {
+    if now.Sub(lastSweep) < sweepInterval {
+        return
+    }
+    lastSweep = now
+
+    for key, b := range buckets {
+        if now.After(b.full) {
+            delete(buckets, key)
+        }
+    }
+    lockout := time.Duration(config.Get().RateLimit.Lockout)
+    for client, state := range failures {
+        expired := now.After(state.lockedUntil)
+        for _, at := range state.attempts {
+            if now.Sub(at) < lockout {
+                expired = false
+            }
+        }
+        if expired {
+            delete(failures, client)
+        }
+    }
+}
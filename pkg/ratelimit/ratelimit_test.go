@@ -0,0 +1,212 @@
+package ratelimit
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+    "servis/pkg/audit"
+    "servis/pkg/config"
+)
+
+// setupLimits загружает настройки rate_limit из JSON, направляет журнал аудита во временный каталог
+// и сбрасывает состояние лимитов
+func setupLimits(t *testing.T, rateLimit string) {
+    t.Helper()
+    dir := t.TempDir()
+    path := filepath.Join(dir, "config.json")
+    if err := os.WriteFile(path, []byte(`{"rate_limit": `+rateLimit+`}`), 0600); err != nil {
+        t.Fatal(err)
+    }
+    if err := config.Init([]string{"-config", path}); err != nil {
+        t.Fatalf("config.Init: %v", err)
+    }
+
+    oldPath, oldKey, oldHead := audit.Path, audit.KeyPath, audit.HeadPath
+    audit.Path = filepath.Join(dir, "audit.log")
+    audit.KeyPath = filepath.Join(dir, "audit.key")
+    audit.HeadPath = filepath.Join(dir, "audit.head")
+    reset()
+    t.Cleanup(func() {
+        audit.Path, audit.KeyPath, audit.HeadPath = oldPath, oldKey, oldHead
+        reset()
+    })
+}
+
+// reset забывает лимиты и неудачные попытки, как после перезапуска
+func reset() {
+    mu.Lock()
+    defer mu.Unlock()
+    buckets = make(map[string]*bucket)
+    failures = make(map[string]*failureState)
+    lastSweep = time.Time{}
+}
+
+// limitErr проверяет, что err — *LimitError с причиной want, и возвращает его
+func limitErr(t *testing.T, err, want error) *LimitError {
+    t.Helper()
+    var limitErr *LimitError
+    if !errors.As(err, &limitErr) || !errors.Is(err, want) {
+        t.Fatalf("error %v, want %v", err, want)
+    }
+    if limitErr.RetryAfter <= 0 {
+        t.Fatalf("RetryAfter %s, want positive", limitErr.RetryAfter)
+    }
+    return limitErr
+}
+
+func TestBucketRefill(t *testing.T) {
+    rate := config.Rate{Count: 10, Period: time.Minute}
+    start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+    tests := []struct {
+        name    string
+        taken   int
+        elapsed time.Duration
+        tokens  float64
+        wait    time.Duration
+    }{
+        {"new bucket is full", 0, 0, 10, 0},
+        {"one taken", 1, 0, 9, 0},
+        {"all taken", 10, 0, 0, 6 * time.Second},
+        {"part of a request restored", 10, 3 * time.Second, 0.5, 3 * time.Second},
+        {"one request restored", 10, 6 * time.Second, 1, 0},
+        {"capped at capacity", 10, time.Hour, 10, 0},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            b := &bucket{}
+            b.refill(rate, start)
+            for i := 0; i < test.taken; i++ {
+                b.take(rate)
+            }
+            b.refill(rate, start.Add(test.elapsed))
+
+            if b.tokens != test.tokens {
+                t.Fatalf("tokens %v, want %v", b.tokens, test.tokens)
+            }
+            if wait := b.wait(rate); wait != test.wait {
+                t.Fatalf("wait %s, want %s", wait, test.wait)
+            }
+            wantFull := b.last.Add(time.Duration((10 - test.tokens) * float64(6*time.Second)))
+            if !b.full.Equal(wantFull) {
+                t.Fatalf("full at %s, want %s", b.full, wantFull)
+            }
+        })
+    }
+}
+
+func TestCheckClientLimits(t *testing.T) {
+    setupLimits(t, `{"client": "3/1m", "global": "", "routes": {"connectNetwork": {"client": "1/1m"}}}`)
+
+    for i := 0; i < 3; i++ {
+        if err := Check("getStatus", "10.0.0.1"); err != nil {
+            t.Fatalf("request %d: %v", i+1, err)
+        }
+    }
+    if err := limitErr(t, Check("getStatus", "10.0.0.1"), ErrRateLimited); err.Seconds() != 20 {
+        t.Fatalf("retry after %d s, want 20", err.Seconds())
+    }
+    // Лимит клиента не затрагивает остальных клиентов
+    if err := Check("getStatus", "10.0.0.2"); err != nil {
+        t.Fatalf("other client: %v", err)
+    }
+}
+
+func TestCheckRejectionDoesNotConsume(t *testing.T) {
+    setupLimits(t, `{"client": "3/1m", "global": "", "routes": {"connectNetwork": {"client": "1/1m"}}}`)
+
+    if err := Check("connectNetwork", "10.0.0.1"); err != nil {
+        t.Fatal(err)
+    }
+    // Отклоненный лимитом действия запрос не расходует общий лимит клиента
+    for i := 0; i < 3; i++ {
+        limitErr(t, Check("connectNetwork", "10.0.0.1"), ErrRateLimited)
+    }
+    for i := 0; i < 2; i++ {
+        if err := Check("getStatus", "10.0.0.1"); err != nil {
+            t.Fatalf("request %d after rejected ones: %v", i+1, err)
+        }
+    }
+    limitErr(t, Check("getStatus", "10.0.0.1"), ErrRateLimited)
+}
+
+func TestCheckGlobalSharedByClients(t *testing.T) {
+    setupLimits(t, `{"client": "", "global": "2/1m", "routes": {"login": {"client": "1/1m"}}}`)
+
+    // Запросы до аутентификации не расходуют общий лимит
+    for _, client := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+        if err := Check("getStatus", client); err != nil {
+            t.Fatalf("client %s: %v", client, err)
+        }
+    }
+    if err := CheckGlobal("getStatus"); err != nil {
+        t.Fatal(err)
+    }
+    if err := CheckGlobal("reboot"); err != nil {
+        t.Fatal(err)
+    }
+    limitErr(t, CheckGlobal("getStatus"), ErrRateLimited)
+    // Лимит клиента действует независимо от общего
+    if err := Check("login", "10.0.0.4"); err != nil {
+        t.Fatalf("login: %v", err)
+    }
+}
+
+func TestAuthFailedLocksOutClient(t *testing.T) {
+    setupLimits(t, `{"client": "", "global": "", "auth_failures": 3, "lockout": "15m"}`)
+
+    for i := 0; i < 2; i++ {
+        AuthFailed("10.0.0.1")
+        if err := Check("getStatus", "10.0.0.1"); err != nil {
+            t.Fatalf("after %d failures: %v", i+1, err)
+        }
+    }
+    AuthFailed("10.0.0.1")
+    if err := limitErr(t, Check("getStatus", "10.0.0.1"), ErrLockedOut); err.RetryAfter > 15*time.Minute {
+        t.Fatalf("retry after %s, want at most 15m", err.RetryAfter)
+    }
+
+    // Успешный вход не снимает действующую блокировку
+    AuthSucceeded("10.0.0.1")
+    limitErr(t, Check("getStatus", "10.0.0.1"), ErrLockedOut)
+
+    if err := Check("getStatus", "10.0.0.2"); err != nil {
+        t.Fatalf("other client: %v", err)
+    }
+    // Limit блокировку не проверяет
+    if err := Limit("getStatus", "10.0.0.1"); err != nil {
+        t.Fatalf("Limit for locked out client: %v", err)
+    }
+
+    result := audit.Verify(nil)
+    entries, err := audit.Find(audit.Query{})
+    if err != nil || !result.Valid || len(entries) != 1 || entries[0].Action != "auth_lockout" {
+        t.Fatalf("audit entries %+v (%v), verify %+v, want one auth_lockout", entries, err, result)
+    }
+}
+
+func TestAuthSucceededResetsFailures(t *testing.T) {
+    setupLimits(t, `{"client": "", "global": "", "auth_failures": 3, "lockout": "15m"}`)
+
+    AuthFailed("10.0.0.1")
+    AuthFailed("10.0.0.1")
+    AuthSucceeded("10.0.0.1")
+    AuthFailed("10.0.0.1")
+    AuthFailed("10.0.0.1")
+    if err := Check("getStatus", "10.0.0.1"); err != nil {
+        t.Fatalf("failures before successful login were counted: %v", err)
+    }
+}
+
+func TestAuthFailedDisabled(t *testing.T) {
+    setupLimits(t, `{"client": "", "global": "", "auth_failures": 0}`)
+
+    for i := 0; i < 10; i++ {
+        AuthFailed("10.0.0.1")
+    }
+    if err := Check("getStatus", "10.0.0.1"); err != nil {
+        t.Fatalf("auth_failures 0 locked out the client: %v", err)
+    }
+}
@@ -1,12 +1,80 @@
 package rtc
 
 import (
+    "context"
     "fmt"
     "io/ioutil"
+    "os"
     "os/exec"
+    "path/filepath"
+    "strconv"
     "strings"
+    "sync/atomic"
+    "time"
+    "servis/pkg/config"
+    "servis/pkg/health"
+    "servis/pkg/metrics"
+    "github.com/prometheus/client_golang/prometheus"
 )
 
+// SysfsPath — каталог модуля RTC в sysfs
+var SysfsPath = "/sys/class/rtc/rtc0"
+
+// NTPSyncedPath — файл, который systemd-timesyncd создает после синхронизации времени по NTP
+var NTPSyncedPath = "/run/systemd/timesync/synchronized"
+
+// driftCollector читает расхождение RTC с системным временем при каждом опросе метрик.
+// Если модуля RTC нет, метрика не отдается.
+type driftCollector struct {
+    desc *prometheus.Desc
+}
+
+func (c driftCollector) Describe(ch chan<- *prometheus.Desc) {
+    ch <- c.desc
+}
+
+func (c driftCollector) Collect(ch chan<- prometheus.Metric) {
+    drift, err := Drift()
+    if err != nil {
+        return
+    }
+    ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, drift.Seconds())
+}
+
+// configured — ConfigureRTC завершилась успешно
+var configured atomic.Bool
+
+func init() {
+    metrics.Registry.MustRegister(driftCollector{desc: prometheus.NewDesc(
+        prometheus.BuildFQName(metrics.Namespace, "rtc", "drift_seconds"),
+        "Расхождение времени RTC с системным: положительное — RTC спешит (точность 1 с).",
+        nil, nil,
+    )})
+    health.Register(health.Check{Name: "rtc", Run: checkRTC})
+}
+
+// checkRTC проверяет, что модуль RTC настроен; расхождение с системным временем передается в сведениях
+func checkRTC(ctx context.Context) (health.Details, error) {
+    if !configured.Load() {
+        return nil, fmt.Errorf("RTC is not configured")
+    }
+    details := health.Details{}
+    if drift, err := Drift(); err == nil {
+        details["drift_seconds"] = drift.Seconds()
+    }
+    return details, nil
+}
+
+// ClockValid сообщает, можно ли доверять системному времени: модуль RTC настроен или время синхронизировано по NTP.
+// До этого часы устройства без батарейки могут отставать на месяцы, и действия по расписанию выполнять нельзя.
+func ClockValid() bool {
+    if configured.Load() {
+        return true
+    }
+    _, err := os.Stat(NTPSyncedPath)
+    return err == nil
+}
+
 // RunCommand выполняет команду в shell
 func RunCommand(name string, args ...string) (string, error) {
     cmd := exec.Command(name, args...)
@@ -16,7 +84,7 @@ func RunCommand(name string, args ...string) (string, error) {
 
 // EnableI2C включает интерфейс I2C
 func EnableI2C() error {
-    configFile := "/boot/config.txt"
+    configFile := config.Get().RTC.BootConfig
     content, err := ioutil.ReadFile(configFile)
     if err != nil {
         return err
@@ -75,6 +143,20 @@ func SyncTime() error {
     return err
 }
 
+// Drift возвращает, на сколько время RTC опережает системное; отрицательное значение — RTC отстает.
+// RTC хранит время с точностью до секунды.
+func Drift() (time.Duration, error) {
+    content, err := ioutil.ReadFile(filepath.Join(SysfsPath, "since_epoch"))
+    if err != nil {
+        return 0, fmt.Errorf("failed to read RTC time: %w", err)
+    }
+    seconds, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+    if err != nil {
+        return 0, fmt.Errorf("invalid RTC time %q: %w", strings.TrimSpace(string(content)), err)
+    }
+    return time.Unix(seconds, 0).Sub(time.Now().Truncate(time.Second)), nil
+}
+
 // ConfigureRTC настраивает модуль RTC без перезагрузки
 func ConfigureRTC() error {
     err := EnableI2C()
@@ -97,5 +179,6 @@ func ConfigureRTC() error {
         return fmt.Errorf("failed to sync time with RTC: %w", err)
     }
 
+    configured.Store(true)
     return nil
 }
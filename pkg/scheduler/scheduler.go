@@ -0,0 +1,428 @@
+// Package scheduler выключает и перезагружает устройство по расписанию: однократно в заданное время
+// или повторно по выражению cron. Расписания хранятся в файле и переживают перезапуск servis.
+// Если задано окно обслуживания, действие, время которого пришлось на закрытое окно, откладывается до его открытия.
+package scheduler
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+    "servis/pkg/audit"
+    "servis/pkg/cron"
+    "servis/pkg/events"
+    "servis/pkg/metrics"
+    "servis/pkg/rtc"
+    "servis/pkg/shutdown"
+    "servis/pkg/update"
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+// StorePath — файл с расписаниями
+var StorePath = "/root/dt_backend/schedules.json"
+
+// TickInterval — период проверки расписаний. Время каждый раз берется из системных часов,
+// поэтому скачок часов после синхронизации с RTC или NTP учитывается не позже чем через TickInterval.
+var TickInterval = 30 * time.Second
+
+// MissedGrace — насколько может опоздать действие, например если устройство было выключено.
+// Пропущенное на большее время действие не выполняется, чтобы устройство не перезагрузилось неожиданно.
+var MissedGrace = 15 * time.Minute
+
+// MaxAhead — насколько вперед можно запланировать однократное действие
+const MaxAhead = 366 * 24 * time.Hour
+
+// Результаты последнего срабатывания
+const (
+    ResultScheduled = "scheduled" // действие передано в shutdown
+    ResultSkipped   = "skipped"   // другое действие уже было запланировано
+    ResultDeferred  = "deferred"  // ждет открытия окна обслуживания
+    ResultWaiting   = "waiting"   // ждет завершения обновления прошивки
+    ResultMissed    = "missed"    // время прошло, пока servis не работал
+)
+
+var (
+    ErrInvalidSchedule = errors.New("invalid schedule")
+    ErrNotFound        = errors.New("schedule not found")
+    ErrClockNotSynced  = errors.New("system clock is not synchronized with RTC or NTP")
+)
+
+var runsTotal = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+    Namespace: metrics.Namespace,
+    Subsystem: "scheduler",
+    Name:      "runs_total",
+    Help:      "Срабатывания расписаний по действию и результату (scheduled, skipped, missed).",
+}, []string{"action", "result"})
+
+// Schedule — расписание действия. Задается либо At, либо Cron.
+type Schedule struct {
+    ID         string     `json:"id"`
+    Action     string     `json:"action"`         // shutdown или reboot
+    At         *time.Time `json:"at,omitempty"`   // время однократного выполнения
+    Cron       string     `json:"cron,omitempty"` // выражение cron повторного выполнения (местное время устройства)
+    CreatedBy  string     `json:"created_by"`
+    CreatedAt  time.Time  `json:"created_at"`
+    NextRun    *time.Time `json:"next_run,omitempty"` // следующее время по расписанию
+    RunAt      *time.Time `json:"run_at,omitempty"`   // когда действие будет выполнено с учетом окна обслуживания
+    LastRun    *time.Time `json:"last_run,omitempty"`
+    LastResult string     `json:"last_result,omitempty"`
+    LastError  string     `json:"last_error,omitempty"`
+}
+
+var (
+    mu        sync.Mutex
+    loaded    bool
+    schedules []Schedule
+    wake      = make(chan struct{}, 1)
+)
+
+// load читает расписания с диска при первом обращении; вызывается с захваченным mu
+func load() error {
+    if loaded {
+        return nil
+    }
+
+    data, err := ioutil.ReadFile(StorePath)
+    if os.IsNotExist(err) {
+        loaded = true
+        return nil
+    }
+    if err != nil {
+        return fmt.Errorf("failed to read schedules: %w", err)
+    }
+
+    var stored []Schedule
+    err = json.Unmarshal(data, &stored)
+    if err != nil {
+        return fmt.Errorf("failed to unmarshal schedules: %w", err)
+    }
+    schedules, loaded = stored, true
+    return nil
+}
+
+// save атомарно записывает расписания на диск; вызывается с захваченным mu
+func save(list []Schedule) error {
+    data, err := json.MarshalIndent(list, "", "  ")
+    if err != nil {
+        return fmt.Errorf("failed to marshal schedules: %w", err)
+    }
+
+    err = os.MkdirAll(filepath.Dir(StorePath), 0700)
+    if err != nil {
+        return fmt.Errorf("failed to create schedules directory: %w", err)
+    }
+
+    tmp := StorePath + ".tmp"
+    err = ioutil.WriteFile(tmp, data, 0600)
+    if err != nil {
+        return fmt.Errorf("failed to write schedules: %w", err)
+    }
+    err = os.Rename(tmp, StorePath)
+    if err != nil {
+        return fmt.Errorf("failed to replace schedules: %w", err)
+    }
+
+    schedules = list
+    return nil
+}
+
+// List возвращает расписания
+func List() ([]Schedule, error) {
+    mu.Lock()
+    defer mu.Unlock()
+
+    if err := load(); err != nil {
+        return nil, err
+    }
+
+    w := currentWindow()
+    list := make([]Schedule, 0, len(schedules))
+    for _, s := range schedules {
+        if s.NextRun != nil {
+            runAt := *s.NextRun
+            if w != nil {
+                runAt = w.nextOpen(runAt)
+            }
+            s.RunAt = &runAt
+        }
+        list = append(list, s)
+    }
+    return list, nil
+}
+
+// Create проверяет и сохраняет расписание. Пока время устройства не подтверждено RTC или NTP,
+// расписания не создаются: время в них было бы отсчитано от неверных часов.
+func Create(s Schedule, createdBy string) (*Schedule, error) {
+    if s.Action != shutdown.ActionShutdown && s.Action != shutdown.ActionReboot {
+        return nil, fmt.Errorf("%w: action must be %s or %s", ErrInvalidSchedule, shutdown.ActionShutdown, shutdown.ActionReboot)
+    }
+    if (s.At == nil) == (s.Cron == "") {
+        return nil, fmt.Errorf("%w: exactly one of at and cron must be set", ErrInvalidSchedule)
+    }
+    if !rtc.ClockValid() {
+        return nil, ErrClockNotSynced
+    }
+
+    now := time.Now()
+    var next time.Time
+    if s.At != nil {
+        next = *s.At
+        if !next.After(now) || next.Sub(now) > MaxAhead {
+            return nil, fmt.Errorf("%w: at must be in the future and within %s", ErrInvalidSchedule, MaxAhead)
+        }
+    } else {
+        expr, err := cron.Parse(s.Cron)
+        if err != nil {
+            return nil, fmt.Errorf("%w: %v", ErrInvalidSchedule, err)
+        }
+        next = expr.Next(now)
+        if next.IsZero() {
+            return nil, fmt.Errorf("%w: cron expression %q never fires", ErrInvalidSchedule, s.Cron)
+        }
+        s.Cron = expr.String()
+    }
+
+    id := make([]byte, 4)
+    if _, err := rand.Read(id); err != nil {
+        return nil, fmt.Errorf("failed to generate schedule id: %w", err)
+    }
+    created := Schedule{
+        ID:        hex.EncodeToString(id),
+        Action:    s.Action,
+        At:        s.At,
+        Cron:      s.Cron,
+        CreatedBy: createdBy,
+        CreatedAt: now,
+        NextRun:   &next,
+    }
+
+    mu.Lock()
+    defer mu.Unlock()
+
+    if err := load(); err != nil {
+        return nil, err
+    }
+    if err := save(append(append([]Schedule(nil), schedules...), created)); err != nil {
+        return nil, err
+    }
+
+    runAt := next
+    if w := currentWindow(); w != nil {
+        runAt = w.nextOpen(next)
+    }
+    created.RunAt = &runAt
+
+    log.Printf("Schedule %s created by %s: %s at %s", created.ID, createdBy, created.Action, runAt.Format(time.RFC3339))
+    events.Publish(events.TopicSystem, "schedule_created", created)
+    notify()
+    return &created, nil
+}
+
+// Delete удаляет расписание
+func Delete(id, deletedBy string) error {
+    mu.Lock()
+    defer mu.Unlock()
+
+    if err := load(); err != nil {
+        return err
+    }
+
+    list := make([]Schedule, 0, len(schedules))
+    for _, s := range schedules {
+        if s.ID != id {
+            list = append(list, s)
+        }
+    }
+    if len(list) == len(schedules) {
+        return fmt.Errorf("%w: %s", ErrNotFound, id)
+    }
+    if err := save(list); err != nil {
+        return err
+    }
+
+    log.Printf("Schedule %s deleted by %s", id, deletedBy)
+    events.Publish(events.TopicSystem, "schedule_deleted", map[string]string{"id": id, "deleted_by": deletedBy})
+    return nil
+}
+
+// notify будит Run, чтобы новое расписание было учтено без ожидания TickInterval
+func notify() {
+    select {
+    case wake <- struct{}{}:
+    default:
+    }
+}
+
+// Run проверяет расписания каждые TickInterval, пока не отменен ctx
+func Run(ctx context.Context) error {
+    mu.Lock()
+    err := load()
+    mu.Unlock()
+    if err != nil {
+        return err
+    }
+
+    ticker := time.NewTicker(TickInterval)
+    defer ticker.Stop()
+
+    log.Println("Scheduler started")
+    clockWarned := false
+    for {
+        // Пока часы не подтверждены, сравнивать с ними время расписаний нельзя
+        if rtc.ClockValid() {
+            clockWarned = false
+            for _, s := range check(time.Now()) {
+                run(s)
+            }
+        } else if !clockWarned {
+            log.Println("Scheduler is waiting for the clock to be synchronized with RTC or NTP")
+            clockWarned = true
+        }
+
+        select {
+        case <-ctx.Done():
+            log.Println("Scheduler stopped")
+            return nil
+        case <-ticker.C:
+        case <-wake:
+        }
+    }
+}
+
+// check обновляет состояние расписаний и возвращает те, действие которых нужно выполнить сейчас
+func check(now time.Time) []Schedule {
+    mu.Lock()
+    defer mu.Unlock()
+
+    w := currentWindow()
+    list := append([]Schedule(nil), schedules...)
+    var due []Schedule
+    changed := false
+    for i := 0; i < len(list); i++ {
+        s := &list[i]
+        if s.NextRun == nil || now.Before(*s.NextRun) {
+            continue
+        }
+
+        // Опоздание без отложенного ожидания означает, что servis не работал в назначенное время
+        waited := s.LastResult == ResultDeferred || s.LastResult == ResultWaiting
+        if !waited && now.Sub(*s.NextRun) > MissedGrace {
+            log.Printf("Schedule %s missed %s at %s", s.ID, s.Action, s.NextRun.Format(time.RFC3339))
+            events.Publish(events.TopicSystem, "schedule_missed", map[string]string{"id": s.ID, "action": s.Action, "next_run": s.NextRun.Format(time.RFC3339)})
+            runsTotal.WithLabelValues(s.Action, ResultMissed).Inc()
+            s.LastResult = ResultMissed
+            if advance(s, now) {
+                list = append(list[:i], list[i+1:]...)
+                i--
+            }
+            changed = true
+            continue
+        }
+
+        if w != nil && w.openAt(now).IsZero() {
+            if s.LastResult != ResultDeferred {
+                opensAt := w.start.Next(now)
+                log.Printf("Schedule %s deferred until maintenance window opens at %s", s.ID, opensAt.Format(time.RFC3339))
+                events.Publish(events.TopicSystem, "schedule_deferred", map[string]string{"id": s.ID, "action": s.Action, "opens_at": opensAt.Format(time.RFC3339)})
+                s.LastResult = ResultDeferred
+                changed = true
+            }
+            continue
+        }
+
+        // Выключение во время установки прошивки оставило бы прерванную операцию
+        if job, interrupted, _ := update.CurrentJob(); job != nil && !interrupted {
+            if s.LastResult != ResultWaiting {
+                log.Printf("Schedule %s is waiting for %s operation %s to finish", s.ID, job.Operation, job.ID)
+                s.LastResult = ResultWaiting
+                changed = true
+            }
+            continue
+        }
+
+        due = append(due, *s)
+        lastRun := now
+        s.LastRun = &lastRun
+        s.LastResult, s.LastError = ResultScheduled, ""
+        if advance(s, now) {
+            list = append(list[:i], list[i+1:]...)
+            i--
+        }
+        changed = true
+    }
+
+    if !changed {
+        return nil
+    }
+    // Состояние сохраняется до выполнения: если запись не удалась, однократная перезагрузка
+    // после старта сработала бы снова
+    if err := save(list); err != nil {
+        log.Printf("Failed to save schedules, postponing due actions: %v", err)
+        return nil
+    }
+    return due
+}
+
+// advance переводит расписание на следующее время; true — однократное расписание выполнено и удаляется
+func advance(s *Schedule, now time.Time) bool {
+    if s.At != nil {
+        return true
+    }
+    expr, err := cron.Parse(s.Cron)
+    if err != nil {
+        log.Printf("Schedule %s has invalid cron expression: %v", s.ID, err)
+        return true
+    }
+    next := expr.Next(now)
+    if next.IsZero() {
+        return true
+    }
+    s.NextRun = &next
+    return false
+}
+
+// run передает действие в shutdown; у пользователя остается NotifyDelay, чтобы отменить его через /power/pending
+func run(s Schedule) {
+    principal := "scheduler:" + s.ID
+    _, err := shutdown.Schedule(s.Action, 0, principal)
+
+    result, outcome := ResultScheduled, audit.OutcomeSuccess
+    data := map[string]string{"id": s.ID, "action": s.Action, "created_by": s.CreatedBy}
+    if err != nil {
+        log.Printf("Schedule %s skipped %s: %v", s.ID, s.Action, err)
+        result, outcome = ResultSkipped, audit.OutcomeFailure
+        data["error"] = err.Error()
+        recordError(s.ID, err)
+    }
+    data["result"] = result
+
+    runsTotal.WithLabelValues(s.Action, result).Inc()
+    events.Publish(events.TopicSystem, "schedule_fired", data)
+    payload, _ := json.Marshal(data)
+    audit.Log(audit.Entry{Principal: principal, Action: "scheduled_" + s.Action, Payload: payload, Outcome: outcome})
+}
+
+// recordError сохраняет причину, по которой сработавшее расписание не выполнилось
+func recordError(id string, cause error) {
+    mu.Lock()
+    defer mu.Unlock()
+
+    list := append([]Schedule(nil), schedules...)
+    for i := range list {
+        if list[i].ID == id {
+            list[i].LastResult, list[i].LastError = ResultSkipped, cause.Error()
+            if err := save(list); err != nil {
+                log.Printf("Failed to save schedules: %v", err)
+            }
+            return
+        }
+    }
+}
@@ -0,0 +1,136 @@
+package scheduler
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+    "servis/pkg/config"
+    "servis/pkg/update"
+)
+
+// setupScheduler загружает конфигурацию с окном обслуживания maintenance (JSON) и задает расписания
+// в памяти; файлы расписаний и блокировки обновления — во временном каталоге
+func setupScheduler(t *testing.T, maintenance string, list ...Schedule) {
+    t.Helper()
+    dir := t.TempDir()
+    path := filepath.Join(dir, "config.json")
+    if err := os.WriteFile(path, []byte(`{"maintenance": `+maintenance+`}`), 0600); err != nil {
+        t.Fatal(err)
+    }
+    if err := config.Init([]string{"-config", path}); err != nil {
+        t.Fatalf("config.Init: %v", err)
+    }
+
+    oldStore, oldLock := StorePath, update.LockFilePath
+    StorePath = filepath.Join(dir, "schedules.json")
+    update.LockFilePath = filepath.Join(dir, "update.lock")
+    mu.Lock()
+    schedules, loaded = list, true
+    mu.Unlock()
+    t.Cleanup(func() {
+        StorePath, update.LockFilePath = oldStore, oldLock
+        mu.Lock()
+        schedules, loaded = nil, false
+        mu.Unlock()
+    })
+}
+
+// stored возвращает расписания после check
+func stored() []Schedule {
+    mu.Lock()
+    defer mu.Unlock()
+    return append([]Schedule(nil), schedules...)
+}
+
+func timePtr(t time.Time) *time.Time {
+    return &t
+}
+
+func TestCheck(t *testing.T) {
+    now := utc(t, "2026-10-20 12:00:30")
+    once := func(nextRun time.Time) Schedule {
+        return Schedule{ID: "once", Action: "reboot", At: timePtr(nextRun), NextRun: timePtr(nextRun)}
+    }
+    daily := func(nextRun time.Time) Schedule {
+        return Schedule{ID: "daily", Action: "reboot", Cron: "0 12 * * *", NextRun: timePtr(nextRun)}
+    }
+    tomorrow := utc(t, "2026-10-21 12:00:00")
+
+    tests := []struct {
+        name     string
+        schedule Schedule
+        due      bool
+        result   string
+        nextRun  *time.Time // nil — расписание удалено
+    }{
+        {"one-time not yet", once(now.Add(time.Minute)), false, "", timePtr(now.Add(time.Minute))},
+        {"one-time due", once(now.Add(-30 * time.Second)), true, ResultScheduled, nil},
+        {"one-time within grace", once(now.Add(-MissedGrace)), true, ResultScheduled, nil},
+        {"one-time missed", once(now.Add(-MissedGrace - time.Second)), false, ResultMissed, nil},
+        {"cron due", daily(utc(t, "2026-10-20 12:00:00")), true, ResultScheduled, &tomorrow},
+        {"cron missed", daily(utc(t, "2026-10-20 11:30:00")), false, ResultMissed, &tomorrow},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            setupScheduler(t, `{}`, test.schedule)
+
+            due := check(now)
+            if got := len(due) == 1 && due[0].ID == test.schedule.ID; got != test.due || len(due) > 1 {
+                t.Fatalf("due %+v, want due %v", due, test.due)
+            }
+
+            list := stored()
+            if test.nextRun == nil {
+                if len(list) != 0 {
+                    t.Fatalf("schedules %+v, want the one-time schedule removed", list)
+                }
+                return
+            }
+            if len(list) != 1 || !list[0].NextRun.Equal(*test.nextRun) || list[0].LastResult != test.result {
+                t.Fatalf("schedule %+v, want next run %s and result %q", list, test.nextRun, test.result)
+            }
+        })
+    }
+}
+
+func TestCheckDefersUntilWindowOpens(t *testing.T) {
+    // Окно по субботам 02:00–04:00; действие назначено на вторник
+    nextRun := utc(t, "2026-10-20 12:00:00")
+    setupScheduler(t, `{"window": "0 2 * * 6", "window_duration": "2h"}`,
+        Schedule{ID: "once", Action: "reboot", At: timePtr(nextRun), NextRun: timePtr(nextRun)})
+
+    if due := check(nextRun); len(due) != 0 {
+        t.Fatalf("due %+v while the window is closed", due)
+    }
+    if list := stored(); len(list) != 1 || list[0].LastResult != ResultDeferred {
+        t.Fatalf("schedules %+v, want deferred", list)
+    }
+
+    // Ожидание окна дольше MissedGrace не считается пропуском
+    if due := check(utc(t, "2026-10-24 01:59:00")); len(due) != 0 {
+        t.Fatalf("due %+v before the window opens", due)
+    }
+    due := check(utc(t, "2026-10-24 02:00:00"))
+    if len(due) != 1 || due[0].ID != "once" {
+        t.Fatalf("due %+v when the window opens, want the deferred schedule", due)
+    }
+    if list := stored(); len(list) != 0 {
+        t.Fatalf("schedules %+v, want the one-time schedule removed", list)
+    }
+}
+
+func TestCheckMissedWhileWindowClosed(t *testing.T) {
+    // Без отложенного ожидания опоздание больше MissedGrace — пропуск, даже если окно закрыто
+    nextRun := utc(t, "2026-10-20 12:00:00")
+    setupScheduler(t, `{"window": "0 2 * * 6", "window_duration": "2h"}`,
+        Schedule{ID: "daily", Action: "reboot", Cron: "0 12 * * *", NextRun: timePtr(nextRun)})
+
+    if due := check(nextRun.Add(time.Hour)); len(due) != 0 {
+        t.Fatalf("due %+v, want none", due)
+    }
+    list := stored()
+    if len(list) != 1 || list[0].LastResult != ResultMissed || !list[0].NextRun.Equal(utc(t, "2026-10-21 12:00:00")) {
+        t.Fatalf("schedules %+v, want missed and advanced to the next day", list)
+    }
+}
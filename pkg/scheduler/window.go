@@ -0,0 +1,98 @@
+package scheduler
+
+import (
+    "fmt"
+    "time"
+    "servis/pkg/config"
+    "servis/pkg/cron"
+)
+
+// WindowStatus — состояние окна обслуживания
+type WindowStatus struct {
+    Configured bool       `json:"configured"` // окно не задано — ограничений нет
+    Window     string     `json:"window,omitempty"`
+    Duration   string     `json:"duration,omitempty"`
+    Open       bool       `json:"open"`
+    OpensAt    *time.Time `json:"opens_at,omitempty"`  // начало следующего окна, если сейчас оно закрыто
+    ClosesAt   *time.Time `json:"closes_at,omitempty"` // конец текущего окна
+}
+
+// WindowError возвращается CheckWindow вне окна обслуживания
+type WindowError struct {
+    OpensAt time.Time
+}
+
+func (e *WindowError) Error() string {
+    return fmt.Sprintf("outside of maintenance window, next window opens at %s", e.OpensAt.Format(time.RFC3339))
+}
+
+// window — окно обслуживания из конфигурации
+type window struct {
+    start    *cron.Schedule
+    duration time.Duration
+}
+
+// currentWindow возвращает окно из текущей конфигурации; nil, если окно не задано
+func currentWindow() *window {
+    maintenance := config.Get().Maintenance
+    if maintenance.Window == "" {
+        return nil
+    }
+    // Выражение проверено при загрузке конфигурации
+    start, err := cron.Parse(maintenance.Window)
+    if err != nil {
+        return nil
+    }
+    return &window{start: start, duration: time.Duration(maintenance.WindowDuration)}
+}
+
+// openAt возвращает конец окна, в которое попадает t; нулевое время, если t вне окна
+func (w *window) openAt(t time.Time) time.Time {
+    // Последнее начало окна не раньше t-duration: если оно не позже t, окно еще открыто
+    start := w.start.Next(t.Add(-w.duration))
+    if start.IsZero() || start.After(t) {
+        return time.Time{}
+    }
+    return start.Add(w.duration)
+}
+
+// nextOpen возвращает t, если окно открыто, иначе начало следующего окна
+func (w *window) nextOpen(t time.Time) time.Time {
+    if !w.openAt(t).IsZero() {
+        return t
+    }
+    return w.start.Next(t)
+}
+
+// Window возвращает состояние окна обслуживания
+func Window() WindowStatus {
+    w := currentWindow()
+    if w == nil {
+        return WindowStatus{Open: true}
+    }
+
+    maintenance := config.Get().Maintenance
+    status := WindowStatus{Configured: true, Window: maintenance.Window, Duration: maintenance.WindowDuration.String()}
+    now := time.Now()
+    if end := w.openAt(now); !end.IsZero() {
+        status.Open = true
+        status.ClosesAt = &end
+    } else if next := w.start.Next(now); !next.IsZero() {
+        status.OpensAt = &next
+    }
+    return status
+}
+
+// CheckWindow возвращает WindowError, если окно обслуживания задано и сейчас закрыто.
+// Ее вызывают все источники автоматических обновлений перед началом установки.
+func CheckWindow() error {
+    w := currentWindow()
+    if w == nil {
+        return nil
+    }
+    now := time.Now()
+    if w.openAt(now).IsZero() {
+        return &WindowError{OpensAt: w.start.Next(now)}
+    }
+    return nil
+}
@@ -0,0 +1,91 @@
+package scheduler
+
+import (
+    "testing"
+    "time"
+    "servis/pkg/cron"
+)
+
+// testWindow — окно по субботам с 02:00 на два часа
+func testWindow(t *testing.T) *window {
+    t.Helper()
+    start, err := cron.Parse("0 2 * * 6")
+    if err != nil {
+        t.Fatal(err)
+    }
+    return &window{start: start, duration: 2 * time.Hour}
+}
+
+// utc разбирает время в UTC; 2026-10-24 — суббота
+func utc(t *testing.T, value string) time.Time {
+    t.Helper()
+    parsed, err := time.Parse("2006-01-02 15:04:05", value)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return parsed
+}
+
+func TestWindowOpenAt(t *testing.T) {
+    w := testWindow(t)
+    tests := []struct {
+        name string
+        at   string
+        end  string // пусто — окно закрыто
+    }{
+        {"before opening", "2026-10-24 01:59:59", ""},
+        {"opening minute", "2026-10-24 02:00:00", "2026-10-24 04:00:00"},
+        {"inside", "2026-10-24 03:00:00", "2026-10-24 04:00:00"},
+        {"last second", "2026-10-24 03:59:59", "2026-10-24 04:00:00"},
+        {"closing moment", "2026-10-24 04:00:00", ""},
+        {"other day", "2026-10-23 02:30:00", ""},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            got := w.openAt(utc(t, test.at))
+            if test.end == "" {
+                if !got.IsZero() {
+                    t.Fatalf("openAt = %s, want closed", got)
+                }
+                return
+            }
+            if want := utc(t, test.end); !got.Equal(want) {
+                t.Fatalf("openAt = %s, want %s", got, want)
+            }
+        })
+    }
+}
+
+func TestWindowNextOpen(t *testing.T) {
+    w := testWindow(t)
+    tests := []struct {
+        name string
+        at   string
+        want string
+    }{
+        {"before opening", "2026-10-24 01:59:00", "2026-10-24 02:00:00"},
+        {"opening minute", "2026-10-24 02:00:00", "2026-10-24 02:00:00"},
+        {"inside", "2026-10-24 03:59:59", "2026-10-24 03:59:59"},
+        {"closing moment", "2026-10-24 04:00:00", "2026-10-31 02:00:00"},
+        {"midweek", "2026-10-20 12:00:00", "2026-10-24 02:00:00"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            if got, want := w.nextOpen(utc(t, test.at)), utc(t, test.want); !got.Equal(want) {
+                t.Fatalf("nextOpen = %s, want %s", got, want)
+            }
+        })
+    }
+}
+
+func TestWindowLongerThanPeriod(t *testing.T) {
+    start, err := cron.Parse("0 * * * *")
+    if err != nil {
+        t.Fatal(err)
+    }
+    // Окна перекрываются: время всегда внутри окна, а конец — у самого раннего из них
+    w := &window{start: start, duration: 90 * time.Minute}
+    if got, want := w.openAt(utc(t, "2026-10-24 10:59:00")), utc(t, "2026-10-24 11:30:00"); !got.Equal(want) {
+        t.Fatalf("openAt = %s, want %s", got, want)
+    }
+}
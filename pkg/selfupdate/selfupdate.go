@@ -0,0 +1,525 @@
+package selfupdate
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "net"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+    "servis/pkg/health"
+)
+
+// HealthDeadline — время, за которое новый процесс должен сообщить о готовности
+var HealthDeadline = 30 * time.Second
+
+// StatusPath — файл с результатом последнего самообновления
+var StatusPath = "/root/dt_backend/selfupdate.json"
+
+// Переменные окружения, через которые новый процесс получает слушающий сокет, канал готовности
+// и проверки готовности, не проходившие в прежнем процессе
+const (
+    envListenFD  = "SERVIS_LISTEN_FD"
+    envReadyFD   = "SERVIS_READY_FD"
+    envReadySkip = "SERVIS_READY_SKIP"
+)
+
+// readyInterval — период повторения проверок готовности нового процесса
+const readyInterval = time.Second
+
+// Состояния самообновления
+const (
+    StateRunning    = "running"
+    StateSucceeded  = "succeeded"
+    StateRolledBack = "rolled_back"
+)
+
+// Status описывает последнее самообновление
+type Status struct {
+    State      string    `json:"state"`
+    Binary     string    `json:"binary"`
+    ChildPID   int       `json:"child_pid,omitempty"`
+    StartedAt  time.Time `json:"started_at"`
+    FinishedAt time.Time `json:"finished_at,omitempty"`
+    Error      string    `json:"error,omitempty"`
+}
+
+var (
+    mu       sync.Mutex
+    listener net.Listener
+    shutdown func(context.Context) error
+)
+
+// handedOff — работа передана новому процессу, текущий только останавливается
+var handedOff atomic.Bool
+
+// upgradeHook — функция, зарегистрированная через OnUpgrade
+type upgradeHook struct {
+    id int
+    fn func(state string)
+}
+
+var (
+    hooksMu    sync.Mutex
+    hooks      []upgradeHook
+    lastHookID int
+)
+
+// Executable возвращает путь к исполняемому файлу servis без символических ссылок
+func Executable() (string, error) {
+    exe, err := os.Executable()
+    if err != nil {
+        return "", fmt.Errorf("failed to get executable path: %w", err)
+    }
+    return filepath.EvalSymlinks(exe)
+}
+
+// IsSelf сообщает, указывает ли путь на исполняемый файл servis
+func IsSelf(path string) bool {
+    exe, err := Executable()
+    if err != nil {
+        return false
+    }
+    resolved, err := filepath.EvalSymlinks(path)
+    if err != nil {
+        resolved = filepath.Clean(path)
+    }
+    return resolved == exe
+}
+
+// StagedPath возвращает путь, куда устанавливается новая версия servis
+func StagedPath() string {
+    exe, err := Executable()
+    if err != nil {
+        return ""
+    }
+    return exe + ".new"
+}
+
+// Staged сообщает, подготовлена ли новая версия servis
+func Staged() bool {
+    path := StagedPath()
+    if path == "" {
+        return false
+    }
+    _, err := os.Stat(path)
+    return err == nil
+}
+
+// Discard удаляет подготовленную, но не запущенную новую версию
+func Discard() {
+    if path := StagedPath(); path != "" {
+        os.Remove(path)
+    }
+}
+
+// StageFile копирует новую версию servis рядом с текущей
+func StageFile(binaryPath string) error {
+    src, err := os.Open(binaryPath)
+    if err != nil {
+        return fmt.Errorf("failed to open new binary: %w", err)
+    }
+    defer src.Close()
+
+    staged := StagedPath()
+    if staged == "" {
+        return fmt.Errorf("failed to get executable path")
+    }
+
+    dst, err := os.OpenFile(staged, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+    if err != nil {
+        return fmt.Errorf("failed to create staged binary: %w", err)
+    }
+
+    _, err = io.Copy(dst, src)
+    closeErr := dst.Close()
+    if err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(staged)
+        return fmt.Errorf("failed to copy new binary: %w", err)
+    }
+
+    return nil
+}
+
+// Listen возвращает слушающий сокет: унаследованный от предыдущего процесса при самообновлении или новый
+func Listen(addr string) (net.Listener, error) {
+    mu.Lock()
+    defer mu.Unlock()
+
+    if fdValue := os.Getenv(envListenFD); fdValue != "" {
+        os.Unsetenv(envListenFD)
+
+        fd, err := strconv.Atoi(fdValue)
+        if err != nil {
+            return nil, fmt.Errorf("invalid %s: %w", envListenFD, err)
+        }
+
+        file := os.NewFile(uintptr(fd), "listener")
+        inherited, err := net.FileListener(file)
+        file.Close()
+        if err != nil {
+            return nil, fmt.Errorf("failed to inherit listener: %w", err)
+        }
+
+        log.Printf("Inherited listener on %s from previous process", inherited.Addr())
+        listener = inherited
+        return listener, nil
+    }
+
+    created, err := net.Listen("tcp", addr)
+    if err != nil {
+        return nil, err
+    }
+    listener = created
+    return listener, nil
+}
+
+// Relisten открывает сокет на новом адресе и делает его текущим (именно он передается новому процессу
+// при самообновлении), а прежний сокет закрывает. Если открыть новый сокет не удалось, прежний продолжает работать.
+func Relisten(addr string) (net.Listener, error) {
+    mu.Lock()
+    defer mu.Unlock()
+
+    created, err := net.Listen("tcp", addr)
+    if err != nil {
+        return nil, err
+    }
+    if listener != nil {
+        listener.Close()
+    }
+    listener = created
+    return listener, nil
+}
+
+// SetShutdown задает функцию корректной остановки сервера, вызываемую после передачи работы новому процессу
+func SetShutdown(fn func(context.Context) error) {
+    mu.Lock()
+    defer mu.Unlock()
+    shutdown = fn
+}
+
+// Ready ждет, пока пройдут проверки готовности (/readyz), и сообщает предыдущему процессу, что новая версия
+// готова к работе. Проверки, которые не проходили и в предыдущем процессе (например, нет часов реального
+// времени или идет обновление, запустившее самообновление), не учитываются. Вызывается, когда сервер уже
+// принимает соединения; если проверки не пройдут за HealthDeadline, предыдущий процесс остановит новый
+// и вернет прежний файл. Если процесс запущен не в рамках самообновления, ничего не делает.
+func Ready(ctx context.Context) {
+    fdValue := os.Getenv(envReadyFD)
+    if fdValue == "" {
+        return
+    }
+    os.Unsetenv(envReadyFD)
+
+    fd, err := strconv.Atoi(fdValue)
+    if err != nil {
+        log.Printf("Invalid %s: %v", envReadyFD, err)
+        return
+    }
+
+    file := os.NewFile(uintptr(fd), "ready")
+    defer file.Close()
+
+    skip := make(map[string]bool)
+    for _, name := range strings.Split(os.Getenv(envReadySkip), ",") {
+        skip[name] = true
+    }
+    os.Unsetenv(envReadySkip)
+
+    ticker := time.NewTicker(readyInterval)
+    defer ticker.Stop()
+    for {
+        failing := failingChecks(health.Ready(ctx), skip)
+        if len(failing) == 0 {
+            break
+        }
+        log.Printf("Waiting for readiness checks: %s", strings.Join(failing, ", "))
+        select {
+        case <-ticker.C:
+        case <-ctx.Done():
+            return
+        }
+    }
+
+    _, err = file.Write([]byte("ready\n"))
+    if err != nil {
+        log.Printf("Failed to report readiness: %v", err)
+    }
+}
+
+// failingChecks возвращает имена непройденных проверок отчета, кроме перечисленных в skip
+func failingChecks(report health.Report, skip map[string]bool) []string {
+    var failing []string
+    for _, result := range report.Checks {
+        if result.Status != health.StatusOK && !skip[result.Name] {
+            failing = append(failing, result.Name)
+        }
+    }
+    return failing
+}
+
+// HandedOff сообщает, что процесс передал работу новой версии и останавливается. Состояние, которое видят
+// другие системы (например, статус в MQTT), в этом случае уже сообщает новый процесс.
+func HandedOff() bool {
+    return handedOff.Load()
+}
+
+// OnUpgrade регистрирует функцию, которая получает состояние самообновления текущего процесса: StateRunning
+// перед запуском новой версии, StateSucceeded после передачи ей работы и StateRolledBack, если новая версия
+// не запустилась. Возвращает функцию отмены регистрации для подсистем, которые перезапускаются.
+func OnUpgrade(fn func(state string)) (cancel func()) {
+    hooksMu.Lock()
+    defer hooksMu.Unlock()
+    lastHookID++
+    id := lastHookID
+    hooks = append(hooks, upgradeHook{id: id, fn: fn})
+
+    return func() {
+        hooksMu.Lock()
+        defer hooksMu.Unlock()
+        for i, hook := range hooks {
+            if hook.id == id {
+                hooks = append(hooks[:i], hooks[i+1:]...)
+                return
+            }
+        }
+    }
+}
+
+// notifyUpgrade передает состояние самообновления зарегистрированным функциям
+func notifyUpgrade(state string) {
+    hooksMu.Lock()
+    current := append([]upgradeHook(nil), hooks...)
+    hooksMu.Unlock()
+
+    for _, hook := range current {
+        hook.fn(state)
+    }
+}
+
+// Upgrade запускает подготовленную новую версию servis, передает ей слушающий сокет и ждет готовности.
+// Если новый процесс не сообщил о готовности за HealthDeadline или завершился, восстанавливается прежний файл.
+// При успехе текущий процесс корректно останавливается, а работу продолжает новый.
+func Upgrade() (err error) {
+    mu.Lock()
+    defer mu.Unlock()
+
+    exe, err := Executable()
+    if err != nil {
+        return err
+    }
+    staged := exe + ".new"
+    previous := exe + ".prev"
+
+    if _, err := os.Stat(staged); err != nil {
+        return fmt.Errorf("no staged binary: %w", err)
+    }
+    if listener == nil {
+        return fmt.Errorf("server listener is not available")
+    }
+
+    status := Status{State: StateRunning, Binary: exe, StartedAt: time.Now()}
+    saveStatus(&status)
+    notifyUpgrade(StateRunning)
+    defer func() {
+        if err != nil {
+            notifyUpgrade(StateRolledBack)
+        }
+    }()
+
+    err = os.Chmod(staged, 0755)
+    if err != nil {
+        return fmt.Errorf("failed to make staged binary executable: %w", err)
+    }
+
+    // Работающий файл можно переименовать: процесс продолжит работать со старым inode
+    err = os.Rename(exe, previous)
+    if err != nil {
+        return fmt.Errorf("failed to keep previous binary: %w", err)
+    }
+    err = os.Rename(staged, exe)
+    if err != nil {
+        os.Rename(previous, exe)
+        return fmt.Errorf("failed to install new binary: %w", err)
+    }
+
+    pid, err := startChild(exe)
+    if err != nil {
+        fallbackErr := fallback(exe, previous)
+        if fallbackErr != nil {
+            log.Printf("Failed to restore previous binary: %v", fallbackErr)
+        }
+
+        status.State = StateRolledBack
+        status.FinishedAt = time.Now()
+        status.Error = err.Error()
+        saveStatus(&status)
+        return fmt.Errorf("new binary failed health check, previous binary restored: %w", err)
+    }
+
+    status.State = StateSucceeded
+    status.ChildPID = pid
+    status.FinishedAt = time.Now()
+    saveStatus(&status)
+
+    notifySystemd(fmt.Sprintf("MAINPID=%d", pid))
+    log.Printf("New binary is running as pid %d, stopping current process", pid)
+    handedOff.Store(true)
+    notifyUpgrade(StateSucceeded)
+
+    go stopCurrent(shutdown)
+    return nil
+}
+
+// startChild запускает новый процесс с унаследованным сокетом и ждет от него сообщения о готовности:
+// новый процесс сообщает о ней, когда принимает соединения и проходит проверки готовности
+func startChild(exe string) (int, error) {
+    tcpListener, ok := listener.(interface{ File() (*os.File, error) })
+    if !ok {
+        return 0, fmt.Errorf("listener does not support handoff")
+    }
+    listenerFile, err := tcpListener.File()
+    if err != nil {
+        return 0, fmt.Errorf("failed to get listener file: %w", err)
+    }
+    defer listenerFile.Close()
+
+    readyRead, readyWrite, err := os.Pipe()
+    if err != nil {
+        return 0, fmt.Errorf("failed to create readiness pipe: %w", err)
+    }
+    defer readyRead.Close()
+
+    cmd := exec.Command(exe, os.Args[1:]...)
+    cmd.Stdout = os.Stdout
+    cmd.Stderr = os.Stderr
+    // ExtraFiles получают дескрипторы 3, 4 и т.д. в дочернем процессе
+    cmd.ExtraFiles = []*os.File{listenerFile, readyWrite}
+    // Новый процесс не ждет проверок, которые не проходят и сейчас: их исправит не обновление
+    skip := failingChecks(health.Ready(context.Background()), nil)
+    cmd.Env = append(os.Environ(), envListenFD+"=3", envReadyFD+"=4", envReadySkip+"="+strings.Join(skip, ","))
+
+    err = cmd.Start()
+    readyWrite.Close()
+    if err != nil {
+        return 0, fmt.Errorf("failed to start new binary: %w", err)
+    }
+
+    exited := make(chan error, 1)
+    go func() {
+        exited <- cmd.Wait()
+    }()
+
+    ready := make(chan error, 1)
+    go func() {
+        buf := make([]byte, 16)
+        n, err := readyRead.Read(buf)
+        if err == nil && n > 0 {
+            ready <- nil
+            return
+        }
+        ready <- fmt.Errorf("new binary closed readiness pipe: %v", err)
+    }()
+
+    select {
+    case err := <-ready:
+        if err == nil {
+            return cmd.Process.Pid, nil
+        }
+        cmd.Process.Kill()
+        return 0, err
+    case err := <-exited:
+        return 0, fmt.Errorf("new binary exited: %v", err)
+    case <-time.After(HealthDeadline):
+        cmd.Process.Kill()
+        return 0, fmt.Errorf("new binary did not report ready within %s", HealthDeadline)
+    }
+}
+
+// fallback возвращает прежний исполняемый файл на место
+func fallback(exe, previous string) error {
+    err := os.Rename(exe, exe+".failed")
+    if err != nil {
+        return err
+    }
+    return os.Rename(previous, exe)
+}
+
+// stopCurrent корректно останавливает текущий процесс после передачи работы новому
+func stopCurrent(fn func(context.Context) error) {
+    if fn == nil {
+        os.Exit(0)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+    defer cancel()
+
+    err := fn(ctx)
+    if err != nil {
+        log.Printf("Failed to stop server gracefully: %v", err)
+        os.Exit(0)
+    }
+}
+
+// notifySystemd отправляет сообщение systemd (sd_notify), если servis запущен как служба с NOTIFY_SOCKET
+func notifySystemd(state string) {
+    socket := os.Getenv("NOTIFY_SOCKET")
+    if socket == "" {
+        return
+    }
+
+    conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+    if err != nil {
+        log.Printf("Failed to connect to systemd notify socket: %v", err)
+        return
+    }
+    defer conn.Close()
+
+    _, err = conn.Write([]byte(state))
+    if err != nil {
+        log.Printf("Failed to notify systemd: %v", err)
+    }
+}
+
+// CurrentStatus возвращает результат последнего самообновления
+func CurrentStatus() (*Status, error) {
+    data, err := ioutil.ReadFile(StatusPath)
+    if os.IsNotExist(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read self-update status: %w", err)
+    }
+
+    var status Status
+    err = json.Unmarshal(data, &status)
+    if err != nil {
+        return nil, fmt.Errorf("failed to unmarshal self-update status: %w", err)
+    }
+    return &status, nil
+}
+
+// saveStatus сохраняет результат самообновления
+func saveStatus(status *Status) {
+    data, err := json.MarshalIndent(status, "", "  ")
+    if err != nil {
+        log.Printf("Failed to marshal self-update status: %v", err)
+        return
+    }
+
+    err = ioutil.WriteFile(StatusPath, data, 0644)
+    if err != nil {
+        log.Printf("Failed to write self-update status: %v", err)
+    }
+}
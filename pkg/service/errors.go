@@ -0,0 +1,164 @@
+package service
+
+import (
+    "errors"
+    "log"
+    "time"
+    "servis/pkg/apierror"
+    "servis/pkg/auth"
+    "servis/pkg/ethernet"
+    "servis/pkg/ratelimit"
+    "servis/pkg/scheduler"
+    "servis/pkg/shutdown"
+    "servis/pkg/update"
+    "servis/pkg/wifi"
+)
+
+// BusyDetails — детали ошибок update_in_progress и update_interrupted
+type BusyDetails struct {
+    Job update.Job `json:"job"`
+}
+
+// ManifestDetails — детали ошибки manifest_invalid: поле манифеста и описание проблемы
+type ManifestDetails struct {
+    Problems []update.ManifestProblem `json:"problems"`
+}
+
+// WindowDetails — детали ошибки maintenance_window_closed: начало следующего окна обслуживания
+type WindowDetails struct {
+    OpensAt time.Time `json:"opens_at"`
+}
+
+// DependencyDetails — детали ошибки dependency_conflict: откатываемое назначение и зависящие от него компоненты
+type DependencyDetails struct {
+    Dependents map[string][]string `json:"dependents"`
+}
+
+// RetryDetails — детали ошибок rate_limited и auth_locked_out: через сколько секунд можно повторить запрос
+// (то же значение передается в заголовке Retry-After)
+type RetryDetails struct {
+    RetryAfter int `json:"retry_after"`
+}
+
+// errorCodes сопоставляет ошибки пакетов с кодами API. Если verbose не задан, клиент получает
+// только сообщение из таблицы, а полный текст (с путями и выводом команд) остается в журнале.
+var errorCodes = []struct {
+    err     error
+    code    string
+    message string
+    verbose bool
+}{
+    {auth.ErrInvalidInput, apierror.CodeInvalidRequest, "", true},
+    {auth.ErrInvalidCredentials, apierror.CodeInvalidCredentials, "invalid username or password", false},
+    {auth.ErrNotFound, apierror.CodeNotFound, "", true},
+    {auth.ErrLastAdmin, apierror.CodeLastAdmin, "cannot remove the last admin", false},
+
+    {update.ErrArchiveNotFound, apierror.CodeArchiveNotFound, "firmware archive not found", false},
+    {update.ErrUnknownPackage, apierror.CodeUnknownPackage, "", true},
+    {update.ErrHashMismatch, apierror.CodeHashMismatch, "installed component was modified, update refused", false},
+    {update.ErrWrongDeviceKey, apierror.CodeWrongDeviceKey, "package is encrypted for another device", false},
+    {update.ErrDecryptionFailed, apierror.CodeDecryptionFailed, "failed to decrypt package", false},
+    {update.ErrBackupNotFound, apierror.CodeBackupNotFound, "", true},
+    {update.ErrNotInstalled, apierror.CodeNotInstalled, "", true},
+    {update.ErrNotUSBDevice, apierror.CodeNotUSBDevice, "", true},
+    {update.ErrShuttingDown, apierror.CodeShuttingDown, "servis is shutting down, try again after restart", false},
+    {update.ErrDownloadFailed, apierror.CodeDownloadFailed, "", true},
+    {update.ErrNoInterruptedJob, apierror.CodeNotFound, "", true},
+    {update.ErrUntrustedPackage, apierror.CodeUntrustedPackage, "", true},
+    {update.ErrNoSelfUpdate, apierror.CodeInvalidRequest, "", true},
+
+    {wifi.ErrScanFailed, apierror.CodeWifiScanFailed, "failed to scan wifi networks", false},
+    {wifi.ErrConfigFailed, apierror.CodeWifiConfigFailed, "failed to update wifi configuration", false},
+    {wifi.ErrInterfaceFailed, apierror.CodeWifiConnectFailed, "failed to restart wifi interface", false},
+    {wifi.ErrAssociationFailed, apierror.CodeWifiConnectFailed, "failed to connect to wifi network", false},
+    {wifi.ErrDHCPFailed, apierror.CodeWifiDHCPFailed, "failed to obtain address via DHCP", false},
+
+    {ethernet.ErrUnavailable, apierror.CodeEthernetUnavailable, "ethernet interface is unavailable", false},
+    {ethernet.ErrConfigFailed, apierror.CodeEthernetConfigFailed, "failed to update ethernet configuration", false},
+
+    {shutdown.ErrInvalidAction, apierror.CodeInvalidRequest, "", true},
+    {shutdown.ErrInvalidDelay, apierror.CodeInvalidRequest, "", true},
+    {shutdown.ErrInvalidConfirmation, apierror.CodeInvalidConfirmation, "", true},
+    {shutdown.ErrNoPendingAction, apierror.CodeNotFound, "", true},
+
+    {scheduler.ErrInvalidSchedule, apierror.CodeInvalidRequest, "", true},
+    {scheduler.ErrNotFound, apierror.CodeNotFound, "", true},
+    {scheduler.ErrClockNotSynced, apierror.CodeClockNotSynchronized, "", true},
+}
+
+// Error переводит ошибку пакета в ошибку API; nil — ошибка неизвестна
+func Error(err error) *apierror.Error {
+    var apiErr *apierror.Error
+    if errors.As(err, &apiErr) {
+        return apiErr
+    }
+
+    var busyErr *update.BusyError
+    if errors.As(err, &busyErr) {
+        code := apierror.CodeUpdateInProgress
+        if busyErr.Interrupted {
+            code = apierror.CodeUpdateInterrupted
+        }
+        return apierror.New(code, busyErr.Error()).WithDetails(BusyDetails{Job: busyErr.Job})
+    }
+
+    var manifestErr *update.ManifestError
+    if errors.As(err, &manifestErr) {
+        return apierror.New(apierror.CodeManifestInvalid, "invalid manifest").WithDetails(ManifestDetails{Problems: manifestErr.Problems})
+    }
+
+    var depErr *update.DependencyError
+    if errors.As(err, &depErr) {
+        return apierror.New(apierror.CodeDependencyConflict, "rollback breaks dependencies").WithDetails(DependencyDetails{Dependents: depErr.Dependents})
+    }
+
+    var windowErr *scheduler.WindowError
+    if errors.As(err, &windowErr) {
+        return apierror.New(apierror.CodeMaintenanceWindowClosed, windowErr.Error()).WithDetails(WindowDetails{OpensAt: windowErr.OpensAt})
+    }
+
+    var limitErr *ratelimit.LimitError
+    if errors.As(err, &limitErr) {
+        code := apierror.CodeRateLimited
+        if errors.Is(err, ratelimit.ErrLockedOut) {
+            code = apierror.CodeLockedOut
+        }
+        return apierror.New(code, limitErr.Error()).WithDetails(RetryDetails{RetryAfter: limitErr.Seconds()})
+    }
+
+    var pendingErr *shutdown.PendingError
+    if errors.As(err, &pendingErr) {
+        return apierror.New(apierror.CodePowerActionPending, pendingErr.Error()).WithDetails(pendingErr.Pending)
+    }
+
+    for _, mapping := range errorCodes {
+        if !errors.Is(err, mapping.err) {
+            continue
+        }
+        if mapping.verbose {
+            return apierror.New(mapping.code, err.Error())
+        }
+        return apierror.New(mapping.code, mapping.message)
+    }
+    return nil
+}
+
+// Classify возвращает ошибку API для err. Неизвестная ошибка записывается в журнал, а клиент получает
+// код fallbackCode и сообщение message без подробностей (путей и вывода команд).
+func Classify(err error, fallbackCode, message string) *apierror.Error {
+    apiErr := Error(err)
+    if apiErr == nil {
+        log.Printf("%s: %v", message, err)
+        apiErr = apierror.New(fallbackCode, message)
+    }
+    return apiErr
+}
+
+// Invalid возвращает ошибку invalid_request; field — поле запроса, вызвавшее ошибку
+func Invalid(message, field string) *apierror.Error {
+    apiErr := apierror.New(apierror.CodeInvalidRequest, message)
+    if field != "" {
+        apiErr.WithDetails(map[string]string{"field": field})
+    }
+    return apiErr
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+    "strings"
+    "servis/pkg/events"
+)
+
+// Progress — ход операции обновления или отката для потоковых ответов
+type Progress struct {
+    Operation   string `json:"operation"`
+    Stage       string `json:"stage"` // started, progress, failed или completed
+    JobID       string `json:"job_id,omitempty"`
+    Destination string `json:"destination,omitempty"`
+    Done        int    `json:"done,omitempty"`
+    Total       int    `json:"total,omitempty"`
+    Error       string `json:"error,omitempty"`
+}
+
+// watchProgress выполняет операцию run и передает progress события обновления, опубликованные за время ее работы.
+// Блокировка обновления не допускает параллельных операций, поэтому события относятся к операции run;
+// события другой операции, начатой до подписки, отсеиваются по job_id.
+func watchProgress(progress func(Progress), run func() error) error {
+    if progress == nil {
+        return run()
+    }
+
+    sub, _, _ := events.Subscribe([]string{events.TopicUpdate}, 0)
+    defer sub.Close()
+
+    done := make(chan error, 1)
+    go func() {
+        done <- run()
+    }()
+
+    // Если progress не успевает за событиями, подписка закрывается и операция дорабатывает без них
+    jobID := ""
+    updates := sub.C
+    for {
+        select {
+        case err := <-done:
+            // События, опубликованные до завершения run, уже в буфере подписки
+            for updates != nil {
+                select {
+                case event, ok := <-updates:
+                    if !ok {
+                        return err
+                    }
+                    forwardProgress(event, &jobID, progress)
+                default:
+                    return err
+                }
+            }
+            return err
+        case event, ok := <-updates:
+            if !ok {
+                updates = nil
+                continue
+            }
+            forwardProgress(event, &jobID, progress)
+        }
+    }
+}
+
+// forwardProgress преобразует событие обновления в Progress и передает его progress
+func forwardProgress(event events.Event, jobID *string, progress func(Progress)) {
+    separator := strings.LastIndex(event.Type, "_")
+    data, _ := event.Data.(map[string]interface{})
+    if separator < 0 || data == nil {
+        return
+    }
+
+    p := Progress{Operation: event.Type[:separator], Stage: event.Type[separator+1:]}
+    p.JobID, _ = data["job_id"].(string)
+    if p.Stage == "started" && *jobID == "" {
+        *jobID = p.JobID
+    }
+    if p.JobID == "" || p.JobID != *jobID {
+        return
+    }
+
+    p.Destination, _ = data["destination"].(string)
+    p.Done, _ = data["done"].(int)
+    p.Total, _ = data["total"].(int)
+    p.Error, _ = data["error"].(string)
+    progress(p)
+}
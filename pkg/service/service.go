@@ -0,0 +1,384 @@
+// Package service — операции servis, общие для HTTP API (пакет api) и gRPC (пакет grpcapi): сети, питание,
+// пакеты прошивки на USB, обновление и откат. Транспорты только разбирают запрос, проверяют права
+// и переводят результат в свой формат, поэтому поведение и ошибки двух API не могут разойтись.
+// Операции возвращают ошибки *apierror.Error с кодом, который получает клиент любого транспорта.
+package service
+
+import (
+    "context"
+    "net"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+    "servis/pkg/apierror"
+    "servis/pkg/config"
+    "servis/pkg/ethernet"
+    "servis/pkg/scheduler"
+    "servis/pkg/shutdown"
+    "servis/pkg/update"
+    "servis/pkg/wifi"
+)
+
+type NetworkSelection struct {
+    Name     string `json:"name"`
+    Password string `json:"password"`
+}
+
+type Network struct {
+    Name    string `json:"name"`
+    Quality string `json:"quality,omitempty"`
+}
+
+// EthernetStatus — текущие адрес и линк интерфейса Ethernet
+type EthernetStatus struct {
+    Interface string   `json:"interface"`
+    LinkUp    bool     `json:"link_up"`
+    Address   string   `json:"address,omitempty"`
+    Netmask   string   `json:"netmask,omitempty"` // длина префикса, например 24
+    Gateway   string   `json:"gateway,omitempty"`
+    DNS       []string `json:"dns,omitempty"`
+}
+
+// EthernetSettings — статический адрес интерфейса Ethernet. Netmask задается длиной префикса (24) или маской (255.255.255.0).
+type EthernetSettings struct {
+    Address string   `json:"address"`
+    Netmask string   `json:"netmask"`
+    Gateway string   `json:"gateway"`
+    DNS     []string `json:"dns"`
+}
+
+// PowerRequest — запрос на выключение или перезагрузку. Без confirm_token возвращается токен подтверждения
+// и описание последствий; с токеном действие планируется через delay (например, 5m) или сразу.
+type PowerRequest struct {
+    Delay        string `json:"delay,omitempty"`
+    ConfirmToken string `json:"confirm_token,omitempty"`
+}
+
+// PowerResponse — ответ на запрос выключения или перезагрузки: токен подтверждения на первом шаге
+// или запланированное действие на втором
+type PowerResponse struct {
+    Confirmation *shutdown.Confirmation `json:"confirmation,omitempty"`
+    Pending      *shutdown.Pending      `json:"pending,omitempty"`
+}
+
+type FileInfo struct {
+    Source      string `json:"source"`
+    FileVersion string `json:"file_version"`
+}
+
+type ZipFileInfo struct {
+    Path       string                   `json:"path"`
+    Files      []FileInfo               `json:"files"`
+    Packages   []PackageInfo            `json:"packages,omitempty"`
+    Signature  string                   `json:"signature,omitempty"`
+    Encrypted  bool                     `json:"encrypted"`
+    Compatible bool                     `json:"compatible"`
+    Issues     []string                 `json:"issues,omitempty"`
+    Error      string                   `json:"error,omitempty"`
+    Problems   []update.ManifestProblem `json:"problems,omitempty"`
+}
+
+type PackageInfo struct {
+    Name        string     `json:"name"`
+    Description string     `json:"description,omitempty"`
+    Files       []FileInfo `json:"files"`
+    Compatible  bool       `json:"compatible"`
+    Issues      []string   `json:"issues,omitempty"`
+}
+
+type UpdateRequest struct {
+    SelectedFile string   `json:"selected_file"`
+    Packages     []string `json:"packages,omitempty"` // пустой список — установить все пакеты архива
+}
+
+// URLUpdateRequest — установка прошивки из архива, который скачивается по адресу url
+type URLUpdateRequest struct {
+    URL      string   `json:"url"`
+    SHA256   string   `json:"sha256"`             // ожидаемый хеш архива, обязателен
+    Packages []string `json:"packages,omitempty"` // пустой список — установить все пакеты архива
+}
+
+type RollbackRequest struct {
+    Destinations      []string `json:"destinations,omitempty"`
+    IncludeDependents bool     `json:"include_dependents,omitempty"`
+}
+
+type JobResponse struct {
+    Job         *update.Job `json:"job"`
+    Interrupted bool        `json:"interrupted"`
+}
+
+// Networks возвращает доступные сети WiFi
+func Networks() ([]Network, error) {
+    scanned, err := wifi.ScanNetworks(config.Get().Network.WifiInterface)
+    if err != nil {
+        return nil, Classify(err, apierror.CodeWifiScanFailed, "failed to scan wifi networks")
+    }
+
+    networks := []Network{}
+    for _, network := range scanned {
+        name, _ := network["name"].(string)
+        quality, _ := network["quality"].(string)
+        networks = append(networks, Network{Name: name, Quality: quality})
+    }
+    return networks, nil
+}
+
+// ConnectNetwork подключается к выбранной сети WiFi
+func ConnectNetwork(selection NetworkSelection) error {
+    network := config.Get().Network
+    err := wifi.Connect(network.WifiInterface, network.WpaSupplicantConf, selection.Name, selection.Password)
+    if err != nil {
+        return Classify(err, apierror.CodeWifiConnectFailed, "failed to connect to wifi network")
+    }
+    return nil
+}
+
+// Ethernet возвращает текущие адрес, маршрут по умолчанию и DNS интерфейса Ethernet
+func Ethernet() (*EthernetStatus, error) {
+    interfaceName := config.Get().Network.EthernetInterface
+    ipAddr, netmask, gateway, dns, err := ethernet.GetEthernetInfo(interfaceName)
+    if err != nil {
+        return nil, Classify(err, apierror.CodeEthernetUnavailable, "failed to read ethernet configuration")
+    }
+
+    return &EthernetStatus{
+        Interface: interfaceName,
+        LinkUp:    ethernet.LinkUp(interfaceName),
+        Address:   ipAddr,
+        Netmask:   netmask,
+        Gateway:   gateway,
+        DNS:       strings.Fields(dns),
+    }, nil
+}
+
+// ConfigureEthernet задает статический адрес интерфейса Ethernet и сразу применяет его
+func ConfigureEthernet(settings EthernetSettings) error {
+    if net.ParseIP(settings.Address).To4() == nil {
+        return Invalid("address must be an IPv4 address", "address")
+    }
+    if !validNetmask(settings.Netmask) {
+        return Invalid("netmask must be a prefix length (24) or a mask (255.255.255.0)", "netmask")
+    }
+    if net.ParseIP(settings.Gateway).To4() == nil {
+        return Invalid("gateway must be an IPv4 address", "gateway")
+    }
+    if len(settings.DNS) == 0 {
+        return Invalid("at least one dns server is required", "dns")
+    }
+    for _, server := range settings.DNS {
+        if net.ParseIP(server) == nil {
+            return Invalid("invalid dns server "+server, "dns")
+        }
+    }
+
+    err := ethernet.SetStatic(settings.Address, settings.Netmask, settings.Gateway, strings.Join(settings.DNS, " "))
+    if err != nil {
+        return Classify(err, apierror.CodeEthernetConfigFailed, "failed to update ethernet configuration")
+    }
+    return nil
+}
+
+// validNetmask проверяет маску IPv4 в виде длины префикса или адреса
+func validNetmask(netmask string) bool {
+    if prefix, err := strconv.Atoi(netmask); err == nil {
+        return prefix >= 0 && prefix <= 32
+    }
+    ip := net.ParseIP(netmask).To4()
+    if ip == nil {
+        return false
+    }
+    _, bits := net.IPMask(ip).Size()
+    return bits == 32
+}
+
+// Power выполняет первый или второй шаг выключения или перезагрузки от имени пользователя requestedBy
+func Power(action string, req PowerRequest, requestedBy string) (*PowerResponse, error) {
+    if req.ConfirmToken == "" {
+        var delay time.Duration
+        if req.Delay != "" {
+            var err error
+            delay, err = time.ParseDuration(req.Delay)
+            if err != nil {
+                return nil, Invalid("invalid delay: "+err.Error(), "delay")
+            }
+        }
+
+        confirmation, err := shutdown.Prepare(action, delay, requestedBy)
+        if err != nil {
+            return nil, Classify(err, apierror.CodeInternal, "failed to prepare "+action)
+        }
+        return &PowerResponse{Confirmation: confirmation}, nil
+    }
+
+    if req.Delay != "" {
+        return nil, Invalid("delay is set on the first step, not with confirm_token", "delay")
+    }
+    pending, err := shutdown.Confirm(action, req.ConfirmToken, requestedBy)
+    if err != nil {
+        return nil, Classify(err, apierror.CodeInternal, "failed to schedule "+action)
+    }
+    return &PowerResponse{Pending: pending}, nil
+}
+
+// PendingPower возвращает запланированное выключение или перезагрузку; Pending пустой, если их нет
+func PendingPower() *PowerResponse {
+    return &PowerResponse{Pending: shutdown.Current()}
+}
+
+// CancelPower отменяет запланированное действие и возвращает его
+func CancelPower(cancelledBy string) (*PowerResponse, error) {
+    cancelled, err := shutdown.Cancel(cancelledBy)
+    if err != nil {
+        return nil, Classify(err, apierror.CodeInternal, "failed to cancel pending action")
+    }
+    return &PowerResponse{Pending: cancelled}, nil
+}
+
+// USBPackages возвращает ZIP-архивы на USB-накопителях с информацией о файлах и их версиях.
+// Архивы ищутся рекурсивно; ошибка в отдельном архиве возвращается в его записи, а не для всего списка.
+// Если архивов нет, возвращается пустой список.
+func USBPackages(ctx context.Context) ([]ZipFileInfo, error) {
+    packages, err := update.ScanUSBPackages(ctx, config.Get().Update.VersionFile, update.DefaultScanOptions)
+    if err != nil && packages == nil {
+        return nil, Classify(err, apierror.CodeInternal, "failed to get USB devices")
+    }
+
+    zipFilesInfo := []ZipFileInfo{}
+    for _, zipFile := range packages {
+        var packageInfos []PackageInfo
+        for _, pkg := range zipFile.Packages {
+            packageInfos = append(packageInfos, PackageInfo{
+                Name:        pkg.Name,
+                Description: pkg.Description,
+                Files:       toFileInfos(pkg.Files),
+                Compatible:  pkg.Compatible,
+                Issues:      pkg.Issues,
+            })
+        }
+        zipFilesInfo = append(zipFilesInfo, ZipFileInfo{
+            Path:       zipFile.Path,
+            Files:      toFileInfos(zipFile.Files),
+            Packages:   packageInfos,
+            Signature:  zipFile.Signature,
+            Encrypted:  zipFile.Encrypted,
+            Compatible: zipFile.Compatible,
+            Issues:     zipFile.Issues,
+            Error:      zipFile.Error,
+            Problems:   zipFile.Problems,
+        })
+    }
+    return zipFilesInfo, nil
+}
+
+// toFileInfos преобразует файлы пакета в формат ответа API
+func toFileInfos(files []update.PackageFile) []FileInfo {
+    fileInfos := []FileInfo{}
+    for _, file := range files {
+        fileInfos = append(fileInfos, FileInfo{
+            Source:      file.Source,
+            FileVersion: file.FileVersion,
+        })
+    }
+    return fileInfos
+}
+
+// UpdateFirmware устанавливает прошивку из архива. Если progress задан, он получает ход операции.
+func UpdateFirmware(req UpdateRequest, progress func(Progress)) error {
+    if req.SelectedFile == "" {
+        return Invalid("no file selected", "selected_file")
+    }
+
+    settings := config.Get().Update
+    err := watchProgress(progress, func() error {
+        return update.UpdatePackages(req.SelectedFile, settings.VersionFile, settings.BackupDir, req.Packages)
+    })
+    if err != nil {
+        return Classify(err, apierror.CodeInternal, "failed to update firmware")
+    }
+    return nil
+}
+
+// PlanFirmwareUpdate показывает, какие компоненты изменит установка архива, ничего не устанавливая
+func PlanFirmwareUpdate(req UpdateRequest) (*update.UpdatePlan, error) {
+    if req.SelectedFile == "" {
+        return nil, Invalid("no file selected", "selected_file")
+    }
+
+    plan, err := update.PlanUpdate(req.SelectedFile, config.Get().Update.VersionFile, req.Packages)
+    if err != nil {
+        return nil, Classify(err, apierror.CodeInternal, "failed to plan firmware update")
+    }
+    return plan, nil
+}
+
+// UpdateFirmwareFromURL скачивает архив и устанавливает из него прошивку; скачанный архив затем удаляется.
+// Это удаленная установка без участия человека у устройства, поэтому она выполняется только в окне обслуживания
+// и только для архивов, подписанных доверенным ключом.
+func UpdateFirmwareFromURL(ctx context.Context, req URLUpdateRequest, progress func(Progress)) error {
+    if req.URL == "" {
+        return Invalid("no url specified", "url")
+    }
+    if req.SHA256 == "" {
+        return Invalid("no sha256 specified", "sha256")
+    }
+    if err := scheduler.CheckWindow(); err != nil {
+        return Classify(err, apierror.CodeInternal, "failed to check maintenance window")
+    }
+
+    archive, err := update.Download(ctx, req.URL, req.SHA256)
+    if err != nil {
+        return Classify(err, apierror.CodeDownloadFailed, "failed to download firmware archive")
+    }
+    defer os.Remove(archive)
+
+    settings := config.Get().Update
+    err = watchProgress(progress, func() error {
+        return update.UpdateSignedPackages(archive, settings.VersionFile, settings.BackupDir, req.Packages)
+    })
+    if err != nil {
+        return Classify(err, apierror.CodeInternal, "failed to update firmware")
+    }
+    return nil
+}
+
+// RollbackFirmware откатывает последнее обновление целиком или, если указаны Destinations, только выбранные
+// компоненты; для них возвращается результат отката каждого компонента. Если progress задан, он получает ход операции.
+func RollbackFirmware(req RollbackRequest, progress func(Progress)) ([]update.ComponentRollback, error) {
+    settings := config.Get().Update
+
+    if len(req.Destinations) > 0 {
+        var results []update.ComponentRollback
+        err := watchProgress(progress, func() error {
+            var err error
+            results, err = update.RollbackComponents(settings.BackupDir, settings.VersionFile, req.Destinations, req.IncludeDependents)
+            return err
+        })
+        if err != nil {
+            return nil, Classify(err, apierror.CodeInternal, "failed to rollback components")
+        }
+        return results, nil
+    }
+
+    installedVersions, err := update.LoadInstalledVersions(settings.VersionFile)
+    if err != nil {
+        return nil, Classify(err, apierror.CodeInternal, "failed to load installed versions")
+    }
+    err = watchProgress(progress, func() error {
+        return update.RollbackFirmware(settings.BackupDir, installedVersions)
+    })
+    if err != nil {
+        return nil, Classify(err, apierror.CodeInternal, "failed to rollback firmware")
+    }
+    return nil, nil
+}
+
+// FirmwareJob возвращает выполняемую или прерванную операцию обновления
+func FirmwareJob() (*JobResponse, error) {
+    job, interrupted, err := update.CurrentJob()
+    if err != nil {
+        return nil, Classify(err, apierror.CodeInternal, "failed to get current job")
+    }
+    return &JobResponse{Job: job, Interrupted: interrupted}, nil
+}
@@ -1,26 +1,273 @@
+// Package shutdown выключает и перезагружает устройство. Действие выполняется в два шага: первый запрос
+// возвращает одноразовый токен подтверждения и описание последствий, второй с этим токеном планирует действие.
+// Запланированное действие можно отменить до его выполнения.
 package shutdown
 
 import (
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
     "fmt"
+    "log"
     "os/exec"
+    "strings"
+    "sync"
+    "time"
+    "servis/pkg/events"
+    "servis/pkg/update"
 )
 
-// Shutdown выключает устройство
-func Shutdown() error {
-    cmd := exec.Command("sudo", "shutdown", "now")
-    err := cmd.Run()
+// NotifyDelay — наименьшая пауза между событием о выключении или перезагрузке и самой командой,
+// чтобы подписчики успели получить событие
+var NotifyDelay = 2 * time.Second
+
+// ConfirmTTL — время действия токена подтверждения
+var ConfirmTTL = time.Minute
+
+// MaxDelay — наибольшая отсрочка выполнения
+const MaxDelay = 24 * time.Hour
+
+// Действия с питанием
+const (
+    ActionShutdown = "shutdown"
+    ActionReboot   = "reboot"
+)
+
+// commands — команды действий
+var commands = map[string][]string{
+    ActionShutdown: {"sudo", "shutdown", "now"},
+    ActionReboot:   {"sudo", "reboot"},
+}
+
+var (
+    ErrInvalidAction       = errors.New("unknown power action")
+    ErrInvalidDelay        = fmt.Errorf("delay must be between 0 and %s", MaxDelay)
+    ErrInvalidConfirmation = errors.New("confirmation token is invalid, expired or already used")
+    ErrNoPendingAction     = errors.New("no pending power action")
+)
+
+// PendingError возвращается, если другое действие уже запланировано
+type PendingError struct {
+    Pending Pending
+}
+
+func (e *PendingError) Error() string {
+    return fmt.Sprintf("%s is already scheduled at %s", e.Pending.Action, e.Pending.ExecuteAt.Format(time.RFC3339))
+}
+
+// Impact — что затронет действие
+type Impact struct {
+    UpdateJob  *update.Job `json:"update_job,omitempty"`  // выполняемая или прерванная операция обновления
+    MountedUSB []string    `json:"mounted_usb,omitempty"` // смонтированные USB-накопители
+    Warnings   []string    `json:"warnings,omitempty"`
+}
+
+// Confirmation — ответ на первый шаг: токен, который нужно передать вторым запросом до ExpiresAt
+type Confirmation struct {
+    Token     string    `json:"confirm_token"`
+    Action    string    `json:"action"`
+    Delay     string    `json:"delay"`
+    ExpiresAt time.Time `json:"expires_at"`
+    Impact    Impact    `json:"impact"`
+}
+
+// Pending — запланированное действие
+type Pending struct {
+    Action      string    `json:"action"`
+    RequestedBy string    `json:"requested_by"`
+    ExecuteAt   time.Time `json:"execute_at"`
+    SecondsLeft int       `json:"seconds_left"` // обратный отсчет до выполнения
+}
+
+// request — выданный, но еще не использованный токен подтверждения
+type request struct {
+    action      string
+    delay       time.Duration
+    requestedBy string
+    expiresAt   time.Time
+}
+
+var (
+    mu       sync.Mutex
+    requests = make(map[string]request)
+    pending  *Pending
+    timer    *time.Timer
+)
+
+// Prepare выдает токен подтверждения действия и описывает его последствия.
+// Подтвердить действие может только тот же пользователь (requestedBy).
+func Prepare(action string, delay time.Duration, requestedBy string) (*Confirmation, error) {
+    if _, ok := commands[action]; !ok {
+        return nil, fmt.Errorf("%w: %s", ErrInvalidAction, action)
+    }
+    if delay < 0 || delay > MaxDelay {
+        return nil, ErrInvalidDelay
+    }
+
+    impact := describeImpact()
+
+    token := make([]byte, 16)
+    if _, err := rand.Read(token); err != nil {
+        return nil, fmt.Errorf("failed to generate confirmation token: %w", err)
+    }
+
+    mu.Lock()
+    defer mu.Unlock()
+
+    if pending != nil {
+        return nil, &PendingError{Pending: pending.withCountdown()}
+    }
+
+    now := time.Now()
+    for key, r := range requests {
+        if now.After(r.expiresAt) {
+            delete(requests, key)
+        }
+    }
+
+    confirmation := &Confirmation{
+        Token:     hex.EncodeToString(token),
+        Action:    action,
+        Delay:     delay.String(),
+        ExpiresAt: now.Add(ConfirmTTL),
+        Impact:    impact,
+    }
+    requests[confirmation.Token] = request{action: action, delay: delay, requestedBy: requestedBy, expiresAt: confirmation.ExpiresAt}
+    return confirmation, nil
+}
+
+// describeImpact собирает сведения о том, что прервет выключение или перезагрузка
+func describeImpact() Impact {
+    var impact Impact
+
+    job, interrupted, err := update.CurrentJob()
     if err != nil {
-        return fmt.Errorf("failed to shutdown the system: %w", err)
+        log.Printf("Failed to check update state: %v", err)
+    }
+    if job != nil {
+        impact.UpdateJob = job
+        if interrupted {
+            impact.Warnings = append(impact.Warnings, fmt.Sprintf("%s operation %s was interrupted and needs a rollback", job.Operation, job.ID))
+        } else {
+            impact.Warnings = append(impact.Warnings, fmt.Sprintf("%s operation %s is running; servis waits for it within shutdown_timeout before stopping", job.Operation, job.ID))
+        }
+    }
+
+    mounts, _ := update.GetUSBMountPoints()
+    for _, mount := range mounts {
+        if strings.HasPrefix(mount, "/media/") {
+            impact.MountedUSB = append(impact.MountedUSB, mount)
+        }
+    }
+    if len(impact.MountedUSB) > 0 {
+        impact.Warnings = append(impact.Warnings, "USB drives will be unmounted: "+strings.Join(impact.MountedUSB, ", "))
+    }
+    return impact
+}
+
+// Confirm использует токен подтверждения и планирует действие. Токен одноразовый: повторный запрос с ним отклоняется.
+func Confirm(action, token, requestedBy string) (*Pending, error) {
+    mu.Lock()
+    defer mu.Unlock()
+
+    // Чужая попытка не расходует токен, иначе другой пользователь мог бы помешать подтверждению
+    r, ok := requests[token]
+    if !ok || r.requestedBy != requestedBy {
+        return nil, ErrInvalidConfirmation
+    }
+    delete(requests, token)
+    if r.action != action || time.Now().After(r.expiresAt) {
+        return nil, ErrInvalidConfirmation
+    }
+    return schedule(action, r.delay, requestedBy)
+}
+
+// Schedule планирует действие без подтверждения. Используется планировщиком, для которого
+// подтверждением служит само созданное пользователем расписание.
+func Schedule(action string, delay time.Duration, requestedBy string) (*Pending, error) {
+    if _, ok := commands[action]; !ok {
+        return nil, fmt.Errorf("%w: %s", ErrInvalidAction, action)
+    }
+    if delay < 0 || delay > MaxDelay {
+        return nil, ErrInvalidDelay
+    }
+
+    mu.Lock()
+    defer mu.Unlock()
+    return schedule(action, delay, requestedBy)
+}
+
+// schedule запускает таймер действия; вызывается с захваченным mu
+func schedule(action string, delay time.Duration, requestedBy string) (*Pending, error) {
+    if pending != nil {
+        return nil, &PendingError{Pending: pending.withCountdown()}
+    }
+
+    if delay < NotifyDelay {
+        delay = NotifyDelay
+    }
+    pending = &Pending{Action: action, RequestedBy: requestedBy, ExecuteAt: time.Now().Add(delay)}
+    timer = time.AfterFunc(delay, func() { execute(action) })
+
+    log.Printf("%s scheduled by %s at %s", action, requestedBy, pending.ExecuteAt.Format(time.RFC3339))
+    events.Publish(events.TopicSystem, action+"_pending", map[string]string{
+        "delay":        delay.String(),
+        "execute_at":   pending.ExecuteAt.Format(time.RFC3339),
+        "requested_by": requestedBy,
+    })
+
+    scheduled := pending.withCountdown()
+    return &scheduled, nil
+}
+
+// Cancel отменяет запланированное действие
+func Cancel(cancelledBy string) (*Pending, error) {
+    mu.Lock()
+    defer mu.Unlock()
+
+    // Если таймер уже сработал, команда выполняется и отменить ее нельзя
+    if pending == nil || !timer.Stop() {
+        return nil, ErrNoPendingAction
     }
-    return nil
+    cancelled := pending.withCountdown()
+    pending, timer = nil, nil
+
+    log.Printf("%s cancelled by %s", cancelled.Action, cancelledBy)
+    events.Publish(events.TopicSystem, cancelled.Action+"_cancelled", map[string]string{"cancelled_by": cancelledBy})
+    return &cancelled, nil
+}
+
+// Current возвращает запланированное действие; nil, если его нет
+func Current() *Pending {
+    mu.Lock()
+    defer mu.Unlock()
+
+    if pending == nil {
+        return nil
+    }
+    current := pending.withCountdown()
+    return &current
 }
 
-// Reboot перезагружает устройство
-func Reboot() error {
-    cmd := exec.Command("sudo", "reboot")
-    err := cmd.Run()
+func (p *Pending) withCountdown() Pending {
+    current := *p
+    current.SecondsLeft = int(time.Until(p.ExecuteAt).Round(time.Second).Seconds())
+    if current.SecondsLeft < 0 {
+        current.SecondsLeft = 0
+    }
+    return current
+}
+
+// execute выполняет запланированное действие
+func execute(action string) {
+    mu.Lock()
+    pending, timer = nil, nil
+    mu.Unlock()
+
+    command := commands[action]
+    err := exec.Command(command[0], command[1:]...).Run()
     if err != nil {
-        return fmt.Errorf("failed to reboot the system: %w", err)
+        log.Printf("Failed to %s the system: %v", action, err)
+        events.Publish(events.TopicSystem, action+"_failed", map[string]string{"error": err.Error()})
     }
-    return nil
 }
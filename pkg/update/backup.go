@@ -0,0 +1,628 @@
+package update
+
+import (
+    "archive/zip"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+)
+
+// backupManifestName — имя файла с описанием поколения резервной копии
+const backupManifestName = "backup.json"
+
+// BackupPolicy описывает правила хранения резервных копий
+type BackupPolicy struct {
+    KeepGenerations int   `json:"keep_generations"` // сколько поколений хранить (0 — без ограничения)
+    MaxTotalSize    int64 `json:"max_total_size"`   // максимальный суммарный размер в байтах (0 — без ограничения)
+    CompressAfter   int   `json:"compress_after"`   // сколько последних поколений оставлять несжатыми (0 — не сжимать)
+}
+
+// DefaultBackupPolicy применяется после каждого обновления прошивки
+var DefaultBackupPolicy = BackupPolicy{
+    KeepGenerations: 5,
+    MaxTotalSize:    512 << 20,
+    CompressAfter:   1,
+}
+
+// BackupEntry описывает файл или директорию, сохранённые в поколении резервной копии
+type BackupEntry struct {
+    Destination string            `json:"destination"`
+    Name        string            `json:"name,omitempty"` // имя копии в директории поколения
+    FileVersion string            `json:"file_version"`
+    Requires    map[string]string `json:"requires,omitempty"`
+    IsDir       bool              `json:"is_dir"`
+    Restored    bool              `json:"restored,omitempty"` // запись уже использована для покомпонентного отката
+}
+
+// BackupGeneration описывает одно поколение резервной копии, создаваемое при каждом обновлении
+type BackupGeneration struct {
+    ID         string        `json:"id"`
+    CreatedAt  time.Time     `json:"created_at"`
+    Source     string        `json:"source"`
+    Entries    []BackupEntry `json:"entries"`
+    Compressed bool          `json:"compressed"`
+    Size       int64         `json:"size"`
+
+    path string
+}
+
+// newBackupGeneration создает директорию для нового поколения резервной копии
+func newBackupGeneration(backupDir, source string) (*BackupGeneration, error) {
+    err := os.MkdirAll(backupDir, 0755)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create backup directory: %w", err)
+    }
+
+    now := time.Now()
+    id := now.Format("20060102-150405")
+    for i := 1; ; i++ {
+        _, errDir := os.Stat(filepath.Join(backupDir, id))
+        _, errZip := os.Stat(filepath.Join(backupDir, id+".zip"))
+        if os.IsNotExist(errDir) && os.IsNotExist(errZip) {
+            break
+        }
+        id = fmt.Sprintf("%s-%d", now.Format("20060102-150405"), i)
+    }
+
+    generation := &BackupGeneration{
+        ID:        id,
+        CreatedAt: now,
+        Source:    source,
+        Entries:   []BackupEntry{},
+        path:      filepath.Join(backupDir, id),
+    }
+
+    err = os.MkdirAll(generation.path, 0755)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create backup generation directory: %w", err)
+    }
+
+    return generation, generation.save()
+}
+
+// store копирует файл или директорию в поколение и сразу обновляет его описание. Копия называется
+// по порядковому номеру записи: у разных назначений может совпадать имя файла, а имя backup.json занято описанием.
+func (g *BackupGeneration) store(destination, fileVersion string, requires map[string]string, isDir bool) error {
+    name := fmt.Sprintf("%d-%s", len(g.Entries)+1, filepath.Base(destination))
+    err := createBackup(destination, filepath.Join(g.path, name))
+    if err != nil {
+        return err
+    }
+
+    g.Entries = append(g.Entries, BackupEntry{
+        Destination: destination,
+        Name:        name,
+        FileVersion: fileVersion,
+        Requires:    requires,
+        IsDir:       isDir,
+    })
+    return g.save()
+}
+
+// path возвращает путь копии записи внутри директории поколения dir. Поколения, созданные до появления
+// поля name, хранят копию под именем файла назначения.
+func (e BackupEntry) path(dir string) string {
+    if e.Name == "" {
+        return filepath.Join(dir, filepath.Base(e.Destination))
+    }
+    return filepath.Join(dir, e.Name)
+}
+
+// save записывает описание поколения в его директорию
+func (g *BackupGeneration) save() error {
+    data, err := json.MarshalIndent(g, "", "  ")
+    if err != nil {
+        return fmt.Errorf("failed to marshal backup manifest: %w", err)
+    }
+
+    err = ioutil.WriteFile(filepath.Join(g.path, backupManifestName), data, 0644)
+    if err != nil {
+        return fmt.Errorf("failed to write backup manifest: %w", err)
+    }
+
+    return nil
+}
+
+// markRestored помечает запись поколения как использованную для отката
+func (g *BackupGeneration) markRestored(destination string) error {
+    for i := range g.Entries {
+        if g.Entries[i].Destination == destination {
+            g.Entries[i].Restored = true
+        }
+    }
+
+    if !g.Compressed {
+        return g.save()
+    }
+    return g.rewriteArchiveManifest()
+}
+
+// rewriteArchiveManifest заменяет описание поколения внутри ZIP-архива
+func (g *BackupGeneration) rewriteArchiveManifest() error {
+    data, err := json.MarshalIndent(g, "", "  ")
+    if err != nil {
+        return fmt.Errorf("failed to marshal backup manifest: %w", err)
+    }
+
+    zipReader, err := zip.OpenReader(g.path)
+    if err != nil {
+        return fmt.Errorf("failed to open backup archive: %w", err)
+    }
+    defer zipReader.Close()
+
+    tmpPath := g.path + ".tmp"
+    out, err := os.Create(tmpPath)
+    if err != nil {
+        return fmt.Errorf("failed to create archive: %w", err)
+    }
+    defer os.Remove(tmpPath)
+
+    zipWriter := zip.NewWriter(out)
+    for _, file := range zipReader.File {
+        if file.Name == backupManifestName {
+            continue
+        }
+        err = zipWriter.Copy(file)
+        if err != nil {
+            out.Close()
+            return fmt.Errorf("failed to copy %s to archive: %w", file.Name, err)
+        }
+    }
+
+    writer, err := zipWriter.Create(backupManifestName)
+    if err == nil {
+        _, err = writer.Write(data)
+    }
+    if err == nil {
+        err = zipWriter.Close()
+    }
+    closeErr := out.Close()
+    if err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        return fmt.Errorf("failed to write backup archive: %w", err)
+    }
+
+    return os.Rename(tmpPath, g.path)
+}
+
+// ListBackups возвращает список поколений резервных копий, начиная с самого нового
+func ListBackups(backupDir string) ([]BackupGeneration, error) {
+    entries, err := os.ReadDir(backupDir)
+    if os.IsNotExist(err) {
+        return []BackupGeneration{}, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read backup directory: %w", err)
+    }
+
+    generations := []BackupGeneration{}
+    for _, entry := range entries {
+        path := filepath.Join(backupDir, entry.Name())
+
+        var generation *BackupGeneration
+        if entry.IsDir() {
+            generation, err = readBackupDir(path)
+        } else if strings.HasSuffix(entry.Name(), ".zip") {
+            generation, err = readBackupArchive(path)
+        } else {
+            continue
+        }
+
+        if err != nil {
+            // Файлы старого формата (без backup.json) не считаются поколениями
+            continue
+        }
+        generations = append(generations, *generation)
+    }
+
+    sort.Slice(generations, func(i, j int) bool {
+        return generations[i].CreatedAt.After(generations[j].CreatedAt)
+    })
+
+    return generations, nil
+}
+
+// FindBackup ищет поколение резервной копии по идентификатору
+func FindBackup(backupDir, id string) (*BackupGeneration, error) {
+    generations, err := ListBackups(backupDir)
+    if err != nil {
+        return nil, err
+    }
+
+    for i := range generations {
+        if generations[i].ID == id {
+            return &generations[i], nil
+        }
+    }
+
+    return nil, fmt.Errorf("%w: %s", ErrBackupNotFound, id)
+}
+
+// latestBackup возвращает самое новое поколение резервной копии или nil, если их нет
+func latestBackup(backupDir string) (*BackupGeneration, error) {
+    generations, err := ListBackups(backupDir)
+    if err != nil {
+        return nil, err
+    }
+
+    if len(generations) == 0 {
+        return nil, nil
+    }
+
+    return &generations[0], nil
+}
+
+// readBackupDir читает описание несжатого поколения
+func readBackupDir(path string) (*BackupGeneration, error) {
+    data, err := ioutil.ReadFile(filepath.Join(path, backupManifestName))
+    if err != nil {
+        return nil, err
+    }
+
+    var generation BackupGeneration
+    err = json.Unmarshal(data, &generation)
+    if err != nil {
+        return nil, fmt.Errorf("failed to unmarshal backup manifest: %w", err)
+    }
+
+    size, err := directorySize(path)
+    if err != nil {
+        return nil, err
+    }
+
+    generation.Compressed = false
+    generation.Size = size
+    generation.path = path
+    return &generation, nil
+}
+
+// readBackupArchive читает описание сжатого поколения из ZIP-архива
+func readBackupArchive(path string) (*BackupGeneration, error) {
+    zipReader, err := zip.OpenReader(path)
+    if err != nil {
+        return nil, err
+    }
+    defer zipReader.Close()
+
+    for _, file := range zipReader.File {
+        if file.Name != backupManifestName {
+            continue
+        }
+
+        f, err := file.Open()
+        if err != nil {
+            return nil, err
+        }
+        defer f.Close()
+
+        var generation BackupGeneration
+        err = json.NewDecoder(f).Decode(&generation)
+        if err != nil {
+            return nil, fmt.Errorf("failed to unmarshal backup manifest: %w", err)
+        }
+
+        info, err := os.Stat(path)
+        if err != nil {
+            return nil, err
+        }
+
+        generation.Compressed = true
+        generation.Size = info.Size()
+        generation.path = path
+        return &generation, nil
+    }
+
+    return nil, fmt.Errorf("backup manifest not found in %s", path)
+}
+
+// directorySize вычисляет суммарный размер файлов в директории
+func directorySize(path string) (int64, error) {
+    var size int64
+    err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+        if err != nil {
+            return err
+        }
+        if !info.IsDir() {
+            size += info.Size()
+        }
+        return nil
+    })
+    return size, err
+}
+
+// ApplyBackupRetention сжимает старые поколения и удаляет лишние согласно политике хранения
+func ApplyBackupRetention(backupDir string, policy BackupPolicy) error {
+    generations, err := ListBackups(backupDir)
+    if err != nil {
+        return err
+    }
+
+    if policy.CompressAfter > 0 {
+        for i := policy.CompressAfter; i < len(generations); i++ {
+            if generations[i].Compressed {
+                continue
+            }
+            err := compressBackup(&generations[i])
+            if err != nil {
+                return fmt.Errorf("failed to compress backup %s: %w", generations[i].ID, err)
+            }
+            log.Printf("Backup %s compressed", generations[i].ID)
+        }
+    }
+
+    var totalSize int64
+    for i, generation := range generations {
+        totalSize += generation.Size
+
+        // Самое новое поколение никогда не удаляется — оно нужно для отката
+        if i == 0 {
+            continue
+        }
+
+        tooMany := policy.KeepGenerations > 0 && i >= policy.KeepGenerations
+        tooLarge := policy.MaxTotalSize > 0 && totalSize > policy.MaxTotalSize
+        if !tooMany && !tooLarge {
+            continue
+        }
+
+        err := os.RemoveAll(generation.path)
+        if err != nil {
+            return fmt.Errorf("failed to remove backup %s: %w", generation.ID, err)
+        }
+        totalSize -= generation.Size
+        log.Printf("Backup %s removed by retention policy", generation.ID)
+    }
+
+    return nil
+}
+
+// compressBackup упаковывает несжатое поколение в ZIP-архив и удаляет исходную директорию
+func compressBackup(generation *BackupGeneration) error {
+    archivePath := generation.path + ".zip"
+    tmpPath := archivePath + ".tmp"
+
+    out, err := os.Create(tmpPath)
+    if err != nil {
+        return fmt.Errorf("failed to create archive: %w", err)
+    }
+
+    err = zipDirectory(generation.path, out)
+    closeErr := out.Close()
+    if err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(tmpPath)
+        return err
+    }
+
+    err = os.Rename(tmpPath, archivePath)
+    if err != nil {
+        os.Remove(tmpPath)
+        return fmt.Errorf("failed to rename archive: %w", err)
+    }
+
+    err = os.RemoveAll(generation.path)
+    if err != nil {
+        return fmt.Errorf("failed to remove compressed backup directory: %w", err)
+    }
+
+    info, err := os.Stat(archivePath)
+    if err != nil {
+        return err
+    }
+
+    generation.path = archivePath
+    generation.Compressed = true
+    generation.Size = info.Size()
+    return nil
+}
+
+// openBackup возвращает директорию с файлами поколения; сжатые поколения распаковываются во временную директорию
+func openBackup(generation *BackupGeneration) (string, func(), error) {
+    if !generation.Compressed {
+        return generation.path, func() {}, nil
+    }
+
+    tmpDir, err := ioutil.TempDir("", "servis-backup-")
+    if err != nil {
+        return "", nil, fmt.Errorf("failed to create temp directory: %w", err)
+    }
+    cleanup := func() { os.RemoveAll(tmpDir) }
+
+    err = extractZip(generation.path, tmpDir)
+    if err != nil {
+        cleanup()
+        return "", nil, err
+    }
+
+    return tmpDir, cleanup, nil
+}
+
+// ExportBackup записывает поколение резервной копии в виде ZIP-архива
+func ExportBackup(backupDir, id string, w io.Writer) error {
+    generation, err := FindBackup(backupDir, id)
+    if err != nil {
+        return err
+    }
+
+    if !generation.Compressed {
+        return zipDirectory(generation.path, w)
+    }
+
+    f, err := os.Open(generation.path)
+    if err != nil {
+        return fmt.Errorf("failed to open backup archive: %w", err)
+    }
+    defer f.Close()
+
+    _, err = io.Copy(w, f)
+    if err != nil {
+        return fmt.Errorf("failed to copy backup archive: %w", err)
+    }
+
+    return nil
+}
+
+// ExportBackupToUSB сохраняет поколение резервной копии на смонтированный USB-накопитель
+func ExportBackupToUSB(backupDir, id, mountPoint string) (string, error) {
+    mountPoints, err := GetUSBMountPoints()
+    if err != nil {
+        return "", err
+    }
+
+    found := false
+    for _, mp := range mountPoints {
+        if mp == mountPoint {
+            found = true
+            break
+        }
+    }
+    if !found {
+        return "", fmt.Errorf("%w: %s", ErrNotUSBDevice, mountPoint)
+    }
+
+    targetPath := filepath.Join(mountPoint, fmt.Sprintf("servis-backup-%s.zip", id))
+    out, err := os.Create(targetPath)
+    if err != nil {
+        return "", fmt.Errorf("failed to create export file: %w", err)
+    }
+
+    err = ExportBackup(backupDir, id, out)
+    closeErr := out.Close()
+    if err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(targetPath)
+        return "", err
+    }
+
+    log.Printf("Backup %s exported to %s", id, targetPath)
+    return targetPath, nil
+}
+
+// zipDirectory упаковывает содержимое директории в ZIP-архив
+func zipDirectory(dir string, w io.Writer) error {
+    zipWriter := zip.NewWriter(w)
+
+    err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+        if err != nil {
+            return err
+        }
+        if path == dir {
+            return nil
+        }
+
+        relPath, err := filepath.Rel(dir, path)
+        if err != nil {
+            return err
+        }
+
+        header, err := zip.FileInfoHeader(info)
+        if err != nil {
+            return err
+        }
+        header.Name = filepath.ToSlash(relPath)
+        if info.IsDir() {
+            header.Name += "/"
+        } else {
+            header.Method = zip.Deflate
+        }
+
+        writer, err := zipWriter.CreateHeader(header)
+        if err != nil {
+            return err
+        }
+        if info.IsDir() {
+            return nil
+        }
+
+        f, err := os.Open(path)
+        if err != nil {
+            return err
+        }
+        defer f.Close()
+
+        _, err = io.Copy(writer, f)
+        return err
+    })
+    if err != nil {
+        zipWriter.Close()
+        return fmt.Errorf("failed to archive directory: %w", err)
+    }
+
+    return zipWriter.Close()
+}
+
+// extractZip распаковывает ZIP-архив в директорию, не допуская выхода за её пределы
+func extractZip(archivePath, destination string) error {
+    zipReader, err := zip.OpenReader(archivePath)
+    if err != nil {
+        return fmt.Errorf("failed to open archive: %w", err)
+    }
+    defer zipReader.Close()
+
+    for _, file := range zipReader.File {
+        destPath := filepath.Join(destination, file.Name)
+        if !strings.HasPrefix(destPath, filepath.Clean(destination)+string(os.PathSeparator)) {
+            return fmt.Errorf("invalid path in archive: %s", file.Name)
+        }
+
+        if file.FileInfo().IsDir() {
+            err := os.MkdirAll(destPath, 0755)
+            if err != nil {
+                return fmt.Errorf("failed to create directory: %w", err)
+            }
+            continue
+        }
+
+        err := extractZipFile(file, destPath)
+        if err != nil {
+            return err
+        }
+    }
+
+    return nil
+}
+
+// extractZipFile распаковывает один файл из архива
+func extractZipFile(file *zip.File, destPath string) error {
+    err := os.MkdirAll(filepath.Dir(destPath), 0755)
+    if err != nil {
+        return fmt.Errorf("failed to create directory: %w", err)
+    }
+
+    srcFile, err := file.Open()
+    if err != nil {
+        return fmt.Errorf("failed to open file in archive: %w", err)
+    }
+    defer srcFile.Close()
+
+    // Права файла сохраняются в архиве: исполняемые файлы должны остаться исполняемыми после отката
+    mode := file.Mode().Perm()
+    if mode == 0 {
+        mode = 0644
+    }
+    destFile, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+    if err != nil {
+        return fmt.Errorf("failed to create file: %w", err)
+    }
+    defer destFile.Close()
+
+    _, err = io.Copy(destFile, srcFile)
+    if err != nil {
+        return fmt.Errorf("failed to extract file: %w", err)
+    }
+
+    return nil
+}
@@ -0,0 +1,98 @@
+package update
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "path"
+    "strings"
+    "time"
+)
+
+var (
+    // DownloadDir — каталог для архивов, скачиваемых по URL; архив удаляется после установки
+    DownloadDir = "/root/dt_backend/downloads"
+    // MaxDownloadSize — наибольший размер скачиваемого архива
+    MaxDownloadSize int64 = 2 << 30
+    // DownloadTimeout ограничивает время скачивания архива
+    DownloadTimeout = 30 * time.Minute
+)
+
+// Download скачивает архив прошивки по адресу rawURL (только https) в DownloadDir и возвращает путь к нему.
+// Хеш скачанного файла должен совпасть с expectedSHA256. Подпись манифеста проверяется при установке:
+// скачанные архивы устанавливаются только через UpdateSignedPackages.
+func Download(ctx context.Context, rawURL, expectedSHA256 string) (string, error) {
+    parsed, err := url.Parse(rawURL)
+    if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+        return "", fmt.Errorf("%w: url must be an https address", ErrDownloadFailed)
+    }
+    if !hashPattern.MatchString(strings.ToLower(expectedSHA256)) {
+        return "", fmt.Errorf("%w: sha256 of the archive is required (64 hex digits)", ErrDownloadFailed)
+    }
+    name := path.Base(parsed.Path)
+    if !strings.HasSuffix(strings.ToLower(name), ".zip") {
+        name = "firmware.zip"
+    }
+
+    err = os.MkdirAll(DownloadDir, 0700)
+    if err != nil {
+        return "", fmt.Errorf("failed to create download directory: %w", err)
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, DownloadTimeout)
+    defer cancel()
+
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+    if err != nil {
+        return "", fmt.Errorf("%w: %v", ErrDownloadFailed, err)
+    }
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        // HTTP-клиент убирает пароль из адреса в тексте ошибки
+        return "", fmt.Errorf("%w: %v", ErrDownloadFailed, err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return "", fmt.Errorf("%w: %s responded with %s", ErrDownloadFailed, parsed.Host, resp.Status)
+    }
+    if resp.ContentLength > MaxDownloadSize {
+        return "", fmt.Errorf("%w: archive is larger than %d bytes", ErrDownloadFailed, MaxDownloadSize)
+    }
+
+    // Уникальное имя: одновременно скачиваемые архивы не заменяют друг друга
+    file, err := os.CreateTemp(DownloadDir, "*-"+name+".part")
+    if err != nil {
+        return "", fmt.Errorf("failed to create download file: %w", err)
+    }
+    defer os.Remove(file.Name())
+    defer file.Close()
+
+    hash := sha256.New()
+    written, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(resp.Body, MaxDownloadSize+1))
+    if err != nil {
+        return "", fmt.Errorf("%w: transfer from %s interrupted", ErrDownloadFailed, parsed.Host)
+    }
+    if written > MaxDownloadSize {
+        return "", fmt.Errorf("%w: archive is larger than %d bytes", ErrDownloadFailed, MaxDownloadSize)
+    }
+    actual := hex.EncodeToString(hash.Sum(nil))
+    if !strings.EqualFold(actual, expectedSHA256) {
+        return "", fmt.Errorf("%w: sha256 mismatch, expected %s, got %s", ErrDownloadFailed, expectedSHA256, actual)
+    }
+    err = file.Close()
+    if err != nil {
+        return "", fmt.Errorf("failed to write download file: %w", err)
+    }
+
+    target := strings.TrimSuffix(file.Name(), ".part")
+    err = os.Rename(file.Name(), target)
+    if err != nil {
+        return "", fmt.Errorf("failed to save downloaded archive: %w", err)
+    }
+    return target, nil
+}
@@ -0,0 +1,389 @@
+package update
+
+import (
+    "bufio"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/ecdh"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/binary"
+    "encoding/hex"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// DeviceKeyPath — закрытый ключ X25519 устройства (base64), для которого шифруются ключи пакетов
+var DeviceKeyPath = "/root/dt_backend/device.key"
+
+// EncryptionAlgorithm — единственный поддерживаемый алгоритм шифрования содержимого
+const EncryptionAlgorithm = "AES-256-GCM"
+
+// DefaultChunkSize — размер блока потокового шифрования по умолчанию
+const DefaultChunkSize = 64 << 10
+
+// maxChunkSize ограничивает размер блока, чтобы расшифровка не требовала много памяти
+const maxChunkSize = 16 << 20
+
+// noncePrefixSize — длина случайного префикса nonce; оставшиеся 5 байт — счетчик блоков и признак последнего блока
+const noncePrefixSize = 7
+
+// keyWrapInfo добавляется при выводе ключа шифрования ключа из общего секрета X25519
+const keyWrapInfo = "servis-key-wrap-v1"
+
+// payloadInfo начинает дополнительные данные (AAD) блоков содержимого
+const payloadInfo = "servis-payload-v1"
+
+// errTruncated возвращается, если зашифрованный поток оборван
+var errTruncated = fmt.Errorf("%w: encrypted payload is truncated", ErrDecryptionFailed)
+
+// Encryption описывает шифрование пакета в манифесте.
+// Ключ содержимого (AES-256) зашифрован для открытого ключа устройства: эфемерный ключ X25519 + AES-256-GCM.
+type Encryption struct {
+    Algorithm          string `json:"algorithm"`
+    KeyID              string `json:"key_id"`               // идентификатор ключа устройства, для которого зашифрован пакет
+    EphemeralPublicKey string `json:"ephemeral_public_key"` // base64
+    WrapNonce          string `json:"wrap_nonce"`           // base64
+    WrappedKey         string `json:"wrapped_key"`          // base64
+    ChunkSize          int    `json:"chunk_size"`
+}
+
+// payloadKey — расшифрованный ключ содержимого пакета
+type payloadKey struct {
+    key       []byte
+    chunkSize int
+    aad       []byte // дополнительные данные файла, см. bind
+}
+
+// bind возвращает ключ для файла архива source, устанавливаемого в destination: блоки файла
+// расшифруются, только если они были зашифрованы для этой пары путей и того же размера блока
+func (k *payloadKey) bind(source, destination string) *payloadKey {
+    if k == nil {
+        return nil
+    }
+    bound := *k
+    bound.aad = PayloadAAD(source, destination, k.chunkSize)
+    return &bound
+}
+
+// PayloadAAD возвращает дополнительные данные блоков файла: путь в архиве, назначение и размер блока.
+// Зашифрованный файл нельзя подменить другим файлом того же пакета или установить по другому пути.
+func PayloadAAD(source, destination string, chunkSize int) []byte {
+    return []byte(fmt.Sprintf("%s\x00%s\x00%s\x00%d", payloadInfo, source, destination, chunkSize))
+}
+
+// LoadDeviceKey загружает закрытый ключ устройства
+func LoadDeviceKey() (*ecdh.PrivateKey, error) {
+    data, err := ioutil.ReadFile(DeviceKeyPath)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read device key: %w", err)
+    }
+
+    raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+    if err != nil {
+        return nil, fmt.Errorf("failed to decode device key: %w", err)
+    }
+
+    key, err := ecdh.X25519().NewPrivateKey(raw)
+    if err != nil {
+        return nil, fmt.Errorf("invalid device key: %w", err)
+    }
+    return key, nil
+}
+
+// EnsureDeviceKey загружает ключ устройства, создавая его при первом запуске
+func EnsureDeviceKey() (*ecdh.PrivateKey, error) {
+    if _, err := os.Stat(DeviceKeyPath); err == nil {
+        return LoadDeviceKey()
+    }
+
+    key, err := ecdh.X25519().GenerateKey(rand.Reader)
+    if err != nil {
+        return nil, fmt.Errorf("failed to generate device key: %w", err)
+    }
+
+    err = os.MkdirAll(filepath.Dir(DeviceKeyPath), 0700)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create device key directory: %w", err)
+    }
+
+    err = ioutil.WriteFile(DeviceKeyPath, []byte(base64.StdEncoding.EncodeToString(key.Bytes())), 0600)
+    if err != nil {
+        return nil, fmt.Errorf("failed to write device key: %w", err)
+    }
+
+    log.Printf("Device key generated, key id %s", DeviceKeyID(key.PublicKey()))
+    return key, nil
+}
+
+// DeviceKeyID возвращает короткий идентификатор открытого ключа устройства
+func DeviceKeyID(publicKey *ecdh.PublicKey) string {
+    sum := sha256.Sum256(publicKey.Bytes())
+    return hex.EncodeToString(sum[:8])
+}
+
+// deriveWrapKey выводит ключ шифрования ключа из общего секрета X25519
+func deriveWrapKey(shared, ephemeralPublic, devicePublic []byte) []byte {
+    hasher := sha256.New()
+    hasher.Write([]byte(keyWrapInfo))
+    hasher.Write(shared)
+    hasher.Write(ephemeralPublic)
+    hasher.Write(devicePublic)
+    return hasher.Sum(nil)
+}
+
+// WrapContentKey шифрует ключ содержимого для открытого ключа устройства (используется при сборке пакетов)
+func WrapContentKey(devicePublic *ecdh.PublicKey, contentKey []byte, chunkSize int) (*Encryption, error) {
+    ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+    if err != nil {
+        return nil, fmt.Errorf("failed to generate ephemeral key: %w", err)
+    }
+
+    shared, err := ephemeral.ECDH(devicePublic)
+    if err != nil {
+        return nil, fmt.Errorf("failed to compute shared secret: %w", err)
+    }
+
+    aead, err := newGCM(deriveWrapKey(shared, ephemeral.PublicKey().Bytes(), devicePublic.Bytes()))
+    if err != nil {
+        return nil, err
+    }
+
+    nonce := make([]byte, aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return nil, fmt.Errorf("failed to generate nonce: %w", err)
+    }
+
+    return &Encryption{
+        Algorithm:          EncryptionAlgorithm,
+        KeyID:              DeviceKeyID(devicePublic),
+        EphemeralPublicKey: base64.StdEncoding.EncodeToString(ephemeral.PublicKey().Bytes()),
+        WrapNonce:          base64.StdEncoding.EncodeToString(nonce),
+        WrappedKey:         base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, contentKey, []byte(keyWrapInfo))),
+        ChunkSize:          chunkSize,
+    }, nil
+}
+
+// unwrap расшифровывает ключ содержимого закрытым ключом устройства
+func (e *Encryption) unwrap(deviceKey *ecdh.PrivateKey) (*payloadKey, error) {
+    if keyID := DeviceKeyID(deviceKey.PublicKey()); e.KeyID != keyID {
+        return nil, fmt.Errorf("%w: package key %s, device key %s", ErrWrongDeviceKey, e.KeyID, keyID)
+    }
+
+    ephemeralRaw, err := base64.StdEncoding.DecodeString(e.EphemeralPublicKey)
+    if err != nil {
+        return nil, fmt.Errorf("invalid ephemeral public key: %w", err)
+    }
+    ephemeral, err := ecdh.X25519().NewPublicKey(ephemeralRaw)
+    if err != nil {
+        return nil, fmt.Errorf("invalid ephemeral public key: %w", err)
+    }
+    nonce, err := base64.StdEncoding.DecodeString(e.WrapNonce)
+    if err != nil {
+        return nil, fmt.Errorf("invalid wrap nonce: %w", err)
+    }
+    wrapped, err := base64.StdEncoding.DecodeString(e.WrappedKey)
+    if err != nil {
+        return nil, fmt.Errorf("invalid wrapped key: %w", err)
+    }
+
+    shared, err := deviceKey.ECDH(ephemeral)
+    if err != nil {
+        return nil, fmt.Errorf("failed to compute shared secret: %w", err)
+    }
+
+    aead, err := newGCM(deriveWrapKey(shared, ephemeralRaw, deviceKey.PublicKey().Bytes()))
+    if err != nil {
+        return nil, err
+    }
+    if len(nonce) != aead.NonceSize() {
+        return nil, fmt.Errorf("invalid wrap nonce size")
+    }
+
+    contentKey, err := aead.Open(nil, nonce, wrapped, []byte(keyWrapInfo))
+    if err != nil {
+        return nil, fmt.Errorf("%w: failed to unwrap content key: %v", ErrDecryptionFailed, err)
+    }
+    if len(contentKey) != 32 {
+        return nil, fmt.Errorf("invalid content key size %d", len(contentKey))
+    }
+
+    return &payloadKey{key: contentKey, chunkSize: e.ChunkSize}, nil
+}
+
+// validate проверяет описание шифрования в манифесте
+func (e *Encryption) validate(addProblem func(string, string, ...interface{})) {
+    if e.Algorithm != EncryptionAlgorithm {
+        addProblem("encryption.algorithm", "unsupported algorithm %q, expected %s", e.Algorithm, EncryptionAlgorithm)
+    }
+    if e.KeyID == "" {
+        addProblem("encryption.key_id", "is required")
+    }
+    fields := []struct{ name, value string }{
+        {"encryption.ephemeral_public_key", e.EphemeralPublicKey},
+        {"encryption.wrap_nonce", e.WrapNonce},
+        {"encryption.wrapped_key", e.WrappedKey},
+    }
+    for _, field := range fields {
+        if _, err := base64.StdEncoding.DecodeString(field.value); err != nil || field.value == "" {
+            addProblem(field.name, "must be non-empty base64")
+        }
+    }
+    if e.ChunkSize <= 0 || e.ChunkSize > maxChunkSize {
+        addProblem("encryption.chunk_size", "must be between 1 and %d", maxChunkSize)
+    }
+}
+
+// newGCM создает AES-256-GCM для ключа
+func newGCM(key []byte) (cipher.AEAD, error) {
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create cipher: %w", err)
+    }
+    aead, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create GCM: %w", err)
+    }
+    return aead, nil
+}
+
+// chunkNonce формирует nonce блока: префикс, номер блока и признак последнего блока
+func chunkNonce(prefix []byte, counter uint32, last bool) []byte {
+    nonce := make([]byte, 0, 12)
+    nonce = append(nonce, prefix...)
+    nonce = binary.BigEndian.AppendUint32(nonce, counter)
+    if last {
+        return append(nonce, 1)
+    }
+    return append(nonce, 0)
+}
+
+// EncryptStream шифрует поток блоками AES-256-GCM (используется при сборке пакетов).
+// Формат: префикс nonce, затем блоки по chunkSize байт открытого текста с тегом аутентификации.
+// aad — дополнительные данные файла, которые получаются из PayloadAAD по его путям в архиве и на устройстве.
+func EncryptStream(w io.Writer, r io.Reader, key []byte, chunkSize int, aad []byte) error {
+    aead, err := newGCM(key)
+    if err != nil {
+        return err
+    }
+
+    prefix := make([]byte, noncePrefixSize)
+    if _, err := rand.Read(prefix); err != nil {
+        return fmt.Errorf("failed to generate nonce prefix: %w", err)
+    }
+    if _, err := w.Write(prefix); err != nil {
+        return err
+    }
+
+    src := bufio.NewReader(r)
+    plain := make([]byte, chunkSize)
+    var sealed []byte
+    for counter := uint32(0); ; counter++ {
+        n, err := io.ReadFull(src, plain)
+        if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+            return err
+        }
+
+        last := err != nil
+        if !last {
+            if _, peekErr := src.Peek(1); peekErr == io.EOF {
+                last = true
+            }
+        }
+
+        sealed = aead.Seal(sealed[:0], chunkNonce(prefix, counter, last), plain[:n], aad)
+        if _, err := w.Write(sealed); err != nil {
+            return err
+        }
+        if last {
+            return nil
+        }
+        if counter == ^uint32(0) {
+            return fmt.Errorf("payload is too large")
+        }
+    }
+}
+
+// decryptReader расшифровывает поток блоками, не загружая файл в память целиком
+type decryptReader struct {
+    src     *bufio.Reader
+    aead    cipher.AEAD
+    aad     []byte
+    prefix  []byte
+    counter uint32
+    in      []byte
+    out     []byte
+    pending []byte
+    done    bool
+}
+
+// newDecryptReader создает поток расшифровки для содержимого, зашифрованного EncryptStream
+func newDecryptReader(r io.Reader, key *payloadKey) (io.Reader, error) {
+    aead, err := newGCM(key.key)
+    if err != nil {
+        return nil, err
+    }
+
+    src := bufio.NewReader(r)
+    prefix := make([]byte, noncePrefixSize)
+    if _, err := io.ReadFull(src, prefix); err != nil {
+        return nil, errTruncated
+    }
+
+    return &decryptReader{
+        src:    src,
+        aead:   aead,
+        aad:    key.aad,
+        prefix: prefix,
+        in:     make([]byte, key.chunkSize+aead.Overhead()),
+    }, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+    for len(d.pending) == 0 {
+        if d.done {
+            return 0, io.EOF
+        }
+        if err := d.nextChunk(); err != nil {
+            return 0, err
+        }
+    }
+
+    n := copy(p, d.pending)
+    d.pending = d.pending[n:]
+    return n, nil
+}
+
+// nextChunk читает и расшифровывает следующий блок
+func (d *decryptReader) nextChunk() error {
+    n, err := io.ReadFull(d.src, d.in)
+    if err == io.EOF {
+        return errTruncated
+    }
+    if err != nil && err != io.ErrUnexpectedEOF {
+        return err
+    }
+
+    last := err == io.ErrUnexpectedEOF
+    if !last {
+        if _, peekErr := d.src.Peek(1); peekErr == io.EOF {
+            last = true
+        }
+    }
+
+    d.out, err = d.aead.Open(d.out[:0], chunkNonce(d.prefix, d.counter, last), d.in[:n], d.aad)
+    if err != nil {
+        return fmt.Errorf("%w: chunk %d: %v", ErrDecryptionFailed, d.counter, err)
+    }
+
+    d.pending = d.out
+    d.done = last
+    d.counter++
+    return nil
+}
@@ -0,0 +1,141 @@
+package update
+
+import (
+    "bytes"
+    "crypto/rand"
+    "errors"
+    "io"
+    "testing"
+)
+
+// encryptForTest шифрует данные так же, как при сборке пакета
+func encryptForTest(t *testing.T, plain, key []byte, chunkSize int, aad []byte) []byte {
+    t.Helper()
+    var buf bytes.Buffer
+    if err := EncryptStream(&buf, bytes.NewReader(plain), key, chunkSize, aad); err != nil {
+        t.Fatalf("EncryptStream: %v", err)
+    }
+    return buf.Bytes()
+}
+
+// decryptForTest расшифровывает поток целиком
+func decryptForTest(sealed []byte, key *payloadKey) ([]byte, error) {
+    r, err := newDecryptReader(bytes.NewReader(sealed), key)
+    if err != nil {
+        return nil, err
+    }
+    return io.ReadAll(r)
+}
+
+func testKey(t *testing.T) []byte {
+    t.Helper()
+    key := make([]byte, 32)
+    if _, err := rand.Read(key); err != nil {
+        t.Fatal(err)
+    }
+    return key
+}
+
+func TestEncryptStreamRoundTrip(t *testing.T) {
+    const chunkSize = 16
+    key := testKey(t)
+    payload := &payloadKey{key: key, chunkSize: chunkSize}
+
+    tests := []struct {
+        name string
+        size int
+    }{
+        {"empty", 0},
+        {"shorter than chunk", 5},
+        {"exactly one chunk", chunkSize},
+        {"chunk and a byte", chunkSize + 1},
+        {"several full chunks", 3 * chunkSize},
+        {"several chunks and a tail", 3*chunkSize + 7},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            plain := make([]byte, test.size)
+            rand.Read(plain)
+
+            bound := payload.bind("app/bin", "/opt/app/bin")
+            sealed := encryptForTest(t, plain, key, chunkSize, bound.aad)
+            got, err := decryptForTest(sealed, bound)
+            if err != nil {
+                t.Fatalf("decrypt: %v", err)
+            }
+            if !bytes.Equal(got, plain) {
+                t.Fatalf("decrypted %d bytes, want %d", len(got), len(plain))
+            }
+        })
+    }
+}
+
+func TestDecryptRejectsTampering(t *testing.T) {
+    const chunkSize = 16
+    key := testKey(t)
+    payload := &payloadKey{key: key, chunkSize: chunkSize}
+    bound := payload.bind("app/bin", "/opt/app/bin")
+
+    plain := make([]byte, 3*chunkSize+7)
+    rand.Read(plain)
+    sealed := encryptForTest(t, plain, key, chunkSize, bound.aad)
+    sealedChunk := chunkSize + 16 // блок с тегом GCM
+
+    tests := []struct {
+        name   string
+        sealed []byte
+        key    *payloadKey
+    }{
+        {"prefix only", sealed[:noncePrefixSize], bound},
+        {"truncated inside prefix", sealed[:noncePrefixSize-1], bound},
+        // Без последнего блока предпоследний расшифровывается как последний, и nonce не совпадает
+        {"last chunk dropped", sealed[:noncePrefixSize+3*sealedChunk], bound},
+        {"truncated inside chunk", sealed[:len(sealed)-3], bound},
+        {"flipped bit", flipBit(sealed, noncePrefixSize+sealedChunk+1), bound},
+        {"other destination", sealed, payload.bind("app/bin", "/opt/other/bin")},
+        {"other source", sealed, payload.bind("app/lib", "/opt/app/bin")},
+        {"other chunk size", sealed, (&payloadKey{key: key, chunkSize: 2 * chunkSize}).bind("app/bin", "/opt/app/bin")},
+        {"wrong key", sealed, (&payloadKey{key: testKey(t), chunkSize: chunkSize}).bind("app/bin", "/opt/app/bin")},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            got, err := decryptForTest(test.sealed, test.key)
+            if err == nil {
+                t.Fatalf("decrypted %d bytes, want an error", len(got))
+            }
+            if !errors.Is(err, ErrDecryptionFailed) {
+                t.Fatalf("error %v is not ErrDecryptionFailed", err)
+            }
+        })
+    }
+}
+
+func TestDecryptRejectsReorderedChunks(t *testing.T) {
+    const chunkSize = 16
+    key := testKey(t)
+    bound := (&payloadKey{key: key, chunkSize: chunkSize}).bind("app/bin", "/opt/app/bin")
+
+    plain := make([]byte, 3*chunkSize)
+    rand.Read(plain)
+    sealed := encryptForTest(t, plain, key, chunkSize, bound.aad)
+
+    sealedChunk := chunkSize + 16
+    first := sealed[noncePrefixSize : noncePrefixSize+sealedChunk]
+    second := sealed[noncePrefixSize+sealedChunk : noncePrefixSize+2*sealedChunk]
+    var reordered []byte
+    reordered = append(reordered, sealed[:noncePrefixSize]...)
+    reordered = append(reordered, second...)
+    reordered = append(reordered, first...)
+    reordered = append(reordered, sealed[noncePrefixSize+2*sealedChunk:]...)
+
+    if _, err := decryptForTest(reordered, bound); !errors.Is(err, ErrDecryptionFailed) {
+        t.Fatalf("reordered chunks: got %v, want ErrDecryptionFailed", err)
+    }
+}
+
+// flipBit возвращает копию данных с инвертированным битом в байте i
+func flipBit(data []byte, i int) []byte {
+    flipped := append([]byte(nil), data...)
+    flipped[i] ^= 1
+    return flipped
+}
@@ -0,0 +1,66 @@
+package update
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "path/filepath"
+    "syscall"
+    "servis/pkg/config"
+    "servis/pkg/health"
+)
+
+func init() {
+    health.Register(health.Check{Name: "update", Run: checkUpdate})
+    health.Register(health.Check{Name: "disk", Run: checkDiskSpace})
+}
+
+// checkUpdate проверяет, что обновление или откат не выполняется и не был прерван, а servis не останавливается:
+// посреди операции компоненты находятся в смешанном состоянии
+func checkUpdate(ctx context.Context) (health.Details, error) {
+    lockMu.Lock()
+    stopping := draining
+    lockMu.Unlock()
+    if stopping {
+        return nil, ErrShuttingDown
+    }
+
+    job, interrupted, err := CurrentJob()
+    if err != nil {
+        return nil, err
+    }
+    if job == nil {
+        return nil, nil
+    }
+    details := health.Details{"job": job, "interrupted": interrupted}
+    if interrupted {
+        return details, fmt.Errorf("%s operation %s was interrupted", job.Operation, job.ID)
+    }
+    return details, fmt.Errorf("%s operation %s is in progress", job.Operation, job.ID)
+}
+
+// checkDiskSpace проверяет, что на разделе с резервными копиями хватает места для следующего обновления
+func checkDiskSpace(ctx context.Context) (health.Details, error) {
+    cfg := config.Get()
+
+    // Каталог резервных копий создается при первом обновлении; до этого проверяется ближайший существующий
+    path := cfg.Update.BackupDir
+    for {
+        if _, err := os.Stat(path); err == nil || filepath.Dir(path) == path {
+            break
+        }
+        path = filepath.Dir(path)
+    }
+
+    var stat syscall.Statfs_t
+    if err := syscall.Statfs(path, &stat); err != nil {
+        return nil, fmt.Errorf("failed to get free space of %s: %w", path, err)
+    }
+
+    freeMB := int64(stat.Bavail) * int64(stat.Bsize) / (1 << 20)
+    details := health.Details{"path": path, "free_mb": freeMB, "min_free_mb": cfg.Health.MinFreeSpaceMB}
+    if freeMB < int64(cfg.Health.MinFreeSpaceMB) {
+        return details, fmt.Errorf("only %d MB free on %s, %d MB required", freeMB, path, cfg.Health.MinFreeSpaceMB)
+    }
+    return details, nil
+}
@@ -0,0 +1,278 @@
+package update
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "os"
+    "path/filepath"
+    "sync"
+    "syscall"
+    "time"
+)
+
+// LockFilePath — файл блокировки, общий для всех процессов servis.
+// Пока операция выполняется, на файл установлена flock-блокировка, а в самом файле записано описание операции.
+// Если процесс завершился посреди операции, описание остается в файле, и новые обновления запрещены до явного сброса.
+var LockFilePath = "/root/dt_backend/update.lock"
+
+// Операции, выполняемые под блокировкой
+const (
+    OperationUpdate   = "update"
+    OperationRollback = "rollback"
+)
+
+// Job описывает операцию, которая удерживает блокировку
+type Job struct {
+    ID        string    `json:"id"`
+    Operation string    `json:"operation"`
+    Source    string    `json:"source,omitempty"`
+    StartedAt time.Time `json:"started_at"`
+    PID       int       `json:"pid"`
+}
+
+// BusyError возвращается, если другая операция уже выполняется или была прервана
+type BusyError struct {
+    Job         Job  // пустая, если запись об операции еще не сохранена или не читается
+    Interrupted bool // операция была прервана перезапуском процесса
+}
+
+func (e *BusyError) Error() string {
+    if e.Interrupted {
+        return fmt.Sprintf("previous %s operation %s was interrupted", e.Job.Operation, e.Job.ID)
+    }
+    if e.Job.ID == "" {
+        return "another update operation is already running"
+    }
+    return fmt.Sprintf("%s operation %s is already running", e.Job.Operation, e.Job.ID)
+}
+
+var (
+    lockMu     sync.Mutex
+    currentJob *Job
+    draining   bool // servis останавливается: новые операции не начинаются
+)
+
+// updateLock — удерживаемая блокировка
+type updateLock struct {
+    file *os.File
+    job  Job
+}
+
+// acquireLock захватывает блокировку для операции.
+// Прерванная операция не мешает только откату, так как он и есть способ восстановления.
+func acquireLock(operation, source string) (*updateLock, error) {
+    lockMu.Lock()
+    defer lockMu.Unlock()
+
+    if currentJob != nil {
+        return nil, &BusyError{Job: *currentJob}
+    }
+    if draining {
+        return nil, ErrShuttingDown
+    }
+
+    err := os.MkdirAll(filepath.Dir(LockFilePath), 0755)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create lock directory: %w", err)
+    }
+
+    file, err := os.OpenFile(LockFilePath, os.O_RDWR|os.O_CREATE, 0644)
+    if err != nil {
+        return nil, fmt.Errorf("failed to open lock file: %w", err)
+    }
+
+    err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+    if err != nil {
+        job, _ := readJob(file)
+        file.Close()
+        // Блокировку держит другой процесс (например, servis во время самообновления). Он мог еще не записать
+        // операцию в файл или записать ее не полностью, но обновление все равно уже выполняется.
+        if err == syscall.EWOULDBLOCK {
+            busy := &BusyError{}
+            if job != nil {
+                busy.Job = *job
+            }
+            return nil, busy
+        }
+        return nil, fmt.Errorf("failed to lock %s: %w", LockFilePath, err)
+    }
+
+    previous, err := readJob(file)
+    if err != nil {
+        log.Printf("Ignoring unreadable lock file %s: %v", LockFilePath, err)
+    }
+    if previous != nil {
+        if operation != OperationRollback {
+            syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+            file.Close()
+            return nil, &BusyError{Job: *previous, Interrupted: true}
+        }
+        log.Printf("Recovering from interrupted %s operation %s", previous.Operation, previous.ID)
+    }
+
+    job := Job{
+        ID:        fmt.Sprintf("%s-%d", operation, time.Now().UnixNano()),
+        Operation: operation,
+        Source:    source,
+        StartedAt: time.Now(),
+        PID:       os.Getpid(),
+    }
+
+    err = writeJob(file, &job)
+    if err != nil {
+        syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+        file.Close()
+        return nil, err
+    }
+
+    currentJob = &job
+    operationInProgress.Set(1)
+    return &updateLock{file: file, job: job}, nil
+}
+
+// release освобождает блокировку и очищает описание операции
+func (l *updateLock) release() {
+    lockMu.Lock()
+    defer lockMu.Unlock()
+
+    err := writeJob(l.file, nil)
+    if err != nil {
+        log.Printf("Failed to clear lock file: %v", err)
+    }
+    syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+    l.file.Close()
+    currentJob = nil
+    operationInProgress.Set(0)
+}
+
+// readJob читает описание операции из файла блокировки; пустой файл означает отсутствие операции
+func readJob(file *os.File) (*Job, error) {
+    _, err := file.Seek(0, 0)
+    if err != nil {
+        return nil, err
+    }
+
+    data, err := ioutil.ReadAll(file)
+    if err != nil {
+        return nil, err
+    }
+    if len(data) == 0 {
+        return nil, nil
+    }
+
+    var job Job
+    err = json.Unmarshal(data, &job)
+    if err != nil {
+        return nil, fmt.Errorf("failed to unmarshal lock file: %w", err)
+    }
+    return &job, nil
+}
+
+// writeJob записывает описание операции в файл блокировки; nil очищает файл
+func writeJob(file *os.File, job *Job) error {
+    err := file.Truncate(0)
+    if err != nil {
+        return fmt.Errorf("failed to truncate lock file: %w", err)
+    }
+    if job == nil {
+        return file.Sync()
+    }
+
+    data, err := json.Marshal(job)
+    if err != nil {
+        return fmt.Errorf("failed to marshal job: %w", err)
+    }
+
+    _, err = file.WriteAt(data, 0)
+    if err != nil {
+        return fmt.Errorf("failed to write lock file: %w", err)
+    }
+    return file.Sync()
+}
+
+// CurrentJob возвращает выполняемую (или прерванную) операцию; nil, если блокировка свободна
+func CurrentJob() (*Job, bool, error) {
+    lockMu.Lock()
+    defer lockMu.Unlock()
+
+    if currentJob != nil {
+        job := *currentJob
+        return &job, false, nil
+    }
+
+    file, err := os.Open(LockFilePath)
+    if os.IsNotExist(err) {
+        return nil, false, nil
+    }
+    if err != nil {
+        return nil, false, fmt.Errorf("failed to open lock file: %w", err)
+    }
+    defer file.Close()
+
+    job, err := readJob(file)
+    if err != nil || job == nil {
+        return nil, false, err
+    }
+
+    // Если flock свободен, операция не выполняется — значит, она была прервана
+    err = syscall.Flock(int(file.Fd()), syscall.LOCK_SH|syscall.LOCK_NB)
+    if err != nil {
+        return job, false, nil
+    }
+    syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+    return job, true, nil
+}
+
+// Drain запрещает новые операции и ждет завершения текущей: обновление или откат, прерванные посередине,
+// оставляют компоненты в смешанном состоянии. Если операция не завершилась до отмены ctx, возвращается ошибка,
+// а после остановки процесса операция будет считаться прерванной.
+func Drain(ctx context.Context) error {
+    lockMu.Lock()
+    draining = true
+    lockMu.Unlock()
+
+    ticker := time.NewTicker(200 * time.Millisecond)
+    defer ticker.Stop()
+
+    logged := false
+    for {
+        lockMu.Lock()
+        job := currentJob
+        lockMu.Unlock()
+        if job == nil {
+            return nil
+        }
+
+        if !logged {
+            log.Printf("Waiting for %s operation %s to finish before stopping", job.Operation, job.ID)
+            logged = true
+        }
+
+        select {
+        case <-ctx.Done():
+            return fmt.Errorf("%s operation %s did not finish: %w", job.Operation, job.ID, ctx.Err())
+        case <-ticker.C:
+        }
+    }
+}
+
+// ClearInterruptedJob сбрасывает запись о прерванной операции, разрешая новые обновления.
+// Если прерванной операции нет, возвращает ErrNoInterruptedJob.
+func ClearInterruptedJob() error {
+    job, _, err := CurrentJob()
+    if err == nil && job == nil {
+        return ErrNoInterruptedJob
+    }
+
+    // Выполняемую операцию acquireLock отклонит с BusyError; нечитаемая запись сбрасывается как прерванная
+    lock, err := acquireLock(OperationRollback, "")
+    if err != nil {
+        return err
+    }
+    lock.release()
+    log.Println("Interrupted operation cleared")
+    return nil
+}
@@ -0,0 +1,319 @@
+package update
+
+import (
+    "archive/zip"
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "path"
+    "regexp"
+    "strings"
+)
+
+// ManifestName — имя обязательного файла с описанием прошивки в корне ZIP-архива
+const ManifestName = "manifest.json"
+
+// Поддерживаемые версии схемы манифеста
+const (
+    SchemaVersionSingle  = 1 // один безымянный набор файлов в поле files
+    SchemaVersionPackage = 2 // несколько именованных пакетов в поле packages
+)
+
+// DefaultPackageName — имя пакета для манифестов первой версии схемы
+const DefaultPackageName = "default"
+
+var (
+    packageNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+    versionPattern     = regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+    hashPattern        = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// Manifest описывает содержимое manifest.json
+type Manifest struct {
+    SchemaVersion int               `json:"schema_version"`
+    Packages      []PackageManifest `json:"packages,omitempty"`
+    Files         []FirmwareFile    `json:"files,omitempty"`
+    Encryption    *Encryption       `json:"encryption,omitempty"` // обязательно, если есть файлы с encrypted=true
+}
+
+// PackageManifest описывает именованный пакет внутри архива (например, backend, frontend, configs)
+type PackageManifest struct {
+    Name        string         `json:"name"`
+    Description string         `json:"description,omitempty"`
+    Files       []FirmwareFile `json:"files"`
+}
+
+// ManifestProblem описывает одну ошибку в манифесте
+type ManifestProblem struct {
+    Field   string `json:"field"`
+    Message string `json:"message"`
+}
+
+// ManifestError возвращается, если манифест не прошел проверку
+type ManifestError struct {
+    Problems []ManifestProblem
+}
+
+func (e *ManifestError) Error() string {
+    var parts []string
+    for _, problem := range e.Problems {
+        parts = append(parts, fmt.Sprintf("%s: %s", problem.Field, problem.Message))
+    }
+    return "invalid manifest: " + strings.Join(parts, "; ")
+}
+
+// LoadManifest читает и проверяет manifest.json из ZIP-архива
+func LoadManifest(zipReader *zip.Reader) (*Manifest, error) {
+    _, data, err := findManifest(context.Background(), zipReader)
+    if err != nil {
+        return nil, err
+    }
+
+    return parseManifest(zipReader, data)
+}
+
+// loadSignedManifest читает и проверяет manifest.json и возвращает статус его подписи
+func loadSignedManifest(ctx context.Context, zipReader *zip.Reader) (*Manifest, string, error) {
+    name, data, err := findManifest(ctx, zipReader)
+    if err != nil {
+        return nil, "", err
+    }
+
+    manifest, err := parseManifest(zipReader, data)
+    if err != nil {
+        return nil, "", err
+    }
+
+    var signature []byte
+    if sigFile := findZipFile(zipReader, name+signatureSuffix); sigFile != nil {
+        signature, err = readZipFile(ctx, sigFile)
+        if err != nil {
+            return nil, "", fmt.Errorf("failed to read signature: %w", err)
+        }
+    }
+
+    // Ошибка загрузки доверенных ключей не мешает прочитать манифест: подпись считается непроверенной
+    status, err := verifySignature(data, signature)
+    if err != nil {
+        log.Printf("Failed to verify manifest signature: %v", err)
+    }
+
+    return manifest, status, nil
+}
+
+// findManifest возвращает имя и содержимое manifest.json из корня архива
+func findManifest(ctx context.Context, zipReader *zip.Reader) (string, []byte, error) {
+    file := findZipFile(zipReader, ManifestName)
+    if file == nil {
+        return "", nil, &ManifestError{Problems: []ManifestProblem{{
+            Field:   ManifestName,
+            Message: "file not found in archive root",
+        }}}
+    }
+
+    data, err := readZipFile(ctx, file)
+    if err != nil {
+        return "", nil, fmt.Errorf("failed to read %s: %w", ManifestName, err)
+    }
+
+    return file.Name, data, nil
+}
+
+// parseManifest разбирает манифест и проверяет его по содержимому архива
+func parseManifest(zipReader *zip.Reader, data []byte) (*Manifest, error) {
+    decoder := json.NewDecoder(bytes.NewReader(data))
+    decoder.DisallowUnknownFields()
+
+    var manifest Manifest
+    err := decoder.Decode(&manifest)
+    if err != nil {
+        return nil, &ManifestError{Problems: []ManifestProblem{{
+            Field:   ManifestName,
+            Message: fmt.Sprintf("malformed JSON: %v", err),
+        }}}
+    }
+
+    problems := manifest.validate(zipReader)
+    if len(problems) > 0 {
+        return nil, &ManifestError{Problems: problems}
+    }
+
+    // Манифест первой версии приводится к одному пакету, чтобы дальше работать единообразно
+    if manifest.SchemaVersion == SchemaVersionSingle {
+        manifest.Packages = []PackageManifest{{Name: DefaultPackageName, Files: manifest.Files}}
+        manifest.Files = nil
+    }
+
+    return &manifest, nil
+}
+
+// validate проверяет манифест и возвращает список всех найденных проблем
+func (m *Manifest) validate(zipReader *zip.Reader) []ManifestProblem {
+    var problems []ManifestProblem
+    addProblem := func(field, format string, args ...interface{}) {
+        problems = append(problems, ManifestProblem{Field: field, Message: fmt.Sprintf(format, args...)})
+    }
+
+    switch m.SchemaVersion {
+    case 0:
+        addProblem("schema_version", "is required")
+        return problems
+    case SchemaVersionSingle:
+        if len(m.Packages) > 0 {
+            addProblem("packages", "is not supported by schema version %d", m.SchemaVersion)
+        }
+        if len(m.Files) == 0 {
+            addProblem("files", "must contain at least one entry")
+        }
+        validateFiles(zipReader, "files", m.Files, addProblem)
+    case SchemaVersionPackage:
+        if len(m.Files) > 0 {
+            addProblem("files", "is not supported by schema version %d, use packages", m.SchemaVersion)
+        }
+        if len(m.Packages) == 0 {
+            addProblem("packages", "must contain at least one package")
+        }
+
+        names := make(map[string]bool)
+        for i, pkg := range m.Packages {
+            field := fmt.Sprintf("packages[%d]", i)
+            if !packageNamePattern.MatchString(pkg.Name) {
+                addProblem(field+".name", "invalid package name %q", pkg.Name)
+            } else if names[pkg.Name] {
+                addProblem(field+".name", "duplicate package name %q", pkg.Name)
+            }
+            names[pkg.Name] = true
+
+            if len(pkg.Files) == 0 {
+                addProblem(field+".files", "must contain at least one entry")
+            }
+            validateFiles(zipReader, field+".files", pkg.Files, addProblem)
+        }
+    default:
+        addProblem("schema_version", "unsupported version %d", m.SchemaVersion)
+        return problems
+    }
+
+    // Одно назначение не может принадлежать двум пакетам
+    destinations := make(map[string]string)
+    m.eachFile(func(field string, file FirmwareFile) {
+        if file.Encrypted && m.Encryption == nil {
+            addProblem(field+".encrypted", "requires the encryption section")
+        }
+        if previous, ok := destinations[file.Destination]; ok {
+            addProblem(field+".destination", "duplicates %s", previous)
+            return
+        }
+        destinations[file.Destination] = field
+    })
+
+    if m.Encryption != nil {
+        m.Encryption.validate(addProblem)
+    }
+
+    return problems
+}
+
+// validateFiles проверяет записи о файлах
+func validateFiles(zipReader *zip.Reader, field string, files []FirmwareFile, addProblem func(string, string, ...interface{})) {
+    for i, file := range files {
+        fileField := fmt.Sprintf("%s[%d]", field, i)
+
+        if file.Source == "" {
+            addProblem(fileField+".source", "is required")
+        } else if !zipContains(zipReader, file.Source, file.IsDir) {
+            addProblem(fileField+".source", "%s not found in archive", file.Source)
+        }
+
+        if !path.IsAbs(file.Destination) {
+            addProblem(fileField+".destination", "must be an absolute path, got %q", file.Destination)
+        }
+
+        if !versionPattern.MatchString(file.FileVersion) {
+            addProblem(fileField+".file_version", "invalid version %q, expected X.Y.Z", file.FileVersion)
+        }
+
+        if file.Hash != "" && !hashPattern.MatchString(file.Hash) {
+            addProblem(fileField+".hash", "must be a lowercase hex SHA-256")
+        }
+
+        for required, minVersion := range file.Requires {
+            if !versionPattern.MatchString(minVersion) {
+                addProblem(fileField+".requires", "invalid version %q for %s", minVersion, required)
+            }
+        }
+    }
+}
+
+// zipContains проверяет наличие файла или директории в архиве
+func zipContains(zipReader *zip.Reader, source string, isDir bool) bool {
+    prefix := zipDirPrefix(source)
+    for _, file := range zipReader.File {
+        if !isDir && file.Name == source {
+            return true
+        }
+        if isDir && strings.HasPrefix(file.Name, prefix) {
+            return true
+        }
+    }
+    return false
+}
+
+// zipDirPrefix возвращает префикс имен файлов директории source в архиве. Префикс заканчивается на "/",
+// чтобы директории app не соответствовали файлы соседней app2.
+func zipDirPrefix(source string) string {
+    return strings.TrimSuffix(source, "/") + "/"
+}
+
+// eachFile обходит все файлы манифеста с указанием поля для сообщений об ошибках
+func (m *Manifest) eachFile(fn func(field string, file FirmwareFile)) {
+    for i, file := range m.Files {
+        fn(fmt.Sprintf("files[%d]", i), file)
+    }
+    for i, pkg := range m.Packages {
+        for j, file := range pkg.Files {
+            fn(fmt.Sprintf("packages[%d].files[%d]", i, j), file)
+        }
+    }
+}
+
+// Encrypted сообщает, содержит ли манифест зашифрованные файлы
+func (m *Manifest) Encrypted() bool {
+    encrypted := false
+    m.eachFile(func(_ string, file FirmwareFile) {
+        encrypted = encrypted || file.Encrypted
+    })
+    return encrypted
+}
+
+// Package возвращает пакет по имени
+func (m *Manifest) Package(name string) (*PackageManifest, error) {
+    for i := range m.Packages {
+        if m.Packages[i].Name == name {
+            return &m.Packages[i], nil
+        }
+    }
+    return nil, fmt.Errorf("%w: %s", ErrUnknownPackage, name)
+}
+
+// Firmware объединяет файлы выбранных пакетов; пустой список означает все пакеты
+func (m *Manifest) Firmware(packages []string) (*FirmwareInfo, error) {
+    firmwareInfo := &FirmwareInfo{}
+    if len(packages) == 0 {
+        for _, pkg := range m.Packages {
+            firmwareInfo.Files = append(firmwareInfo.Files, pkg.Files...)
+        }
+        return firmwareInfo, nil
+    }
+
+    for _, name := range packages {
+        pkg, err := m.Package(name)
+        if err != nil {
+            return nil, err
+        }
+        firmwareInfo.Files = append(firmwareInfo.Files, pkg.Files...)
+    }
+    return firmwareInfo, nil
+}
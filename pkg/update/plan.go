@@ -0,0 +1,107 @@
+package update
+
+import (
+    "context"
+    "fmt"
+    "os"
+)
+
+// Действия над компонентом в плане обновления
+const (
+    PlanInstall = "install" // компонент еще не установлен
+    PlanUpgrade = "upgrade" // установленная версия будет заменена новой
+    PlanSkip    = "skip"    // установленная версия новее версии в архиве, компонент не изменится
+)
+
+// PlannedChange описывает, что обновление сделает с одним компонентом
+type PlannedChange struct {
+    Destination      string `json:"destination"`
+    IsDir            bool   `json:"is_dir"`
+    InstalledVersion string `json:"installed_version,omitempty"`
+    NewVersion       string `json:"new_version"`
+    Action           string `json:"action"`
+    Modified         bool   `json:"modified,omitempty"` // установленный компонент изменен, обновление остановится с ErrHashMismatch
+}
+
+// UpdatePlan — результат проверки архива без установки: изменения по компонентам и препятствия к установке
+type UpdatePlan struct {
+    Archive   string          `json:"archive"`
+    Signature string          `json:"signature"`
+    Encrypted bool            `json:"encrypted"`
+    Changes   []PlannedChange `json:"changes"`
+    Issues    []string        `json:"issues,omitempty"`
+}
+
+// PlanUpdate показывает, что сделает UpdatePackages с теми же аргументами, ничего не меняя на устройстве.
+// Ошибки архива и манифеста те же, что у UpdatePackages.
+func PlanUpdate(zipFilePath string, versionFilePath string, packages []string) (*UpdatePlan, error) {
+    if _, err := os.Stat(zipFilePath); os.IsNotExist(err) {
+        return nil, fmt.Errorf("%w: %s", ErrArchiveNotFound, zipFilePath)
+    }
+
+    cached := readPackageManifest(context.Background(), zipFilePath)
+    if cached.err != nil {
+        return nil, fmt.Errorf("failed to find valid firmware: %w", cached.err)
+    }
+
+    firmwareInfo, err := cached.manifest.Firmware(packages)
+    if err != nil {
+        return nil, fmt.Errorf("failed to select packages: %w", err)
+    }
+
+    installedVersions, err := LoadInstalledVersions(versionFilePath)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load installed versions: %w", err)
+    }
+
+    plan := &UpdatePlan{
+        Archive:   zipFilePath,
+        Signature: cached.signature,
+        Encrypted: cached.manifest.Encrypted(),
+        Changes:   []PlannedChange{},
+        Issues:    checkCompatibility(firmwareInfo, installedVersions),
+    }
+    if plan.Encrypted {
+        plan.Issues = append(plan.Issues, checkEncryption(cached.manifest.Encryption)...)
+    }
+
+    for _, file := range firmwareInfo.Files {
+        change := PlannedChange{
+            Destination: file.Destination,
+            IsDir:       file.IsDir,
+            NewVersion:  file.FileVersion,
+            Action:      PlanInstall,
+        }
+
+        if installed := findInstalled(installedVersions, file.Destination); installed != nil && installed.FileVersion != "" {
+            change.InstalledVersion = installed.FileVersion
+            change.Action = PlanSkip
+            if isNewer, err := compareVersions(file.FileVersion, installed.FileVersion); err == nil && isNewer {
+                change.Action = PlanUpgrade
+            }
+        }
+
+        // Как и при установке, хеш проверяется только у компонентов, которые будут заменены
+        if change.Action == PlanUpgrade {
+            hash, err := componentHash(file)
+            if err != nil {
+                plan.Issues = append(plan.Issues, fmt.Sprintf("%s: failed to calculate hash: %v", file.Destination, err))
+            } else if hash != file.Hash {
+                change.Modified = true
+                plan.Issues = append(plan.Issues, fmt.Sprintf("%s: installed component was modified", file.Destination))
+            }
+        }
+
+        plan.Changes = append(plan.Changes, change)
+    }
+
+    return plan, nil
+}
+
+// componentHash вычисляет текущий хеш установленного файла или директории
+func componentHash(file FirmwareFile) (string, error) {
+    if file.IsDir {
+        return calculateDirectoryHash(file.Destination)
+    }
+    return calculateFileHash(file.Destination)
+}
@@ -0,0 +1,77 @@
+package update
+
+import (
+    "time"
+    "servis/pkg/events"
+    "servis/pkg/metrics"
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+    operationsTotal = metrics.Factory.NewCounterVec(prometheus.CounterOpts{
+        Namespace: metrics.Namespace,
+        Subsystem: "update",
+        Name:      "operations_total",
+        Help:      "Завершенные операции обновления и отката по результату (success или failure).",
+    }, []string{"operation", "result"})
+
+    operationDuration = metrics.Factory.NewHistogramVec(prometheus.HistogramOpts{
+        Namespace: metrics.Namespace,
+        Subsystem: "update",
+        Name:      "operation_duration_seconds",
+        Help:      "Длительность операций обновления и отката.",
+        Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800},
+    }, []string{"operation"})
+
+    operationInProgress = metrics.Factory.NewGauge(prometheus.GaugeOpts{
+        Namespace: metrics.Namespace,
+        Subsystem: "update",
+        Name:      "in_progress",
+        Help:      "1, если операция обновления или отката выполняется.",
+    })
+)
+
+// started сообщает подписчикам о начале операции
+func (l *updateLock) started(details map[string]interface{}) {
+    data := map[string]interface{}{
+        "job_id": l.job.ID,
+        "source": l.job.Source,
+    }
+    for key, value := range details {
+        data[key] = value
+    }
+    events.Publish(events.TopicUpdate, l.job.Operation+"_started", data)
+}
+
+// progress сообщает о завершении шага операции: обработано done из total назначений
+func (l *updateLock) progress(destination string, done, total int) {
+    events.Publish(events.TopicUpdate, l.job.Operation+"_progress", map[string]interface{}{
+        "job_id":      l.job.ID,
+        "destination": destination,
+        "done":        done,
+        "total":       total,
+    })
+}
+
+// finish сообщает о результате операции и освобождает блокировку
+func (l *updateLock) finish(err error) {
+    l.release()
+
+    result := "success"
+    if err != nil {
+        result = "failure"
+    }
+    operationsTotal.WithLabelValues(l.job.Operation, result).Inc()
+    operationDuration.WithLabelValues(l.job.Operation).Observe(time.Since(l.job.StartedAt).Seconds())
+
+    if err != nil {
+        events.Publish(events.TopicUpdate, l.job.Operation+"_failed", map[string]interface{}{
+            "job_id": l.job.ID,
+            "error":  err.Error(),
+        })
+        return
+    }
+    events.Publish(events.TopicUpdate, l.job.Operation+"_completed", map[string]interface{}{
+        "job_id": l.job.ID,
+    })
+}
@@ -0,0 +1,262 @@
+package update
+
+import (
+    "fmt"
+    "log"
+    "sort"
+    "strings"
+
+    "servis/pkg/selfupdate"
+)
+
+// ComponentRollback описывает результат отката одного компонента
+type ComponentRollback struct {
+    Destination string `json:"destination"`
+    FromVersion string `json:"from_version"`
+    ToVersion   string `json:"to_version"`
+    BackupID    string `json:"backup_id"`
+}
+
+// DependencyError возвращается, если откат нарушит требования других установленных компонентов
+type DependencyError struct {
+    Dependents map[string][]string // откатываемое назначение -> зависящие от него назначения
+}
+
+func (e *DependencyError) Error() string {
+    var parts []string
+    for destination, dependents := range e.Dependents {
+        parts = append(parts, fmt.Sprintf("%s is required by %s", destination, strings.Join(dependents, ", ")))
+    }
+    sort.Strings(parts)
+    return "rollback breaks dependencies: " + strings.Join(parts, "; ")
+}
+
+// plannedRollback связывает назначение с записью резервной копии, из которой оно будет восстановлено
+type plannedRollback struct {
+    generation *BackupGeneration
+    entry      *BackupEntry
+}
+
+// planRollback проверяет зависимости и составляет план отката.
+// Компонент, которому после отката не хватит версии зависимости, либо добавляется в план (includeDependents), либо приводит к DependencyError.
+func planRollback(installedVersions *InstalledVersionInfo, generations []BackupGeneration, destinations []string, includeDependents bool) ([]string, map[string]plannedRollback, error) {
+    plan := make(map[string]plannedRollback)
+    add := func(destination string) error {
+        if findInstalled(installedVersions, destination) == nil {
+            return fmt.Errorf("%w: %s", ErrNotInstalled, destination)
+        }
+        generation, entry := findPreviousVersion(generations, destination)
+        if generation == nil {
+            return fmt.Errorf("%w for component %s", ErrBackupNotFound, destination)
+        }
+        plan[destination] = plannedRollback{generation: generation, entry: entry}
+        return nil
+    }
+
+    for _, destination := range destinations {
+        err := add(destination)
+        if err != nil {
+            return nil, nil, err
+        }
+    }
+
+    for {
+        dependents := make(map[string][]string)
+        for _, file := range installedVersions.Files {
+            if _, ok := plan[file.Destination]; ok {
+                continue
+            }
+            for required, minVersion := range file.Requires {
+                planned, ok := plan[required]
+                if !ok {
+                    continue
+                }
+                if !satisfiesVersion(planned.entry.FileVersion, minVersion) {
+                    dependents[required] = append(dependents[required], file.Destination)
+                }
+            }
+        }
+
+        if len(dependents) == 0 {
+            break
+        }
+        if !includeDependents {
+            return nil, nil, &DependencyError{Dependents: dependents}
+        }
+
+        for _, list := range dependents {
+            for _, destination := range list {
+                err := add(destination)
+                if err != nil {
+                    return nil, nil, fmt.Errorf("failed to include dependent component: %w", err)
+                }
+            }
+        }
+    }
+
+    // Сохраняем порядок из инвентаря, чтобы откат был воспроизводимым
+    var order []string
+    for _, file := range installedVersions.Files {
+        if _, ok := plan[file.Destination]; ok {
+            order = append(order, file.Destination)
+        }
+    }
+    return order, plan, nil
+}
+
+// satisfiesVersion проверяет, что версия не ниже минимальной; отсутствующая версия требованиям не удовлетворяет
+func satisfiesVersion(version, minVersion string) bool {
+    if version == "" {
+        return false
+    }
+    ok, err := compareVersions(version, minVersion)
+    return err == nil && ok
+}
+
+// findInstalled возвращает запись инвентаря для назначения или nil
+func findInstalled(installedVersions *InstalledVersionInfo, destination string) *InstalledFile {
+    for i := range installedVersions.Files {
+        if installedVersions.Files[i].Destination == destination {
+            return &installedVersions.Files[i]
+        }
+    }
+    return nil
+}
+
+// removeInstalled удаляет запись инвентаря для назначения
+func removeInstalled(installedVersions *InstalledVersionInfo, destination string) {
+    files := installedVersions.Files[:0]
+    for _, file := range installedVersions.Files {
+        if file.Destination != destination {
+            files = append(files, file)
+        }
+    }
+    installedVersions.Files = files
+}
+
+// findPreviousVersion ищет самое новое неиспользованное поколение, содержащее предыдущую версию назначения
+func findPreviousVersion(generations []BackupGeneration, destination string) (*BackupGeneration, *BackupEntry) {
+    for i := range generations {
+        for j := range generations[i].Entries {
+            entry := &generations[i].Entries[j]
+            if entry.Destination == destination && !entry.Restored {
+                return &generations[i], entry
+            }
+        }
+    }
+    return nil, nil
+}
+
+// RollbackComponents откатывает выбранные назначения на предыдущие версии, не затрагивая остальные компоненты
+func RollbackComponents(backupDir, versionFilePath string, destinations []string, includeDependents bool) (results []ComponentRollback, err error) {
+    if len(destinations) == 0 {
+        return nil, fmt.Errorf("no components selected")
+    }
+
+    lock, err := acquireLock(OperationRollback, strings.Join(destinations, ", "))
+    if err != nil {
+        return nil, err
+    }
+    defer func() { lock.finish(err) }()
+
+    log.Printf("Starting rollback of components: %s", strings.Join(destinations, ", "))
+
+    installedVersions, err := LoadInstalledVersions(versionFilePath)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load installed versions: %w", err)
+    }
+
+    generations, err := ListBackups(backupDir)
+    if err != nil {
+        return nil, fmt.Errorf("failed to list backups: %w", err)
+    }
+
+    rollbackSet, plan, err := planRollback(installedVersions, generations, destinations, includeDependents)
+    if err != nil {
+        return nil, err
+    }
+
+    lock.started(map[string]interface{}{"destinations": rollbackSet, "total": len(rollbackSet)})
+    selfupdate.Discard()
+
+    var selfDestination string
+    openedDirs := make(map[string]string)
+    for i, destination := range rollbackSet {
+        planned := plan[destination]
+
+        dir, ok := openedDirs[planned.generation.ID]
+        if !ok {
+            var cleanup func()
+            dir, cleanup, err = openBackup(planned.generation)
+            if err != nil {
+                return results, fmt.Errorf("failed to open backup %s: %w", planned.generation.ID, err)
+            }
+            defer cleanup()
+            openedDirs[planned.generation.ID] = dir
+        }
+
+        err = restoreEntry(planned.entry.path(dir), destination)
+        if err != nil {
+            return results, err
+        }
+
+        installed := findInstalled(installedVersions, destination)
+        results = append(results, ComponentRollback{
+            Destination: destination,
+            FromVersion: installed.FileVersion,
+            ToVersion:   planned.entry.FileVersion,
+            BackupID:    planned.generation.ID,
+        })
+        lock.progress(destination, i+1, len(rollbackSet))
+
+        // Прежняя версия servis пока только подготовлена: в инвентарь она попадет после успешного запуска
+        if selfupdate.IsSelf(destination) {
+            selfDestination = destination
+            continue
+        }
+
+        err = recordRollback(versionFilePath, installedVersions, destination, planned)
+        if err != nil {
+            return results, err
+        }
+    }
+
+    if selfDestination != "" {
+        err = startRestoredSelf()
+        if err != nil {
+            return results, err
+        }
+
+        err = recordRollback(versionFilePath, installedVersions, selfDestination, plan[selfDestination])
+        if err != nil {
+            return results, err
+        }
+    }
+
+    return results, nil
+}
+
+// recordRollback записывает откатанную версию в инвентарь и отмечает копию в поколении как использованную
+func recordRollback(versionFilePath string, installedVersions *InstalledVersionInfo, destination string, planned plannedRollback) error {
+    if planned.entry.FileVersion == "" {
+        removeInstalled(installedVersions, destination)
+    } else {
+        installed := findInstalled(installedVersions, destination)
+        installed.FileVersion = planned.entry.FileVersion
+        installed.Requires = planned.entry.Requires
+    }
+
+    // Инвентарь сохраняется после каждого компонента, чтобы он не расходился с файлами при сбое
+    err := saveInstalledVersions(versionFilePath, installedVersions)
+    if err != nil {
+        return fmt.Errorf("failed to save installed versions: %w", err)
+    }
+
+    err = planned.generation.markRestored(destination)
+    if err != nil {
+        return fmt.Errorf("failed to update backup %s: %w", planned.generation.ID, err)
+    }
+
+    log.Printf("Component %s rolled back to version %s", destination, planned.entry.FileVersion)
+    return nil
+}
@@ -0,0 +1,385 @@
+package update
+
+import (
+    "archive/zip"
+    "context"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// ScanOptions задает параметры поиска пакетов прошивки на USB-накопителях
+type ScanOptions struct {
+    MaxDepth    int           // максимальная глубина вложенности директорий (0 — только корень)
+    Workers     int           // количество одновременно проверяемых архивов
+    FileTimeout time.Duration // время на проверку одного архива
+}
+
+// DefaultScanOptions используются, если параметры поиска не заданы
+var DefaultScanOptions = ScanOptions{
+    MaxDepth:    3,
+    Workers:     4,
+    FileTimeout: 10 * time.Second,
+}
+
+// PackageFile описывает файл из манифеста пакета
+type PackageFile struct {
+    Source      string `json:"source"`
+    Destination string `json:"destination"`
+    FileVersion string `json:"file_version"`
+}
+
+// PackageInfo содержит результат проверки одного архива прошивки.
+// Ошибка проверки архива не прерывает поиск, а возвращается в поле Error.
+type PackageInfo struct {
+    Path          string            `json:"path"`
+    Size          int64             `json:"size"`
+    ModTime       time.Time         `json:"mod_time"`
+    SchemaVersion int               `json:"schema_version,omitempty"`
+    Files         []PackageFile     `json:"files"`
+    Packages      []BundlePackage   `json:"packages,omitempty"`
+    Signature     string            `json:"signature"`
+    Encrypted     bool              `json:"encrypted"`
+    Compatible    bool              `json:"compatible"`
+    Issues        []string          `json:"issues,omitempty"`
+    Error         string            `json:"error,omitempty"`
+    Problems      []ManifestProblem `json:"problems,omitempty"`
+}
+
+// BundlePackage описывает именованный пакет внутри архива, который можно установить отдельно
+type BundlePackage struct {
+    Name        string        `json:"name"`
+    Description string        `json:"description,omitempty"`
+    Files       []PackageFile `json:"files"`
+    Compatible  bool          `json:"compatible"`
+    Issues      []string      `json:"issues,omitempty"`
+}
+
+// manifestCacheKey — ключ кэша: путь, размер и время изменения архива
+type manifestCacheKey struct {
+    path    string
+    size    int64
+    modTime int64
+}
+
+// cachedManifest — разобранный манифест архива
+type cachedManifest struct {
+    manifest  *Manifest
+    signature string
+    err       error
+}
+
+var (
+    manifestCacheMu sync.Mutex
+    manifestCache   = make(map[manifestCacheKey]cachedManifest)
+)
+
+// ScanUSBPackages рекурсивно ищет ZIP-пакеты на всех USB-накопителях и проверяет их.
+// Нечитаемые директории и поврежденные архивы попадают в результат с описанием ошибки.
+func ScanUSBPackages(ctx context.Context, versionFilePath string, opts ScanOptions) ([]PackageInfo, error) {
+    mountPoints, err := GetUSBMountPoints()
+    if err != nil {
+        return nil, err
+    }
+
+    return ScanPackages(ctx, mountPoints, versionFilePath, opts)
+}
+
+// ScanPackages ищет и проверяет ZIP-пакеты в указанных корневых директориях
+func ScanPackages(ctx context.Context, roots []string, versionFilePath string, opts ScanOptions) ([]PackageInfo, error) {
+    if opts.Workers <= 0 {
+        opts.Workers = DefaultScanOptions.Workers
+    }
+    if opts.FileTimeout <= 0 {
+        opts.FileTimeout = DefaultScanOptions.FileTimeout
+    }
+
+    installedVersions, err := LoadInstalledVersions(versionFilePath)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load installed versions: %w", err)
+    }
+
+    var results []PackageInfo
+    var candidates []string
+    for _, root := range roots {
+        found, failures := findZipFiles(root, opts.MaxDepth)
+        candidates = append(candidates, found...)
+        results = append(results, failures...)
+    }
+
+    jobs := make(chan string)
+    packages := make(chan PackageInfo)
+    var wg sync.WaitGroup
+    for i := 0; i < opts.Workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for path := range jobs {
+                packages <- inspectWithTimeout(ctx, path, opts.FileTimeout, installedVersions)
+            }
+        }()
+    }
+
+    go func() {
+        defer close(jobs)
+        for _, path := range candidates {
+            select {
+            case jobs <- path:
+            case <-ctx.Done():
+                return
+            }
+        }
+    }()
+
+    go func() {
+        wg.Wait()
+        close(packages)
+    }()
+
+    for info := range packages {
+        results = append(results, info)
+    }
+
+    pruneManifestCache(candidates)
+
+    sort.Slice(results, func(i, j int) bool {
+        return results[i].Path < results[j].Path
+    })
+
+    return results, ctx.Err()
+}
+
+// findZipFiles обходит директорию до заданной глубины и возвращает найденные ZIP-файлы
+// и записи об ошибках для директорий, которые не удалось прочитать
+func findZipFiles(root string, maxDepth int) ([]string, []PackageInfo) {
+    var found []string
+    var failures []PackageInfo
+
+    var walk func(dir string, depth int)
+    walk = func(dir string, depth int) {
+        entries, err := os.ReadDir(dir)
+        if err != nil {
+            failures = append(failures, PackageInfo{
+                Path:  dir,
+                Error: fmt.Sprintf("failed to read directory: %v", err),
+            })
+            return
+        }
+
+        for _, entry := range entries {
+            // Скрытые и служебные директории (.Trashes, .Spotlight-V100 и т.п.) пропускаются
+            if strings.HasPrefix(entry.Name(), ".") {
+                continue
+            }
+
+            path := filepath.Join(dir, entry.Name())
+            if entry.IsDir() {
+                if depth < maxDepth {
+                    walk(path, depth+1)
+                }
+                continue
+            }
+
+            if entry.Type().IsRegular() && strings.HasSuffix(strings.ToLower(entry.Name()), ".zip") {
+                found = append(found, path)
+            }
+        }
+    }
+
+    walk(root, 0)
+    return found, failures
+}
+
+// inspectWithTimeout проверяет архив, ограничивая время проверки
+func inspectWithTimeout(ctx context.Context, path string, timeout time.Duration, installedVersions *InstalledVersionInfo) PackageInfo {
+    ctx, cancel := context.WithTimeout(ctx, timeout)
+    defer cancel()
+
+    done := make(chan PackageInfo, 1)
+    go func() {
+        done <- inspectPackage(ctx, path, installedVersions)
+    }()
+
+    select {
+    case info := <-done:
+        return info
+    case <-ctx.Done():
+        log.Printf("Inspection of %s timed out", path)
+        return PackageInfo{
+            Path:  path,
+            Error: fmt.Sprintf("inspection timed out: %v", ctx.Err()),
+        }
+    }
+}
+
+// inspectPackage проверяет один архив, используя кэш разобранных манифестов. После отмены ctx чтение архива
+// прекращается, а результат не кэшируется.
+func inspectPackage(ctx context.Context, path string, installedVersions *InstalledVersionInfo) PackageInfo {
+    info := PackageInfo{Path: path}
+
+    stat, err := os.Stat(path)
+    if err != nil {
+        info.Error = fmt.Sprintf("failed to stat file: %v", err)
+        return info
+    }
+    info.Size = stat.Size()
+    info.ModTime = stat.ModTime()
+
+    key := manifestCacheKey{path: path, size: stat.Size(), modTime: stat.ModTime().UnixNano()}
+
+    manifestCacheMu.Lock()
+    cached, ok := manifestCache[key]
+    manifestCacheMu.Unlock()
+
+    if !ok {
+        cached = readPackageManifest(ctx, path)
+        if ctx.Err() != nil {
+            info.Error = fmt.Sprintf("inspection canceled: %v", ctx.Err())
+            return info
+        }
+        manifestCacheMu.Lock()
+        for oldKey := range manifestCache {
+            if oldKey.path == path {
+                delete(manifestCache, oldKey)
+            }
+        }
+        manifestCache[key] = cached
+        manifestCacheMu.Unlock()
+    }
+
+    info.Signature = cached.signature
+    if cached.err != nil {
+        info.Error = cached.err.Error()
+        var manifestErr *ManifestError
+        if errors.As(cached.err, &manifestErr) {
+            info.Problems = manifestErr.Problems
+        }
+        return info
+    }
+
+    info.SchemaVersion = cached.manifest.SchemaVersion
+    for _, pkg := range cached.manifest.Packages {
+        bundlePackage := BundlePackage{
+            Name:        pkg.Name,
+            Description: pkg.Description,
+            Files:       packageFiles(pkg.Files),
+        }
+        bundlePackage.Issues = checkCompatibility(&FirmwareInfo{Files: pkg.Files}, installedVersions)
+        bundlePackage.Compatible = len(bundlePackage.Issues) == 0
+        info.Packages = append(info.Packages, bundlePackage)
+    }
+
+    firmwareInfo, _ := cached.manifest.Firmware(nil)
+    info.Files = packageFiles(firmwareInfo.Files)
+    info.Issues = checkCompatibility(firmwareInfo, installedVersions)
+    if cached.manifest.Encrypted() {
+        info.Encrypted = true
+        info.Issues = append(info.Issues, checkEncryption(cached.manifest.Encryption)...)
+    }
+    info.Compatible = len(info.Issues) == 0
+    return info
+}
+
+// checkEncryption проверяет, что зашифрованный пакет предназначен для ключа этого устройства
+func checkEncryption(encryption *Encryption) []string {
+    deviceKey, err := LoadDeviceKey()
+    if err != nil {
+        return []string{"device key is not available for encrypted package"}
+    }
+
+    if keyID := DeviceKeyID(deviceKey.PublicKey()); encryption.KeyID != keyID {
+        return []string{fmt.Sprintf("package is encrypted for key %s, device key is %s", encryption.KeyID, keyID)}
+    }
+
+    return nil
+}
+
+// packageFiles преобразует записи манифеста в описание для списка пакетов
+func packageFiles(files []FirmwareFile) []PackageFile {
+    result := []PackageFile{}
+    for _, file := range files {
+        result = append(result, PackageFile{
+            Source:      file.Source,
+            Destination: file.Destination,
+            FileVersion: file.FileVersion,
+        })
+    }
+    return result
+}
+
+// readPackageManifest открывает архив и читает из него манифест и статус подписи; после отмены ctx чтение прекращается
+func readPackageManifest(ctx context.Context, path string) cachedManifest {
+    zipReader, err := zip.OpenReader(path)
+    if err != nil {
+        return cachedManifest{err: fmt.Errorf("failed to open zip file: %w", err)}
+    }
+    defer zipReader.Close()
+
+    manifest, status, err := loadSignedManifest(ctx, &zipReader.Reader)
+    if err != nil {
+        return cachedManifest{err: err}
+    }
+
+    return cachedManifest{manifest: manifest, signature: status}
+}
+
+// checkCompatibility проверяет, можно ли установить пакет поверх текущих версий
+func checkCompatibility(firmwareInfo *FirmwareInfo, installedVersions *InstalledVersionInfo) []string {
+    var issues []string
+    packageVersions := make(map[string]string)
+    for _, file := range firmwareInfo.Files {
+        packageVersions[file.Destination] = file.FileVersion
+    }
+
+    for _, file := range firmwareInfo.Files {
+        if _, err := compareVersions(file.FileVersion, file.FileVersion); err != nil {
+            issues = append(issues, fmt.Sprintf("%s: invalid version %s", file.Destination, file.FileVersion))
+            continue
+        }
+
+        installed := findInstalled(installedVersions, file.Destination)
+        if installed != nil && installed.FileVersion != "" {
+            isNewer, err := compareVersions(file.FileVersion, installed.FileVersion)
+            if err == nil && !isNewer {
+                issues = append(issues, fmt.Sprintf("%s: installed version %s is newer than %s", file.Destination, installed.FileVersion, file.FileVersion))
+            }
+        }
+
+        for required, minVersion := range file.Requires {
+            version, ok := packageVersions[required]
+            if !ok {
+                if dependency := findInstalled(installedVersions, required); dependency != nil {
+                    version = dependency.FileVersion
+                }
+            }
+            if !satisfiesVersion(version, minVersion) {
+                issues = append(issues, fmt.Sprintf("%s: requires %s >= %s", file.Destination, required, minVersion))
+            }
+        }
+    }
+
+    return issues
+}
+
+// pruneManifestCache удаляет из кэша архивы, которых больше нет на накопителях
+func pruneManifestCache(paths []string) {
+    present := make(map[string]bool, len(paths))
+    for _, path := range paths {
+        present[path] = true
+    }
+
+    manifestCacheMu.Lock()
+    defer manifestCacheMu.Unlock()
+
+    for key := range manifestCache {
+        if !present[key.path] {
+            delete(manifestCache, key)
+        }
+    }
+}
@@ -0,0 +1,84 @@
+package update
+
+import (
+    "crypto/ed25519"
+    "encoding/base64"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// TrustedKeysDir — директория с доверенными открытыми ключами Ed25519 (файлы *.pub в base64)
+var TrustedKeysDir = "/root/dt_backend/trusted_keys"
+
+// Статусы подписи манифеста
+const (
+    SignatureValid    = "valid"
+    SignatureInvalid  = "invalid"
+    SignatureUnsigned = "unsigned"
+    SignatureUnknown  = "unknown_key" // подпись есть, но доверенных ключей нет
+)
+
+// signatureSuffix — суффикс файла подписи рядом с манифестом в ZIP-архиве
+const signatureSuffix = ".sig"
+
+// loadTrustedKeys загружает доверенные открытые ключи
+func loadTrustedKeys() ([]ed25519.PublicKey, error) {
+    entries, err := os.ReadDir(TrustedKeysDir)
+    if os.IsNotExist(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to read trusted keys directory: %w", err)
+    }
+
+    var keys []ed25519.PublicKey
+    for _, entry := range entries {
+        if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".pub") {
+            continue
+        }
+
+        data, err := ioutil.ReadFile(filepath.Join(TrustedKeysDir, entry.Name()))
+        if err != nil {
+            return nil, fmt.Errorf("failed to read trusted key %s: %w", entry.Name(), err)
+        }
+
+        key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+        if err != nil || len(key) != ed25519.PublicKeySize {
+            return nil, fmt.Errorf("invalid trusted key %s", entry.Name())
+        }
+        keys = append(keys, ed25519.PublicKey(key))
+    }
+
+    return keys, nil
+}
+
+// verifySignature проверяет подпись манифеста (base64 Ed25519) доверенными ключами
+func verifySignature(manifest, signature []byte) (string, error) {
+    if signature == nil {
+        return SignatureUnsigned, nil
+    }
+
+    keys, err := loadTrustedKeys()
+    if err != nil {
+        return SignatureUnknown, err
+    }
+    if len(keys) == 0 {
+        return SignatureUnknown, nil
+    }
+
+    sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+    if err != nil {
+        return SignatureInvalid, nil
+    }
+
+    for _, key := range keys {
+        if ed25519.Verify(key, manifest, sig) {
+            return SignatureValid, nil
+        }
+    }
+
+    return SignatureInvalid, nil
+}
@@ -2,9 +2,11 @@ package update
 
 import (
     "archive/zip"
+    "context"
     "crypto/sha256"
     "encoding/hex"
     "encoding/json"
+    "errors"
     "fmt"
     "io"
     "io/ioutil"
@@ -14,26 +16,55 @@ import (
     "path/filepath"
     "regexp"
     "sort"
+    "strconv"
     "strings"
+
+    "servis/pkg/selfupdate"
+)
+
+// Ошибки, по которым вызывающий код определяет причину сбоя; возвращаются обернутыми через %w
+var (
+    ErrArchiveNotFound  = errors.New("firmware archive not found")
+    ErrUnknownPackage   = errors.New("package not found in manifest")
+    ErrHashMismatch     = errors.New("installed component was modified")
+    ErrWrongDeviceKey   = errors.New("package is encrypted for another device")
+    ErrDecryptionFailed = errors.New("failed to decrypt package")
+    ErrBackupNotFound   = errors.New("backup not found")
+    ErrNotInstalled     = errors.New("component is not installed")
+    ErrNotUSBDevice     = errors.New("not a mounted USB device")
+    ErrShuttingDown     = errors.New("servis is shutting down")
+    ErrDownloadFailed   = errors.New("failed to download firmware archive")
+    ErrNoInterruptedJob = errors.New("no interrupted operation to clear")
+    ErrUntrustedPackage = errors.New("package is not signed by a trusted key")
+    ErrNoSelfUpdate     = errors.New("archive has no newer servis executable")
 )
 
-// FirmwareInfo содержит информацию из JSON-файла о прошивке
+// FirmwareInfo содержит список файлов прошивки (всего архива или одного пакета из манифеста)
 type FirmwareInfo struct {
-    Files []struct {
-        Source      string `json:"source"`
-        Destination string `json:"destination"`
-        FileVersion string `json:"file_version"`
-        IsDir       bool   `json:"is_dir"`
-        Hash        string `json:"hash"`
-    } `json:"files"`
+    Files []FirmwareFile `json:"files"`
+}
+
+// FirmwareFile описывает файл или директорию из манифеста
+type FirmwareFile struct {
+    Source      string            `json:"source"`
+    Destination string            `json:"destination"`
+    FileVersion string            `json:"file_version"`
+    IsDir       bool              `json:"is_dir"`
+    Hash        string            `json:"hash"`
+    Requires    map[string]string `json:"requires,omitempty"`
+    Encrypted   bool              `json:"encrypted,omitempty"` // содержимое зашифровано ключом из Manifest.Encryption
 }
 
 // InstalledVersionInfo содержит информацию о текущих версиях установленных файлов и директорий
 type InstalledVersionInfo struct {
-    Files []struct {
-        Destination string `json:"destination"`
-        FileVersion string `json:"file_version"`
-    } `json:"files"`
+    Files []InstalledFile `json:"files"`
+}
+
+// InstalledFile описывает установленный файл или директорию
+type InstalledFile struct {
+    Destination string            `json:"destination"`
+    FileVersion string            `json:"file_version"`
+    Requires    map[string]string `json:"requires,omitempty"` // назначение -> минимальная требуемая версия
 }
 
 // GetUSBMountPoints возвращает список всех смонтированных USB-устройств.
@@ -64,37 +95,53 @@ func GetUSBMountPoints() ([]string, error) {
     return usbMountPoints, nil
 }
 
-// FindValidFirmware извлекает информацию о прошивке из JSON-файла в ZIP-архиве
+// FindValidFirmware извлекает информацию о прошивке из manifest.json в ZIP-архиве (файлы всех пакетов)
 func FindValidFirmware(zipReader *zip.Reader) (*FirmwareInfo, error) {
-    var firmwareInfo *FirmwareInfo
+    manifest, err := LoadManifest(zipReader)
+    if err != nil {
+        return nil, err
+    }
 
-    for _, file := range zipReader.File {
-        if strings.HasSuffix(file.Name, ".json") {
-            f, err := file.Open()
-            if err != nil {
-                return nil, fmt.Errorf("failed to open JSON file in zip: %w", err)
-            }
-            defer f.Close()
+    return manifest.Firmware(nil)
+}
 
-            jsonContent, err := ioutil.ReadAll(f)
-            if err != nil {
-                return nil, fmt.Errorf("failed to read JSON file: %w", err)
-            }
+// readZipFile читает содержимое файла из ZIP-архива
+func readZipFile(ctx context.Context, file *zip.File) ([]byte, error) {
+    if err := ctx.Err(); err != nil {
+        return nil, err
+    }
 
-            firmwareInfo = &FirmwareInfo{}
-            err = json.Unmarshal(jsonContent, firmwareInfo)
-            if err != nil {
-                return nil, fmt.Errorf("failed to unmarshal JSON content: %w", err)
-            }
-            break
-        }
+    f, err := file.Open()
+    if err != nil {
+        return nil, fmt.Errorf("failed to open %s in zip: %w", file.Name, err)
     }
+    defer f.Close()
+
+    return ioutil.ReadAll(&contextReader{ctx: ctx, r: f})
+}
+
+// contextReader прекращает чтение после отмены контекста, чтобы проверка архива с медленного накопителя
+// не продолжалась после истечения отведенного на нее времени
+type contextReader struct {
+    ctx context.Context
+    r   io.Reader
+}
 
-    if firmwareInfo == nil {
-        return nil, fmt.Errorf("valid JSON file not found")
+func (c *contextReader) Read(p []byte) (int, error) {
+    if err := c.ctx.Err(); err != nil {
+        return 0, err
     }
+    return c.r.Read(p)
+}
 
-    return firmwareInfo, nil
+// findZipFile ищет файл в ZIP-архиве по имени
+func findZipFile(zipReader *zip.Reader, name string) *zip.File {
+    for _, file := range zipReader.File {
+        if file.Name == name {
+            return file
+        }
+    }
+    return nil
 }
 
 // calculateHash вычисляет SHA-256 хеш для данных
@@ -143,10 +190,8 @@ func calculateDirectoryHash(dirPath string) (string, error) {
     return calculateHash([]byte(combinedHashes)), nil
 }
 
-// createBackup создает резервную копию файла или директории
-func createBackup(source, backupDir string) error {
-    backupPath := filepath.Join(backupDir, filepath.Base(source))
-
+// createBackup создает резервную копию файла или директории по пути backupPath
+func createBackup(source, backupPath string) error {
     info, err := os.Stat(source)
     if err != nil {
         return fmt.Errorf("failed to stat source for backup: %w", err)
@@ -159,8 +204,13 @@ func createBackup(source, backupDir string) error {
     }
 }
 
-// copyFile копирует файл
+// copyFile копирует файл вместе с правами доступа
 func copyFile(source, destination string) error {
+    info, err := os.Stat(source)
+    if err != nil {
+        return fmt.Errorf("failed to stat source file: %w", err)
+    }
+
     input, err := ioutil.ReadFile(source)
     if err != nil {
         return fmt.Errorf("failed to read source file: %w", err)
@@ -171,11 +221,17 @@ func copyFile(source, destination string) error {
         return fmt.Errorf("failed to create destination directory: %w", err)
     }
 
-    err = ioutil.WriteFile(destination, input, 0644)
+    err = ioutil.WriteFile(destination, input, info.Mode().Perm())
     if err != nil {
         return fmt.Errorf("failed to write to destination file: %w", err)
     }
 
+    // WriteFile не меняет права уже существующего файла
+    err = os.Chmod(destination, info.Mode().Perm())
+    if err != nil {
+        return fmt.Errorf("failed to set destination file mode: %w", err)
+    }
+
     fmt.Printf("Backup created for file %s at %s\n", source, destination)
     return nil
 }
@@ -219,27 +275,61 @@ func copyDirectory(source, destination string) error {
 // restoreBackup восстанавливает файлы и директории из бэкапа
 func restoreBackup(backupDir string, installedVersions *InstalledVersionInfo) error {
     for _, file := range installedVersions.Files {
-        backupPath := filepath.Join(backupDir, filepath.Base(file.Destination))
+        err := restoreEntry(filepath.Join(backupDir, filepath.Base(file.Destination)), file.Destination)
+        if err != nil {
+            return err
+        }
+    }
+
+    fmt.Println("Backup restored successfully")
+    return nil
+}
 
-        info, err := os.Stat(backupPath)
+// restoreEntry восстанавливает один файл или директорию из копии backupPath
+func restoreEntry(backupPath, destination string) error {
+    info, err := os.Stat(backupPath)
+    if err != nil {
+        return fmt.Errorf("failed to stat backup: %w", err)
+    }
+
+    if info.IsDir() {
+        err := copyDirectory(backupPath, destination)
         if err != nil {
-            return fmt.Errorf("failed to stat backup: %w", err)
+            return fmt.Errorf("failed to restore directory from backup: %w", err)
         }
+    } else if selfupdate.IsSelf(destination) {
+        // Работающий исполняемый файл нельзя перезаписать (ETXTBSY): прежняя версия ставится рядом
+        // и запускается через selfupdate.Upgrade после восстановления остальных записей
+        err := selfupdate.StageFile(backupPath)
+        if err != nil {
+            return fmt.Errorf("failed to stage servis from backup: %w", err)
+        }
+    } else {
+        err := copyFile(backupPath, destination)
+        if err != nil {
+            return fmt.Errorf("failed to restore file from backup: %w", err)
+        }
+    }
 
-        if info.IsDir() {
-            err := copyDirectory(backupPath, file.Destination)
-            if err != nil {
-                return fmt.Errorf("failed to restore directory from backup: %w", err)
-            }
-        } else {
-            err := copyFile(backupPath, file.Destination)
-            if err != nil {
-                return fmt.Errorf("failed to restore file from backup: %w", err)
-            }
+    return nil
+}
+
+// restoreGeneration восстанавливает все записи поколения резервной копии
+func restoreGeneration(generation *BackupGeneration) error {
+    dir, cleanup, err := openBackup(generation)
+    if err != nil {
+        return fmt.Errorf("failed to open backup %s: %w", generation.ID, err)
+    }
+    defer cleanup()
+
+    for _, entry := range generation.Entries {
+        err := restoreEntry(entry.path(dir), entry.Destination)
+        if err != nil {
+            return err
         }
     }
 
-    fmt.Println("Backup restored successfully")
+    fmt.Printf("Backup %s restored successfully\n", generation.ID)
     return nil
 }
 
@@ -282,6 +372,33 @@ func saveInstalledVersions(versionFilePath string, installedVersionInfo *Install
     return nil
 }
 
+// setInstalledVersion обновляет или добавляет запись об установленном файле
+func setInstalledVersion(installedVersions *InstalledVersionInfo, destination, version string, requires map[string]string) {
+    for i := range installedVersions.Files {
+        if installedVersions.Files[i].Destination == destination {
+            installedVersions.Files[i].FileVersion = version
+            installedVersions.Files[i].Requires = requires
+            return
+        }
+    }
+
+    installedVersions.Files = append(installedVersions.Files, InstalledFile{
+        Destination: destination,
+        FileVersion: version,
+        Requires:    requires,
+    })
+}
+
+// installedRequires возвращает зависимости установленного компонента
+func installedRequires(installedVersions *InstalledVersionInfo, destination string) map[string]string {
+    for _, file := range installedVersions.Files {
+        if file.Destination == destination {
+            return file.Requires
+        }
+    }
+    return nil
+}
+
 // compareVersions сравнивает версии (возвращает true, если v1 >= v2)
 func compareVersions(v1, v2 string) (bool, error) {
     re := regexp.MustCompile(`^(\d+)\.(\d+)\.(\d+)$`)
@@ -292,18 +409,82 @@ func compareVersions(v1, v2 string) (bool, error) {
         return false, fmt.Errorf("invalid version format")
     }
 
+    // Части сравниваются как числа: 1.10.0 новее 1.9.0
     for i := 1; i < 4; i++ {
-        if m1[i] > m2[i] {
+        n1, err := strconv.Atoi(m1[i])
+        if err != nil {
+            return false, fmt.Errorf("invalid version %s: %w", v1, err)
+        }
+        n2, err := strconv.Atoi(m2[i])
+        if err != nil {
+            return false, fmt.Errorf("invalid version %s: %w", v2, err)
+        }
+        if n1 > n2 {
             return true, nil
-        } else if m1[i] < m2[i] {
+        } else if n1 < n2 {
             return false, nil
         }
     }
     return true, nil
 }
 
+// installZipFile распаковывает файл из архива (расшифровывая его при необходимости) во временный файл рядом с назначением
+// и заменяет назначение только после того, как все содержимое прочитано и проверено
+func installZipFile(file *zip.File, destination string, key *payloadKey) error {
+    srcFile, err := file.Open()
+    if err != nil {
+        return fmt.Errorf("failed to open source file in zip: %w", err)
+    }
+    defer srcFile.Close()
+
+    var src io.Reader = srcFile
+    if key != nil {
+        src, err = newDecryptReader(srcFile, key)
+        if err != nil {
+            return fmt.Errorf("failed to decrypt %s: %w", file.Name, err)
+        }
+    }
+
+    err = os.MkdirAll(filepath.Dir(destination), 0755)
+    if err != nil {
+        return fmt.Errorf("failed to create destination directory: %w", err)
+    }
+
+    // Сохраняем права существующего файла (например, исполняемого)
+    mode := os.FileMode(0644)
+    if info, err := os.Stat(destination); err == nil {
+        mode = info.Mode().Perm()
+    } else if file.Mode().Perm() != 0 {
+        mode = file.Mode().Perm()
+    }
+
+    stagingPath := destination + ".staging"
+    destFile, err := os.OpenFile(stagingPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+    if err != nil {
+        return fmt.Errorf("failed to create destination file: %w", err)
+    }
+
+    _, err = io.Copy(destFile, src)
+    closeErr := destFile.Close()
+    if err == nil {
+        err = closeErr
+    }
+    if err != nil {
+        os.Remove(stagingPath)
+        return fmt.Errorf("failed to copy file content: %w", err)
+    }
+
+    err = os.Rename(stagingPath, destination)
+    if err != nil {
+        os.Remove(stagingPath)
+        return fmt.Errorf("failed to replace destination file: %w", err)
+    }
+
+    return nil
+}
+
 // copyFileFromZipWithBackupAndChecks проверяет и копирует файл из zip в указанное место с созданием резервной копии, проверкой версии и хеша
-func copyFileFromZipWithBackupAndChecks(zipReader *zip.ReadCloser, source, destination, backupDir, newVersion, expectedHash string, installedVersions *InstalledVersionInfo) error {
+func copyFileFromZipWithBackupAndChecks(zipReader *zip.ReadCloser, source, destination string, backup *BackupGeneration, newVersion, expectedHash string, requires map[string]string, key *payloadKey, installedVersions *InstalledVersionInfo) error {
     var currentVersion string
     for _, file := range installedVersions.Files {
         if file.Destination == destination {
@@ -328,11 +509,11 @@ func copyFileFromZipWithBackupAndChecks(zipReader *zip.ReadCloser, source, desti
             return fmt.Errorf("failed to calculate current file hash: %w", err)
         }
         if actualHash != expectedHash {
-            return fmt.Errorf("hash mismatch for file %s: expected %s, got %s", destination, expectedHash, actualHash)
+            return fmt.Errorf("%w: hash mismatch for file %s: expected %s, got %s", ErrHashMismatch, destination, expectedHash, actualHash)
         }
     }
 
-    err := createBackup(destination, backupDir)
+    err := backup.store(destination, currentVersion, installedRequires(installedVersions, destination), false)
     if err != nil {
         return fmt.Errorf("failed to create backup for %s: %w", destination, err)
     }
@@ -340,44 +521,20 @@ func copyFileFromZipWithBackupAndChecks(zipReader *zip.ReadCloser, source, desti
     for _, file := range zipReader.File {
         if file.Name == source {
             fmt.Println("Copying file from zip:", file.Name)
-            srcFile, err := file.Open()
-            if err != nil {
-                return fmt.Errorf("failed to open source file in zip: %w", err)
-            }
-            defer srcFile.Close()
 
-            destDir := filepath.Dir(destination)
-            err = os.MkdirAll(destDir, 0755)
-            if err != nil {
-                return fmt.Errorf("failed to create destination directory: %w", err)
+            // Работающий исполняемый файл servis не перезаписывается: новая версия ставится рядом и запускается через selfupdate
+            target := destination
+            if selfupdate.IsSelf(destination) {
+                target = selfupdate.StagedPath()
             }
 
-            destFile, err := os.Create(destination)
+            err := installZipFile(file, target, key.bind(file.Name, destination))
             if err != nil {
-                return fmt.Errorf("failed to create destination file: %w", err)
-            }
-            defer destFile.Close()
-
-            _, err = io.Copy(destFile, srcFile)
-            if err != nil {
-                return fmt.Errorf("failed to copy file content: %w", err)
+                return err
             }
 
             // Обновляем версию файла в installedVersions
-            updated := false
-            for i := range installedVersions.Files {
-                if installedVersions.Files[i].Destination == destination {
-                    installedVersions.Files[i].FileVersion = newVersion
-                    updated = true
-                    break
-                }
-            }
-            if !updated {
-                installedVersions.Files = append(installedVersions.Files, struct {
-                    Destination string `json:"destination"`
-                    FileVersion string `json:"file_version"`
-                }{Destination: destination, FileVersion: newVersion})
-            }
+            setInstalledVersion(installedVersions, destination, newVersion, requires)
 
             return nil
         }
@@ -386,7 +543,7 @@ func copyFileFromZipWithBackupAndChecks(zipReader *zip.ReadCloser, source, desti
 }
 
 // copyDirectoryFromZipWithBackupAndChecks проверяет и копирует директорию из zip в указанное место с созданием резервной копии, проверкой версии и хеша
-func copyDirectoryFromZipWithBackupAndChecks(zipReader *zip.ReadCloser, source, destination, backupDir, newVersion, expectedHash string, installedVersions *InstalledVersionInfo) error {
+func copyDirectoryFromZipWithBackupAndChecks(zipReader *zip.ReadCloser, source, destination string, backup *BackupGeneration, newVersion, expectedHash string, requires map[string]string, key *payloadKey, installedVersions *InstalledVersionInfo) error {
     var currentVersion string
     for _, file := range installedVersions.Files {
         if file.Destination == destination {
@@ -411,18 +568,19 @@ func copyDirectoryFromZipWithBackupAndChecks(zipReader *zip.ReadCloser, source,
             return fmt.Errorf("failed to calculate current directory hash: %w", err)
         }
         if actualHash != expectedHash {
-            return fmt.Errorf("hash mismatch for directory %s: expected %s, got %s", destination, expectedHash, actualHash)
+            return fmt.Errorf("%w: hash mismatch for directory %s: expected %s, got %s", ErrHashMismatch, destination, expectedHash, actualHash)
         }
     }
 
-    err := createBackup(destination, backupDir)
+    err := backup.store(destination, currentVersion, installedRequires(installedVersions, destination), true)
     if err != nil {
         return fmt.Errorf("failed to create backup for %s: %w", destination, err)
     }
 
+    prefix := zipDirPrefix(source)
     for _, file := range zipReader.File {
-        if strings.HasPrefix(file.Name, source) {
-            relativePath := strings.TrimPrefix(file.Name, source)
+        if strings.HasPrefix(file.Name, prefix) {
+            relativePath := strings.TrimPrefix(file.Name, prefix)
             destPath := filepath.Join(destination, relativePath)
             if file.FileInfo().IsDir() {
                 fmt.Println("Creating directory from zip:", destPath)
@@ -432,78 +590,140 @@ func copyDirectoryFromZipWithBackupAndChecks(zipReader *zip.ReadCloser, source,
                 }
             } else {
                 fmt.Println("Copying file from zip:", file.Name)
-                srcFile, err := file.Open()
+                err := installZipFile(file, destPath, key.bind(file.Name, destPath))
                 if err != nil {
-                    return fmt.Errorf("failed to open source file in zip: %w", err)
-                }
-                defer srcFile.Close()
-
-                destFile, err := os.Create(destPath)
-                if err != nil {
-                    return fmt.Errorf("failed to create destination file: %w", err)
-                }
-                defer destFile.Close()
-
-                _, err = io.Copy(destFile, srcFile)
-                if err != nil {
-                    return fmt.Errorf("failed to copy file content: %w", err)
+                    return err
                 }
             }
         }
     }
 
     // Обновляем версию директории в installedVersions
-    updated := false
-    for i := range installedVersions.Files {
-        if installedVersions.Files[i].Destination == destination {
-            installedVersions.Files[i].FileVersion = newVersion
-            updated = true
-            break
-        }
-    }
-    if !updated {
-        installedVersions.Files = append(installedVersions.Files, struct {
-            Destination string `json:"destination"`
-            FileVersion string `json:"file_version"`
-        }{Destination: destination, FileVersion: newVersion})
-    }
+    setInstalledVersion(installedVersions, destination, newVersion, requires)
 
     return nil
 }
 
-// UpdateFirmware выполняет основную функцию обновления прошивки
+// UpdateFirmware выполняет основную функцию обновления прошивки (все пакеты архива)
 func UpdateFirmware(zipFilePath string, versionFilePath string, backupDir string) error {
+    return UpdatePackages(zipFilePath, versionFilePath, backupDir, nil)
+}
+
+// UpdatePackages устанавливает выбранные пакеты из архива; пустой список означает все пакеты
+func UpdatePackages(zipFilePath string, versionFilePath string, backupDir string, packages []string) error {
+    return installPackages(zipFilePath, versionFilePath, backupDir, packages, false)
+}
+
+// UpdateSignedPackages устанавливает выбранные пакеты, только если манифест архива подписан доверенным ключом.
+// Используется для архивов, полученных не от человека у устройства (по ссылке, самообновление).
+func UpdateSignedPackages(zipFilePath string, versionFilePath string, backupDir string, packages []string) error {
+    return installPackages(zipFilePath, versionFilePath, backupDir, packages, true)
+}
+
+// installPackages устанавливает пакеты архива; при requireSignature архив без действительной подписи отклоняется
+func installPackages(zipFilePath string, versionFilePath string, backupDir string, packages []string, requireSignature bool) (err error) {
+    lock, err := acquireLock(OperationUpdate, zipFilePath)
+    if err != nil {
+        return err
+    }
+    defer func() { lock.finish(err) }()
+
     log.Printf("Starting firmware update with zip file: %s", zipFilePath)
     zipReader, err := zip.OpenReader(zipFilePath)
+    if os.IsNotExist(err) {
+        return fmt.Errorf("%w: %s", ErrArchiveNotFound, zipFilePath)
+    }
     if err != nil {
         return fmt.Errorf("failed to open zip file: %w", err)
     }
     defer zipReader.Close()
 
-    firmwareInfo, err := FindValidFirmware(&zipReader.Reader)
+    manifest, signature, err := loadSignedManifest(context.Background(), &zipReader.Reader)
     if err != nil {
         return fmt.Errorf("failed to find valid firmware: %w", err)
     }
+    if requireSignature && signature != SignatureValid {
+        return fmt.Errorf("%w: signature is %s", ErrUntrustedPackage, signature)
+    }
+
+    firmwareInfo, err := manifest.Firmware(packages)
+    if err != nil {
+        return fmt.Errorf("failed to select packages: %w", err)
+    }
+
+    var key *payloadKey
+    if manifest.Encrypted() {
+        deviceKey, err := LoadDeviceKey()
+        if err != nil {
+            return fmt.Errorf("failed to load device key for encrypted package: %w", err)
+        }
+        key, err = manifest.Encryption.unwrap(deviceKey)
+        if err != nil {
+            return fmt.Errorf("failed to unwrap package key: %w", err)
+        }
+    }
 
     installedVersions, err := LoadInstalledVersions(versionFilePath)
     if err != nil {
         return fmt.Errorf("failed to load installed versions: %w", err)
     }
 
-    for _, file := range firmwareInfo.Files {
+    backup, err := newBackupGeneration(backupDir, zipFilePath)
+    if err != nil {
+        return fmt.Errorf("failed to create backup generation: %w", err)
+    }
+    defer func() {
+        // Пустое поколение не нужно для отката и не должно вытеснять предыдущие
+        if len(backup.Entries) == 0 {
+            os.RemoveAll(backup.path)
+        }
+
+        err := ApplyBackupRetention(backupDir, DefaultBackupPolicy)
+        if err != nil {
+            log.Printf("Failed to apply backup retention: %v", err)
+        }
+    }()
+
+    selfupdate.Discard()
+
+    lock.started(map[string]interface{}{"packages": packages, "total": len(firmwareInfo.Files)})
+    for i, file := range firmwareInfo.Files {
+        var fileKey *payloadKey
+        if file.Encrypted {
+            fileKey = key
+        }
+
         if file.IsDir {
-            err := copyDirectoryFromZipWithBackupAndChecks(zipReader, file.Source, file.Destination, backupDir, file.FileVersion, file.Hash, installedVersions)
+            err := copyDirectoryFromZipWithBackupAndChecks(zipReader, file.Source, file.Destination, backup, file.FileVersion, file.Hash, file.Requires, fileKey, installedVersions)
             if err != nil {
                 return fmt.Errorf("failed to copy directory from zip: %w", err)
             }
             log.Printf("Updated or added directory %s to %s\n", file.Source, file.Destination)
         } else {
-            err := copyFileFromZipWithBackupAndChecks(zipReader, file.Source, file.Destination, backupDir, file.FileVersion, file.Hash, installedVersions)
+            err := copyFileFromZipWithBackupAndChecks(zipReader, file.Source, file.Destination, backup, file.FileVersion, file.Hash, file.Requires, fileKey, installedVersions)
             if err != nil {
                 return fmt.Errorf("failed to copy file from zip: %w", err)
             }
             log.Printf("Updated or added file %s to %s\n", file.Source, file.Destination)
         }
+        lock.progress(file.Destination, i+1, len(firmwareInfo.Files))
+    }
+
+    if selfupdate.Staged() {
+        err = selfupdate.Upgrade()
+        if err != nil {
+            // Прежний исполняемый файл восстановлен, поэтому и в инвентаре возвращаем прежнюю версию
+            for _, entry := range backup.Entries {
+                if selfupdate.IsSelf(entry.Destination) {
+                    setInstalledVersion(installedVersions, entry.Destination, entry.FileVersion, entry.Requires)
+                }
+            }
+            saveErr := saveInstalledVersions(versionFilePath, installedVersions)
+            if saveErr != nil {
+                log.Printf("Failed to save installed versions: %v", saveErr)
+            }
+            return fmt.Errorf("failed to update servis binary: %w", err)
+        }
     }
 
     err = saveInstalledVersions(versionFilePath, installedVersions)
@@ -515,8 +735,106 @@ func UpdateFirmware(zipFilePath string, versionFilePath string, backupDir string
     return nil
 }
 
-// RollbackFirmware выполняет основную функцию отката прошивки
-func RollbackFirmware(backupDir string, installedVersions *InstalledVersionInfo) error {
+// RollbackFirmware выполняет основную функцию отката прошивки из последнего поколения резервной копии
+func RollbackFirmware(backupDir string, installedVersions *InstalledVersionInfo) (err error) {
+    lock, err := acquireLock(OperationRollback, backupDir)
+    if err != nil {
+        return err
+    }
+    defer func() { lock.finish(err) }()
+
     log.Println("Starting firmware rollback")
-    return restoreBackup(backupDir, installedVersions)
+    lock.started(nil)
+
+    generation, err := latestBackup(backupDir)
+    if err != nil {
+        return fmt.Errorf("failed to find backup: %w", err)
+    }
+
+    selfupdate.Discard()
+
+    // Резервные копии старого формата лежат прямо в backupDir
+    if generation == nil {
+        err = restoreBackup(backupDir, installedVersions)
+    } else {
+        err = restoreGeneration(generation)
+    }
+    if err != nil {
+        return err
+    }
+
+    return startRestoredSelf()
+}
+
+// startRestoredSelf запускает прежнюю версию servis, если откат подготовил ее рядом с работающей
+func startRestoredSelf() error {
+    if !selfupdate.Staged() {
+        return nil
+    }
+
+    err := selfupdate.Upgrade()
+    if err != nil {
+        return fmt.Errorf("failed to start restored servis binary: %w", err)
+    }
+    return nil
+}
+
+// SelfUpdate устанавливает новую версию servis из подписанного архива прошивки и перезапускает процесс.
+// Устанавливаются только пакеты с исполняемым файлом servis: как и при обычном обновлении, версия
+// записывается в инвентарь, а прежний файл сохраняется в резервной копии.
+func SelfUpdate(zipFilePath string, versionFilePath string, backupDir string) error {
+    packages, err := selfPackages(zipFilePath, versionFilePath)
+    if err != nil {
+        return err
+    }
+
+    log.Printf("Starting self-update from %s (packages %v)", zipFilePath, packages)
+    return UpdateSignedPackages(zipFilePath, versionFilePath, backupDir, packages)
+}
+
+// selfPackages возвращает пакеты архива, содержащие более новую версию исполняемого файла servis
+func selfPackages(zipFilePath string, versionFilePath string) ([]string, error) {
+    zipReader, err := zip.OpenReader(zipFilePath)
+    if os.IsNotExist(err) {
+        return nil, fmt.Errorf("%w: %s", ErrArchiveNotFound, zipFilePath)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to open zip file: %w", err)
+    }
+    defer zipReader.Close()
+
+    manifest, err := LoadManifest(&zipReader.Reader)
+    if err != nil {
+        return nil, fmt.Errorf("failed to find valid firmware: %w", err)
+    }
+
+    installedVersions, err := LoadInstalledVersions(versionFilePath)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load installed versions: %w", err)
+    }
+
+    var packages []string
+    for _, pkg := range manifest.Packages {
+        for _, file := range pkg.Files {
+            if file.IsDir || !selfupdate.IsSelf(file.Destination) {
+                continue
+            }
+            if installed := findInstalled(installedVersions, file.Destination); installed != nil {
+                isNewer, err := compareVersions(file.FileVersion, installed.FileVersion)
+                if err != nil {
+                    return nil, fmt.Errorf("failed to compare versions: %w", err)
+                }
+                if !isNewer || file.FileVersion == installed.FileVersion {
+                    continue
+                }
+            }
+            packages = append(packages, pkg.Name)
+            break
+        }
+    }
+
+    if len(packages) == 0 {
+        return nil, ErrNoSelfUpdate
+    }
+    return packages, nil
 }
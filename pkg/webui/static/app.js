@@ -0,0 +1,544 @@
+'use strict';
+
+// Веб-интерфейс servis: работает только с API того же устройства и не загружает ничего извне.
+
+const API = '/api/v1';
+const ROLES = ['viewer', 'operator', 'admin'];
+
+// Сообщения для кодов ошибок API; для остальных показывается текст из ответа
+const ERROR_MESSAGES = {
+    invalid_credentials: 'Неверный пользователь или пароль',
+    invalid_token: 'Токен недействителен или истек',
+    insufficient_role: 'Недостаточно прав',
+    update_in_progress: 'Уже выполняется обновление или откат',
+    update_interrupted: 'Предыдущая операция была прервана; нужен откат или сброс блокировки администратором',
+    shutting_down: 'servis останавливается',
+    manifest_invalid: 'Манифест пакета не прошел проверку',
+    untrusted_package: 'Пакет не подписан доверенным ключом',
+    wrong_device_key: 'Пакет зашифрован для другого устройства',
+    hash_mismatch: 'Контрольная сумма файла не совпадает',
+    dependency_conflict: 'Откат нарушит зависимости других компонентов',
+    backup_not_found: 'Резервная копия не найдена',
+    wifi_scan_failed: 'Не удалось найти сети WiFi',
+    wifi_connect_failed: 'Не удалось подключиться к сети',
+    wifi_dhcp_failed: 'Не удалось получить адрес по DHCP',
+    invalid_confirmation: 'Подтверждение истекло или уже использовано, повторите команду',
+    power_action_pending: 'Уже запланировано выключение или перезагрузка',
+    rate_limited: 'Слишком много запросов',
+    auth_locked_out: 'Слишком много неудачных попыток входа, доступ с этого адреса временно закрыт',
+};
+
+const POWER_ACTIONS = { shutdown: 'Выключение', reboot: 'Перезагрузка' };
+
+const state = {
+    token: sessionStorage.getItem('servis_token') || '',
+    identity: null,
+    events: null,
+    countdown: null,
+};
+
+const $ = (selector) => document.querySelector(selector);
+
+// el создает элемент с текстом; данные с устройства (имена сетей, пути) никогда не вставляются как HTML
+function el(tag, text, className) {
+    const node = document.createElement(tag);
+    if (text !== undefined && text !== null) {
+        node.textContent = text;
+    }
+    if (className) {
+        node.className = className;
+    }
+    return node;
+}
+
+function notify(message, isError) {
+    const notice = $('#notice');
+    notice.textContent = message;
+    notice.className = isError ? 'error' : '';
+    notice.hidden = false;
+}
+
+function describeError(error) {
+    let message = ERROR_MESSAGES[error.code] || error.message;
+    if (error.code === 'manifest_invalid' && error.details && error.details.problems) {
+        message += ': ' + error.details.problems.map((p) => p.message || p.field).join('; ');
+    }
+    if (error.code === 'dependency_conflict' && error.details && error.details.dependents) {
+        const parts = Object.entries(error.details.dependents).map(([dest, deps]) => dest + ' ← ' + deps.join(', '));
+        message += ': ' + parts.join('; ');
+    }
+    if (error.details && error.details.retry_after) {
+        message += ', повторите через ' + formatRetry(error.details.retry_after);
+    }
+    return message + (error.code ? ' (' + error.code + ')' : '');
+}
+
+function formatRetry(seconds) {
+    return seconds < 60 ? seconds + ' с' : Math.ceil(seconds / 60) + ' мин';
+}
+
+async function api(method, path, body) {
+    const options = { method, headers: {} };
+    if (state.token) {
+        options.headers['Authorization'] = 'Bearer ' + state.token;
+    }
+    if (body !== undefined) {
+        options.headers['Content-Type'] = 'application/json';
+        options.body = JSON.stringify(body);
+    }
+
+    let response;
+    try {
+        response = await fetch(API + path, options);
+    } catch (e) {
+        throw Object.assign(new Error('Нет связи с устройством'), { code: '' });
+    }
+
+    const text = await response.text();
+    let data = null;
+    try {
+        data = text ? JSON.parse(text) : null;
+    } catch (e) {
+        data = null;
+    }
+
+    if (!response.ok) {
+        const error = data && data.error ? data.error : { code: 'http_' + response.status, message: text || response.statusText };
+        if (response.status === 401 && path !== '/auth/login') {
+            logout();
+        }
+        throw Object.assign(new Error(error.message), { code: error.code, details: error.details });
+    }
+    return data;
+}
+
+// run выполняет действие кнопки: блокирует ее на время запроса и показывает результат или ошибку
+async function run(button, action) {
+    if (button) {
+        button.disabled = true;
+    }
+    try {
+        const message = await action();
+        if (message) {
+            notify(message, false);
+        }
+    } catch (error) {
+        notify(describeError(error), true);
+    } finally {
+        if (button) {
+            button.disabled = false;
+            applyRole();
+        }
+    }
+}
+
+// Сессия
+
+async function login(token) {
+    state.token = token;
+    const identity = await api('GET', '/auth/me');
+    state.identity = identity;
+    sessionStorage.setItem('servis_token', token);
+
+    $('#identity').textContent = identity.name + ' (' + identity.role + ')';
+    $('#session').hidden = false;
+    $('#login-view').hidden = true;
+    $('#app-view').hidden = false;
+    $('#notice').hidden = true;
+    applyRole();
+    subscribe();
+    loadJob();
+}
+
+function logout() {
+    state.token = '';
+    state.identity = null;
+    sessionStorage.removeItem('servis_token');
+    if (state.events) {
+        state.events.close();
+        state.events = null;
+    }
+    showPending(null);
+    $('#session').hidden = true;
+    $('#app-view').hidden = true;
+    $('#login-view').hidden = false;
+}
+
+// applyRole блокирует кнопки, для которых у пользователя недостаточно прав
+function applyRole() {
+    const level = state.identity ? ROLES.indexOf(state.identity.role) : -1;
+    document.querySelectorAll('[data-role]').forEach((button) => {
+        if (ROLES.indexOf(button.dataset.role) > level) {
+            button.disabled = true;
+            button.title = 'Нужна роль ' + button.dataset.role;
+        }
+    });
+}
+
+// События: ход обновления и подключение накопителей
+
+function subscribe() {
+    if (state.events) {
+        state.events.close();
+    }
+    // EventSource не позволяет задать заголовок, поэтому токен передается в параметре access_token
+    const url = API + '/events?topics=update,device,system&access_token=' + encodeURIComponent(state.token);
+    state.events = new EventSource(url);
+    state.events.onmessage = (message) => handleEvent(JSON.parse(message.data));
+}
+
+function handleEvent(event) {
+    const log = $('#events');
+    const data = event.data || {};
+    const line = new Date(event.time).toLocaleTimeString() + ' ' + event.type +
+        (data.destination ? ' ' + data.destination : '') +
+        (data.mount_point ? ' ' + data.mount_point : '') +
+        (data.error ? ': ' + data.error : '');
+    log.prepend(el('li', line));
+    while (log.children.length > 100) {
+        log.lastChild.remove();
+    }
+
+    switch (event.type) {
+    case 'update_started':
+    case 'rollback_started':
+        showProgress(0, 0, 'Начато');
+        break;
+    case 'update_progress':
+    case 'rollback_progress':
+        showProgress(data.done, data.total, data.destination);
+        break;
+    case 'update_completed':
+    case 'rollback_completed':
+        showProgress(1, 1, 'Завершено');
+        loadJob();
+        break;
+    case 'update_failed':
+    case 'rollback_failed':
+        showProgress(0, 1, 'Ошибка: ' + data.error);
+        loadJob();
+        break;
+    case 'usb_mounted':
+        loadArchives();
+        break;
+    case 'shutdown_pending':
+    case 'reboot_pending':
+    case 'shutdown_cancelled':
+    case 'reboot_cancelled':
+        run(null, loadPending);
+        break;
+    }
+}
+
+function showProgress(done, total, text) {
+    $('#progress').hidden = false;
+    const bar = $('#progress-bar');
+    if (total > 0) {
+        bar.max = total;
+        bar.value = done;
+    } else {
+        bar.removeAttribute('value');
+    }
+    $('#progress-text').textContent = (total > 0 ? done + ' / ' + total + ' ' : '') + (text || '');
+}
+
+// Сеть
+
+async function scanNetworks() {
+    const networks = await api('GET', '/networks/all');
+    const list = $('#networks');
+    list.replaceChildren();
+    (networks || []).forEach((network) => {
+        const item = el('li', network.name || '(скрытая сеть)');
+        if (network.quality) {
+            item.append(' ', el('span', 'качество ' + network.quality, 'muted'));
+        }
+        item.addEventListener('click', () => {
+            $('#connect-form').elements.name.value = network.name;
+            $('#connect-form').elements.password.focus();
+        });
+        list.append(item);
+    });
+    return networks && networks.length ? '' : 'Сети не найдены';
+}
+
+async function connectNetwork(form) {
+    const result = await api('POST', '/networks/connect', {
+        name: form.elements.name.value,
+        password: form.elements.password.value,
+    });
+    form.elements.password.value = '';
+    return result.message;
+}
+
+// Обновление
+
+async function loadJob() {
+    const box = $('#job');
+    box.replaceChildren();
+    try {
+        const result = await api('GET', '/firmware/job');
+        if (!result.job) {
+            return;
+        }
+        const job = result.job;
+        const text = result.interrupted
+            ? 'Операция ' + job.operation + ' от ' + new Date(job.started_at).toLocaleString() + ' была прервана. Выполните откат.'
+            : 'Выполняется ' + job.operation + ' (' + (job.source || '') + ')';
+        box.append(el('p', text, result.interrupted ? 'warning' : ''));
+    } catch (error) {
+        box.append(el('p', describeError(error), 'warning'));
+    }
+}
+
+async function loadArchives() {
+    const archives = await api('GET', '/usb/files');
+    const box = $('#archives');
+    box.replaceChildren();
+    if (!archives || archives.length === 0) {
+        box.append(el('p', 'На USB-накопителях нет пакетов прошивки', 'muted'));
+        return '';
+    }
+    archives.forEach((archive) => box.append(renderArchive(archive)));
+    return '';
+}
+
+function renderArchive(archive) {
+    const card = el('div', null, 'card');
+    card.append(el('strong', archive.path));
+
+    const info = [];
+    if (archive.signature) {
+        info.push('подпись: ' + archive.signature);
+    }
+    if (archive.encrypted) {
+        info.push('зашифрован');
+    }
+    info.push(archive.compatible ? 'совместим' : 'несовместим');
+    card.append(el('div', info.join(', '), 'muted'));
+
+    if (archive.error) {
+        card.append(el('div', archive.error, 'warning'));
+        return card;
+    }
+    (archive.issues || []).forEach((issue) => card.append(el('div', issue, 'warning')));
+    (archive.problems || []).forEach((problem) => card.append(el('div', problem.message || JSON.stringify(problem), 'warning')));
+
+    const checkboxes = [];
+    (archive.packages || []).forEach((pkg) => {
+        const label = el('label', null, 'inline');
+        const checkbox = document.createElement('input');
+        checkbox.type = 'checkbox';
+        checkbox.value = pkg.name;
+        checkbox.checked = pkg.compatible;
+        checkbox.disabled = !pkg.compatible;
+        checkboxes.push(checkbox);
+
+        const versions = (pkg.files || []).map((file) => file.source + ' ' + file.file_version).join(', ');
+        label.append(checkbox, el('span', pkg.name + (pkg.description ? ' — ' + pkg.description : '')), el('span', versions, 'muted'));
+        card.append(label);
+        (pkg.issues || []).forEach((issue) => card.append(el('div', issue, 'warning')));
+    });
+    if (checkboxes.length === 0) {
+        (archive.files || []).forEach((file) => card.append(el('div', file.source + ' ' + file.file_version, 'muted')));
+    }
+
+    const button = el('button', 'Установить');
+    button.type = 'button';
+    button.dataset.role = 'operator';
+    button.disabled = !archive.compatible;
+    button.addEventListener('click', () => run(button, async () => {
+        const packages = checkboxes.filter((c) => c.checked).map((c) => c.value);
+        if (checkboxes.length > 0 && packages.length === 0) {
+            throw Object.assign(new Error('Не выбран ни один пакет'), { code: '' });
+        }
+        if (!confirm('Установить ' + (packages.length ? packages.join(', ') : 'все пакеты') + ' из ' + archive.path + '?')) {
+            return '';
+        }
+        showProgress(0, 0, 'Начато');
+        const result = await api('POST', '/firmware/update', { selected_file: archive.path, packages });
+        return result.message;
+    }));
+    card.append(button);
+    return card;
+}
+
+// Откат
+
+async function loadBackups() {
+    const backups = await api('GET', '/firmware/backups');
+    const box = $('#backups');
+    box.replaceChildren();
+    if (!backups || backups.length === 0) {
+        box.append(el('p', 'Резервных копий нет', 'muted'));
+        return '';
+    }
+
+    backups.forEach((generation, index) => {
+        const card = el('div', null, 'card');
+        card.append(el('strong', generation.id), el('div', new Date(generation.created_at).toLocaleString() + ' — ' + generation.source, 'muted'));
+
+        const checkboxes = [];
+        (generation.entries || []).forEach((entry) => {
+            const label = el('label', null, 'inline');
+            if (index === 0 && !entry.restored) {
+                const checkbox = document.createElement('input');
+                checkbox.type = 'checkbox';
+                checkbox.value = entry.destination;
+                checkboxes.push(checkbox);
+                label.append(checkbox);
+            }
+            label.append(el('span', entry.destination + ' ' + entry.file_version + (entry.restored ? ' (восстановлен)' : '')));
+            card.append(label);
+        });
+
+        if (checkboxes.length > 0) {
+            const dependents = el('label', null, 'inline');
+            const includeDependents = document.createElement('input');
+            includeDependents.type = 'checkbox';
+            dependents.append(includeDependents, el('span', 'Откатить и зависящие компоненты'));
+            card.append(dependents);
+
+            const button = el('button', 'Откатить выбранные');
+            button.type = 'button';
+            button.dataset.role = 'operator';
+            button.addEventListener('click', () => run(button, async () => {
+                const destinations = checkboxes.filter((c) => c.checked).map((c) => c.value);
+                if (destinations.length === 0) {
+                    throw Object.assign(new Error('Не выбран ни один компонент'), { code: '' });
+                }
+                if (!confirm('Откатить ' + destinations.join(', ') + '?')) {
+                    return '';
+                }
+                const results = await api('POST', '/firmware/rollback', { destinations, include_dependents: includeDependents.checked });
+                loadBackups();
+                return 'Откачено: ' + (results || []).map((r) => r.destination + ' ' + r.from_version + ' → ' + r.to_version).join(', ');
+            }));
+            card.append(button);
+        }
+        box.append(card);
+    });
+    applyRole();
+    return '';
+}
+
+async function rollbackAll() {
+    if (!confirm('Откатить последнее обновление целиком?')) {
+        return '';
+    }
+    const result = await api('POST', '/firmware/rollback');
+    loadBackups();
+    return result && result.message ? result.message : 'Откат выполнен';
+}
+
+// Питание
+
+// power выполняет действие в два шага: сервер возвращает токен и описание последствий,
+// а после подтверждения пользователем токен отправляется повторно
+async function power(action, question) {
+    const delay = $('#power-delay').value;
+    const { confirmation } = await api('POST', '/' + action, delay ? { delay } : {});
+    const warnings = confirmation.impact.warnings || [];
+    const text = question + (delay ? ' (через ' + delay + ')' : '') +
+        (warnings.length ? '\n\n' + warnings.join('\n') : '');
+    if (!confirm(text)) {
+        return '';
+    }
+    const { pending } = await api('POST', '/' + action, { confirm_token: confirmation.confirm_token });
+    showPending(pending);
+    return POWER_ACTIONS[action] + ' запланирована на ' + new Date(pending.execute_at).toLocaleTimeString();
+}
+
+async function loadPending() {
+    const { pending } = await api('GET', '/power/pending');
+    showPending(pending);
+    return '';
+}
+
+// showPending показывает обратный отсчет до запланированного действия
+function showPending(pending) {
+    clearInterval(state.countdown);
+    state.countdown = null;
+    $('#power-pending').hidden = !pending;
+    if (!pending) {
+        return;
+    }
+
+    const executeAt = Date.now() + pending.seconds_left * 1000;
+    const tick = () => {
+        const left = Math.max(0, Math.round((executeAt - Date.now()) / 1000));
+        $('#power-countdown').textContent = POWER_ACTIONS[pending.action] + ' через ' +
+            Math.floor(left / 60) + ':' + String(left % 60).padStart(2, '0') + ' (запросил ' + pending.requested_by + ')';
+    };
+    tick();
+    state.countdown = setInterval(tick, 1000);
+}
+
+async function cancelPending() {
+    const { pending } = await api('DELETE', '/power/pending');
+    showPending(null);
+    return POWER_ACTIONS[pending.action] + ' отменена';
+}
+
+// Привязка элементов
+
+function showTab(name) {
+    document.querySelectorAll('nav button').forEach((button) => button.classList.toggle('active', button.dataset.tab === name));
+    document.querySelectorAll('.tab').forEach((tab) => {
+        tab.hidden = tab.id !== 'tab-' + name;
+    });
+    if (name === 'update') {
+        run(null, loadArchives);
+        loadJob();
+    }
+    if (name === 'rollback') {
+        run(null, loadBackups);
+    }
+    if (name === 'power') {
+        run(null, loadPending);
+    }
+}
+
+document.addEventListener('DOMContentLoaded', () => {
+    $('#login-form').addEventListener('submit', (e) => {
+        e.preventDefault();
+        const form = e.target;
+        run(form.querySelector('button'), async () => {
+            const result = await api('POST', '/auth/login', {
+                username: form.elements.username.value,
+                password: form.elements.password.value,
+            });
+            form.elements.password.value = '';
+            await login(result.token);
+            return '';
+        });
+    });
+
+    $('#token-form').addEventListener('submit', (e) => {
+        e.preventDefault();
+        const form = e.target;
+        run(form.querySelector('button'), async () => {
+            await login(form.elements.token.value.trim());
+            form.elements.token.value = '';
+            return '';
+        });
+    });
+
+    $('#logout').addEventListener('click', logout);
+    document.querySelectorAll('nav button').forEach((button) => button.addEventListener('click', () => showTab(button.dataset.tab)));
+
+    $('#scan').addEventListener('click', (e) => run(e.target, scanNetworks));
+    $('#connect-form').addEventListener('submit', (e) => {
+        e.preventDefault();
+        run(e.target.querySelector('button'), () => connectNetwork(e.target));
+    });
+    $('#refresh-usb').addEventListener('click', (e) => run(e.target, loadArchives));
+    $('#rollback-all').addEventListener('click', (e) => run(e.target, rollbackAll));
+    $('#reboot').addEventListener('click', (e) => run(e.target, () => power('reboot', 'Перезагрузить устройство?')));
+    $('#shutdown').addEventListener('click', (e) => run(e.target, () => power('shutdown', 'Выключить устройство?')));
+    $('#power-cancel').addEventListener('click', (e) => run(e.target, cancelPending));
+
+    if (state.token) {
+        login(state.token).catch(() => logout());
+    }
+});
@@ -0,0 +1,30 @@
+// Package webui — встроенный в исполняемый файл веб-интерфейс: настройка сети, установка пакетов с USB,
+// ход обновления, откат и управление питанием. Интерфейс работает только с API устройства
+// и не загружает ресурсы извне, поэтому доступен без интернета.
+package webui
+
+import (
+    "embed"
+    "io/fs"
+    "net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler отдает файлы интерфейса; путь запроса указывается без префикса, под которым интерфейс опубликован
+func Handler() http.Handler {
+    // Каталог static встраивается при сборке, поэтому ошибки здесь быть не может
+    files, _ := fs.Sub(static, "static")
+    server := http.FileServer(http.FS(files))
+
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        // Скрипты и запросы — только с самого устройства; страницу нельзя встроить в чужой сайт
+        w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+        w.Header().Set("X-Content-Type-Options", "nosniff")
+        w.Header().Set("Referrer-Policy", "no-referrer")
+        // После самообновления браузер должен получить файлы новой версии
+        w.Header().Set("Cache-Control", "no-cache")
+        server.ServeHTTP(w, r)
+    })
+}
@@ -2,11 +2,52 @@ package wifi
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os/exec"
+	"strconv"
 	"strings"
 	"time"
+
+	"servis/pkg/config"
+	"servis/pkg/events"
+	"servis/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Ошибки, по которым вызывающий код определяет этап, на котором не удалось подключиться
+var (
+	ErrScanFailed        = errors.New("wifi scan failed")
+	ErrConfigFailed      = errors.New("failed to update wifi configuration")
+	ErrInterfaceFailed   = errors.New("failed to restart wifi interface")
+	ErrAssociationFailed = errors.New("failed to associate with wifi network")
+	ErrDHCPFailed        = errors.New("failed to obtain address via DHCP")
+)
+
+var (
+	connectedGauge = metrics.Factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "wifi",
+		Name:      "connected",
+		Help:      "1, если интерфейс WiFi подключен к сети.",
+	}, []string{"interface"})
+
+	linkQualityGauge = metrics.Factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "wifi",
+		Name:      "link_quality",
+		Help:      "Качество связи WiFi по данным драйвера (обычно из 70).",
+	}, []string{"interface"})
+
+	signalLevelGauge = metrics.Factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "wifi",
+		Name:      "signal_level_dbm",
+		Help:      "Уровень сигнала WiFi в дБм.",
+	}, []string{"interface"})
 )
 
 func RunCommand(name string, args ...string) (string, error) {
@@ -90,7 +131,7 @@ network={
 func ScanNetworks(wifiInterface string) ([]map[string]interface{}, error) {
 	output, err := RunCommand("iwlist", wifiInterface, "scan")
 	if err != nil {
-		return nil, fmt.Errorf("error scanning networks: %v\nOutput: %s", err, output)
+		return nil, fmt.Errorf("%w: %v\nOutput: %s", ErrScanFailed, err, output)
 	}
 
 	lines := strings.Split(output, "\n")
@@ -124,3 +165,130 @@ func ScanNetworks(wifiInterface string) ([]map[string]interface{}, error) {
 
 	return networks, nil
 }
+
+// Connect записывает сеть в конфигурацию wpa_supplicant, перезапускает интерфейс и получает адрес по DHCP
+func Connect(wifiInterface, configPath, ssid, psk string) error {
+	err := UpdateNetworkConfig(configPath, ssid, psk)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrConfigFailed, err)
+	}
+
+	StopWpaSupplicant()
+
+	output, err := RunCommand("ip", "link", "set", wifiInterface, "down")
+	if err != nil {
+		return fmt.Errorf("%w: %v\nOutput: %s", ErrInterfaceFailed, err, output)
+	}
+	output, err = RunCommand("ip", "link", "set", wifiInterface, "up")
+	if err != nil {
+		return fmt.Errorf("%w: %v\nOutput: %s", ErrInterfaceFailed, err, output)
+	}
+
+	output, err = RunCommand("wpa_supplicant", "-B", "-i", wifiInterface, "-c", configPath)
+	if err != nil {
+		return fmt.Errorf("%w: %v\nOutput: %s", ErrAssociationFailed, err, output)
+	}
+
+	time.Sleep(5 * time.Second)
+
+	output, err = RunCommand("dhclient", wifiInterface)
+	if err != nil {
+		return fmt.Errorf("%w: %v\nOutput: %s", ErrDHCPFailed, err, output)
+	}
+
+	return nil
+}
+
+// SignalQuality возвращает качество связи и уровень сигнала (дБм) интерфейса из /proc/net/wireless
+func SignalQuality(wifiInterface string) (quality, level float64, err error) {
+	content, err := ioutil.ReadFile("/proc/net/wireless")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read wireless statistics: %w", err)
+	}
+
+	// Строка интерфейса: "wlan0: 0000   70.  -39.  -256 ..." — состояние, качество, уровень сигнала, шум
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] != wifiInterface+":" {
+			continue
+		}
+		quality, err = strconv.ParseFloat(strings.TrimSuffix(fields[2], "."), 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid link quality %q: %w", fields[2], err)
+		}
+		level, err = strconv.ParseFloat(strings.TrimSuffix(fields[3], "."), 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid signal level %q: %w", fields[3], err)
+		}
+		return quality, level, nil
+	}
+	return 0, 0, fmt.Errorf("no wireless statistics for %s", wifiInterface)
+}
+
+// updateMetrics записывает состояние подключения и качество сигнала интерфейса
+func updateMetrics(wifiInterface string, connected bool) {
+	connectedGauge.WithLabelValues(wifiInterface).Set(metrics.Bool(connected))
+
+	quality, level, err := SignalQuality(wifiInterface)
+	if !connected || err != nil {
+		linkQualityGauge.DeleteLabelValues(wifiInterface)
+		signalLevelGauge.DeleteLabelValues(wifiInterface)
+		return
+	}
+	linkQualityGauge.WithLabelValues(wifiInterface).Set(quality)
+	signalLevelGauge.WithLabelValues(wifiInterface).Set(level)
+}
+
+// Monitor периодически проверяет подключение интерфейса WiFi к сети и публикует события
+// wifi_connected (при подключении или смене сети) и wifi_disconnected, а также обновляет метрики подключения
+// и уровня сигнала.
+// Интерфейс и период проверки берутся из конфигурации на каждой итерации, поэтому их можно менять без перезапуска.
+// Monitor работает до отмены ctx.
+func Monitor(ctx context.Context) error {
+	currentInterface := ""
+	currentSSID := ""
+
+	for {
+		network := config.Get().Network
+		wifiInterface := network.WifiInterface
+
+		if wifiInterface != currentInterface {
+			connectedGauge.DeleteLabelValues(currentInterface)
+			linkQualityGauge.DeleteLabelValues(currentInterface)
+			signalLevelGauge.DeleteLabelValues(currentInterface)
+			if currentSSID != "" {
+				events.Publish(events.TopicNetwork, "wifi_disconnected", map[string]string{
+					"interface": currentInterface,
+					"ssid":      currentSSID,
+				})
+			}
+			currentInterface, currentSSID = wifiInterface, ""
+		}
+
+		output, err := RunCommand("iwgetid", "-r", wifiInterface)
+		ssid := ""
+		if err == nil {
+			ssid = strings.TrimSpace(output)
+		}
+
+		if ssid != "" && ssid != currentSSID {
+			events.Publish(events.TopicNetwork, "wifi_connected", map[string]string{
+				"interface": wifiInterface,
+				"ssid":      ssid,
+			})
+		} else if ssid == "" && currentSSID != "" {
+			events.Publish(events.TopicNetwork, "wifi_disconnected", map[string]string{
+				"interface": wifiInterface,
+				"ssid":      currentSSID,
+			})
+		}
+		currentSSID = ssid
+		updateMetrics(wifiInterface, ssid != "")
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Duration(network.MonitorInterval)):
+		}
+	}
+}